@@ -0,0 +1,253 @@
+package blinkhash
+
+import "math"
+
+// Aggregator 把一个窗口内的条目依次累加为一个结果，由 AggregateFunc 为每个窗口新建一个
+type Aggregator interface {
+	// Add 按键的顺序加入一个条目，ts 为 KeyExtractor 从键中取出的时间戳
+	Add(ts int, value interface{})
+	// Result 返回窗口的结果，窗口中至少有一个条目
+	Result() interface{}
+}
+
+// AggregateFunc 为每个窗口新建一个 Aggregator。内置的有 AggregateSum、AggregateCount、AggregateMin、
+// AggregateMax、AggregateMean、AggregateFirst 和 AggregateLast，也可以自定义
+type AggregateFunc func() Aggregator
+
+// 内置的聚合函数。求和、最值和平均值只统计数值(各种整数和浮点数)，跳过其他类型的值，
+// 窗口中没有数值时结果为 nil；求和与平均值的结果为 float64，最值返回原来的值
+var (
+	AggregateSum   AggregateFunc = func() Aggregator { return &sumAggregator{} }
+	AggregateCount AggregateFunc = func() Aggregator { return &countAggregator{} }
+	AggregateMin   AggregateFunc = func() Aggregator { return &extremeAggregator{less: true} }
+	AggregateMax   AggregateFunc = func() Aggregator { return &extremeAggregator{} }
+	AggregateMean  AggregateFunc = func() Aggregator { return &sumAggregator{mean: true} }
+	AggregateFirst AggregateFunc = func() Aggregator { return &firstAggregator{} }
+	AggregateLast  AggregateFunc = func() Aggregator { return &lastAggregator{} }
+)
+
+// KeyExtractor 从键中取出时间戳，Aggregate 按时间戳划分窗口
+type KeyExtractor func(key int) int
+
+// IdentityKey 键本身就是时间戳
+func IdentityKey(key int) int { return key }
+
+// PackedTimestamp 返回时间戳位于高位的键的提取器。TimeStampTest 的键为
+// timestamp<<16 | sensorID<<6 | tid，对应 PackedTimestamp(16)
+func PackedTimestamp(shift uint) KeyExtractor {
+	return func(key int) int { return key >> shift }
+}
+
+// AggregateRow Aggregate 结果中的一行，对应时间戳在 [Start, Start+window) 内的条目
+type AggregateRow struct {
+	Start int         // 窗口起始的时间戳，window 为 0 时为第一个条目的时间戳
+	Count int         // 窗口中的条目数
+	Value interface{} // Aggregator.Result 的结果
+}
+
+// Aggregate 对键在 [lo, hi) 内的条目做降采样：extract 从键中取出时间戳(nil 时键就是时间戳)，
+// 按时间戳把条目划分为长度为 window 的窗口(window 不大于 0 时整个范围为一个窗口)，
+// 每个非空窗口用 fn 新建的 Aggregator 聚合为一行，结果按窗口的顺序排列。
+//
+// 沿叶子链按键的顺序读取，条目在所在叶子通过版本校验之后直接交给 Aggregator，
+// 不会为整个范围建立中间结果。时间戳需要随键单调不减：TimeStampTest 的键时间戳在高位，
+// 任意范围都满足；时间戳在低位的键(例如 sensorID<<48 | timestamp)需要把范围限制在一个传感器内。
+// 不满足时同一个窗口可能出现在不止一行中
+func (bt *BTree) Aggregate(lo, hi interface{}, window int, fn AggregateFunc, extract KeyExtractor, ti *ThreadInfo) []AggregateRow {
+	if extract == nil {
+		extract = IdentityKey
+	}
+	var rows []AggregateRow
+	var row AggregateRow
+	var agg Aggregator
+	bt.walkRange(lo.(int), hi.(int), ti, func(key int, value interface{}) {
+		ts := extract(key)
+		start := row.Start
+		if window > 0 {
+			start = ts - floorMod(ts, window)
+		} else if agg == nil {
+			start = ts
+		}
+		if agg == nil || start != row.Start {
+			if agg != nil {
+				row.Value = agg.Result()
+				rows = append(rows, row)
+			}
+			row, agg = AggregateRow{Start: start}, fn()
+		}
+		agg.Add(ts, value)
+		row.Count++
+	})
+	if agg != nil {
+		row.Value = agg.Result()
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// floorMod 返回 a 除以 b(b > 0)的非负余数，负的时间戳也落在正确的窗口中
+func floorMod(a, b int) int {
+	m := a % b
+	if m < 0 {
+		m += b
+	}
+	return m
+}
+
+// walkRange 按键的顺序把 [lo, hi) 内未过期的条目逐个交给 visit，每次只持有一个叶子的条目。
+// 叶子的条目在版本校验通过之后才交给 visit，重启时从已经交出的最后一个键之后继续，每个条目只交出一次
+func (bt *BTree) walkRange(lo, hi int, ti *ThreadInfo, visit func(key int, value interface{})) {
+	if lo >= hi {
+		return
+	}
+	eg := NewEpocheGuard(ti)
+	defer eg.Release()
+	expires := bt.expires()
+	from := lo
+walkLoop:
+	for {
+		leaf, leafVersion := bt.findLeaf(from)
+		continued := false
+		for {
+			collected, retCode, _ := leaf.RangeLookUpEntries(from, math.MaxInt, continued, leafVersion)
+			if retCode == NeedRestart {
+				continue walkLoop
+			} else if retCode == NeedConvert {
+				// 转换成功时旧叶子仍处于锁定状态，需要在这里释放；失败时 Convert 已自行解锁
+				if bt.convert(leaf, leafVersion, ti) {
+					leaf.WriteUnlock()
+				}
+				continue walkLoop
+			}
+			continued = true
+
+			sibling, highKey := leaf.GetSiblingPtr(), leaf.GetHighKey()
+			leafEndVersion, needRestart := leaf.GetVersion()
+			if needRestart || leafVersion != leafEndVersion {
+				continue walkLoop
+			}
+			var now int64
+			if expires {
+				now = ttlNow()
+			}
+			for _, e := range collected {
+				key := e.Key.(int)
+				if key >= hi {
+					return
+				}
+				from = key + 1
+				if value, live := liveValue(e.Value, now); live {
+					visit(key, value)
+				}
+			}
+			// 后面的叶子中的键都大于 highKey
+			if sibling == nil || compareIntKeys(highKey, hi-1) >= 0 {
+				return
+			}
+
+			siblingVersion, sibRestart := sibling.TryReadLock()
+			if sibRestart {
+				continue walkLoop
+			}
+			lf, ok := sibling.(LeafNodeInterface)
+			if !ok {
+				panic("expected LeafNodeInterface")
+			}
+			leaf, leafVersion = lf, siblingVersion
+		}
+	}
+}
+
+// toFloat64 把数值转换为 float64，其他类型返回 false
+func toFloat64(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+type sumAggregator struct {
+	sum  float64
+	n    int
+	mean bool
+}
+
+func (a *sumAggregator) Add(_ int, value interface{}) {
+	if v, ok := toFloat64(value); ok {
+		a.sum += v
+		a.n++
+	}
+}
+
+func (a *sumAggregator) Result() interface{} {
+	if a.n == 0 {
+		return nil
+	}
+	if a.mean {
+		return a.sum / float64(a.n)
+	}
+	return a.sum
+}
+
+type countAggregator struct {
+	n int
+}
+
+func (a *countAggregator) Add(int, interface{}) { a.n++ }
+
+func (a *countAggregator) Result() interface{} { return a.n }
+
+// extremeAggregator less 为 true 时求最小值，否则求最大值，相等时保留先出现的值
+type extremeAggregator struct {
+	less  bool
+	best  float64
+	value interface{}
+}
+
+func (a *extremeAggregator) Add(_ int, value interface{}) {
+	v, ok := toFloat64(value)
+	if !ok {
+		return
+	}
+	if a.value == nil || (a.less && v < a.best) || (!a.less && v > a.best) {
+		a.best, a.value = v, value
+	}
+}
+
+func (a *extremeAggregator) Result() interface{} { return a.value }
+
+type firstAggregator struct {
+	value interface{}
+	seen  bool
+}
+
+func (a *firstAggregator) Add(_ int, value interface{}) {
+	if !a.seen {
+		a.value, a.seen = value, true
+	}
+}
+
+func (a *firstAggregator) Result() interface{} { return a.value }
+
+type lastAggregator struct {
+	value interface{}
+}
+
+func (a *lastAggregator) Add(_ int, value interface{}) { a.value = value }
+
+func (a *lastAggregator) Result() interface{} { return a.value }
@@ -0,0 +1,213 @@
+package blinkhash
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// bruteAggregate 直接按定义计算 Aggregate 的结果，entries 按键有序
+func bruteAggregate(entries []Entry, lo, hi, window int, fn AggregateFunc, extract KeyExtractor) []AggregateRow {
+	var rows []AggregateRow
+	var aggs []Aggregator
+	for _, e := range entries {
+		k := e.Key.(int)
+		if k < lo || k >= hi {
+			continue
+		}
+		ts := extract(k)
+		start := ts - floorMod(ts, window)
+		if len(rows) == 0 || rows[len(rows)-1].Start != start {
+			rows = append(rows, AggregateRow{Start: start})
+			aggs = append(aggs, fn())
+		}
+		aggs[len(aggs)-1].Add(ts, e.Value)
+		rows[len(rows)-1].Count++
+	}
+	for i := range rows {
+		rows[i].Value = aggs[i].Result()
+	}
+	return rows
+}
+
+func TestAggregate_Builtins(t *testing.T) {
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	for k := 0; k < 10000; k++ {
+		tree.Insert(k, k%37, ti)
+	}
+	// 窗口 [200, 300) 只有后一半在范围内
+	rows := tree.Aggregate(250, 1250, 100, AggregateSum, nil, ti)
+	if len(rows) != 11 || rows[0].Start != 200 || rows[0].Count != 50 || rows[10].Start != 1200 || rows[10].Count != 50 {
+		t.Fatalf("Expected 11 windows from 200 to 1200, got %+v", rows)
+	}
+	for _, r := range rows[1:10] {
+		if r.Count != 100 {
+			t.Errorf("window %d: expected 100 entries, got %d", r.Start, r.Count)
+		}
+	}
+
+	entries := tree.RangeLookupEntries(0, 10000, ti)
+	for name, fn := range map[string]AggregateFunc{
+		"sum": AggregateSum, "count": AggregateCount, "min": AggregateMin, "max": AggregateMax,
+		"mean": AggregateMean, "first": AggregateFirst, "last": AggregateLast,
+	} {
+		for _, window := range []int{1, 7, 100, 4096} {
+			got := tree.Aggregate(13, 9000, window, fn, nil, ti)
+			if want := bruteAggregate(entries, 13, 9000, window, fn, IdentityKey); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s over windows of %d: results differ from the definition", name, window)
+			}
+		}
+	}
+
+	rows = tree.Aggregate(100, 200, 0, AggregateMean, nil, ti)
+	if len(rows) != 1 || rows[0].Start != 100 || rows[0].Count != 100 {
+		t.Fatalf("Expected a single window for window 0, got %+v", rows)
+	}
+	if rows := tree.Aggregate(20000, 30000, 10, AggregateCount, nil, ti); rows != nil {
+		t.Errorf("Expected no rows for an empty range, got %+v", rows)
+	}
+	if rows := tree.Aggregate(500, 500, 10, AggregateCount, nil, ti); rows != nil {
+		t.Errorf("Expected no rows for lo == hi, got %+v", rows)
+	}
+}
+
+func TestAggregate_Values(t *testing.T) {
+	now := withClock(t)
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	// 负的时间戳、非数值的值和过期的条目
+	values := map[int]interface{}{-15: 2.5, -10: "skip", -3: uint64(7), 0: int32(-1), 4: float32(0.5), 9: "skip"}
+	for k, v := range values {
+		tree.Insert(k, v, ti)
+	}
+	tree.InsertWithTTL(5, 1000, time.Second, ti)
+	atomic.AddInt64(now, int64(time.Second))
+
+	rows := tree.Aggregate(math.MinInt, math.MaxInt, 10, AggregateSum, nil, ti)
+	want := []AggregateRow{{Start: -20, Count: 1, Value: 2.5}, {Start: -10, Count: 2, Value: 7.0}, {Start: 0, Count: 3, Value: -0.5}}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("Expected %+v, got %+v", want, rows)
+	}
+	rows = tree.Aggregate(math.MinInt, math.MaxInt, 10, AggregateMax, nil, ti)
+	if rows[1].Value != uint64(7) || rows[2].Value != float32(0.5) {
+		t.Errorf("Expected max to return the original values, got %+v", rows)
+	}
+	rows = tree.Aggregate(9, 10, 10, AggregateMean, nil, ti)
+	if len(rows) != 1 || rows[0].Count != 1 || rows[0].Value != nil {
+		t.Errorf("Expected a window without numbers to aggregate to nil, got %+v", rows)
+	}
+	rows = tree.Aggregate(-10, 10, 10, AggregateLast, nil, ti)
+	if len(rows) != 2 || rows[0].Value != uint64(7) || rows[1].Value != "skip" {
+		t.Errorf("Expected last to keep any value, got %+v", rows)
+	}
+}
+
+// spanAggregator 自定义聚合：窗口中第一个和最后一个时间戳的差
+type spanAggregator struct {
+	first, last int
+	seen        bool
+}
+
+func (a *spanAggregator) Add(ts int, _ interface{}) {
+	if !a.seen {
+		a.first, a.seen = ts, true
+	}
+	a.last = ts
+}
+
+func (a *spanAggregator) Result() interface{} { return a.last - a.first }
+
+func TestAggregate_PackedKeys(t *testing.T) {
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	rnd := rand.New(rand.NewSource(1))
+	// TimeStampTest 的格式: timestamp<<16 | sensorID<<6 | tid
+	for ts := 1000; ts < 6000; ts++ {
+		for sensor := 0; sensor < 4; sensor++ {
+			if rnd.Intn(3) > 0 {
+				tree.Insert(ts<<16|sensor<<6|rnd.Intn(64), rnd.Float64(), ti)
+			}
+		}
+	}
+	extract := PackedTimestamp(16)
+	entries := tree.RangeLookupEntries(math.MinInt, 1<<20, ti)
+	lo, hi := 1234<<16, 5678<<16
+	for name, fn := range map[string]AggregateFunc{"mean": AggregateMean, "max": AggregateMax, "span": func() Aggregator { return &spanAggregator{} }} {
+		got := tree.Aggregate(lo, hi, 60, fn, extract, ti)
+		if want := bruteAggregate(entries, lo, hi, 60, fn, extract); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: results differ from the definition", name)
+		}
+		if got[0].Start != 1200 || got[len(got)-1].Start != 5640 {
+			t.Errorf("%s: expected windows from 1200 to 5640, got %d to %d", name, got[0].Start, got[len(got)-1].Start)
+		}
+	}
+
+	// 传感器在高位时把范围限制在一个传感器内
+	sensorKey := func(sensor, ts int) int { return sensor<<40 | ts }
+	for ts := 0; ts < 1000; ts++ {
+		tree.Insert(sensorKey(1, ts), ts, ti)
+		tree.Insert(sensorKey(2, ts), -ts, ti)
+	}
+	low40 := func(key int) int { return key & (1<<40 - 1) }
+	rows := tree.Aggregate(sensorKey(2, 0), sensorKey(2, 1000), 100, AggregateMin, low40, ti)
+	if len(rows) != 10 || rows[3].Start != 300 || rows[3].Count != 100 || rows[3].Value != -399 {
+		t.Errorf("Expected per-sensor windows, got %+v", rows)
+	}
+}
+
+// 聚合与范围外的写入、叶子的转换和分裂并发进行，结果不受影响
+func TestAggregate_Concurrent(t *testing.T) {
+	const n = 20000
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	for k := 0; k < n; k++ {
+		tree.Insert(k*2, 1, ti)
+	}
+	var done int32
+	var wg sync.WaitGroup
+	for w := 0; w < 2; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			ti := NewThreadInfo(tree.GetEpoche())
+			for k := 2*n + w; atomic.LoadInt32(&done) == 0; k += 2 {
+				tree.Insert(k, 1, ti)
+			}
+		}(w)
+	}
+	errs := make(chan string, 4)
+	var readers sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			ti := NewThreadInfo(tree.GetEpoche())
+			for i := 0; i < 20; i++ {
+				// 一开始叶子都是哈希叶子，聚合过程中被转换
+				rows := tree.Aggregate(0, 2*n, 1000, AggregateSum, nil, ti)
+				if len(rows) != 2*n/1000 {
+					errs <- "unexpected number of windows"
+					return
+				}
+				for _, r := range rows {
+					if r.Count != 500 || r.Value != 500.0 {
+						errs <- "window aggregated a wrong number of entries"
+						return
+					}
+				}
+			}
+		}()
+	}
+	readers.Wait()
+	atomic.StoreInt32(&done, 1)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
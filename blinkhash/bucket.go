@@ -15,22 +15,71 @@ const (
 
 type Bucket struct {
 	lock         uint32
+	size         uint32 // 槽位数，普通桶为 EntryNum，溢出区可以更少
 	state        State
-	fingerprints []uint8
-	entries      []Entry
+	fingerprints [bucketFingerprintBytes]uint8 // 每个槽位一个指纹，按 8 个一组做 SWAR 匹配，见 swar.go；最低位为 1 表示槽位被占用
+	keys         [EntryNum]int                 // 按槽位存放的键，不装箱
+	values       slotValues
 }
 
+// bucketFingerprintBytes 指纹数组的字节数，向上补齐到 8 的倍数，补齐的字节总是空指纹
+const bucketFingerprintBytes = (EntryNum + 7) &^ 7
+
 func NewBucket() *Bucket {
-	bucket := &Bucket{
-		lock:         0,
-		fingerprints: make([]uint8, EntryNum),
-		entries:      make([]Entry, EntryNum),
-	}
-	for j := 0; j < len(bucket.entries); j++ {
-		bucket.entries[j].Key = nil
-		bucket.entries[j].Value = nil
-	}
-	return bucket
+	return newBucketWithSlots(EntryNum)
+}
+
+// newBucketWithSlots 创建有 slots 个槽位的桶，slots 不超过 EntryNum。
+// 指纹、键和值都是桶内的定长数组，整个桶是一块连续的内存
+func newBucketWithSlots(slots int) *Bucket {
+	return &Bucket{size: uint32(minInt(slots, EntryNum))}
+}
+
+// occupiedFingerprint 不使用指纹时写入的占位指纹，只用于标记槽位被占用
+const occupiedFingerprint = 0b1
+
+// intKey 取出 int 键，其他类型的键不可能存在于桶中
+func intKey(key interface{}) (int, bool) {
+	k, ok := key.(int)
+	return k, ok
+}
+
+// slots 返回桶的槽位数
+func (b *Bucket) slots() int {
+	return int(b.size)
+}
+
+// occupied 判断槽位是否被占用
+func (b *Bucket) occupied(i int) bool {
+	return b.fingerprints[i]&0b1 == 0b1
+}
+
+// entry 以 Entry 形式返回槽位中的键值
+func (b *Bucket) entry(i int) Entry {
+	return Entry{Key: b.keys[i], Value: b.values.get(i)}
+}
+
+// setSlot 写入槽位，fingerprint 的最低位总是置 1 以标记占用
+func (b *Bucket) setSlot(i int, key int, value interface{}, fingerprint uint8) {
+	b.keys[i] = key
+	b.values.set(i, value)
+	b.fingerprints[i] = fingerprint | occupiedFingerprint
+}
+
+// clearSlot 清空槽位
+func (b *Bucket) clearSlot(i int) {
+	b.fingerprints[i] = EmptyFingerprint
+	b.keys[i] = 0
+	b.values.clear(i)
+}
+
+// moveSlot 把槽位 i 的条目移动到 dst 的槽位 j，fingerprint 为条目在 dst 中的指纹
+func (b *Bucket) moveSlot(i int, dst *Bucket, j int, fingerprint uint8) {
+	dst.keys[j] = b.keys[i]
+	b.values.moveTo(i, &dst.values, j)
+	dst.fingerprints[j] = fingerprint | occupiedFingerprint
+	b.fingerprints[i] = EmptyFingerprint
+	b.keys[i] = 0
 }
 
 func (b *Bucket) TryLock() bool {
@@ -50,14 +99,7 @@ func (b *Bucket) Unlock() {
 	atomic.AddUint32(&b.lock, 0b10)
 }
 func (b *Bucket) Insert(key, value interface{}) bool {
-	for i := range b.entries {
-		if b.entries[i].Key == nil { // assuming nil as empty
-			b.entries[i].Key = key
-			b.entries[i].Value = value
-			return true
-		}
-	}
-	return false
+	return b.InsertWithFingerprint(key, value, occupiedFingerprint, EmptyFingerprint)
 }
 
 //-------------------------------------------
@@ -65,38 +107,49 @@ func (b *Bucket) Insert(key, value interface{}) bool {
 //-------------------------------------------
 
 func (b *Bucket) InsertWithFingerprint(key, value interface{}, fingerprint, empty uint8) bool {
-	for i := range b.entries {
-		// 检查fingerprints[i]是否等于empty以表示空闲槽位
-		if b.fingerprints[i] == empty {
-			b.fingerprints[i] = fingerprint
-			b.entries[i].Key = key
-			b.entries[i].Value = value
-			return true
-		}
+	k, ok := intKey(key)
+	if !ok {
+		return false
 	}
-	return false
+	// 指纹等于empty的槽位为空闲槽位
+	i := b.matchSlot(empty)
+	if i < 0 {
+		return false
+	}
+	b.setSlot(i, k, value, fingerprint)
+	return true
 }
 
 // Find 在没有Fingerprint的情况下查找
 func (b *Bucket) Find(key interface{}) (interface{}, bool) {
-	for i := range b.entries {
-		if b.entries[i].Key == key {
-			return b.entries[i].Value, true
-		}
+	if i := b.findOccupied(key); i >= 0 {
+		return b.values.get(i), true
 	}
 	return nil, false
 }
 
+// findOccupied 不比较指纹，返回键等于 key 的已占用槽位，不存在时返回 -1
+func (b *Bucket) findOccupied(key interface{}) int {
+	k, ok := intKey(key)
+	if !ok {
+		return -1
+	}
+	for i := 0; i < b.slots(); i++ {
+		if b.keys[i] == k && b.occupied(i) {
+			return i
+		}
+	}
+	return -1
+}
+
 //-------------------------------------------
 // 有Fingerprint版本的函数
 //-------------------------------------------
 
 // FindWithFingerprint 带Fingerprint的查找
 func (b *Bucket) FindWithFingerprint(key interface{}, fingerprint uint8) (interface{}, bool) {
-	for i := range b.entries {
-		if b.fingerprints[i] == fingerprint && b.entries[i].Key == key {
-			return b.entries[i].Value, true
-		}
+	if i := b.findSlot(key, fingerprint); i >= 0 {
+		return b.values.get(i), true
 	}
 	return nil, false
 }
@@ -105,9 +158,10 @@ func (b *Bucket) FindWithFingerprint(key interface{}, fingerprint uint8) (interf
 func (b *Bucket) Collect(key interface{}) []Entry {
 	var buf []Entry
 	// 假设key为int
-	for _, entry := range b.entries {
-		if entry.Key != nil && entry.Key.(int) >= key.(int) {
-			buf = append(buf, entry)
+	from := key.(int)
+	for i, k := range b.keys[:b.slots()] {
+		if b.occupied(i) && k >= from {
+			buf = append(buf, b.entry(i))
 		}
 	}
 	return buf
@@ -120,9 +174,10 @@ func (b *Bucket) Collect(key interface{}) []Entry {
 // CollectWithFingerprint 带Fingerprint的收集 >= key的entry
 func (b *Bucket) CollectWithFingerprint(key interface{}, empty uint8) []Entry {
 	var buf []Entry
-	for i, e := range b.entries {
-		if b.fingerprints[i] != empty && e.Key.(int) >= key.(int) {
-			buf = append(buf, e)
+	from := key.(int)
+	for i, k := range b.keys[:b.slots()] {
+		if b.fingerprints[i] != empty && k >= from {
+			buf = append(buf, b.entry(i))
 		}
 	}
 	return buf
@@ -131,9 +186,9 @@ func (b *Bucket) CollectWithFingerprint(key interface{}, empty uint8) []Entry {
 // CollectAll gathers all non-empty entries from the bucket.
 func (b *Bucket) CollectAll() []Entry {
 	var buf []Entry
-	for _, entry := range b.entries {
-		if entry.Key != nil {
-			buf = append(buf, entry)
+	for i := 0; i < b.slots(); i++ {
+		if b.occupied(i) {
+			buf = append(buf, b.entry(i))
 		}
 	}
 	return buf
@@ -146,9 +201,9 @@ func (b *Bucket) CollectAll() []Entry {
 // CollectAllWithFingerprint 带Fingerprint收集所有非空entry
 func (b *Bucket) CollectAllWithFingerprint(empty uint8) []Entry {
 	var buf []Entry
-	for i, e := range b.entries {
+	for i := 0; i < b.slots(); i++ {
 		if b.fingerprints[i] != empty {
-			buf = append(buf, e)
+			buf = append(buf, b.entry(i))
 		}
 	}
 	return buf
@@ -156,11 +211,9 @@ func (b *Bucket) CollectAllWithFingerprint(empty uint8) []Entry {
 
 // Update updates the value for a given key if it exists in the bucket.
 func (b *Bucket) Update(key, value interface{}) bool {
-	for i := range b.entries {
-		if compareIntKeys(b.entries[i].Key, key) == 0 {
-			b.entries[i].Value = value
-			return true
-		}
+	if i := b.findOccupied(key); i >= 0 {
+		b.values.set(i, value)
+		return true
 	}
 	return false
 }
@@ -171,22 +224,18 @@ func (b *Bucket) Update(key, value interface{}) bool {
 
 // UpdateWithFingerprint 带Fingerprint的更新
 func (b *Bucket) UpdateWithFingerprint(key, value interface{}, fingerprint uint8) bool {
-	for i := range b.entries {
-		if b.fingerprints[i] == fingerprint && b.entries[i].Key == key {
-			b.entries[i].Value = value
-			return true
-		}
+	if i := b.findSlot(key, fingerprint); i >= 0 {
+		b.values.set(i, value)
+		return true
 	}
 	return false
 }
 
 // Remove 从桶中移除指定键的条目
 func (b *Bucket) Remove(key interface{}) bool {
-	for i := range b.entries {
-		if compareIntKeys(b.entries[i].Key, key) == 0 {
-			b.entries[i].Key = nil // 假设 nil 表示空
-			return true
-		}
+	if i := b.findOccupied(key); i >= 0 {
+		b.clearSlot(i)
+		return true
 	}
 	return false
 }
@@ -197,23 +246,20 @@ func (b *Bucket) Remove(key interface{}) bool {
 
 // RemoveWithFingerprint 带Fingerprint的移除
 func (b *Bucket) RemoveWithFingerprint(key interface{}, fingerprint uint8) bool {
-	for i := range b.entries {
-		if b.fingerprints[i] == fingerprint && b.entries[i].Key == key {
-			b.fingerprints[i] = 0
-			b.entries[i].Key = nil
-			b.entries[i].Value = nil
-			return true
-		}
+	i := b.findSlot(key, fingerprint)
+	if i < 0 {
+		return false
 	}
-	return false
+	b.clearSlot(i)
+	return true
 }
 
 // CollectKeys collects keys up to a specified cardinality and returns true if it collects exactly the cardinality.
 func (b *Bucket) CollectKeys(cardinality int) ([]interface{}, bool) {
 	keys := make([]interface{}, 0, cardinality)
-	for _, entry := range b.entries {
-		if !IsEmptyKey(entry.Key) {
-			keys = append(keys, entry.Key)
+	for i, k := range b.keys[:b.slots()] {
+		if b.occupied(i) {
+			keys = append(keys, k)
 			if len(keys) == cardinality {
 				return keys, true
 			}
@@ -229,9 +275,9 @@ func (b *Bucket) CollectKeys(cardinality int) ([]interface{}, bool) {
 // CollectKeysWithFingerprint 带Fingerprint收集最多cardinality个key
 func (b *Bucket) CollectKeysWithFingerprint(cardinality int, empty uint8) ([]interface{}, bool) {
 	keys := make([]interface{}, 0, cardinality)
-	for i, e := range b.entries {
+	for i, k := range b.keys[:b.slots()] {
 		if b.fingerprints[i] != empty {
-			keys = append(keys, e.Key)
+			keys = append(keys, k)
 			if len(keys) == cardinality {
 				return keys, true
 			}
@@ -242,10 +288,10 @@ func (b *Bucket) CollectKeysWithFingerprint(cardinality int, empty uint8) ([]int
 
 // CollectAllKeys collects all keys that are not empty.
 func (b *Bucket) CollectAllKeys() []interface{} {
-	keys := make([]interface{}, 0, len(b.entries))
-	for _, entry := range b.entries {
-		if !IsEmptyKey(entry.Key) {
-			keys = append(keys, entry.Key)
+	keys := make([]interface{}, 0, b.slots())
+	for i, k := range b.keys[:b.slots()] {
+		if b.occupied(i) {
+			keys = append(keys, k)
 		}
 	}
 	return keys
@@ -257,10 +303,10 @@ func (b *Bucket) CollectAllKeys() []interface{} {
 
 // CollectAllKeysWithFingerprint 带Fingerprint收集所有非空key
 func (b *Bucket) CollectAllKeysWithFingerprint(empty uint8) []interface{} {
-	keys := make([]interface{}, 0, len(b.entries))
-	for i, entry := range b.entries {
+	keys := make([]interface{}, 0, b.slots())
+	for i, k := range b.keys[:b.slots()] {
 		if b.fingerprints[i] != empty {
-			keys = append(keys, entry.Key)
+			keys = append(keys, k)
 		}
 	}
 	return keys
@@ -276,25 +322,14 @@ func (b *Bucket) Footprint(metrics *FootprintMetrics) {
 		metrics.Meta += 8
 	}
 
-	for i := 0; i < len(b.entries); i++ {
-		if FINGERPRINT {
-			// 按原C++逻辑:
-			// if((fingerprints[i] & 0b1) == 0b1)表示occupied
-			if b.fingerprints[i]&0b1 == 0b1 {
-				metrics.StructuralDataOccupied += 1 // fingerprint占1字节
-				// 假设每个entry 16字节 (可根据实际键值大小调整)
-				metrics.KeyDataOccupied += 16
-			} else {
-				metrics.StructuralDataUnoccupied += 1
-				metrics.KeyDataUnoccupied += 16
-			}
+	for i := 0; i < b.slots(); i++ {
+		// 键和值各占 8 字节；不使用指纹时指纹字节只用于标记占用，仍计入结构数据
+		if b.occupied(i) {
+			metrics.StructuralDataOccupied += 1
+			metrics.KeyDataOccupied += 16
 		} else {
-			// 无fingerprint时，根据key是否为空
-			if !IsEmptyKey(b.entries[i].Key) {
-				metrics.KeyDataOccupied += 16
-			} else {
-				metrics.KeyDataUnoccupied += 16
-			}
+			metrics.StructuralDataUnoccupied += 1
+			metrics.KeyDataUnoccupied += 16
 		}
 	}
 	return
@@ -312,10 +347,10 @@ func (b *Bucket) Print() {
 
 	// 打印 fingerprints 切片
 	fmt.Printf("\tFingerprints: ")
-	if len(b.fingerprints) == 0 {
+	if b.slots() == 0 {
 		fmt.Println("nil")
 	} else {
-		for i, fingerprint := range b.fingerprints {
+		for i, fingerprint := range b.fingerprints[:b.slots()] {
 			if i > 0 {
 				fmt.Print(", ")
 			}
@@ -324,14 +359,16 @@ func (b *Bucket) Print() {
 		fmt.Println()
 	}
 
-	// 打印 entries 切片
+	// 打印槽位
 	fmt.Printf("\tEntries: \n")
-	if len(b.entries) == 0 {
+	if b.slots() == 0 {
 		fmt.Println("nil")
 	} else {
-		for i, entry := range b.entries {
-			// 假设 Entry 类型有 Key 和 Value 字段
-			fmt.Printf("\tEntry %d: Key = %v, Value = %v\n", i, entry.Key, entry.Value)
+		for i := 0; i < b.slots(); i++ {
+			if !b.occupied(i) {
+				continue
+			}
+			fmt.Printf("\tEntry %d: Key = %v, Value = %v\n", i, b.keys[i], b.values.get(i))
 		}
 	}
 }
@@ -0,0 +1,420 @@
+package blinkhash
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+)
+
+// Codec 把键或值编码为字节，用于日志、检查点和树文件。
+//
+// 编码结果必须能够自行界定长度：Decode 从 data 的开头解码一个值并返回消耗的字节数，
+// data 之后可能紧跟着其他数据。文件中只记录编解码器的名称，读取时按名称查找，
+// 因此同一个名称的编码格式一旦写入文件就不能再改变。
+type Codec interface {
+	Name() string
+	// Append 把 v 的编码追加到 buf，v 的类型不受支持时返回 ErrCodecType
+	Append(buf []byte, v interface{}) ([]byte, error)
+	Decode(data []byte) (v interface{}, n int, err error)
+}
+
+// ErrCodecType 值的类型不能用指定的编解码器编码
+var ErrCodecType = errors.New("blinkhash: value type not supported by codec")
+
+// errCorrupt 记录的帧完整且校验通过，但内容无法解码
+var errCorrupt = errors.New("blinkhash: corrupt encoded data")
+
+// 内置的编解码器，都已按名称注册
+var (
+	// IntCodec int，zigzag varint。树的键总是使用它
+	IntCodec Codec = intCodec{}
+	// Int64Codec int64，zigzag varint
+	Int64Codec Codec = int64Codec{}
+	// Uint64Codec uint64，varint
+	Uint64Codec Codec = uint64Codec{}
+	// Float64Codec float64，8 字节小端 IEEE 754
+	Float64Codec Codec = float64Codec{}
+	// StringCodec string，长度前缀
+	StringCodec Codec = stringCodec{}
+	// BytesCodec []byte，长度前缀，解码结果不与输入共享内存
+	BytesCodec Codec = bytesCodec{}
+	// TaggedCodec 以一个类型标记字节开头，支持 nil、bool、int、int64、uint64、float64、string 和 []byte 的混合。
+	// 没有指定编解码器时使用它
+	TaggedCodec Codec = taggedCodec{}
+	// GobCodec 任意类型的后备方案，每个值独立编码(含类型信息)。
+	// 以接口存放的具体类型需要先 gob.Register
+	GobCodec Codec = gobCodec{}
+	// JSONCodec 任意类型的后备方案。解码得到的是 encoding/json 的通用类型：
+	// 数字为 float64，对象为 map[string]interface{}
+	JSONCodec Codec = jsonCodec{}
+)
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = make(map[string]Codec)
+)
+
+func init() {
+	for _, c := range []Codec{IntCodec, Int64Codec, Uint64Codec, Float64Codec, StringCodec, BytesCodec, TaggedCodec, GobCodec, JSONCodec} {
+		RegisterCodec(c)
+	}
+}
+
+// RegisterCodec 按 c.Name() 注册编解码器，读取文件时据此找回写入时使用的编解码器。
+// 名称为空或已被注册时 panic
+func RegisterCodec(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	name := c.Name()
+	if name == "" {
+		panic("blinkhash: RegisterCodec with empty name")
+	}
+	if _, dup := codecs[name]; dup {
+		panic("blinkhash: RegisterCodec called twice for " + name)
+	}
+	codecs[name] = c
+}
+
+// LookupCodec 返回以 name 注册的编解码器
+func LookupCodec(name string) (Codec, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[name]
+	return c, ok
+}
+
+// codecByName 与 LookupCodec 相同，未注册时返回错误
+func codecByName(name string) (Codec, error) {
+	if c, ok := LookupCodec(name); ok {
+		return c, nil
+	}
+	return nil, fmt.Errorf("blinkhash: codec %q is not registered", name)
+}
+
+func codecTypeError(c Codec, v interface{}) error {
+	return fmt.Errorf("%w: %s cannot encode %T", ErrCodecType, c.Name(), v)
+}
+
+// appendLengthPrefixed 追加 uvarint 长度和 data
+func appendLengthPrefixed(buf, data []byte) []byte {
+	return append(binary.AppendUvarint(buf, uint64(len(data))), data...)
+}
+
+// decodeLengthPrefixed 读取 appendLengthPrefixed 写入的数据，返回的切片与 data 共享内存
+func decodeLengthPrefixed(data []byte) ([]byte, int, error) {
+	size, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n) < size {
+		return nil, 0, errCorrupt
+	}
+	end := n + int(size)
+	return data[n:end:end], end, nil
+}
+
+type intCodec struct{}
+
+func (intCodec) Name() string { return "int" }
+
+func (c intCodec) Append(buf []byte, v interface{}) ([]byte, error) {
+	i, ok := v.(int)
+	if !ok {
+		return buf, codecTypeError(c, v)
+	}
+	return binary.AppendVarint(buf, int64(i)), nil
+}
+
+func (intCodec) Decode(data []byte) (interface{}, int, error) {
+	i, n := binary.Varint(data)
+	if n <= 0 {
+		return nil, 0, errCorrupt
+	}
+	return int(i), n, nil
+}
+
+type int64Codec struct{}
+
+func (int64Codec) Name() string { return "int64" }
+
+func (c int64Codec) Append(buf []byte, v interface{}) ([]byte, error) {
+	i, ok := v.(int64)
+	if !ok {
+		return buf, codecTypeError(c, v)
+	}
+	return binary.AppendVarint(buf, i), nil
+}
+
+func (int64Codec) Decode(data []byte) (interface{}, int, error) {
+	i, n := binary.Varint(data)
+	if n <= 0 {
+		return nil, 0, errCorrupt
+	}
+	return i, n, nil
+}
+
+type uint64Codec struct{}
+
+func (uint64Codec) Name() string { return "uint64" }
+
+func (c uint64Codec) Append(buf []byte, v interface{}) ([]byte, error) {
+	u, ok := v.(uint64)
+	if !ok {
+		return buf, codecTypeError(c, v)
+	}
+	return binary.AppendUvarint(buf, u), nil
+}
+
+func (uint64Codec) Decode(data []byte) (interface{}, int, error) {
+	u, n := binary.Uvarint(data)
+	if n <= 0 {
+		return nil, 0, errCorrupt
+	}
+	return u, n, nil
+}
+
+type float64Codec struct{}
+
+func (float64Codec) Name() string { return "float64" }
+
+func (c float64Codec) Append(buf []byte, v interface{}) ([]byte, error) {
+	f, ok := v.(float64)
+	if !ok {
+		return buf, codecTypeError(c, v)
+	}
+	return binary.LittleEndian.AppendUint64(buf, math.Float64bits(f)), nil
+}
+
+func (float64Codec) Decode(data []byte) (interface{}, int, error) {
+	if len(data) < 8 {
+		return nil, 0, errCorrupt
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(data)), 8, nil
+}
+
+type stringCodec struct{}
+
+func (stringCodec) Name() string { return "string" }
+
+func (c stringCodec) Append(buf []byte, v interface{}) ([]byte, error) {
+	s, ok := v.(string)
+	if !ok {
+		return buf, codecTypeError(c, v)
+	}
+	return append(binary.AppendUvarint(buf, uint64(len(s))), s...), nil
+}
+
+func (stringCodec) Decode(data []byte) (interface{}, int, error) {
+	b, n, err := decodeLengthPrefixed(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	return string(b), n, nil
+}
+
+type bytesCodec struct{}
+
+func (bytesCodec) Name() string { return "bytes" }
+
+func (c bytesCodec) Append(buf []byte, v interface{}) ([]byte, error) {
+	b, ok := v.([]byte)
+	if !ok {
+		return buf, codecTypeError(c, v)
+	}
+	return appendLengthPrefixed(buf, b), nil
+}
+
+func (bytesCodec) Decode(data []byte) (interface{}, int, error) {
+	b, n, err := decodeLengthPrefixed(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	return append([]byte{}, b...), n, nil
+}
+
+// TaggedCodec 的类型标记，只能追加新的标记，已有标记的编码不能改变
+const (
+	valueTagNil byte = iota
+	valueTagInt
+	valueTagString
+	valueTagBytes
+	valueTagFloat64
+	valueTagBool
+	valueTagInt64
+	valueTagUint64
+)
+
+type taggedCodec struct{}
+
+func (taggedCodec) Name() string { return "tagged" }
+
+func (c taggedCodec) Append(buf []byte, value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return append(buf, valueTagNil), nil
+	case int:
+		return binary.AppendVarint(append(buf, valueTagInt), int64(v)), nil
+	case int64:
+		return binary.AppendVarint(append(buf, valueTagInt64), v), nil
+	case uint64:
+		return binary.AppendUvarint(append(buf, valueTagUint64), v), nil
+	case string:
+		buf = binary.AppendUvarint(append(buf, valueTagString), uint64(len(v)))
+		return append(buf, v...), nil
+	case []byte:
+		return appendLengthPrefixed(append(buf, valueTagBytes), v), nil
+	case float64:
+		return binary.LittleEndian.AppendUint64(append(buf, valueTagFloat64), math.Float64bits(v)), nil
+	case bool:
+		if v {
+			return append(buf, valueTagBool, 1), nil
+		}
+		return append(buf, valueTagBool, 0), nil
+	}
+	return buf, codecTypeError(c, value)
+}
+
+func (taggedCodec) Decode(data []byte) (interface{}, int, error) {
+	if len(data) == 0 {
+		return nil, 0, errCorrupt
+	}
+	var (
+		v   interface{}
+		n   int
+		err error
+	)
+	switch data[0] {
+	case valueTagNil:
+		return nil, 1, nil
+	case valueTagInt:
+		v, n, err = IntCodec.Decode(data[1:])
+	case valueTagInt64:
+		v, n, err = Int64Codec.Decode(data[1:])
+	case valueTagUint64:
+		v, n, err = Uint64Codec.Decode(data[1:])
+	case valueTagString:
+		v, n, err = StringCodec.Decode(data[1:])
+	case valueTagBytes:
+		v, n, err = BytesCodec.Decode(data[1:])
+	case valueTagFloat64:
+		v, n, err = Float64Codec.Decode(data[1:])
+	case valueTagBool:
+		if len(data) < 2 {
+			return nil, 0, errCorrupt
+		}
+		return data[1] != 0, 2, nil
+	default:
+		return nil, 0, errCorrupt
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return v, n + 1, nil
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string { return "gob" }
+
+func (c gobCodec) Append(buf []byte, v interface{}) ([]byte, error) {
+	var out bytes.Buffer
+	if err := gob.NewEncoder(&out).Encode(&v); err != nil {
+		return buf, fmt.Errorf("%w: %v", ErrCodecType, err)
+	}
+	return appendLengthPrefixed(buf, out.Bytes()), nil
+}
+
+func (gobCodec) Decode(data []byte) (interface{}, int, error) {
+	b, n, err := decodeLengthPrefixed(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	var v interface{}
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&v); err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", errCorrupt, err)
+	}
+	return v, n, nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "json" }
+
+func (c jsonCodec) Append(buf []byte, v interface{}) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return buf, fmt.Errorf("%w: %v", ErrCodecType, err)
+	}
+	return appendLengthPrefixed(buf, b), nil
+}
+
+func (jsonCodec) Decode(data []byte) (interface{}, int, error) {
+	b, n, err := decodeLengthPrefixed(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", errCorrupt, err)
+	}
+	return v, n, nil
+}
+
+// decoder 顺序读取一段编码后的数据，出错后的读取都返回零值，由调用方最后检查 err
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) fail() {
+	if d.err == nil {
+		d.err = errCorrupt
+	}
+	d.data = nil
+}
+
+func (d *decoder) byte() byte {
+	if len(d.data) < 1 {
+		d.fail()
+		return 0
+	}
+	b := d.data[0]
+	d.data = d.data[1:]
+	return b
+}
+
+func (d *decoder) varint() int64 {
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *decoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+// value 用 c 解码一个值
+func (d *decoder) value(c Codec) interface{} {
+	if d.err != nil {
+		return nil
+	}
+	v, n, err := c.Decode(d.data)
+	if err != nil {
+		d.fail()
+		return nil
+	}
+	d.data = d.data[n:]
+	return v
+}
@@ -0,0 +1,175 @@
+package blinkhash
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCodec_RoundTrip(t *testing.T) {
+	// 以接口存放的具体类型需要先向 gob 注册
+	gob.Register(map[string]int{})
+	cases := []struct {
+		codec  Codec
+		values []interface{}
+	}{
+		{IntCodec, []interface{}{0, -1, 1 << 40, -(1 << 62)}},
+		{Int64Codec, []interface{}{int64(0), int64(-5), int64(1) << 62}},
+		{Uint64Codec, []interface{}{uint64(0), uint64(1) << 63}},
+		{Float64Codec, []interface{}{0.0, -2.5, 1e300}},
+		{StringCodec, []interface{}{"", "a", strings.Repeat("x", 300)}},
+		{BytesCodec, []interface{}{[]byte{}, []byte("abc")}},
+		{TaggedCodec, []interface{}{nil, true, false, 7, int64(-7), uint64(7), 1.5, "s", []byte("b")}},
+		{GobCodec, []interface{}{7, "s", []string{"a", "b"}, map[string]int{"a": 1}}},
+		{JSONCodec, []interface{}{nil, true, 1.5, "s", []interface{}{"a", 2.0}, map[string]interface{}{"k": "v"}}},
+	}
+	for _, c := range cases {
+		t.Run(c.codec.Name(), func(t *testing.T) {
+			if got, ok := LookupCodec(c.codec.Name()); !ok || got != c.codec {
+				t.Fatalf("Expected %s to be registered", c.codec.Name())
+			}
+			// 所有值连续编码，逐个解码时必须恰好消耗各自的字节
+			var buf []byte
+			for _, v := range c.values {
+				var err error
+				if buf, err = c.codec.Append(buf, v); err != nil {
+					t.Fatalf("Append(%v): %v", v, err)
+				}
+			}
+			for _, v := range c.values {
+				got, n, err := c.codec.Decode(buf)
+				if err != nil {
+					t.Fatalf("Decode(%v): %v", v, err)
+				}
+				if !reflect.DeepEqual(got, v) {
+					t.Fatalf("Expected %#v, got %#v", v, got)
+				}
+				buf = buf[n:]
+			}
+			if len(buf) != 0 {
+				t.Fatalf("Expected all bytes to be consumed, %d left", len(buf))
+			}
+		})
+	}
+}
+
+func TestCodec_Errors(t *testing.T) {
+	if _, err := IntCodec.Append(nil, "x"); !errors.Is(err, ErrCodecType) {
+		t.Errorf("Expected ErrCodecType, got %v", err)
+	}
+	if _, err := TaggedCodec.Append(nil, struct{}{}); !errors.Is(err, ErrCodecType) {
+		t.Errorf("Expected ErrCodecType, got %v", err)
+	}
+	if _, err := JSONCodec.Append(nil, func() {}); !errors.Is(err, ErrCodecType) {
+		t.Errorf("Expected ErrCodecType, got %v", err)
+	}
+	buf, _ := StringCodec.Append(nil, "hello")
+	if _, _, err := StringCodec.Decode(buf[:3]); err == nil {
+		t.Errorf("Expected an error for a truncated string")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected RegisterCodec to panic on a duplicate name")
+		}
+	}()
+	RegisterCodec(taggedCodec{})
+}
+
+// upperCodec 测试用的自定义编解码器，把字符串存为大写
+type upperCodec struct{ name string }
+
+func (c upperCodec) Name() string { return c.name }
+
+func (c upperCodec) Append(buf []byte, v interface{}) ([]byte, error) {
+	s, ok := v.(string)
+	if !ok {
+		return buf, codecTypeError(c, v)
+	}
+	return StringCodec.Append(buf, strings.ToUpper(s))
+}
+
+func (c upperCodec) Decode(data []byte) (interface{}, int, error) {
+	return StringCodec.Decode(data)
+}
+
+// registerUpperCodec 注册测试用的 upperCodec。注册表是全局的，-count 大于 1 时测试会重复运行
+var registerUpperCodec sync.Once
+
+func TestCodec_SaveTo(t *testing.T) {
+	registerUpperCodec.Do(func() { RegisterCodec(upperCodec{"test-upper"}) })
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	for k := 0; k < 100; k++ {
+		tree.Insert(k, "v", ti)
+	}
+	var buf bytes.Buffer
+	if err := tree.SaveToWithCodec(&buf, upperCodec{"test-upper"}); err != nil {
+		t.Fatal(err)
+	}
+	data := append([]byte(nil), buf.Bytes()...)
+	loaded, err := LoadFrom(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := loaded.Lookup(50, NewThreadInfo(loaded.GetEpoche())); got != "V" {
+		t.Errorf("Expected the value to be decoded by the recorded codec, got %v", got)
+	}
+
+	// 文件头记录的编解码器没有注册时不去猜测
+	renamed := bytes.Replace(data, []byte("test-upper"), []byte("test-lower"), 1)
+	if _, err := LoadFrom(bytes.NewReader(renamed)); err == nil || !strings.Contains(err.Error(), "test-lower") {
+		t.Errorf("Expected an unregistered codec error, got %v", err)
+	}
+	if err := tree.SaveToWithCodec(&buf, upperCodec{"test-unregistered"}); err == nil {
+		t.Errorf("Expected an error when saving with an unregistered codec")
+	}
+	tree.Insert(100, 100, ti)
+	if err := tree.SaveToWithCodec(&bytes.Buffer{}, StringCodec); !errors.Is(err, ErrCodecType) {
+		t.Errorf("Expected ErrCodecType for an int value, got %v", err)
+	}
+}
+
+// 日志和检查点各自记录编解码器，重新打开时即使选项不同也按记录的编解码器读取
+func TestCodec_WAL(t *testing.T) {
+	dir := t.TempDir()
+	tree, err := OpenWithOptions(dir, WALOptions{Sync: WALSyncNone, BufferSize: 1 << 10, ValueCodec: JSONCodec})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ti := NewThreadInfo(tree.GetEpoche())
+	for k := 0; k < 100; k++ {
+		tree.Insert(k, map[string]interface{}{"v": float64(k)}, ti)
+	}
+	if err := tree.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	tree.Insert(100, []interface{}{"x"}, ti)
+	tree.Close()
+
+	tree, err = OpenWithOptions(dir, WALOptions{Sync: WALSyncNone, BufferSize: 1 << 10, ValueCodec: GobCodec})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ti = NewThreadInfo(tree.GetEpoche())
+	if got := tree.Lookup(42, ti); !reflect.DeepEqual(got, map[string]interface{}{"v": 42.0}) {
+		t.Errorf("Expected the checkpointed value, got %#v", got)
+	}
+	if got := tree.Lookup(100, ti); !reflect.DeepEqual(got, []interface{}{"x"}) {
+		t.Errorf("Expected the logged value, got %#v", got)
+	}
+	tree.Insert(101, 101, ti)
+	tree.Close()
+
+	tree, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if got := tree.Lookup(101, NewThreadInfo(tree.GetEpoche())); got != 101 {
+		t.Errorf("Expected a gob-encoded value, got %#v", got)
+	}
+}
@@ -7,10 +7,12 @@ import (
 )
 
 const (
-	BASENode  NodeType = iota
-	INNERNode NodeType = iota
-	BTreeNode NodeType = iota
-	HashNode  NodeType = iota
+	BASENode       NodeType = iota
+	INNERNode      NodeType = iota
+	BTreeNode      NodeType = iota
+	HashNode       NodeType = iota
+	CompressedNode NodeType = iota // 压缩的只读叶子，见 compress.go
+	AppendNode     NodeType = iota // 只追加的叶子，见 lnode_append.go
 )
 
 const (
@@ -92,7 +94,7 @@ const (
 
 // prod
 const (
-	LNodeHashCardinality  = (LeafHashSize - int(unsafe.Sizeof(Node{})) - int(unsafe.Sizeof(uintptr(0)))) / int(unsafe.Sizeof(Bucket{}))
+	LNodeHashCardinality  = (LeafHashSize - int(unsafe.Sizeof(Node{})) - int(unsafe.Sizeof(uintptr(0)))) / hashBucketBudget
 	LNodeBTreeCardinality = (LeafBTreeSize - int(unsafe.Sizeof(Node{})) - int(unsafe.Sizeof(uintptr(0)))) / int(unsafe.Sizeof(Entry{}))
 	INodeCardinality      = int((PageSize - int(unsafe.Sizeof(Node{})) - int(unsafe.Sizeof(new(interface{})))) / int(unsafe.Sizeof(Entry{})))
 	EntryNum              = 32
@@ -102,6 +104,10 @@ const (
 	Adaption              = true //lnodeHash是否需要转换为bNode
 )
 
+// hashBucketBudget 计算哈希叶子桶数时每个桶占用的字节数，即桶头(锁、状态和指纹、键值两个切片头)的大小。
+// 槽位数组内联进 Bucket 之后不再用 unsafe.Sizeof(Bucket{}) 计算，叶子仍是 4095 个桶、每桶 EntryNum 个槽位
+const hashBucketBudget = 64
+
 // dev
 // const (
 //
@@ -136,3 +142,17 @@ func getCallerInfo(skip int) (funcName, fileName string, line int) {
 	}
 	return funcName, file, line
 }
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,627 @@
+package blinkhash
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+	"sort"
+	"sync/atomic"
+	"unsafe"
+)
+
+// 压缩叶子：只读为主的时序数据叶子。
+//
+// 键是时间戳，按块(compressedBlockSize 个条目)编码：块的第一个键记在块索引中，
+// 第二个键记与第一个键的差(uvarint)，之后每个键记差值的变化量(delta-of-delta，varint)。
+// 等间隔采样时变化量为 0，每个键只占一个字节。
+//
+// 值只支持 float64，按 Gorilla 的 XOR 方案逐位编码：块的第一个值记原始的 64 位，
+// 之后每个值与前一个值异或，结果为 0 时记一位 '0'；有效位落在上一次的前导零和尾随零窗口内时
+// 记 '10' 和窗口内的位；否则记 '11'、5 位前导零数、6 位有效位数和有效位。每个块的值从新的字节开始。
+//
+// 块之间互不依赖，点查询先按块索引二分，再只解码一个块。
+//
+// 压缩叶子创建后不再修改，读者不需要担心读到一半的内容。写入时先把它解压回 LNodeBTree
+// 替换到树中(thaw)，旧叶子标记为过时，写入方重启后落到新叶子上。
+// CompactLeaves 把已满并且自上一次调用以来没有写入过的 LNodeBTree 叶子压缩，
+// 叶子的利用率达到 TreeOptions.CompactMinFill 才会被压缩，默认只压缩已满的叶子
+
+// compressedBlockSize 每个块的条目数
+const compressedBlockSize = 64
+
+// compressedBlock 块索引，count 由块的位置推出：除最后一块外都是 compressedBlockSize
+type compressedBlock struct {
+	first  int    // 块中的第一个键
+	keyOff uint32 // 第二个键在 keys 中的字节位置
+	valOff uint32 // 块的值在 vals 中的字节位置
+}
+
+type LNodeCompressed struct {
+	Node
+	nodeOptions
+	HighKey interface{}
+	n       int
+	blocks  []compressedBlock
+	keys    []byte
+	vals    []byte
+
+	tree *BTree
+	left NodeInterface // 左邻叶子，替换时用作提示，可能已经过时
+}
+
+// newLNodeCompressed 压缩 lb 的内容，调用方持有 lb 的写锁。
+// 叶子为空、已换出或含有 float64 以外的值时返回 nil
+func newLNodeCompressed(lb *LNodeBTree, tree *BTree) *LNodeCompressed {
+	src := lb.loaded()
+	if lb.stub != nil || src.len() == 0 {
+		return nil
+	}
+	n := src.len()
+	c := &LNodeCompressed{
+		Node: Node{
+			siblingPtr: lb.siblingPtr,
+			count:      int32(n),
+			level:      lb.level,
+		},
+		nodeOptions: lb.nodeOptions,
+		HighKey:     lb.HighKey,
+		n:           n,
+		blocks:      make([]compressedBlock, 0, ceilDiv(n, compressedBlockSize)),
+		tree:        tree,
+	}
+	var w bitWriter
+	var x xorEncoder
+	var delta int
+	keys := src.sortedKeys()
+	for i, k := range keys {
+		v, ok := src.values.get(i).(float64)
+		if !ok {
+			return nil
+		}
+		switch j := i % compressedBlockSize; j {
+		case 0:
+			w.align()
+			c.blocks = append(c.blocks, compressedBlock{first: k, keyOff: uint32(len(c.keys)), valOff: uint32(len(w.buf))})
+			x = xorEncoder{w: &w}
+		case 1:
+			delta = k - keys[i-1]
+			c.keys = binary.AppendUvarint(c.keys, uint64(delta))
+		default:
+			d := k - keys[i-1]
+			c.keys = binary.AppendVarint(c.keys, int64(d-delta))
+			delta = d
+		}
+		x.encode(math.Float64bits(v))
+	}
+	c.vals = w.buf
+	return c
+}
+
+// decodeBlock 依次解码第 b 块的条目，fn 返回 false 时停止，返回是否解码完整个块
+func (c *LNodeCompressed) decodeBlock(b int, fn func(key int, value float64) bool) bool {
+	blk := c.blocks[b]
+	n := minInt(compressedBlockSize, c.n-b*compressedBlockSize)
+	kp := c.keys[blk.keyOff:]
+	x := xorDecoder{r: bitReader{data: c.vals[blk.valOff:]}}
+	key, delta := blk.first, 0
+	for i := 0; i < n; i++ {
+		switch i {
+		case 0:
+		case 1:
+			d, m := binary.Uvarint(kp)
+			delta, kp = int(d), kp[m:]
+			key += delta
+		default:
+			dd, m := binary.Varint(kp)
+			delta, kp = delta+int(dd), kp[m:]
+			key += delta
+		}
+		if !fn(key, math.Float64frombits(x.decode())) {
+			return false
+		}
+	}
+	return true
+}
+
+// blockOf 返回可能包含 key 的块，key 小于第一个键时返回 -1
+func (c *LNodeCompressed) blockOf(key int) int {
+	return sort.Search(len(c.blocks), func(i int) bool { return c.blocks[i].first > key }) - 1
+}
+
+// thaw 把压缩叶子解压回 LNodeBTree 替换到树中，version 为调用方读到的版本。
+// 失败时什么也不做，调用方总是重启，之后仍落到压缩叶子上时会再次尝试
+func (c *LNodeCompressed) thaw(version uint64) {
+	bt := c.tree
+	left := c.left
+	if left == nil || left.GetSiblingPtr() != NodeInterface(c) {
+		left = bt.leftOf(c)
+	}
+	leftLeaf, _ := left.(LeafNodeInterface)
+	build := func() LeafNodeInterface {
+		lb := NewLNodeBTreeWithSibling(nil, int32(c.n), c.level)
+		lb.opts = c.opts
+		contents := newLeafContents(maxInt(lb.Cardinality, c.n))
+		for b := range c.blocks {
+			c.decodeBlock(b, func(key int, value float64) bool {
+				contents.appendEntry(key, value)
+				return true
+			})
+		}
+		lb.contents.Store(contents)
+		lb.written = 1
+		return lb
+	}
+	// 写入方只持有只读的 epoch，这里不登记过时的叶子，由 GC 在读者离开后回收
+	repl := bt.replaceLeaf(leftLeaf, c, version, c.blocks[0].first, build, nil)
+	if lb, ok := repl.(*LNodeBTree); ok && bt.pool != nil {
+		bt.pool.adopt(lb)
+	}
+}
+
+// CompactLeaves 把已满并且自上一次调用以来没有写入过的 B 树叶子压缩为 LNodeCompressed，
+// 返回压缩的叶子数。只有值全部是 float64 的叶子会被压缩。
+// 可以与其他操作并发调用，遇到锁冲突的叶子跳过，留给下一次
+func (bt *BTree) CompactLeaves(ti *ThreadInfo) int {
+	eg := NewEpocheGuard(ti)
+	defer eg.Release()
+
+	compacted := 0
+	var left LeafNodeInterface
+	for leaf := bt.firstLeaf(); leaf != nil; leaf = nextLeaf(leaf) {
+		lb, ok := leaf.(*LNodeBTree)
+		// 写过的叶子本次跳过并清除标记，下一次仍没有写入时再压缩
+		if ok && atomic.SwapUint32(&lb.written, 0) == 0 && float64(lb.Len()) >= float64(lb.Cardinality)*bt.opts.CompactMinFill {
+			if version, needRestart := lb.TryReadLock(); !needRestart {
+				build := func() LeafNodeInterface {
+					if c := newLNodeCompressed(lb, bt); c != nil {
+						return c
+					}
+					return nil
+				}
+				first := 0
+				if keys := lb.loaded().sortedKeys(); len(keys) > 0 {
+					first = keys[0]
+				}
+				if repl := bt.replaceLeaf(left, lb, version, first, build, ti); repl != nil {
+					if lb.pool != nil {
+						atomic.AddInt64(&lb.pool.resident, -1)
+					}
+					compacted++
+					leaf = repl
+				}
+			}
+		}
+		left = leaf
+	}
+	return compacted
+}
+
+// replaceLeaf 用 build 的结果替换叶子 old：左邻叶子的兄弟指针和父节点中的孩子指针都改为指向新叶子，
+// old 标记为过时。version 为 old 的读版本，key 为 old 中的任意键，left 为 nil 时 old 必须是最左的叶子。
+// 依次尝试锁住 left、old 和父节点，任何一步失败或 build 返回 nil 时放弃并返回 nil，不会等待。
+// build 在锁住 old 之后调用，新叶子的兄弟指针和上界由这里设置
+func (bt *BTree) replaceLeaf(left, old LeafNodeInterface, version uint64, key int,
+	build func() LeafNodeInterface, ti *ThreadInfo) LeafNodeInterface {
+	if left != nil {
+		if !left.TryWriteLock() {
+			return nil
+		}
+		defer left.WriteUnlock()
+		if left.GetSiblingPtr() != NodeInterface(old) {
+			return nil
+		}
+	}
+	if ok, needRestart := old.TryUpgradeWriteLock(version); !ok || needRestart {
+		return nil
+	}
+	if left == nil && bt.firstLeaf() != old {
+		old.WriteUnlock()
+		return nil
+	}
+	var parent *INode
+	slot := 0
+	if old != bt.root {
+		var parentVersion uint64
+		var ok bool
+		if parent, slot, parentVersion, ok = bt.findParent(old, key); !ok {
+			old.WriteUnlock()
+			return nil
+		}
+		if ok, needRestart := parent.TryUpgradeWriteLock(parentVersion); !ok || needRestart {
+			old.WriteUnlock()
+			return nil
+		}
+		defer parent.WriteUnlock()
+	}
+	repl := build()
+	if repl == nil {
+		old.WriteUnlock()
+		return nil
+	}
+
+	right := old.GetSiblingPtr()
+	repl.GetNode().siblingPtr = right
+	repl.SetHighKey(old.GetHighKey())
+	linkLeft(right, repl)
+	if left != nil {
+		left.SetSibling(repl)
+		linkLeft(repl, left)
+	}
+	switch {
+	case parent == nil:
+		bt.root = repl
+	case slot < 0:
+		parent.leftmostPtr = repl
+	default:
+		parent.Entries[slot].Value = repl
+	}
+	old.WriteUnlockObsolete()
+	if ti != nil {
+		ti.Epoche.MarkNodeForDeletion(old, ti)
+	}
+	return repl
+}
+
+// findParent 返回指向 leaf 的父节点及其读版本，slot 为 leaf 在 Entries 中的下标，-1 表示 leftmostPtr。
+// key 为 leaf 中的任意键，用于下探到父节点所在的位置
+func (bt *BTree) findParent(leaf NodeInterface, key int) (*INode, int, uint64, bool) {
+	cur := bt.root
+	version, needRestart := cur.TryReadLock()
+	if needRestart {
+		return nil, 0, 0, false
+	}
+	for cur.GetLevel() > 1 {
+		child := cur.(INodeInterface).ScanNode(key)
+		childVersion, needRestart := child.TryReadLock()
+		if needRestart {
+			return nil, 0, 0, false
+		}
+		if endVersion, needRestart := cur.GetVersion(); needRestart || endVersion != version {
+			return nil, 0, 0, false
+		}
+		cur, version = child, childVersion
+	}
+	// 父节点可能因分裂移到了右侧的兄弟中
+	for cur != nil && cur.GetLevel() == 1 {
+		in, ok := cur.(*INode)
+		if !ok {
+			return nil, 0, 0, false
+		}
+		slot := 0
+		switch {
+		case in.leftmostPtr == leaf:
+			slot = -1
+		default:
+			slot = len(in.Entries)
+			for i := 0; i < int(in.count) && i < len(in.Entries); i++ {
+				if in.Entries[i].Value == leaf {
+					slot = i
+					break
+				}
+			}
+		}
+		next := in.siblingPtr
+		if endVersion, needRestart := in.GetVersion(); needRestart || endVersion != version {
+			return nil, 0, 0, false
+		}
+		if slot < len(in.Entries) {
+			return in, slot, version, true
+		}
+		if next == nil {
+			break
+		}
+		if version, needRestart = next.TryReadLock(); needRestart {
+			return nil, 0, 0, false
+		}
+		cur = next
+	}
+	return nil, 0, 0, false
+}
+
+// leftOf 沿叶子链找到 leaf 的左邻叶子，leaf 是最左的叶子或不在链上时返回 nil
+func (bt *BTree) leftOf(leaf NodeInterface) NodeInterface {
+	for cur := bt.firstLeaf(); cur != nil; cur = nextLeaf(cur) {
+		if cur.GetSiblingPtr() == leaf {
+			return cur
+		}
+	}
+	return nil
+}
+
+// linkLeft 更新 right 记录的左邻叶子。哈希叶子、压缩叶子和追加叶子需要它来锁住左邻叶子并替换自己
+func linkLeft(right, left NodeInterface) {
+	switch r := right.(type) {
+	case *LNodeHash:
+		r.LeftSiblingPtr = left
+	case *LNodeCompressed:
+		r.left = left
+	case *LNodeAppend:
+		r.left = left
+	}
+}
+
+func (c *LNodeCompressed) GetHighKey() interface{} {
+	return c.HighKey
+}
+
+func (c *LNodeCompressed) SetHighKey(key interface{}) { c.HighKey = key }
+
+// Print 打印压缩叶子的信息和全部条目
+func (c *LNodeCompressed) Print() {
+	fmt.Printf("LNodeCompressed Information:\n")
+	fmt.Printf("HighKey: %v\n", c.HighKey)
+	fmt.Printf("Entries: %d, Blocks: %d, Bytes: %d\n", c.n, len(c.blocks), len(c.keys)+len(c.vals))
+	c.Node.Print()
+	for i, e := range c.GetEntries() {
+		fmt.Printf("\tEntry %d: Key = %v, Value = %v\n", i, e.Key, e.Value)
+	}
+}
+
+// SanityCheck 检查键有序并且不超过上界
+func (c *LNodeCompressed) SanityCheck(_highKey interface{}, first bool) {
+	prev := 0
+	for i, e := range c.GetEntries() {
+		key := e.Key.(int)
+		if i > 0 && key <= prev {
+			fmt.Printf("lnode_compressed:: key order is not preserved: %d after %d\n", key, prev)
+		}
+		if c.siblingPtr != nil && compareIntKeys(key, c.HighKey) > 0 {
+			fmt.Printf("lnode_compressed:: (%v) is higher than high Key %v\n", key, c.HighKey)
+		}
+		prev = key
+	}
+	if c.siblingPtr != nil {
+		c.siblingPtr.SanityCheck(c.HighKey, false)
+	}
+}
+
+// Insert 压缩叶子不能直接写入，解压后让调用方重启
+func (c *LNodeCompressed) Insert(key interface{}, value interface{}, version uint64) int {
+	c.thaw(version)
+	return NeedRestart
+}
+
+// Split 压缩叶子的 Insert 不会返回 NeedSplit，返回 nil 让调用方重启
+func (c *LNodeCompressed) Split(key interface{}, value interface{}, version uint64) (Splittable, interface{}) {
+	return nil, nil
+}
+
+// Update key 存在时解压后让调用方重启
+func (c *LNodeCompressed) Update(key interface{}, value interface{}, version uint64) int {
+	if _, ok := c.Find(key); !ok {
+		return c.missing(version, UpdateFailure)
+	}
+	c.thaw(version)
+	return NeedRestart
+}
+
+// Remove key 存在时解压后让调用方重启
+func (c *LNodeCompressed) Remove(key interface{}, version uint64) int {
+	if _, ok := c.Find(key); !ok {
+		return c.missing(version, KeyNotFound)
+	}
+	c.thaw(version)
+	return NeedRestart
+}
+
+// missing 在 key 不存在时校验版本，叶子已被替换时返回 NeedRestart
+func (c *LNodeCompressed) missing(version uint64, ret int) int {
+	if endVersion, needRestart := c.GetVersion(); needRestart || endVersion != version {
+		return NeedRestart
+	}
+	return ret
+}
+
+func (c *LNodeCompressed) Find(key interface{}) (interface{}, bool) {
+	k, ok := intKey(key)
+	if !ok {
+		return nil, false
+	}
+	b := c.blockOf(k)
+	if b < 0 {
+		return nil, false
+	}
+	var value interface{}
+	found := false
+	c.decodeBlock(b, func(key int, v float64) bool {
+		if key < k {
+			return true
+		}
+		if key == k {
+			value, found = v, true
+		}
+		return false
+	})
+	return value, found
+}
+
+func (c *LNodeCompressed) RangeLookUp(key interface{}, upTo int, continued bool, version uint64) ([]interface{}, int, int) {
+	entries, retCode, count := c.RangeLookUpEntries(key, upTo, continued, version)
+	return entryValues(entries), retCode, count
+}
+
+// RangeLookUpEntries 从第一个不小于 key 的条目开始收集，continued 时从头开始
+func (c *LNodeCompressed) RangeLookUpEntries(key interface{}, upTo int, continued bool, version uint64) ([]Entry, int, int) {
+	start, b := math.MinInt, 0
+	if !continued {
+		keyInt, ok := key.(int)
+		if !ok {
+			panic("RangeLookUpEntries: key is not of type int")
+		}
+		start, b = keyInt, maxInt(c.blockOf(keyInt), 0)
+	}
+	if upTo <= 0 {
+		return nil, 0, 0
+	}
+	collected := make([]Entry, 0, minInt(upTo, c.n))
+	for ; b < len(c.blocks) && len(collected) < upTo; b++ {
+		c.decodeBlock(b, func(key int, v float64) bool {
+			if key >= start {
+				collected = append(collected, Entry{Key: key, Value: v})
+			}
+			return len(collected) < upTo
+		})
+	}
+	return collected, 0, len(collected)
+}
+
+func (c *LNodeCompressed) GetEntries() []Entry {
+	entries := make([]Entry, 0, c.n)
+	for b := range c.blocks {
+		c.decodeBlock(b, func(key int, v float64) bool {
+			entries = append(entries, Entry{Key: key, Value: v})
+			return true
+		})
+	}
+	return entries
+}
+
+// Utilization 压缩叶子没有空闲槽位
+func (c *LNodeCompressed) Utilization() float64 {
+	return 1
+}
+
+// Footprint 按编码后的大小计入键值数据，并记录与同样条目的 LNodeBTree 相比节省的字节数
+func (c *LNodeCompressed) Footprint(metrics *FootprintMetrics) {
+	size := uint64(len(c.keys)+len(c.vals)) + uint64(unsafe.Sizeof(compressedBlock{}))*uint64(len(c.blocks))
+	raw := uint64(unsafe.Sizeof(int(0))*2) * uint64(c.n)
+	metrics.KeyDataOccupied += size
+	metrics.CompressedLeaves++
+	metrics.CompressedBytes += size
+	if raw > size {
+		metrics.CompressionSaved += raw - size
+	}
+}
+
+func (c *LNodeCompressed) GetNode() *Node {
+	return &c.Node
+}
+
+func (c *LNodeCompressed) GetType() NodeType {
+	return CompressedNode
+}
+
+func (c *LNodeCompressed) GetCardinality() int {
+	return c.n
+}
+
+func (c *LNodeCompressed) SetSibling(sibling LeafNodeInterface) {
+	c.siblingPtr = sibling
+}
+
+// bitWriter 按位追加，高位在前
+type bitWriter struct {
+	buf  []byte
+	free uint // 最后一个字节中剩余的位数
+}
+
+func (w *bitWriter) writeBits(v uint64, n uint) {
+	for n > 0 {
+		if w.free == 0 {
+			w.buf = append(w.buf, 0)
+			w.free = 8
+		}
+		take := n
+		if take > w.free {
+			take = w.free
+		}
+		chunk := byte(v>>(n-take)) & byte(1<<take-1)
+		w.buf[len(w.buf)-1] |= chunk << (w.free - take)
+		w.free -= take
+		n -= take
+	}
+}
+
+// align 之后的位从新的字节开始
+func (w *bitWriter) align() {
+	w.free = 0
+}
+
+// bitReader 按位读取 bitWriter 写入的内容，越界时读到 0
+type bitReader struct {
+	data []byte
+	pos  uint
+}
+
+func (r *bitReader) readBits(n uint) uint64 {
+	var v uint64
+	for n > 0 {
+		idx := r.pos >> 3
+		if idx >= uint(len(r.data)) {
+			return v << n
+		}
+		avail := 8 - r.pos&7
+		take := n
+		if take > avail {
+			take = avail
+		}
+		chunk := uint64(r.data[idx]>>(avail-take)) & (1<<take - 1)
+		v = v<<take | chunk
+		r.pos += take
+		n -= take
+	}
+	return v
+}
+
+// xorEncoder Gorilla 的值编码，见文件开头
+type xorEncoder struct {
+	w               *bitWriter
+	prev            uint64
+	started         bool
+	leading, sigLen uint // 上一次写出的窗口，sigLen 为 0 时没有窗口
+}
+
+func (x *xorEncoder) encode(v uint64) {
+	if !x.started {
+		x.w.writeBits(v, 64)
+		x.prev, x.started = v, true
+		return
+	}
+	xor := v ^ x.prev
+	x.prev = v
+	if xor == 0 {
+		x.w.writeBits(0, 1)
+		return
+	}
+	leading := uint(bits.LeadingZeros64(xor))
+	trailing := uint(bits.TrailingZeros64(xor))
+	if leading > 31 {
+		leading = 31
+	}
+	if x.sigLen != 0 && leading >= x.leading && trailing >= 64-x.leading-x.sigLen {
+		x.w.writeBits(0b10, 2)
+		x.w.writeBits(xor>>(64-x.leading-x.sigLen), x.sigLen)
+		return
+	}
+	sigLen := 64 - leading - trailing
+	x.w.writeBits(0b11, 2)
+	x.w.writeBits(uint64(leading), 5)
+	x.w.writeBits(uint64(sigLen-1), 6)
+	x.w.writeBits(xor>>trailing, sigLen)
+	x.leading, x.sigLen = leading, sigLen
+}
+
+// xorDecoder 解码 xorEncoder 写出的值
+type xorDecoder struct {
+	r               bitReader
+	prev            uint64
+	started         bool
+	leading, sigLen uint
+}
+
+func (x *xorDecoder) decode() uint64 {
+	if !x.started {
+		x.prev, x.started = x.r.readBits(64), true
+		return x.prev
+	}
+	if x.r.readBits(1) == 0 {
+		return x.prev
+	}
+	if x.r.readBits(1) == 1 {
+		x.leading = uint(x.r.readBits(5))
+		x.sigLen = uint(x.r.readBits(6)) + 1
+	}
+	x.prev ^= x.r.readBits(x.sigLen) << (64 - x.leading - x.sigLen)
+	return x.prev
+}
@@ -0,0 +1,214 @@
+package blinkhash
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// newFloatTree 建立 n 个 float64 条目，键为 k*10，并把哈希叶子全部转换为 B 树叶子
+func newFloatTree(n int) (*BTree, *ThreadInfo) {
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	for k := 0; k < n; k++ {
+		tree.Insert(k*10, float64(k)/4, ti)
+	}
+	tree.RangeLookup(0, n, ti)
+	return tree, ti
+}
+
+func TestCompress_RoundTrip(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	patterns := map[string]func(i int) (int, float64){
+		"regular":  func(i int) (int, float64) { return 1700000000 + i*60, 21.5 },
+		"drifting": func(i int) (int, float64) { return i*1000 + rnd.Intn(7), 20 + math.Sin(float64(i)/10) },
+		"random":   func(i int) (int, float64) { return i * 3, rnd.NormFloat64() * 1e6 },
+		"special": func(i int) (int, float64) {
+			vals := []float64{0, math.Copysign(0, -1), math.Inf(1), math.Inf(-1), math.MaxFloat64, math.SmallestNonzeroFloat64}
+			return i - 100, vals[i%len(vals)]
+		},
+		"extremes": func(i int) (int, float64) {
+			keys := []int{math.MinInt, -1, 0, 1, math.MaxInt / 2, math.MaxInt}
+			return keys[i], float64(i)
+		},
+	}
+	for name, gen := range patterns {
+		lb := NewLNodeBTree(0)
+		for i := 0; i < 200; i++ {
+			if name == "extremes" && i >= 6 {
+				break
+			}
+			k, v := gen(i)
+			lb.putLocked(k, v)
+		}
+		c := newLNodeCompressed(lb, nil)
+		if c == nil {
+			t.Fatalf("%s: expected the leaf to compress", name)
+		}
+		got := c.GetEntries()
+		if len(got) != lb.Len() {
+			t.Fatalf("%s: expected %d entries, got %d", name, lb.Len(), len(got))
+		}
+		for i, e := range got {
+			want := lb.loaded().entry(i)
+			if e.Key != want.Key || math.Float64bits(e.Value.(float64)) != math.Float64bits(want.Value.(float64)) {
+				t.Fatalf("%s: entry %d: expected %v=%v, got %v=%v", name, i, want.Key, want.Value, e.Key, e.Value)
+			}
+			if v, ok := c.Find(want.Key); !ok || math.Float64bits(v.(float64)) != math.Float64bits(want.Value.(float64)) {
+				t.Fatalf("%s: find %v: got %v, %v", name, want.Key, v, ok)
+			}
+		}
+		if from := lb.loaded().keys[lb.Len()/2]; from > math.MinInt {
+			if _, ok := c.Find(from - 1); ok && lb.findPos(from-1) < 0 {
+				t.Errorf("%s: found a missing key %d", name, from-1)
+			}
+			entries, _, _ := c.RangeLookUpEntries(from, 70, false, 0)
+			if len(entries) == 0 || entries[0].Key != from || len(entries) > 70 {
+				t.Errorf("%s: range from %d returned %d entries", name, from, len(entries))
+			}
+		}
+	}
+
+	lb := NewLNodeBTree(0)
+	lb.putLocked(1, 1.0)
+	lb.putLocked(2, "not a float")
+	if newLNodeCompressed(lb, nil) != nil {
+		t.Errorf("Expected a leaf with non-float values to stay uncompressed")
+	}
+}
+
+func TestCompress_CompactAndThaw(t *testing.T) {
+	const n = 10000
+	tree, ti := newFloatTree(n)
+	var before FootprintMetrics
+	tree.Footprint(&before)
+
+	compacted := tree.CompactLeaves(ti)
+	if compacted == 0 {
+		t.Fatal("Expected some leaves to be compacted")
+	}
+	var after FootprintMetrics
+	tree.Footprint(&after)
+	if after.CompressedLeaves != uint64(compacted) || after.CompressionSaved == 0 || after.KeyDataOccupied >= before.KeyDataOccupied {
+		t.Errorf("Expected the footprint to shrink, before %+v, after %+v", before, after)
+	}
+
+	for k := 0; k < n; k += 7 {
+		if got := tree.Lookup(k*10, ti); got != float64(k)/4 {
+			t.Fatalf("key %d: expected %v, got %v", k*10, float64(k)/4, got)
+		}
+		if got := tree.Lookup(k*10+1, ti); got != nil {
+			t.Fatalf("key %d: expected a missing key, got %v", k*10+1, got)
+		}
+	}
+	entries := tree.RangeLookupEntries(5, n, ti)
+	if len(entries) != n-1 || entries[0].Key != 10 {
+		t.Fatalf("Expected %d entries from key 10, got %d", n-1, len(entries))
+	}
+
+	// 写入压缩叶子时先解压，未命中的更新和删除不解压
+	if tree.Update(15, 1.0, ti) || tree.Remove(15, ti) {
+		t.Errorf("Expected missing keys to be reported")
+	}
+	tree.Insert(15, 1.5, ti)
+	tree.Update(20, "two", ti)
+	tree.Remove(30, ti)
+	wb := tree.NewWriteBatch()
+	wb.Put(n*5, -1.0)
+	wb.Commit(ti)
+	var thawed FootprintMetrics
+	tree.Footprint(&thawed)
+	if thawed.CompressedLeaves >= after.CompressedLeaves {
+		t.Errorf("Expected writes to thaw compressed leaves, %d before, %d after", after.CompressedLeaves, thawed.CompressedLeaves)
+	}
+	for k, want := range map[int]interface{}{15: 1.5, 20: "two", 30: nil, n * 5: -1.0, 40: 1.0} {
+		if got := tree.Lookup(k, ti); got != want {
+			t.Errorf("key %d: expected %v, got %v", k, want, got)
+		}
+	}
+
+	// 刚写过的叶子下一次跳过，再下一次才压缩；含有字符串的叶子始终不压缩
+	tree.Update(40, 2.0, ti)
+	if got := tree.CompactLeaves(ti); got != 0 {
+		t.Errorf("Expected recently written leaves to be skipped, compacted %d", got)
+	}
+	if got := tree.CompactLeaves(ti); got == 0 {
+		t.Errorf("Expected idle leaves to be compacted on the next pass")
+	}
+	if got := tree.Lookup(40, ti); got != 2.0 {
+		t.Errorf("key 40: expected 2, got %v", got)
+	}
+	if got := len(tree.RangeLookup(math.MinInt, 2*n, ti)); got != n {
+		t.Errorf("Expected %d entries, got %d", n, got)
+	}
+}
+
+func TestCompress_Concurrent(t *testing.T) {
+	const n, threads = 8000, 6
+	tree, _ := newFloatTree(n)
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	errs := make(chan string, threads)
+	go func() {
+		ti := NewThreadInfo(tree.GetEpoche())
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				tree.CompactLeaves(ti)
+			}
+		}
+	}()
+	for w := 0; w < threads; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			ti := NewThreadInfo(tree.GetEpoche())
+			for i := 0; i < 2000; i++ {
+				k := (i*7919 + w*131) % n
+				if w%3 == 0 {
+					// 写线程只写自己负责的键，值不变
+					k = k - k%threads + w
+					tree.Update(k*10, float64(k)/4, ti)
+					continue
+				}
+				if got := tree.Lookup(k*10, ti); got != float64(k)/4 {
+					errs <- "lookup returned a wrong value"
+					return
+				}
+				if got := tree.RangeLookupEntries(k*10, 20, ti); len(got) == 0 || got[0].Key != k*10 {
+					errs <- "range lookup missed its first key"
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(stop)
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	ti := NewThreadInfo(tree.GetEpoche())
+	if got := len(tree.RangeLookup(math.MinInt, 2*n, ti)); got != n {
+		t.Errorf("Expected %d entries, got %d", n, got)
+	}
+}
+
+// BenchmarkCompressedLookup 比较压缩前后的随机点查询
+func BenchmarkCompressedLookup(b *testing.B) {
+	const n = 100000
+	for _, compact := range []bool{false, true} {
+		tree, ti := newFloatTree(n)
+		if compact {
+			tree.CompactLeaves(ti)
+		}
+		b.Run(map[bool]string{false: "btree", true: "compressed"}[compact], func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				tree.Lookup(i*7919%n*10, ti)
+			}
+		})
+	}
+}
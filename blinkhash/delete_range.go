@@ -0,0 +1,201 @@
+package blinkhash
+
+import "runtime"
+
+// DeleteRange 删除键在 [lo, hi) 内的全部条目，返回删除的条目数(包括已过期但还没有删除的条目)。
+//
+// 用于删除一段错误写入的数据，例如某个传感器的一个时间窗口。与 TruncateBefore 相同，按节点而不是按条目删除：
+// 分别找到 lo 和 hi-1 所在的叶子，两条路径在某个内部节点分开，每层位于两条路径之间的节点整棵摘下，
+// 在一次结构修改中完成。两条路径分开处删去中间的孩子和分隔键，原来属于中间部分的键区间并入左路径：
+// 左路径上的节点删去右侧的孩子，上界扩大到右路径的下界，兄弟指针直接指向右路径上同一层的节点；
+// 右路径上的节点删去左侧的孩子。两个边界叶子(B 树叶子或追加叶子)分别删去范围内的后缀和前缀，
+// 两个边界在同一个叶子中时只删除叶子中间的一段。
+// 执行期间挡住所有写入，摘下的节点以 WriteUnlockObsolete 解锁，读者通过节点版本发现变化后重试。树的高度不变
+func (bt *BTree) DeleteRange(lo, hi interface{}, ti *ThreadInfo) int {
+	l, h := lo.(int), hi.(int)
+	if l >= h {
+		return 0
+	}
+	bt.lockWrites()
+	defer bt.unlockWrites()
+	if !bt.logLocked(bt.walRangeRecord(l, h)) {
+		return 0
+	}
+	return bt.deleteRange(l, h, ti)
+}
+
+// deleteRange 在调用方挡住所有写入时执行范围删除，不写日志
+func (bt *BTree) deleteRange(lo, hi int, ti *ThreadInfo) int {
+	eg := NewEpocheGuard(ti)
+	defer eg.Release()
+	for {
+		if bt.Err() != nil {
+			return 0
+		}
+		if d, ok := bt.lockDeletion(lo, hi, ti); ok {
+			return d.apply(bt, ti)
+		}
+		runtime.Gosched()
+	}
+}
+
+// rangeDeletion 一次范围删除锁住的节点。kept 中依次是从根到分开处的共同路径，以及左右两条路径上的节点。
+// fork 为两条路径分开的内部节点，forkL、forkR 为两条路径在其中的孩子下标；
+// 两个边界在同一个叶子中时 fork 为 nil，共同路径的末端就是这个叶子，[fromL, toL) 为其中要删除的条目。
+// left、right 为 fork 之下两条路径上的节点，末端为边界叶子，leftSlots、rightSlots 为路径在其中内部节点里的孩子下标，
+// [fromL, toL) 和 [fromR, toR) 分别为两个边界叶子中要删除的条目
+type rangeDeletion struct {
+	detachment
+	fork                  *INode
+	forkL, forkR          int
+	left, right           []NodeInterface
+	leftSlots, rightSlots []int
+	fromL, toL            int
+	fromR, toR            int
+}
+
+// lockDeletion 自顶向下锁住两条路径和要摘下的节点，加锁和重试的方式与 lockTruncation 相同。
+// 左边界叶子的上界和兄弟指针要被修改，因此除了有要删除条目的边界叶子，
+// 左边界叶子是哈希叶子或压缩叶子时也要先转换或解压
+func (bt *BTree) lockDeletion(lo, hi int, ti *ThreadInfo) (*rangeDeletion, bool) {
+	d := &rangeDeletion{}
+	last := hi - 1
+	root := bt.root
+	if !d.lockKept(root) {
+		return nil, false
+	}
+	if bt.root != root {
+		d.unlock()
+		return nil, false
+	}
+
+	// 两条路径分开之前只锁共同路径
+	cur := root
+	for cur.GetLevel() > 0 {
+		in, ok := cur.(*INode)
+		if !ok {
+			d.unlock()
+			return nil, false
+		}
+		sl, sr := in.FindLowerBound(lo), in.FindLowerBound(last)
+		if sl == sr {
+			child := childAt(in, sl)
+			if !d.lockKept(child) {
+				return nil, false
+			}
+			if !covers(child, last) {
+				d.unlock()
+				return nil, false
+			}
+			cur = child
+			continue
+		}
+		d.fork, d.forkL, d.forkR = in, sl, sr
+		break
+	}
+	if d.fork == nil {
+		from, to, ok := leafSpanLocked(cur, lo, hi, false)
+		if !ok {
+			d.unlock()
+			bt.prepareLeaf(cur, ti)
+			return nil, false
+		}
+		d.fromL, d.toL = from, to
+		return d, true
+	}
+
+	// 分开之后每层依次锁住左路径上的节点、两条路径之间的节点和右路径上的节点
+	level := appendChildren(nil, d.fork, d.forkL+1, d.forkR)
+	l, r := childAt(d.fork, d.forkL), childAt(d.fork, d.forkR)
+	for {
+		if !d.lockKept(l) || !d.lockDetached(level) || !d.lockKept(r) {
+			return nil, false
+		}
+		d.left, d.right = append(d.left, l), append(d.right, r)
+		if !linked(append([]NodeInterface{l}, level...), r) || !covers(r, last) {
+			d.unlock()
+			return nil, false
+		}
+		if l.GetLevel() == 0 {
+			break
+		}
+		li, ok := l.(*INode)
+		ri, ok2 := r.(*INode)
+		if !ok || !ok2 {
+			d.unlock()
+			return nil, false
+		}
+		sl, sr := li.FindLowerBound(lo), ri.FindLowerBound(last)
+		next := appendChildren(nil, li, sl+1, int(li.count))
+		for _, n := range level {
+			in := n.(*INode)
+			next = appendChildren(next, in, -1, int(in.count))
+		}
+		next = appendChildren(next, ri, -1, sr)
+		d.leftSlots, d.rightSlots = append(d.leftSlots, sl), append(d.rightSlots, sr)
+		level, l, r = next, childAt(li, sl), childAt(ri, sr)
+	}
+
+	fromL, toL, ok := leafSpanLocked(l, lo, hi, true)
+	if !ok {
+		d.unlock()
+		bt.prepareLeaf(l, ti)
+		return nil, false
+	}
+	fromR, toR, ok := leafSpanLocked(r, lo, hi, false)
+	if !ok {
+		d.unlock()
+		bt.prepareLeaf(r, ti)
+		return nil, false
+	}
+	d.fromL, d.toL, d.fromR, d.toR = fromL, toL, fromR, toR
+	return d, true
+}
+
+// apply 修改锁住的节点并释放所有的锁，返回删除的条目数
+func (d *rangeDeletion) apply(bt *BTree, ti *ThreadInfo) int {
+	removed, recs := d.collect(bt, nil)
+	if d.fork == nil {
+		trimmed, recs := trimLeaf(bt, d.kept[len(d.kept)-1], d.fromL, d.toL, recs)
+		d.release(bt, recs, ti)
+		return removed + trimmed
+	}
+
+	l, r := d.left[len(d.left)-1], d.right[len(d.right)-1]
+	trimmed, recs := trimLeaf(bt, l, d.fromL, d.toL, recs)
+	removed += trimmed
+	trimmed, recs = trimLeaf(bt, r, d.fromR, d.toR, recs)
+	removed += trimmed
+
+	// 右路径的下界是 fork 中右路径孩子的分隔键
+	sep := d.fork.Entries[d.forkR].Key
+	d.fork.dropBetween(d.forkL, d.forkR)
+	for i := range d.left {
+		if i < len(d.leftSlots) {
+			d.left[i].(*INode).dropAfter(d.leftSlots[i])
+			if slot := d.rightSlots[i]; slot >= 0 {
+				d.right[i].(*INode).dropBefore(slot)
+			}
+		}
+		d.left[i].(HighkeySetter).SetHighKey(sep)
+		baseNode(d.left[i]).siblingPtr = d.right[i]
+	}
+	linkLeft(r, l)
+	d.release(bt, recs, ti)
+	return removed
+}
+
+// dropBetween 删除下标在 (from, to) 之间的孩子和它们的分隔键，下标为 from 的孩子的上界变为原来
+// 下标为 to 的孩子的分隔键，调用方持有写锁
+func (in *INode) dropBetween(from, to int) {
+	count := int(in.count)
+	n := from + 1 + copy(in.Entries[from+1:], in.Entries[to:count])
+	in.clearTail(n, count)
+	in.count = int32(n)
+}
+
+// dropAfter 删除 slot 之后的孩子和分隔键，调用方持有写锁
+func (in *INode) dropAfter(slot int) {
+	in.clearTail(slot+1, int(in.count))
+	in.count = int32(slot + 1)
+}
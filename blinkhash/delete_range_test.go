@@ -0,0 +1,264 @@
+package blinkhash
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// checkKeys 检查树中的条目恰好是 want 中的键，值等于键，并且每个键都能查到
+func checkKeys(t *testing.T, tree *BTree, ti *ThreadInfo, want map[int]bool) {
+	t.Helper()
+	keys := make([]int, 0, len(want))
+	for k := range want {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	entries := tree.RangeLookupEntries(math.MinInt, len(keys)+1, ti)
+	if len(entries) != len(keys) {
+		t.Fatalf("Expected %d entries, got %d", len(keys), len(entries))
+	}
+	for i, e := range entries {
+		if e.Key != keys[i] || e.Value != keys[i] {
+			t.Fatalf("entry %d: expected %d, got %v=%v", i, keys[i], e.Key, e.Value)
+		}
+	}
+	for _, k := range keys {
+		if got := tree.Lookup(k, ti); got != k {
+			t.Fatalf("key %d: expected %d, got %v", k, k, got)
+		}
+	}
+}
+
+// deleteFrom 从 want 中删除 [lo, hi) 内的键，返回删除的个数
+func deleteFrom(want map[int]bool, lo, hi int) int {
+	n := 0
+	for k := range want {
+		if k >= lo && k < hi {
+			delete(want, k)
+			n++
+		}
+	}
+	return n
+}
+
+func TestDeleteRange_Basic(t *testing.T) {
+	for _, appendLeaves := range []bool{false, true} {
+		t.Run(map[bool]string{false: "btree", true: "append"}[appendLeaves], func(t *testing.T) {
+			const n = 20000
+			tree := newTestTree(appendLeaves)
+			ti := NewThreadInfo(tree.GetEpoche())
+			want := make(map[int]bool)
+			for k := 0; k < n; k += 2 {
+				tree.Insert(k, k, ti)
+				want[k] = true
+			}
+			if !appendLeaves {
+				tree.ConvertAll(ti)
+			}
+			height := tree.GetHeight()
+			leaves := leafCount(tree)
+
+			ranges := [][2]int{
+				{10, 10}, {11, 5}, // 空区间
+				{101, 103},           // 一个叶子中间的一个键
+				{5000, 15000},        // 跨越多个内部节点
+				{4001, 15001},        // 边界落在上一次删除的两侧
+				{-100, 3},            // 最左的叶子
+				{n - 7, math.MaxInt}, // 最右的叶子
+				{15001, 15003},
+			}
+			for _, r := range ranges {
+				if got, exp := tree.DeleteRange(r[0], r[1], ti), deleteFrom(want, r[0], r[1]); got != exp {
+					t.Fatalf("delete [%d, %d): expected %d removed, got %d", r[0], r[1], exp, got)
+				}
+				checkKeys(t, tree, ti, want)
+			}
+			if got := leafCount(tree); got > leaves*2/3 {
+				t.Errorf("Expected whole leaves to be detached, %d of %d left", got, leaves)
+			}
+			if tree.GetHeight() != height {
+				t.Errorf("Expected the height to stay %d, got %d", height, tree.GetHeight())
+			}
+
+			// 被删除的区间仍然可以写入
+			for k := 4001; k < 15001; k += 3 {
+				tree.Insert(k, k, ti)
+				want[k] = true
+			}
+			checkKeys(t, tree, ti, want)
+
+			rnd := rand.New(rand.NewSource(1))
+			for i := 0; i < 200; i++ {
+				lo := rnd.Intn(n + 100)
+				hi := lo + rnd.Intn(1+rnd.Intn(n/4))
+				if got, exp := tree.DeleteRange(lo, hi, ti), deleteFrom(want, lo, hi); got != exp {
+					t.Fatalf("delete [%d, %d): expected %d removed, got %d", lo, hi, exp, got)
+				}
+				for j := 0; j < 50; j++ {
+					k := rnd.Intn(n)
+					if !want[k] {
+						tree.Insert(k, k, ti)
+						want[k] = true
+					}
+				}
+			}
+			checkKeys(t, tree, ti, want)
+			if got := tree.DeleteRange(math.MinInt, math.MaxInt, ti); got != len(want) {
+				t.Errorf("Expected %d entries removed, got %d", len(want), got)
+			}
+			checkKeys(t, tree, ti, nil)
+		})
+	}
+}
+
+func TestDeleteRange_LeafTypes(t *testing.T) {
+	// 只有一个哈希叶子的树：叶子先被转换
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	want := make(map[int]bool)
+	for k := 0; k < 100; k++ {
+		tree.Insert(k, k, ti)
+		want[k] = true
+	}
+	if got := tree.DeleteRange(200, 300, ti); got != 0 || tree.root.GetType() != HashNode {
+		t.Errorf("Expected an empty range to leave the hash leaf alone, removed %d", got)
+	}
+	if got := tree.DeleteRange(40, 60, ti); got != deleteFrom(want, 40, 60) {
+		t.Errorf("Expected 20 entries removed from the root leaf, got %d", got)
+	}
+	checkKeys(t, tree, ti, want)
+
+	// 多个哈希叶子：边界叶子先被转换，中间的叶子直接摘下
+	tree = NewBTree()
+	ti = NewThreadInfo(tree.GetEpoche())
+	want = make(map[int]bool)
+	for k := 0; k < 20000; k++ {
+		tree.Insert(k, k, ti)
+		want[k] = true
+	}
+	if got := tree.DeleteRange(3333, 16666, ti); got != deleteFrom(want, 3333, 16666) {
+		t.Errorf("Expected %d entries removed, got %d", 16666-3333, got)
+	}
+	checkKeys(t, tree, ti, want)
+
+	// 边界落在压缩叶子中时先解压
+	ftree, fti := newFloatTree(10000)
+	if ftree.CompactLeaves(fti) == 0 {
+		t.Fatal("Expected some leaves to be compacted")
+	}
+	if got := ftree.DeleteRange(20005, 80005, fti); got != 6000 {
+		t.Errorf("Expected 6000 entries removed, got %d", got)
+	}
+	if ftree.Lookup(20000, fti) != float64(2000)/4 || ftree.Lookup(20010, fti) != nil || ftree.Lookup(80010, fti) != float64(8001)/4 {
+		t.Errorf("Expected only the range to be removed")
+	}
+	if got := len(ftree.RangeLookup(math.MinInt, 20000, fti)); got != 4000 {
+		t.Errorf("Expected 4000 entries left, got %d", got)
+	}
+}
+
+func TestDeleteRange_SnapshotAndWAL(t *testing.T) {
+	dir := t.TempDir()
+	tree, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ti := NewThreadInfo(tree.GetEpoche())
+	for k := 0; k < 5000; k++ {
+		tree.Insert(k, k, ti)
+	}
+	tree.ConvertAll(ti)
+	snap := tree.Snapshot()
+	if got := tree.DeleteRange(1000, 4000, ti); got != 3000 {
+		t.Fatalf("Expected 3000 entries removed, got %d", got)
+	}
+	// 快照仍然看到删除之前的全部条目
+	if got := len(snap.RangeLookupEntries(0, 10000, ti)); got != 5000 {
+		t.Errorf("Expected the snapshot to keep 5000 entries, got %d", got)
+	}
+	if got := snap.Lookup(2500, ti); got != 2500 {
+		t.Errorf("Expected the snapshot to see deleted key 2500, got %v", got)
+	}
+	snap.Release()
+	tree.Insert(2000, "after", ti)
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	ti = NewThreadInfo(tree.GetEpoche())
+	entries := tree.RangeLookupEntries(math.MinInt, 10000, ti)
+	if len(entries) != 2001 || entries[999].Key != 999 || entries[1000].Value != "after" || entries[1001].Key != 4000 {
+		t.Errorf("Expected the range delete to be replayed, got %d entries", len(entries))
+	}
+}
+
+// 读者只访问不会被删除的键，删除者不断删除窗口，同时在尾部追加
+func TestDeleteRange_Concurrent(t *testing.T) {
+	const n, window = 40000, 1000
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	for k := 0; k < n; k++ {
+		tree.Insert(k, k, ti)
+	}
+	tree.ConvertAll(ti)
+
+	// 每 2*window 个键中后一半被删除，读者只读前一半
+	var done int32
+	var wg sync.WaitGroup
+	errs := make(chan string, 8)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ti := NewThreadInfo(tree.GetEpoche())
+		for k := n; atomic.LoadInt32(&done) == 0; k++ {
+			tree.Insert(k, k, ti)
+		}
+	}()
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			ti := NewThreadInfo(tree.GetEpoche())
+			rnd := rand.New(rand.NewSource(int64(r)))
+			for atomic.LoadInt32(&done) == 0 {
+				base := rnd.Intn(n/window/2) * 2 * window
+				k := base + rnd.Intn(window)
+				if got := tree.Lookup(k, ti); got != k {
+					errs <- "lookup missed a key outside the deleted windows"
+					return
+				}
+				got := tree.RangeLookupEntries(k, window, ti)
+				if len(got) < base+window-k || got[0].Key != k || got[base+window-k-1].Key != base+window-1 {
+					errs <- "range lookup missed a key outside the deleted windows"
+					return
+				}
+			}
+		}(r)
+	}
+	removed := 0
+	for base := window; base < n; base += 2 * window {
+		removed += tree.DeleteRange(base, base+window, ti)
+	}
+	atomic.StoreInt32(&done, 1)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if removed != n/2 {
+		t.Errorf("Expected %d entries removed, got %d", n/2, removed)
+	}
+	entries := tree.RangeLookupEntries(0, n/2, ti)
+	if len(entries) != n/2 || entries[window].Key != 2*window || entries[n/2-1].Key != n-window-1 {
+		t.Errorf("Expected %d entries left below %d", n/2, n)
+	}
+}
@@ -8,3 +8,15 @@ type Entry struct {
 
 // Empty 是用来表示空键的全局变量，这里以 interface{} 类型实现以模拟模板功能。
 var Empty interface{} = nil
+
+// entryValues 提取条目中的值，保持原有顺序
+func entryValues(entries []Entry) []interface{} {
+	if entries == nil {
+		return nil
+	}
+	values := make([]interface{}, len(entries))
+	for i, entry := range entries {
+		values[i] = entry.Value
+	}
+	return values
+}
@@ -88,6 +88,8 @@ type Epoche struct {
 type ThreadInfo struct {
 	Epoche       *Epoche
 	DeletionList *DeletionList
+	staged       []Entry    // 摄入模式下尚未刷新的条目，见 BTree.IngestInsert
+	finger       leafFinger // 上一次访问的叶子，见 finger.go
 }
 
 // NewEpoche creates a new Epoche instance with the specified StartGCThreshold.
@@ -130,6 +132,15 @@ func (e *Epoche) MarkNodeForDeletion(n NodeInterface, ti *ThreadInfo) {
 	ti.DeletionList.ThresholdCounter++
 }
 
+// MarkNodesForDeletion marks a batch of detached nodes for deletion in one epoch.
+func (e *Epoche) MarkNodesForDeletion(nodes []NodeInterface, ti *ThreadInfo) {
+	currentEpoche := atomic.LoadUint64(&e.CurrentEpoche)
+	for _, n := range nodes {
+		ti.DeletionList.Add(n, currentEpoche)
+	}
+	ti.DeletionList.ThresholdCounter += len(nodes)
+}
+
 // ExitEpocheAndCleanup marks the end of an epoch and performs cleanup if necessary.
 func (e *Epoche) ExitEpocheAndCleanup(ti *ThreadInfo) {
 	dl := ti.DeletionList
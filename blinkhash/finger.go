@@ -0,0 +1,84 @@
+package blinkhash
+
+// fingerMaxHops 从记住的叶子出发最多向右移动的兄弟数，
+// 顺序访问越过 HighKey 时通常只需要移动到右兄弟，更远的键重新下探更快
+const fingerMaxHops = 1
+
+// leafFinger 线程上一次访问的叶子。
+//
+// B-link 树中叶子的下界从不改变：分裂只把上半部分移到新的右兄弟，
+// 哈希叶子转换为 B 树叶子时旧叶子会被标记为过时。因此曾经路由到该叶子的键 low
+// 到叶子当前 HighKey 之间的键都属于这个叶子，大于 HighKey 的键在它右侧，
+// 可以像普通下探一样沿兄弟指针右移。
+//
+// 这里不比较记住时的版本：B 树叶子每次写入都会增加版本，顺序插入时记住的版本总是过期的。
+// 使用时读取叶子当前的版本，在检查完 HighKey 之后再校验，与 findLeaf 返回的版本用法相同。
+type leafFinger struct {
+	tree *BTree
+	leaf LeafNodeInterface
+	low  interface{}
+}
+
+// fingerLeaf 尝试从 ti 记住的叶子定位 key，成功时返回叶子及其读版本。
+// TreeOptions.FingerHint 为 true 时 Insert、Lookup、Update 先走这里，
+// 顺序回放和有序摄入时下一个键几乎总落在同一个叶子里，可以省去从根开始的下探
+func (bt *BTree) fingerLeaf(key interface{}, ti *ThreadInfo) (LeafNodeInterface, uint64, bool) {
+	f := &ti.finger
+	if !bt.opts.FingerHint || f.tree != bt || f.leaf == nil || compareIntKeys(key, f.low) < 0 {
+		return nil, 0, false
+	}
+	leaf := f.leaf
+	version, needRestart := leaf.TryReadLock()
+	if needRestart {
+		return nil, 0, false
+	}
+	for hops := 0; leaf.GetSiblingPtr() != nil && compareIntKeys(leaf.GetHighKey(), key) < 0; hops++ {
+		if hops == fingerMaxHops {
+			return nil, 0, false
+		}
+		sibling, ok := leaf.GetSiblingPtr().(LeafNodeInterface)
+		if !ok {
+			return nil, 0, false
+		}
+		siblingVersion, needRestart := sibling.TryReadLock()
+		if needRestart {
+			return nil, 0, false
+		}
+		leafEndVersion, needRestart := leaf.GetVersion()
+		if needRestart || version != leafEndVersion {
+			return nil, 0, false
+		}
+		leaf, version = sibling, siblingVersion
+	}
+	// HighKey 和兄弟指针在节点锁内修改，版本不变说明上面读到的是一致的值
+	if leafEndVersion, needRestart := leaf.GetVersion(); needRestart || version != leafEndVersion {
+		return nil, 0, false
+	}
+	return leaf, version, true
+}
+
+// rememberLeaf 记录 key 路由到的叶子，供同一线程的下一次访问使用
+func (bt *BTree) rememberLeaf(ti *ThreadInfo, leaf LeafNodeInterface, key interface{}) {
+	if !bt.opts.FingerHint {
+		return
+	}
+	f := &ti.finger
+	if f.tree == bt && f.leaf == leaf {
+		if compareIntKeys(key, f.low) < 0 {
+			f.low = key
+		}
+		return
+	}
+	*f = leafFinger{tree: bt, leaf: leaf, low: key}
+}
+
+// locateLeaf 先尝试 ti 记住的叶子，失败时从根下探，返回叶子及其读版本。
+// 与 findLeaf 一样不持有任何锁，调用方需要在使用叶子后自行校验版本。
+func (bt *BTree) locateLeaf(key interface{}, ti *ThreadInfo) (LeafNodeInterface, uint64) {
+	leaf, version, ok := bt.fingerLeaf(key, ti)
+	if !ok {
+		leaf, version = bt.findLeaf(key)
+	}
+	bt.rememberLeaf(ti, leaf, key)
+	return leaf, version
+}
@@ -0,0 +1,147 @@
+package blinkhash
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestFinger_SequentialAccess(t *testing.T) {
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	n := 20000
+	for k := 1; k <= n; k++ {
+		tree.Insert(k, k, ti)
+	}
+	if ti.finger.leaf == nil || ti.finger.tree != tree {
+		t.Fatalf("Expected ThreadInfo to remember the last leaf")
+	}
+	for k := 1; k <= n; k++ {
+		if !tree.Update(k, -k, ti) {
+			t.Fatalf("Expected update of key %d to succeed", k)
+		}
+	}
+	// 倒序访问时键总是小于记住的下界，每次都退回普通下探
+	for k := n; k >= 1; k-- {
+		if got := tree.Lookup(k, ti); got != -k {
+			t.Fatalf("key %d: expected %d, got %v", k, -k, got)
+		}
+	}
+	if got := tree.Lookup(n+1, ti); got != nil {
+		t.Errorf("Expected missing key to return nil, got %v", got)
+	}
+	if tree.Update(n+1, 0, ti) {
+		t.Errorf("Expected update of missing key to fail")
+	}
+}
+
+func TestFinger_StaleLeaf(t *testing.T) {
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	n := 5000
+	for k := 1; k <= n; k++ {
+		tree.Insert(k*2, k, ti)
+	}
+	// 另一个线程的范围查询把哈希叶子转换为 B 树叶子，ti 记住的叶子变为过时
+	other := NewThreadInfo(tree.GetEpoche())
+	if got := len(tree.RangeLookup(1, 2*n, other)); got != n {
+		t.Fatalf("Expected %d entries from range lookup, got %d", n, got)
+	}
+	if _, needRestart := ti.finger.leaf.TryReadLock(); !needRestart {
+		t.Fatalf("Expected the remembered hash leaf to be obsolete after conversion")
+	}
+	for k := 1; k <= n; k++ {
+		tree.Insert(k*2+1, -k, ti)
+	}
+	for k := 1; k <= n; k++ {
+		if got := tree.Lookup(k*2, ti); got != k {
+			t.Fatalf("key %d: expected %d, got %v", k*2, k, got)
+		}
+		if got := tree.Lookup(k*2+1, ti); got != -k {
+			t.Fatalf("key %d: expected %d, got %v", k*2+1, -k, got)
+		}
+	}
+}
+
+func TestFinger_SharedAcrossTrees(t *testing.T) {
+	a, b := NewBTree(), NewBTree()
+	ti := NewThreadInfo(a.GetEpoche())
+	for k := 1; k <= 1000; k++ {
+		a.Insert(k, k, ti)
+		b.Insert(k, -k, ti)
+	}
+	for k := 1; k <= 1000; k++ {
+		if got := a.Lookup(k, ti); got != k {
+			t.Fatalf("tree a key %d: expected %d, got %v", k, k, got)
+		}
+		if got := b.Lookup(k, ti); got != -k {
+			t.Fatalf("tree b key %d: expected %d, got %v", k, -k, got)
+		}
+	}
+}
+
+func TestFinger_Concurrent(t *testing.T) {
+	tree := NewBTree()
+	threads, perThread := 4, 20000
+	var wg sync.WaitGroup
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ti := NewThreadInfo(tree.GetEpoche())
+			// 每个线程顺序写入自己的区间，区间之间交错分裂彼此的叶子
+			for j := 1; j <= perThread; j++ {
+				k := j*threads + i
+				tree.Insert(k, k, ti)
+				if j%7 == 0 {
+					tree.Update(k-threads*3, -(k - threads*3), ti)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	ti := NewThreadInfo(tree.GetEpoche())
+	for i := 0; i < threads; i++ {
+		for j := 1; j <= perThread; j++ {
+			k := j*threads + i
+			expected := k
+			if (j+3)%7 == 0 && j+3 <= perThread {
+				expected = -k
+			}
+			if got := tree.Lookup(k, ti); got != expected {
+				t.Fatalf("key %d: expected %d, got %v", k, expected, got)
+			}
+		}
+	}
+}
+
+// BenchmarkFinger 比较顺序插入和顺序查找时开启与关闭 TreeOptions.FingerHint 的耗时
+func BenchmarkFinger(b *testing.B) {
+	for _, enabled := range []bool{false, true} {
+		b.Run(fmt.Sprintf("insert/finger=%v", enabled), func(b *testing.B) {
+			opts := DefaultTreeOptions
+			opts.FingerHint = enabled
+			tree := NewBTreeWithOptions(opts)
+			ti := NewThreadInfo(tree.GetEpoche())
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				tree.Insert(i+1, i, ti)
+			}
+		})
+		b.Run(fmt.Sprintf("lookup/finger=%v", enabled), func(b *testing.B) {
+			opts := DefaultTreeOptions
+			opts.FingerHint = enabled
+			tree := NewBTreeWithOptions(opts)
+			ti := NewThreadInfo(tree.GetEpoche())
+			n := 1000000
+			for k := 1; k <= n; k++ {
+				tree.Insert(k, k, ti)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				tree.Lookup(i%n+1, ti)
+			}
+		})
+	}
+}
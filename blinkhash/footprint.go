@@ -7,4 +7,13 @@ type FootprintMetrics struct {
 	StructuralDataUnoccupied uint64
 	KeyDataOccupied          uint64
 	KeyDataUnoccupied        uint64
+	StashOccupied            uint64 // 哈希叶子溢出区中的条目数
+	StashCapacity            uint64 // 哈希叶子溢出区的总槽位数
+	StashedLeaves            uint64 // 溢出区非空的哈希叶子数
+	HashLeaves               uint64 // 哈希叶子数
+	HashBuckets              uint64 // 哈希叶子当前的桶数之和，随扩容变化
+	HashSlots                uint64 // 哈希叶子当前可容纳的条目数(桶槽位 + 溢出区)
+	CompressedLeaves         uint64 // 压缩叶子数
+	CompressedBytes          uint64 // 压缩叶子编码后的键值和块索引占用的字节数
+	CompressionSaved         uint64 // 与同样条目的 B 树叶子相比压缩节省的字节数
 }
@@ -0,0 +1,518 @@
+package blinkhash
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+)
+
+// 冻结文件的格式(所有定长整数均为小端)，按页组织，页的大小记录在文件头中：
+//
+//	第 0 页：文件头
+//	  magic         8 字节 "BLHFRZN\x00"
+//	  version       uint32，树中没有带过期时间的条目时为 1，否则为 frozenFormatVersion
+//	  page size     uint32
+//	  entries       uint64，条目总数
+//	  levels        uint32，内部节点的层数
+//	  checksum      uint32，第 1 页起所有字节的 CRC32C
+//	  values offset uint64，值区域的起始字节
+//	  values size   uint64，值区域的字节数
+//	  level start   levels 个 uint64，每层内部节点的起始页号，自底向上
+//	  key codec     uvarint 长度 + 名称，总是 "int"
+//	  value codec   uvarint 长度 + 名称
+//	叶子页，从第 1 页开始连续存放
+//	  keys          pageSize/16 个 int64，按键有序
+//	  value offsets pageSize/16 个 uint64，值在值区域中的偏移
+//	内部节点页，每层连续存放，自底向上
+//	  keys          pageSize/8 个 int64，第 i 个是下一层第 page*fanout+i 页的第一个键
+//	值区域
+//	  每个值按文件头记录的编解码器编码，自行界定长度。
+//	  版本 2 的每个值之前有 1 字节的标记，为 1 时其后是 varint 的过期时间，为 0 表示不会过期
+//
+// 除每层的最后一页外所有页都是满的，因此页号和页内条目数都可以由条目总数算出，
+// 页中不需要保存指针和计数。
+const (
+	frozenMagic         = "BLHFRZN\x00"
+	frozenFormatVersion = 2
+	frozenHeaderSize    = 48
+)
+
+// frozenPageSize Freeze 写出的页大小，读取时使用文件头记录的页大小
+var frozenPageSize = 4096
+
+// Freeze 把树在调用时刻的快照写成只读的冻结文件，值使用 values 编码，之后用 OpenFrozen 打开。
+// 文件先写入 path+".tmp"，落盘后再改名为 path
+func (bt *BTree) Freeze(path string, values Codec) error {
+	if _, err := codecByName(values.Name()); err != nil {
+		return err
+	}
+	snap := bt.Snapshot()
+	defer snap.Release()
+
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	defer file.Close()
+	// 叶子页写完之前不知道值区域的位置，值先写入临时文件，最后拷贝到索引之后
+	spill, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".values*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(spill.Name())
+	defer spill.Close()
+
+	if err := bt.writeFrozen(file, spill, snap, values); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+func (bt *BTree) writeFrozen(file, spill *os.File, snap *Snapshot, values Codec) error {
+	pageSize := frozenPageSize
+	leafCap, fanout := pageSize/16, pageSize/8
+	out := bufio.NewWriterSize(file, 64<<10)
+	if _, err := out.Write(make([]byte, pageSize)); err != nil {
+		return err
+	}
+	cw := checksumWriter{w: out, crc: crc32.New(walCRC)}
+	vout := bufio.NewWriterSize(spill, 64<<10)
+
+	page := make([]byte, pageSize)
+	var firstKeys []int
+	var entries, valuesSize uint64
+	var buf []byte
+	n := 0
+	// 使用过 TTL 的树才写出过期时间，其余的树保持版本 1 的格式
+	version, now := uint32(1), ttlNow()
+	if bt.expires() {
+		version = frozenFormatVersion
+	}
+	flushLeaf := func() error {
+		for i := n; i < leafCap; i++ {
+			binary.LittleEndian.PutUint64(page[8*i:], 0)
+			binary.LittleEndian.PutUint64(page[8*(leafCap+i):], 0)
+		}
+		n = 0
+		_, err := cw.Write(page)
+		return err
+	}
+
+	it := snap.rawIterator(math.MinInt, NewThreadInfo(bt.epoche))
+	for e, ok := it.Next(); ok; e, ok = it.Next() {
+		if expired(e.Value, now) {
+			continue
+		}
+		key := e.Key.(int)
+		value := e.Value
+		buf = buf[:0]
+		if version >= 2 {
+			if ev, ok := value.(*expiringValue); ok {
+				buf = binary.AppendVarint(append(buf, 1), ev.deadline)
+				value = ev.value
+			} else {
+				buf = append(buf, 0)
+			}
+		}
+		var err error
+		if buf, err = values.Append(buf, value); err != nil {
+			return fmt.Errorf("key %d: %w", key, err)
+		}
+		if n == 0 {
+			firstKeys = append(firstKeys, key)
+		}
+		binary.LittleEndian.PutUint64(page[8*n:], uint64(key))
+		binary.LittleEndian.PutUint64(page[8*(leafCap+n):], valuesSize)
+		if _, err := vout.Write(buf); err != nil {
+			return err
+		}
+		valuesSize += uint64(len(buf))
+		entries++
+		if n++; n == leafCap {
+			if err := flushLeaf(); err != nil {
+				return err
+			}
+		}
+	}
+	if n > 0 {
+		if err := flushLeaf(); err != nil {
+			return err
+		}
+	}
+
+	// 自底向上写内部节点，直到某一层只剩一页
+	nextPage := uint64(1 + len(firstKeys))
+	var levelStart []uint64
+	for keys := firstKeys; len(keys) > 1; {
+		levelStart = append(levelStart, nextPage)
+		var upper []int
+		for start := 0; start < len(keys); start += fanout {
+			end := minInt(start+fanout, len(keys))
+			upper = append(upper, keys[start])
+			for i := range page {
+				page[i] = 0
+			}
+			for i, k := range keys[start:end] {
+				binary.LittleEndian.PutUint64(page[8*i:], uint64(k))
+			}
+			if _, err := cw.Write(page); err != nil {
+				return err
+			}
+			nextPage++
+		}
+		keys = upper
+	}
+
+	if err := vout.Flush(); err != nil {
+		return err
+	}
+	if _, err := spill.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.Copy(cw, bufio.NewReaderSize(spill, 64<<10)); err != nil {
+		return err
+	}
+	if err := out.Flush(); err != nil {
+		return err
+	}
+
+	header := append([]byte(frozenMagic), make([]byte, frozenHeaderSize-len(frozenMagic))...)
+	binary.LittleEndian.PutUint32(header[8:], version)
+	binary.LittleEndian.PutUint32(header[12:], uint32(pageSize))
+	binary.LittleEndian.PutUint64(header[16:], entries)
+	binary.LittleEndian.PutUint32(header[24:], uint32(len(levelStart)))
+	binary.LittleEndian.PutUint32(header[28:], cw.crc.Sum32())
+	binary.LittleEndian.PutUint64(header[32:], nextPage*uint64(pageSize))
+	binary.LittleEndian.PutUint64(header[40:], valuesSize)
+	for _, start := range levelStart {
+		header = binary.LittleEndian.AppendUint64(header, start)
+	}
+	header = appendCodecNames(header, IntCodec, values)
+	if len(header) > pageSize {
+		return fmt.Errorf("blinkhash: frozen header of %d bytes does not fit in a page", len(header))
+	}
+	_, err := file.WriteAt(header, 0)
+	return err
+}
+
+// frozenLevel 一层内部节点
+type frozenLevel struct {
+	start int // 起始页号
+	keys  int // 本层的键数，即下一层的页数
+}
+
+// FrozenTree Freeze 写出的只读树。查询直接读取映射的页，不在堆上建立节点，也不加锁，
+// 可以被任意多个线程并发使用。返回的值由编解码器解码得到，不引用映射的内存
+type FrozenTree struct {
+	data      []byte
+	mapped    bool
+	values    Codec
+	expiring  bool // 版本 2：值带有过期时间标记
+	pageSize  int
+	leafCap   int
+	fanout    int
+	entries   int
+	levels    []frozenLevel
+	valuesOff int
+	closed    int32
+}
+
+// OpenFrozen 以只读方式映射冻结文件。只检查文件头和文件大小，完整校验见 Verify
+func OpenFrozen(path string) (*FrozenTree, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < frozenHeaderSize || info.Size() > math.MaxInt {
+		return nil, fmt.Errorf("blinkhash: opening %s: %w", path, ErrBadTreeFile)
+	}
+	data, mapped, err := mapFile(file, int(info.Size()))
+	if err != nil {
+		return nil, err
+	}
+	ft, err := newFrozenTree(data)
+	if err != nil {
+		if mapped {
+			unmapFile(data)
+		}
+		return nil, fmt.Errorf("blinkhash: opening %s: %w", path, err)
+	}
+	ft.mapped = mapped
+	return ft, nil
+}
+
+func newFrozenTree(data []byte) (*FrozenTree, error) {
+	if string(data[:len(frozenMagic)]) != frozenMagic {
+		return nil, ErrBadTreeFile
+	}
+	version := binary.LittleEndian.Uint32(data[8:])
+	if version != 1 && version != frozenFormatVersion {
+		return nil, fmt.Errorf("blinkhash: unsupported frozen format version %d", version)
+	}
+	pageSize := uint64(binary.LittleEndian.Uint32(data[12:]))
+	entries := binary.LittleEndian.Uint64(data[16:])
+	levels := uint64(binary.LittleEndian.Uint32(data[24:]))
+	valuesOff := binary.LittleEndian.Uint64(data[32:])
+	valuesSize := binary.LittleEndian.Uint64(data[40:])
+	if pageSize < 64 || pageSize%16 != 0 || uint64(len(data)) < pageSize ||
+		frozenHeaderSize+8*levels > pageSize || entries > math.MaxInt/16 {
+		return nil, ErrBadTreeFile
+	}
+	ft := &FrozenTree{
+		data:     data,
+		pageSize: int(pageSize),
+		leafCap:  int(pageSize / 16),
+		fanout:   int(pageSize / 8),
+		entries:  int(entries),
+		expiring: version >= 2,
+	}
+
+	// 每层的页数由条目总数决定，文件头记录的起始页号必须与之一致
+	pages := ceilDiv(ft.entries, ft.leafCap)
+	next := 1 + pages
+	for i := 0; i < int(levels); i++ {
+		if pages <= 1 {
+			return nil, ErrBadTreeFile
+		}
+		start := binary.LittleEndian.Uint64(data[frozenHeaderSize+8*i:])
+		if start != uint64(next) {
+			return nil, ErrBadTreeFile
+		}
+		ft.levels = append(ft.levels, frozenLevel{start: next, keys: pages})
+		pages = ceilDiv(pages, ft.fanout)
+		next += pages
+	}
+	if pages > 1 || valuesOff != uint64(next)*pageSize || uint64(len(data))-valuesOff != valuesSize {
+		return nil, ErrBadTreeFile
+	}
+	ft.valuesOff = int(valuesOff)
+
+	names := bytes.NewReader(data[frozenHeaderSize+8*levels : pageSize])
+	values, err := readCodecNames(names)
+	if err == errCorrupt {
+		return nil, ErrBadTreeFile
+	} else if err != nil {
+		return nil, err
+	}
+	ft.values = values
+	return ft, nil
+}
+
+func ceilDiv(a, b int) int {
+	return (a + b - 1) / b
+}
+
+// Close 解除映射。调用时不能再有线程在使用这棵树，之后的查询会 panic。重复调用是安全的
+func (ft *FrozenTree) Close() error {
+	if !atomic.CompareAndSwapInt32(&ft.closed, 0, 1) {
+		return nil
+	}
+	data := ft.data
+	ft.data = nil
+	if ft.mapped {
+		return unmapFile(data)
+	}
+	return nil
+}
+
+func (ft *FrozenTree) checkLive() {
+	if atomic.LoadInt32(&ft.closed) != 0 {
+		panic("blinkhash: use of closed frozen tree")
+	}
+}
+
+// Len 返回条目总数，包括冻结之后已经过期的条目
+func (ft *FrozenTree) Len() int {
+	return ft.entries
+}
+
+// ValueCodec 返回文件中的值使用的编解码器
+func (ft *FrozenTree) ValueCodec() Codec {
+	return ft.values
+}
+
+// key 返回第 e 个条目的键
+func (ft *FrozenTree) key(e int) int {
+	off := (1+e/ft.leafCap)*ft.pageSize + 8*(e%ft.leafCap)
+	return int(binary.LittleEndian.Uint64(ft.data[off:]))
+}
+
+// value 解码第 e 个条目的值，条目在 now 已过期时第二个返回值为 false。
+// 文件损坏时 panic，可以先用 Verify 检查
+func (ft *FrozenTree) value(e int, now int64) (interface{}, bool) {
+	off := (1+e/ft.leafCap)*ft.pageSize + 8*(ft.leafCap+e%ft.leafCap)
+	pos := binary.LittleEndian.Uint64(ft.data[off:])
+	if pos >= uint64(len(ft.data)-ft.valuesOff) {
+		panic(fmt.Sprintf("blinkhash: frozen value offset %d out of range", pos))
+	}
+	v, live, err := ft.decodeValue(ft.data[ft.valuesOff+int(pos):], now)
+	if err != nil {
+		panic(fmt.Sprintf("blinkhash: decoding frozen value: %v", err))
+	}
+	return v, live
+}
+
+// decodeValue 解码值区域中的一个值，版本 2 的文件先读出过期时间标记
+func (ft *FrozenTree) decodeValue(data []byte, now int64) (interface{}, bool, error) {
+	live := true
+	if ft.expiring {
+		d := &decoder{data: data}
+		switch d.byte() {
+		case 0:
+		case 1:
+			live = now < d.varint()
+		default:
+			d.fail()
+		}
+		if d.err != nil {
+			return nil, false, errCorrupt
+		}
+		data = d.data
+	}
+	v, _, err := ft.values.Decode(data)
+	return v, live, err
+}
+
+// innerKey 返回第 l 层内部节点中的第 i 个键
+func (ft *FrozenTree) innerKey(l, i int) int {
+	lv := ft.levels[l]
+	off := (lv.start+i/ft.fanout)*ft.pageSize + 8*(i%ft.fanout)
+	return int(binary.LittleEndian.Uint64(ft.data[off:]))
+}
+
+// seek 从根下探，返回第一个键不小于 key 的条目序号，不存在时返回 Len()
+func (ft *FrozenTree) seek(key int) int {
+	if ft.entries == 0 {
+		return 0
+	}
+	page := 0
+	for l := len(ft.levels) - 1; l >= 0; l-- {
+		// 在本页中找到最后一个不大于 key 的键，下探到它对应的子页
+		lo := page * ft.fanout
+		n := minInt(ft.fanout, ft.levels[l].keys-lo)
+		i := sort.Search(n, func(i int) bool { return ft.innerKey(l, lo+i) > key }) - 1
+		page = lo + maxInt(i, 0)
+	}
+	lo := page * ft.leafCap
+	n := minInt(ft.leafCap, ft.entries-lo)
+	return lo + sort.Search(n, func(i int) bool { return ft.key(lo+i) >= key })
+}
+
+// Lookup 返回 key 的值，不存在或已过期时返回 nil。ti 只为与 BTree 的接口一致，可以为 nil
+func (ft *FrozenTree) Lookup(key interface{}, ti *ThreadInfo) interface{} {
+	ft.checkLive()
+	k := key.(int)
+	if e := ft.seek(k); e < ft.entries && ft.key(e) == k {
+		if v, live := ft.value(e, ttlNow()); live {
+			return v
+		}
+	}
+	return nil
+}
+
+// RangeLookup 返回从 minKey 开始的最多 rng 个值
+func (ft *FrozenTree) RangeLookup(minKey interface{}, rng int, ti *ThreadInfo) []interface{} {
+	return entryValues(ft.RangeLookupEntries(minKey, rng, ti))
+}
+
+// RangeLookupEntries 与 RangeLookup 相同，但返回带键的条目，结果按键有序，包含 minKey 本身
+func (ft *FrozenTree) RangeLookupEntries(minKey interface{}, rng int, ti *ThreadInfo) []Entry {
+	ft.checkLive()
+	start := ft.seek(minKey.(int))
+	if rng > ft.entries-start {
+		rng = ft.entries - start
+	}
+	if rng <= 0 {
+		return nil
+	}
+	results := make([]Entry, 0, rng)
+	now := ttlNow()
+	for e := start; e < ft.entries && len(results) < rng; e++ {
+		if v, live := ft.value(e, now); live {
+			results = append(results, Entry{Key: ft.key(e), Value: v})
+		}
+	}
+	return results
+}
+
+// FrozenIterator 按键顺序遍历冻结树
+type FrozenIterator struct {
+	ft  *FrozenTree
+	pos int
+}
+
+// Iterator 返回从 minKey 开始按键顺序遍历的迭代器
+func (ft *FrozenTree) Iterator(minKey interface{}, ti *ThreadInfo) *FrozenIterator {
+	ft.checkLive()
+	return &FrozenIterator{ft: ft, pos: ft.seek(minKey.(int))}
+}
+
+// Next 返回下一个条目，遍历结束时 ok 为 false
+func (it *FrozenIterator) Next() (entry Entry, ok bool) {
+	it.ft.checkLive()
+	now := ttlNow()
+	for ; it.pos < it.ft.entries; it.pos++ {
+		if v, live := it.ft.value(it.pos, now); live {
+			entry = Entry{Key: it.ft.key(it.pos), Value: v}
+			it.pos++
+			return entry, true
+		}
+	}
+	return Entry{}, false
+}
+
+// Verify 读取整个文件，检查校验和、键的顺序、内部节点与子页的对应关系以及每个值能否解码
+func (ft *FrozenTree) Verify() error {
+	ft.checkLive()
+	if crc32.Checksum(ft.data[ft.pageSize:], walCRC) != binary.LittleEndian.Uint32(ft.data[28:]) {
+		return ErrBadTreeFile
+	}
+	for e := 0; e < ft.entries; e++ {
+		if e > 0 && ft.key(e) <= ft.key(e-1) {
+			return ErrBadTreeFile
+		}
+		off := (1+e/ft.leafCap)*ft.pageSize + 8*(ft.leafCap+e%ft.leafCap)
+		pos := binary.LittleEndian.Uint64(ft.data[off:])
+		if pos >= uint64(len(ft.data)-ft.valuesOff) {
+			return ErrBadTreeFile
+		}
+		if _, _, err := ft.decodeValue(ft.data[ft.valuesOff+int(pos):], 0); err != nil {
+			return ErrBadTreeFile
+		}
+	}
+	for l := range ft.levels {
+		for i := 0; i < ft.levels[l].keys; i++ {
+			var first int
+			if l == 0 {
+				first = ft.key(i * ft.leafCap)
+			} else {
+				first = ft.innerKey(l-1, i*ft.fanout)
+			}
+			if ft.innerKey(l, i) != first {
+				return ErrBadTreeFile
+			}
+		}
+	}
+	return nil
+}
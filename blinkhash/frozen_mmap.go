@@ -0,0 +1,21 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package blinkhash
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapFile 以只读方式映射整个文件，mapped 为 true 时需要用 unmapFile 释放
+func mapFile(file *os.File, size int) (data []byte, mapped bool, err error) {
+	data, err = syscall.Mmap(int(file.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+func unmapFile(data []byte) error {
+	return syscall.Munmap(data)
+}
@@ -0,0 +1,21 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package blinkhash
+
+import (
+	"io"
+	"os"
+)
+
+// mapFile 不支持 mmap 的平台上把整个文件读入内存
+func mapFile(file *os.File, size int) (data []byte, mapped bool, err error) {
+	data = make([]byte, size)
+	if _, err := io.ReadFull(file, data); err != nil {
+		return nil, false, err
+	}
+	return data, false, nil
+}
+
+func unmapFile(data []byte) error {
+	return nil
+}
@@ -0,0 +1,213 @@
+package blinkhash
+
+import (
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// withFrozenPageSize 临时改用较小的页，让少量条目也能产生多层内部节点
+func withFrozenPageSize(t *testing.T, size int) {
+	old := frozenPageSize
+	frozenPageSize = size
+	t.Cleanup(func() { frozenPageSize = old })
+}
+
+func TestFrozen_RoundTrip(t *testing.T) {
+	for _, pageSize := range []int{128, 4096} {
+		withFrozenPageSize(t, pageSize)
+		tree := NewBTree()
+		ti := NewThreadInfo(tree.GetEpoche())
+		for k := 0; k < 5000; k++ {
+			tree.Insert(k*3, k, ti)
+		}
+		tree.Update(3, "three", ti)
+		tree.Insert(math.MaxInt, []byte("max"), ti)
+
+		path := filepath.Join(t.TempDir(), "frozen")
+		if err := tree.Freeze(path, TaggedCodec); err != nil {
+			t.Fatal(err)
+		}
+		ft, err := OpenFrozen(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ft.Verify(); err != nil {
+			t.Fatalf("page size %d: %v", pageSize, err)
+		}
+		if pageSize == 128 && len(ft.levels) < 3 {
+			t.Errorf("Expected several inner levels with small pages, got %d", len(ft.levels))
+		}
+		if ft.Len() != 5001 {
+			t.Errorf("Expected 5001 entries, got %d", ft.Len())
+		}
+
+		// 查询结果与原树一致
+		for _, k := range []int{-1, 0, 1, 3, 4, 2999, 3000, 14997, 14998, math.MaxInt} {
+			if got, want := ft.Lookup(k, nil), tree.Lookup(k, ti); !reflect.DeepEqual(got, want) {
+				t.Fatalf("page size %d: key %d: expected %v, got %v", pageSize, k, want, got)
+			}
+		}
+		for _, lo := range []int{math.MinInt, -5, 0, 1, 7000, 14990, 15000} {
+			got := ft.RangeLookupEntries(lo, 100, nil)
+			want := tree.RangeLookupEntries(lo, 100, ti)
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("page size %d: range from %d: expected %d entries, got %d", pageSize, lo, len(want), len(got))
+			}
+		}
+		n := 0
+		it := ft.Iterator(math.MinInt, nil)
+		for e, ok := it.Next(); ok; e, ok = it.Next() {
+			if n < 5000 && e.Key != n*3 {
+				t.Fatalf("Expected key %d, got %v", n*3, e.Key)
+			}
+			n++
+		}
+		if n != 5001 {
+			t.Errorf("Expected the iterator to visit 5001 entries, got %d", n)
+		}
+		if err := ft.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFrozen_Empty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "frozen")
+	if err := NewBTree().Freeze(path, IntCodec); err != nil {
+		t.Fatal(err)
+	}
+	ft, err := OpenFrozen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ft.Close()
+	if err := ft.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	if ft.Lookup(0, nil) != nil || len(ft.RangeLookup(math.MinInt, 10, nil)) != 0 {
+		t.Errorf("Expected an empty tree")
+	}
+	if _, ok := ft.Iterator(0, nil).Next(); ok {
+		t.Errorf("Expected the iterator to be exhausted")
+	}
+}
+
+func TestFrozen_Codec(t *testing.T) {
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	for k := 0; k < 100; k++ {
+		tree.Insert(k, "v", ti)
+	}
+	path := filepath.Join(t.TempDir(), "frozen")
+	if err := tree.Freeze(path, StringCodec); err != nil {
+		t.Fatal(err)
+	}
+	ft, err := OpenFrozen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ft.Close()
+	if ft.ValueCodec() != StringCodec || ft.Lookup(42, nil) != "v" {
+		t.Errorf("Expected values decoded by the recorded codec")
+	}
+	tree.Insert(100, 100, ti)
+	if err := tree.Freeze(path, StringCodec); !errors.Is(err, ErrCodecType) {
+		t.Errorf("Expected ErrCodecType for an int value, got %v", err)
+	}
+}
+
+func TestFrozen_Corrupt(t *testing.T) {
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	for k := 0; k < 1000; k++ {
+		tree.Insert(k, k, ti)
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "frozen")
+	if err := tree.Freeze(path, TaggedCodec); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	truncated := filepath.Join(dir, "truncated")
+	os.WriteFile(truncated, data[:len(data)-1], 0o644)
+	if _, err := OpenFrozen(truncated); !errors.Is(err, ErrBadTreeFile) {
+		t.Errorf("Expected ErrBadTreeFile for a truncated file, got %v", err)
+	}
+	garbage := filepath.Join(dir, "garbage")
+	os.WriteFile(garbage, []byte("not a frozen tree file at all, just some bytes"), 0o644)
+	if _, err := OpenFrozen(garbage); !errors.Is(err, ErrBadTreeFile) {
+		t.Errorf("Expected ErrBadTreeFile for garbage, got %v", err)
+	}
+
+	// 页中的内容只在 Verify 时检查
+	flipped := filepath.Join(dir, "flipped")
+	data[frozenPageSize+100] ^= 0x40
+	os.WriteFile(flipped, data, 0o644)
+	ft, err := OpenFrozen(flipped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ft.Close()
+	if err := ft.Verify(); !errors.Is(err, ErrBadTreeFile) {
+		t.Errorf("Expected ErrBadTreeFile for a flipped bit, got %v", err)
+	}
+}
+
+func TestFrozen_Close(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "frozen")
+	if err := NewBTree().Freeze(path, TaggedCodec); err != nil {
+		t.Fatal(err)
+	}
+	ft, err := OpenFrozen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ft.Close()
+	if err := ft.Close(); err != nil {
+		t.Errorf("Expected a second Close to be a no-op, got %v", err)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected a lookup on a closed tree to panic")
+		}
+	}()
+	ft.Lookup(0, nil)
+}
+
+// BenchmarkFrozenLookup 比较冻结树与内存中的树的点查询
+func BenchmarkFrozenLookup(b *testing.B) {
+	const n = 100000
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	for k := 0; k < n; k++ {
+		tree.Insert(k, k, ti)
+	}
+	path := filepath.Join(b.TempDir(), "frozen")
+	if err := tree.Freeze(path, IntCodec); err != nil {
+		b.Fatal(err)
+	}
+	ft, err := OpenFrozen(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ft.Close()
+
+	b.Run("frozen", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			ft.Lookup(i*7919%n, nil)
+		}
+	})
+	b.Run("live", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			tree.Lookup(i*7919%n, ti)
+		}
+	})
+}
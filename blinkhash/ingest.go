@@ -0,0 +1,121 @@
+package blinkhash
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// defaultIngestBatchSize 摄入模式下暂存区的默认大小
+const defaultIngestBatchSize = 64
+
+// ingestBatchSize 返回暂存区的大小，见 TreeOptions.IngestBatchSize
+func (o *TreeOptions) ingestBatchSize() int {
+	if o.IngestBatchSize <= 0 {
+		return defaultIngestBatchSize
+	}
+	return o.IngestBatchSize
+}
+
+// IngestInsert 以摄入模式插入键值对。
+//
+// 多个线程同时插入当前时间戳时，普通 Insert 会集中争抢最右叶子的版本锁，
+// 并在 TryUpgradeWriteLock 失败后不断重启。摄入模式先把条目写入 ti 私有的
+// 暂存区，攒满 TreeOptions.IngestBatchSize 条后按键排序，按叶子分组一次性写入，
+// 每个叶子只需获取一次写锁。暂存区中的数据在刷新之前对读操作不可见，
+// 线程结束摄入时需要调用 FlushIngest。
+func (bt *BTree) IngestInsert(key, value interface{}, ti *ThreadInfo) {
+	ti.staged = append(ti.staged, Entry{Key: key, Value: value})
+	if len(ti.staged) >= bt.opts.ingestBatchSize() {
+		bt.FlushIngest(ti)
+	}
+}
+
+// FlushIngest 将 ti 暂存区中的条目按键排序后批量写入树中
+func (bt *BTree) FlushIngest(ti *ThreadInfo) {
+	if len(ti.staged) == 0 {
+		return
+	}
+	staged := ti.staged
+	sort.SliceStable(staged, func(i, j int) bool {
+		return compareIntKeys(staged[i].Key, staged[j].Key) < 0
+	})
+	bt.insertSorted(staged, ti)
+	for i := range staged {
+		staged[i] = Entry{}
+	}
+	ti.staged = staged[:0]
+}
+
+// StagedCount 返回 ti 暂存区中尚未刷新的条目数
+func (bt *BTree) StagedCount(ti *ThreadInfo) int {
+	return len(ti.staged)
+}
+
+// insertSorted 将按键排好序的条目写入树：每次下探到第一个条目所在的叶子，
+// 把落在该叶子范围内的一段条目在一次加锁内写入；叶子已满时退回普通
+// Insert，由其完成分裂后继续。
+func (bt *BTree) insertSorted(entries []Entry, ti *ThreadInfo) {
+	for len(entries) > 0 {
+		n := bt.insertRun(entries, ti)
+		if n > 0 {
+			entries = entries[n:]
+			continue
+		}
+		bt.Insert(entries[0].Key, entries[0].Value, ti)
+		entries = entries[1:]
+	}
+}
+
+// insertRun 把 entries 的一个前缀写入第一个条目所在的叶子，返回写入的条目数，
+// 返回 0 表示叶子已满需要分裂，或存在快照需要逐条记录旧版本，或开启了 WAL 需要逐条写日志
+func (bt *BTree) insertRun(entries []Entry, ti *ThreadInfo) int {
+	if bt.wal != nil {
+		return 0
+	}
+	// 批量写入不经过按键的分片锁，与没有快照时的单键写入一样在分片的 inflight 上登记，
+	// 防止写到一半时创建快照；有快照或 lockAll 的持有者时退回逐条写入
+	vs := bt.versions
+	st := vs.stripe(entries[0].Key.(int))
+	if !vs.enterLockFree(st) {
+		return 0
+	}
+	defer atomic.AddInt64(&st.inflight, -1)
+	eg := NewEpocheGuard(ti)
+	defer eg.Release()
+
+	for {
+		if bt.Err() != nil {
+			return 0
+		}
+		leaf, version := bt.locateLeaf(entries[0].Key, ti)
+		switch l := leaf.(type) {
+		case *LNodeBTree:
+			n, ret := l.InsertSorted(entries, version)
+			if ret == NeedRestart {
+				continue
+			}
+			return n
+		case *LNodeAppend:
+			// 乱序时返回 0，由 Insert 完成转换
+			n, ret := l.InsertSorted(entries, version)
+			if ret == NeedRestart {
+				continue
+			}
+			return n
+		default:
+			// 哈希叶子只加桶锁，不存在节点级热点，逐条在同一版本下插入即可
+			n := 0
+			for n < len(entries) {
+				if leaf.GetSiblingPtr() != nil && compareIntKeys(entries[n].Key, leaf.GetHighKey()) > 0 {
+					break
+				}
+				ret := leaf.Insert(entries[n].Key, entries[n].Value, version)
+				if ret != InsertSuccess {
+					break
+				}
+				n++
+			}
+			return n
+		}
+	}
+}
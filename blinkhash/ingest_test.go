@@ -0,0 +1,162 @@
+package blinkhash
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTimeStampTree 创建一棵已经转换为 LNodeBTree 叶子的树，
+// 模拟 TimeStampTest 中持续写入当前时间戳的场景
+func newTimeStampTree() *BTree {
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	for k := 1; k <= 100; k++ {
+		tree.Insert(k, k, ti)
+	}
+	tree.RangeLookup(0, 1, ti)
+	return tree
+}
+
+// timeStampKey 按 TimeStampTest 的格式生成键: timestamp<<16 | sensorID<<6 | tid
+func timeStampKey(base time.Time, sensorID, tid int) int {
+	return int(time.Since(base).Nanoseconds())<<16 | sensorID<<6 | tid
+}
+
+func TestBTree_IngestInsert(t *testing.T) {
+	for _, converted := range []bool{false, true} {
+		tree := NewBTree()
+		if converted {
+			tree = newTimeStampTree()
+		}
+		base := time.Now()
+		numThreads, perThread := 8, 2000
+
+		var wg sync.WaitGroup
+		for tid := 0; tid < numThreads; tid++ {
+			wg.Add(1)
+			go func(tid int) {
+				defer wg.Done()
+				ti := NewThreadInfo(tree.GetEpoche())
+				for i := 0; i < perThread; i++ {
+					key := timeStampKey(base, 0, tid)
+					tree.IngestInsert(key, key, ti)
+				}
+				tree.FlushIngest(ti)
+				if tree.StagedCount(ti) != 0 {
+					t.Errorf("Expected empty staging buffer after flush, got %d", tree.StagedCount(ti))
+				}
+			}(tid)
+		}
+		wg.Wait()
+
+		expected := numThreads * perThread
+		if converted {
+			expected += 100
+		}
+		if got := leafEntryCount(tree); got != expected {
+			t.Errorf("converted=%v: expected %d entries, got %d", converted, expected, got)
+		}
+		ti := NewThreadInfo(tree.GetEpoche())
+		results := tree.RangeLookup(0, expected, ti)
+		for i := 1; i < len(results); i++ {
+			if results[i].(int) < results[i-1].(int) {
+				t.Fatalf("converted=%v: results out of order at %d", converted, i)
+			}
+		}
+	}
+}
+
+func TestBTree_IngestInsertStagedUntilFlush(t *testing.T) {
+	tree := newTimeStampTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	size := tree.opts.ingestBatchSize()
+	for k := 1000; k < 1000+size-1; k++ {
+		tree.IngestInsert(k, k, ti)
+	}
+	if got := leafEntryCount(tree); got != 100 {
+		t.Errorf("Expected staged entries to be invisible before flush, got %d entries", got)
+	}
+	tree.IngestInsert(5000, 5000, ti) // 触发自动刷新
+	if got := leafEntryCount(tree); got != 100+size {
+		t.Errorf("Expected %d entries after automatic flush, got %d", 100+size, got)
+	}
+}
+
+// 每棵树使用自己配置的暂存区大小
+func TestBTree_IngestBatchSizeOption(t *testing.T) {
+	tree := NewBTreeWithOptions(TreeOptions{IngestBatchSize: 4})
+	ti := NewThreadInfo(tree.GetEpoche())
+	for k := 1; k <= 3; k++ {
+		tree.IngestInsert(k, k, ti)
+	}
+	if got := tree.StagedCount(ti); got != 3 {
+		t.Fatalf("Expected 3 staged entries, got %d", got)
+	}
+	tree.IngestInsert(4, 4, ti)
+	if got := tree.StagedCount(ti); got != 0 {
+		t.Errorf("Expected the fourth entry to flush the stage, %d still staged", got)
+	}
+	if got := tree.Lookup(4, ti); got != 4 {
+		t.Errorf("Expected flushed key 4, got %v", got)
+	}
+}
+
+// leafEntryCount 沿叶子链统计树中的条目数
+func leafEntryCount(tree *BTree) int {
+	cur := tree.root
+	for cur.GetLevel() != 0 {
+		cur = cur.GetLeftmostPtr()
+	}
+	total := 0
+	for ; cur != nil; cur = cur.GetSiblingPtr() {
+		total += int(cur.GetCount())
+	}
+	return total
+}
+
+// benchmarkTimeStampWorkload 在 1-64 个 goroutine 下写入 TimeStampTest 风格的键，
+// 报告整体吞吐量(ops/s)
+func benchmarkTimeStampWorkload(b *testing.B, ingest bool) {
+	for _, numThreads := range []int{1, 2, 4, 8, 16, 32, 64} {
+		b.Run(fmt.Sprintf("goroutines=%d", numThreads), func(b *testing.B) {
+			tree := newTimeStampTree()
+			base := time.Now()
+			chunk := b.N/numThreads + 1
+
+			var wg sync.WaitGroup
+			b.ResetTimer()
+			start := time.Now()
+			for tid := 0; tid < numThreads; tid++ {
+				wg.Add(1)
+				go func(tid int) {
+					defer wg.Done()
+					ti := NewThreadInfo(tree.GetEpoche())
+					for i := 0; i < chunk; i++ {
+						key := timeStampKey(base, 0, tid)
+						if ingest {
+							tree.IngestInsert(key, key, ti)
+						} else {
+							tree.Insert(key, key, ti)
+						}
+					}
+					if ingest {
+						tree.FlushIngest(ti)
+					}
+				}(tid)
+			}
+			wg.Wait()
+			b.StopTimer()
+			b.ReportMetric(float64(chunk*numThreads)/time.Since(start).Seconds(), "ops/s")
+		})
+	}
+}
+
+func BenchmarkTimeStampInsert(b *testing.B) {
+	benchmarkTimeStampWorkload(b, false)
+}
+
+func BenchmarkTimeStampIngest(b *testing.B) {
+	benchmarkTimeStampWorkload(b, true)
+}
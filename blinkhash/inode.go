@@ -12,6 +12,7 @@ type INode struct {
 	HighKey     interface{} // 最高键
 	Entries     []Entry     // 条目切片
 	Type        NodeType
+	nodeOptions
 }
 
 func (in *INode) GetHighKey() interface{} {
@@ -114,23 +115,14 @@ func (in *INode) GetNode() *Node {
 	return &in.Node
 }
 
-// FindLowerBound findLowerBound 在有序切片中线性搜索，找到第一个不小于给定键的元素位置
+// FindLowerBound 返回最后一个键小于 key 的条目下标，所有键都不小于 key 时返回 -1。
+// 查找策略由 TreeOptions.INodeSearch 决定，见 search.go
 func (in *INode) FindLowerBound(key interface{}) int {
 	keyInt, ok := key.(int)
 	if !ok {
-		panic("FindLowerBoundLinear: key is not of type int")
+		panic("FindLowerBound: key is not of type int")
 	}
-
-	for index, entry := range in.Entries[:in.count] {
-		entryKey, ok := entry.Key.(int)
-		if !ok {
-			panic(fmt.Sprintf("entry key must be int, got %T", entry.Key))
-		}
-		if entryKey >= keyInt {
-			return index - 1
-		}
-	}
-	return int(in.count - 1)
+	return lowerBoundEntries(in.Entries[:in.count], keyInt, in.options().innerSearch()) - 1
 }
 
 // ScanNode 根据提供的键扫描并返回对应的节点
@@ -240,6 +232,7 @@ func (in *INode) Split() (INodeInterface, interface{}) {
 		in.level,
 		in.HighKey,
 	)
+	newNode.opts = in.opts
 
 	// 复制后一半的条目到新节点
 	copy(newNode.Entries, in.Entries[half+1:])
@@ -462,226 +455,21 @@ func (in *INode) BatchInsertLastLevelWithMovement(
 	return idx, bufIdx, nil
 }
 
-// BatchInsertLastLevel 批量插入到叶子节点，包括迁移和缓冲区处理
-// 返回新节点集合、新Num 和错误（如果有）
+// BatchInsertLastLevel 哈希叶子转换为 num 个 B 树叶子后更新最后一层内部节点，
+// 容量不足时分裂，返回新节点集合和错误（如果有）。batchSize 未使用，分裂按 FillFactor 进行
 func (in *INode) BatchInsertLastLevel(keys []interface{}, values []NodeInterface, num int, batchSize int) ([]INodeInterface, error) {
 	pos := in.FindLowerBound(keys[0])
-	batchSizeCalc := int(float64(in.Cardinality) * FillFactor)
-	// 原版: bool inplace = ((cnt + num) < cardinality);
-	inplace := (int(in.count) + num - 1) <= in.Cardinality
-
-	moveNum := 0
-	idx := 0
+	// 被转换的叶子替换为第一个新叶子，其余新叶子插入到它之后
 	if pos < 0 {
-		// insert at leftmostPtr,因为所有 entry 都要往后挪,这个moveNum代表需要挪动的entry数量
-		moveNum = int(in.count)
+		in.leftmostPtr = values[0]
 	} else {
-		// insert in the middle，把后面 [pos+1 ..count-1] 往后挪 1。
-		moveNum = int(in.count) - (pos + 1)
+		in.Entries[pos].Value = values[0]
 	}
-
-	if inplace {
-		// 如果仅有 1 个 BTreeNode（num=1），说明就是“1对1” 替换，不需要移动 Entry，也不需要计数+1
-		if num == 1 {
-			// 只需替换
-			if pos < 0 {
-				// 哈希节点在 leftmostPtr
-				in.leftmostPtr = values[0]
-			} else {
-				// 哈希节点在 entry[pos]
-				in.Entries[pos].Value = values[0]
-			}
-			// 不需要移动或者插入额外 entry, 也不增加 in.count
-			return nil, nil
-		} else {
-			// === 若 num > 1，才需要移动并插入多条 Entry ===
-
-			// 1) 移动后方 entry
-			in.moveNormalInsertionForLastLevel(pos, num, moveNum)
-
-			// 2) 替换 leftmostPtr 或 entry[pos]
-			if pos < 0 {
-				in.leftmostPtr = values[idx]
-				idx++
-			} else {
-				in.Entries[pos].Value = values[idx]
-				idx++
-			}
-
-			// 3) 插入其余 (num-1) 条 entry 到 [pos+1.. pos+num]
-			for i := pos + 1; i < pos+num; i++ {
-				in.Entries[i].Key = keys[idx]
-				in.Entries[i].Value = values[idx]
-				idx++
-			}
-
-			// c++ 原版： cnt += (num-1); net + (num-1)
-			// 如果在你实现中“覆盖”也算1个slot，那么 net + (num-1).
-			in.count += int32(num - 1)
-
-			return nil, nil
-		}
-	} else {
-
-		// need split / migration
-		prevHighKey := in.HighKey
-
-		// first, set leftmostPtr or entry[pos].value = values[0]
-		if pos < 0 {
-			in.leftmostPtr = values[idx]
-			idx++
-		} else {
-			in.Entries[pos].Value = values[idx]
-			idx++
-		}
-
-		// we go into 2 big branches in c++:
-		// if (batchSize < pos) { ... } else { ... }
-		if batchSizeCalc < pos {
-			// case1: "插入到中间 (migrated + new kvs + moved)"
-			/*
-				如果插入位置 pos 大于一个阈值（例如 batchSizeCalc，或 batch_size），可能意味着你要插入的位置更偏右，
-				从而会把当前结点先留一部分条目，然后有相当多的 old entry 以及新的 (key, value) 都去放入新的结点；
-			*/
-			migrateNum := pos - batchSizeCalc
-			// allocate slice
-			migrate := make([]Entry, migrateNum)
-			copy(migrate, in.Entries[batchSizeCalc:pos])
-
-			buf := make([]Entry, moveNum)
-			copy(buf, in.Entries[pos+1:pos+1+moveNum])
-
-			in.count = int32(batchSizeCalc)
-
-			totalNum := num + moveNum + migrateNum
-			newNum, lastChunk := in.CalculateNodeNum(totalNum, batchSizeCalc)
-
-			newNodes := make([]INodeInterface, newNum)
-			for i := 0; i < newNum; i++ {
-				newNodes[i] = NewINodeForInsertInBatch(in.level)
-			}
-
-			oldSibling, ok := in.siblingPtr.(INodeInterface)
-			if !ok {
-				oldSibling = nil
-			}
-			in.siblingPtr = newNodes[0]
-
-			migrateIdx := 0
-			bufIdx := 0
-			// c++: high_key = migrate[migrateIdx].key
-			// maybe in.Go => in.HighKey = ...
-			if migrateNum > 0 {
-				in.HighKey = migrate[migrateIdx].Key
-			}
-
-			// fill each newNodes[i] except last one
-			for i := 0; i < newNum-1; i++ {
-				newNodes[i].SetSibling(newNodes[i+1])
-				// call BatchInsertLastLevelWithMigrationAndMovement
-				migIdx, bfIdx, err := newNodes[i].BatchInsertLastLevelWithMigrationAndMovement(
-					migrate, migrateIdx, migrateNum,
-					keys, values, idx, num, batchSizeCalc,
-					buf, bufIdx, moveNum,
-				)
-				if err != nil {
-					return nil, err
-				}
-				migrateIdx = migIdx
-				bufIdx = bfIdx
-			}
-
-			newNodes[newNum-1].SetSibling(oldSibling)
-			_, _, err := newNodes[newNum-1].BatchInsertLastLevelWithMigrationAndMovement(
-				migrate, migrateIdx, migrateNum,
-				keys, values, idx, num, lastChunk,
-				buf, 0, moveNum,
-			)
-			if err != nil {
-				return nil, err
-			}
-			newNodes[newNum-1].SetHighKey(prevHighKey)
-
-			return newNodes, nil
-		} else {
-			// case2: "插入到中间 (new_kvs + moved)"
-			/*
-				如果 pos 不大于这个阈值，表示你要插入的位置不那么靠后，只需要把右边部分“moved”到新结点，也就是 Case2（new_kvs + moved）。
-			*/
-			moveIdx := 0
-			//需要移动的部分，先存入buf
-			buf := make([]Entry, moveNum)
-			copy(buf, in.Entries[pos+1:pos+1+moveNum])
-
-			// fill [pos+1.. batchSizeCalc) with as many from keys/values
-			for i := pos + 1; i < batchSizeCalc && idx < num; i++ {
-				in.Entries[i].Key = keys[idx]
-				in.Entries[i].Value = values[idx]
-				idx++
-			}
-
-			// c++ => cnt += (idx - move_num -1)
-			in.count += int32(idx - moveNum - 1)
-
-			for ; in.count < int32(batchSizeCalc) && moveIdx < moveNum; in.count, moveIdx = in.count+1, moveIdx+1 {
-				in.Entries[in.count].Key = buf[moveIdx].Key
-				in.Entries[in.count].Value = buf[moveIdx].Value
-			}
-
-			//var newHighKey interface{}
-			//if idx < num {
-			//	newHighKey = keys[idx]
-			//} else {
-			//	newHighKey = buf[moveIdx].Key
-			//}
-			// 先假设 newHighKey = prevHighKey (把父节点原来的 highKey 带过来)
-			newHighKey := prevHighKey
-			// 如果 idx < num, 说明后面 still have keys[idx.. num-1] 未插完
-			//   => 取 keys[num-1] or keys[idx] 里更大的?
-			//   你要看插入是升序还是？ 如果 keys 是排序好的, 取 keys[num-1] 即最大
-			if idx < num {
-				newHighKey = keys[idx]
-			} else {
-				newHighKey = buf[moveIdx].Key
-			}
-
-			totalNum := num - idx + moveNum - moveIdx
-			newNum, lastChunk := in.CalculateNodeNum(totalNum, batchSizeCalc)
-
-			newNodes := make([]INodeInterface, newNum)
-			for i := range newNodes {
-				newNodes[i] = NewINodeForInsertInBatch(in.level)
-			}
-
-			oldSibling, ok := in.siblingPtr.(INodeInterface)
-			if !ok {
-				oldSibling = nil
-			}
-			in.siblingPtr = newNodes[0]
-
-			for i := 0; i < newNum-1; i++ {
-				newNodes[i].SetSibling(newNodes[i+1])
-				_, _, err := newNodes[i].BatchInsertLastLevelWithMovement(
-					keys, values, idx, num, batchSizeCalc,
-					buf, moveIdx, moveNum,
-				)
-				if err != nil {
-					return nil, err
-				}
-			}
-			newNodes[newNum-1].SetSibling(oldSibling)
-			_, _, err := newNodes[newNum-1].BatchInsertLastLevelWithMovement(
-				keys, values, idx, num, lastChunk,
-				buf, moveIdx, moveNum,
-			)
-			if err != nil {
-				return nil, err
-			}
-			in.HighKey = newHighKey
-			newNodes[newNum-1].SetHighKey(prevHighKey)
-			return newNodes, nil
-		}
+	inserted := make([]Entry, 0, num-1)
+	for i := 1; i < num; i++ {
+		inserted = append(inserted, Entry{Key: keys[i], Value: values[i]})
 	}
+	return in.batchInsertEntries(pos, inserted), nil
 }
 
 // CalculateNodeNum 计算需要的新节点数量和最后一个节点的条目数
@@ -961,190 +749,71 @@ func (in *INode) BatchInsertWithMovement(
 	return idx, bufIdx, nil
 }
 
-// BatchInsert 批量插入到叶子节点，包括迁移和缓冲区处理
-// 返回新节点集合和错误（如果有）
+// BatchInsert 下一层节点批量分裂后，把新节点插入到当前内部节点，
+// 容量不足时分裂，返回新节点集合和错误（如果有）
 func (in *INode) BatchInsert(
 	keys []interface{}, values []NodeInterface, num int,
 ) ([]INodeInterface, error) {
-	// 1) 查找插入位置
+	// keys[0] 是分裂前节点的 HighKey，新节点全部插入到分裂前节点之后
 	pos := in.FindLowerBound(keys[0])
-
-	// 2) 计算阈值 batchSize (比如 batch_size = FillFactor * Cardinality)
-	batchSize := int(float64(in.Cardinality) * FillFactor)
-
-	// 3) 判断能否 in-place
-	//    原版 c++ often do: (cnt+num) < cardinality
-	inPlace := (int(in.count) + num) <= in.Cardinality
-
-	idx := 0
-
-	// 4) 计算 moveNum
-	moveNum := 0
-	if pos < 0 {
-		// 替换 leftmostPtr + 还要在 [0..count-1] 向后挪 num
-		moveNum = int(in.count)
-	} else {
-		// 替换 entry[pos], 还要把 [pos+1.. count-1] 向后挪 num
-		moveNum = int(in.count) - (pos + 1)
+	inserted := make([]Entry, num)
+	for i := 0; i < num; i++ {
+		inserted[i] = Entry{Key: keys[i], Value: values[i]}
+	}
+	return in.batchInsertEntries(pos, inserted), nil
+}
+
+// batchInsertEntries 把 inserted 插入到第 pos 个条目之后(pos 为 -1 时插入到最前面)。
+// 容量足够时原地插入并返回 nil；否则当前节点保留 FillFactor*Cardinality 个条目，
+// 其余条目依次放入新建的右兄弟节点，每个新节点的第一个条目成为它的 leftmostPtr，
+// 返回从左到右相连的新节点，最后一个新节点继承原来的 HighKey 和兄弟指针。
+func (in *INode) batchInsertEntries(pos int, inserted []Entry) []INodeInterface {
+	count := int(in.count)
+	all := make([]Entry, 0, count+len(inserted))
+	all = append(all, in.Entries[:pos+1]...)
+	all = append(all, inserted...)
+	all = append(all, in.Entries[pos+1:count]...)
+	if len(all) <= in.Cardinality {
+		in.Entries = append(in.Entries[:0], all...)
+		in.count = int32(len(all))
+		return nil
 	}
 
-	// Case 1: Insert in-place
-	if inPlace {
-		in.moveNormalInsertionForInnerNode(pos, num, moveNum)
-		//如果插入的位置，是在最左侧，那么整体右移，原来的leftmostPtr现在到了entries[0]
-		// 再插入
-		if pos < 0 {
-			// pos=-1 => entries[0 .. num-1] 填上 (keys, values)
-			for i := 0; i < num; i++ {
-				in.Entries[i] = Entry{Key: keys[i], Value: values[i]}
-			}
+	batchSize := int(float64(in.Cardinality) * FillFactor)
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	prevHighKey, oldSibling := in.HighKey, in.siblingPtr
+	rest := all[batchSize:]
+	in.Entries = append(in.Entries[:0], all[:batchSize]...)
+	in.count = int32(batchSize)
+	// 条目的键是它左侧孩子的 HighKey，也就是下一个节点的下界
+	in.HighKey = rest[0].Key
+
+	var newNodes []INodeInterface
+	prev := in
+	for len(rest) > 0 {
+		n := batchSize + 1
+		if n > len(rest) {
+			n = len(rest)
+		}
+		node := NewINodeForInsertInBatch(in.level)
+		node.opts = in.opts
+		node.leftmostPtr = rest[0].Value.(NodeInterface)
+		node.Entries = append(node.Entries, rest[1:n]...)
+		node.count = int32(n - 1)
+		rest = rest[n:]
+		if len(rest) > 0 {
+			node.HighKey = rest[0].Key
 		} else {
-			// pos>=0 => entry[pos+1.. pos+num] 填
-			for i, j := pos+1, 0; j < num; i, j = i+1, j+1 {
-				in.Entries[i] = Entry{Key: keys[j], Value: values[j]}
-			}
+			node.HighKey = prevHighKey
+			node.siblingPtr = oldSibling
 		}
-		in.count += int32(num)
-		in.HighKey = in.Entries[in.count-1].Value.(INodeInterface).GetHighKey()
-		return nil, nil
-	}
-
-	// Case 2: Split the node for insertion
-	// need insert in the middle (migrated + new kvs + moved)
-	if batchSize < pos {
-		// Migrate entries
-		migrateNum := pos - batchSize
-		migrate := make([]Entry, migrateNum)
-		copy(migrate, in.Entries[batchSize:batchSize+migrateNum])
-
-		// Buffer entries to be moved
-		bufEntries := make([]Entry, moveNum)
-		copy(bufEntries, in.Entries[pos+1:pos+1+moveNum])
-
-		// Adjust current node
-		in.count = int32(batchSize)
-
-		// Calculate new nodes needed
-		totalNum := num + moveNum + migrateNum
-		newNum, lastChunk := in.CalculateNodeNum(totalNum, batchSize)
-
-		// Create new sibling nodes
-		newNodes := make([]INodeInterface, newNum)
-		for i := 0; i < newNum; i++ {
-			newNodes[i] = NewINodeForInsertInBatch(in.level)
-		}
-
-		// Adjust sibling pointers
-		oldSibling, ok := in.siblingPtr.(INodeInterface)
-		if !ok {
-			oldSibling = nil
-		}
-		in.siblingPtr = newNodes[0]
-
-		// Insert data into sibling nodes
-		migrateIdx, moveIdx := 0, 0
-		prevHighKey := in.HighKey
-		if migrateNum > 0 {
-			in.HighKey = migrate[migrateIdx].Key
-		}
-
-		for i := 0; i < newNum-1; i++ {
-			newNodes[i].SetSibling(newNodes[i+1])
-			var err error
-			migrateIdx, moveIdx, err = newNodes[i].BatchInsertWithMigrationAndMovement(
-				migrate, migrateIdx, migrateNum,
-				keys, values, idx, num,
-				batchSize, bufEntries, moveIdx, moveNum,
-			)
-			if err != nil {
-				return nil, err
-			}
-		}
-
-		// Last node adjustments
-		newNodes[newNum-1].SetSibling(oldSibling)
-		newNodes[newNum-1].SetHighKey(prevHighKey)
-		_, _, err := newNodes[newNum-1].BatchInsertWithMigrationAndMovement(
-			migrate, migrateIdx, migrateNum,
-			keys, values, idx, num,
-			lastChunk, bufEntries, moveIdx, moveNum,
-		)
-		if err != nil {
-			return nil, err
-		}
-
-		return newNodes, nil
+		prev.siblingPtr = node
+		prev = node
+		newNodes = append(newNodes, node)
 	}
-
-	// Case 3: Insert into the middle without migration
-	// need insert in the middle (new_kvs + moved)
-	moveIdx := 0
-	bufEntries := make([]Entry, moveNum)
-	copy(bufEntries, in.Entries[pos+1:pos+1+moveNum])
-
-	// Fill the current node
-	for i := pos + 1; i < batchSize && idx < num; i, idx = i+1, idx+1 {
-
-		in.Entries[i] = Entry{Key: keys[idx], Value: values[idx]}
-	}
-
-	in.count += int32(idx - moveNum)
-	for int(in.count) < batchSize && moveIdx < moveNum {
-		if len(in.Entries) <= int(in.count) {
-			in.Entries = append(in.Entries, Entry{}) // Expanding the slice if needed
-		}
-		in.Entries[in.count] = bufEntries[moveIdx]
-		in.IncrementCount()
-		moveIdx++
-	}
-
-	prevHighKey := in.HighKey
-	if idx < num {
-		in.HighKey = keys[idx]
-	} else if moveIdx < moveNum {
-		in.HighKey = bufEntries[moveIdx].Key
-	}
-
-	// Calculate new nodes needed
-	totalNum := num - idx + moveNum - moveIdx
-	newNum, lastChunk := in.CalculateNodeNum(totalNum, batchSize)
-
-	// Create new sibling nodes
-	newNodes := make([]INodeInterface, newNum)
-	for i := 0; i < newNum; i++ {
-		newNodes[i] = NewINodeForInsertInBatch(in.level)
-	}
-
-	// Adjust sibling pointers
-	oldSibling, ok := in.siblingPtr.(INodeInterface)
-	if !ok {
-		oldSibling = nil
-	}
-	in.siblingPtr = newNodes[0]
-
-	// Insert data into sibling nodes
-	for i := 0; i < newNum-1; i++ {
-		newNodes[i].SetSibling(newNodes[i+1])
-		var err error
-		idx, moveIdx, err = newNodes[i].BatchInsertWithMovement(
-			keys, values, idx, num, batchSize, bufEntries, moveIdx, moveNum,
-		)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	// Last node adjustments
-	newNodes[newNum-1].SetSibling(oldSibling)
-	newNodes[newNum-1].SetHighKey(prevHighKey)
-	_, _, err := newNodes[newNum-1].BatchInsertWithMovement(
-		keys, values, idx, num, lastChunk, bufEntries, moveIdx, moveNum,
-	)
-	if err != nil {
-		return nil, err
-	}
-
-	return newNodes, nil
+	return newNodes
 }
 
 func (n *INode) GetRightmostPtr() NodeInterface {
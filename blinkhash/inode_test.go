@@ -58,8 +58,8 @@ func TestINode_Insert(t *testing.T) {
 	if inode.count != 1 {
 		t.Errorf("Expected count to be 1, got %d", inode.count)
 	}
-	if inode.HighKey != 10 {
-		t.Errorf("Expected HighKey to be 10, got %v", inode.HighKey)
+	if inode.HighKey != nil { // HighKey 只在分裂时设置
+		t.Errorf("Expected HighKey to stay nil, got %v", inode.HighKey)
 	}
 	if inode.leftmostPtr != nil {
 		t.Errorf("Expected leftmostPtr to be nil, got %v", inode.leftmostPtr)
@@ -80,7 +80,7 @@ func TestINode_Insert(t *testing.T) {
 	if inode.count != 2 {
 		t.Errorf("Expected count to be 2, got %d", inode.count)
 	}
-	if inode.HighKey != 20 {
+	if inode.HighKey != 20 { // InsertWithLeft 把 HighKey 更新为最后一个键
 		t.Errorf("Expected HighKey to be 20, got %v", inode.HighKey)
 	}
 	if inode.Entries[1].Key != 20 {
@@ -94,6 +94,7 @@ func TestINode_Insert(t *testing.T) {
 // TestINode_Split 测试节点分裂
 func TestINode_Split(t *testing.T) {
 	inode := NewINodeForInsertInBatch(1)
+	inode.HighKey = 50
 	batchSize := 4
 	// 假设卡片容量为4
 	for i := 1; i <= batchSize; i++ {
@@ -104,8 +105,7 @@ func TestINode_Split(t *testing.T) {
 	}
 
 	// 分裂节点
-	splittableNode, splitKey := inode.Split(0, 0, 0)
-	newNode := splittableNode.(INodeInterface)
+	newNode, splitKey := inode.Split()
 	if newNode == nil {
 		t.Fatalf("Split returned nil")
 	}
@@ -121,10 +121,10 @@ func TestINode_Split(t *testing.T) {
 	if inode.HighKey != 30 {
 		t.Errorf("Expected original node HighKey to be 30, got %v", inode.HighKey)
 	}
-	if newNode.GetHighKey() != 40 {
-		t.Errorf("Expected new node HighKey to be 40, got %v", newNode.GetHighKey())
+	if newNode.GetHighKey() != 50 { // 新节点继承原来的 HighKey
+		t.Errorf("Expected new node HighKey to be 50, got %v", newNode.GetHighKey())
 	}
-	if inode.siblingPtr != newNode.GetNode() {
+	if inode.siblingPtr != newNode {
 		t.Errorf("Expected original node siblingPtr to point to new node")
 	}
 }
@@ -151,10 +151,10 @@ func TestINode_BatchInsert(t *testing.T) {
 			t.Errorf("Expected no new nodes, got %v", newNodes)
 		}
 		if inode.count != 2 {
-			t.Errorf("Expected count to be 3, got %d", inode.count)
+			t.Errorf("Expected count to be 2, got %d", inode.count)
 		}
-		if inode.HighKey != 20 {
-			t.Errorf("Expected HighKey to be 30, got %v", inode.HighKey)
+		if inode.HighKey != nil { // 不分裂时 HighKey 不变
+			t.Errorf("Expected HighKey to stay nil, got %v", inode.HighKey)
 		}
 		for i, key := range keys {
 			if inode.Entries[i].Key != key {
@@ -180,16 +180,17 @@ func TestINode_BatchInsert(t *testing.T) {
 			t.Errorf("Expected new node count to be 4, got %d", inode.count)
 		}
 	})
-	t.Run("TEST Case 2， Insertion Causing Split with Migration and Movement", func(t *testing.T) {
+	t.Run("TEST Case 2， Insertion Causing Split", func(t *testing.T) {
 		inode := INode{
 			Node: Node{
 				level: 1,
 			},
 			Cardinality: 5,
+			HighKey:     50,
 			Entries:     make([]Entry, 0, 5), // 初始化为空但有容量
 		}
-		keys := []interface{}{10, 15, 17, 20}
-		values := []*Node{NewNode(10), NewNode(15), NewNode(17), NewNode(20)}
+		keys := []interface{}{10, 20, 30, 40}
+		values := []*Node{NewNode(10), NewNode(20), NewNode(30), NewNode(40)}
 		num := 4
 
 		newNodes, err := inode.BatchInsert(keys, nodeInterfaceSliceForNodes(values), num)
@@ -200,10 +201,7 @@ func TestINode_BatchInsert(t *testing.T) {
 			t.Errorf("Expected no new nodes, got %v", newNodes)
 		}
 		if inode.count != 4 {
-			t.Errorf("Expected count to be 3, got %d", inode.count)
-		}
-		if inode.HighKey != 20 {
-			t.Errorf("Expected HighKey to be 30, got %v", inode.HighKey)
+			t.Errorf("Expected count to be 4, got %d", inode.count)
 		}
 		for i, key := range keys {
 			if inode.Entries[i].Key != key {
@@ -213,24 +211,28 @@ func TestINode_BatchInsert(t *testing.T) {
 				t.Errorf("Expected Entries[%d].Value to be node %d, got %v", i, i+1, inode.Entries[i].Value)
 			}
 		}
-		//继续插入，节点会分裂
-		batch2Keys := []interface{}{12, 18}
-		batch2Values := []*Node{NewNode(12), NewNode(18)}
+		// 键 20 的孩子分裂出两个新节点，插入到它后面，超过容量时节点分裂
+		batch2Keys := []interface{}{22, 24}
+		batch2Values := []*Node{NewNode(22), NewNode(24)}
 		batch2Num := 2
 
 		newNodes, err = inode.BatchInsert(batch2Keys, nodeInterfaceSliceForNodes(batch2Values), batch2Num)
 		if err != nil {
 			t.Fatalf("BatchInsert failed: %v", err)
 		}
-		if newNodes != nil || len(newNodes) != 0 {
-			t.Errorf("Expected insert in-place, got %v", newNodes)
+		if len(newNodes) != 1 {
+			t.Fatalf("Expected one new node after split, got %v", newNodes)
 		}
-		if inode.count != 4 { // adjust based on BatchInsert logic
-			t.Errorf("Expected new node count to be 4, got %d", inode.count)
+		if inode.count != 5 || inode.HighKey != 40 || inode.siblingPtr != newNodes[0] {
+			t.Errorf("Expected the original node to keep 5 entries up to 40, got %d entries up to %v", inode.count, inode.HighKey)
+		}
+		// 第 6 个条目成为新节点的 leftmostPtr，新节点继承原来的 HighKey
+		if newNodes[0].GetCount() != 0 || newNodes[0].GetLeftmostPtr() != values[3] || newNodes[0].GetHighKey() != 50 {
+			t.Errorf("Expected the new node to hold only node 40 up to 50, got %d entries up to %v", newNodes[0].GetCount(), newNodes[0].GetHighKey())
 		}
 	})
 
-	t.Run("TEST Case 2， Insertion Causing Split with Migration and Movement", func(t *testing.T) {
+	t.Run("TEST Case 3， Insertion Filling Node In Place", func(t *testing.T) {
 		inode := INode{
 			Node: Node{
 				level: 1,
@@ -238,8 +240,8 @@ func TestINode_BatchInsert(t *testing.T) {
 			Cardinality: 5,
 			Entries:     make([]Entry, 0, 5), // 初始化为空但有容量
 		}
-		keys := []interface{}{10, 15, 20}
-		values := []*Node{NewNode(10), NewNode(15), NewNode(20)}
+		keys := []interface{}{10, 20, 30}
+		values := []*Node{NewNode(10), NewNode(20), NewNode(30)}
 		num := 3
 
 		newNodes, err := inode.BatchInsert(keys, nodeInterfaceSliceForNodes(values), num)
@@ -252,31 +254,25 @@ func TestINode_BatchInsert(t *testing.T) {
 		if inode.count != 3 {
 			t.Errorf("Expected count to be 3, got %d", inode.count)
 		}
-		if inode.HighKey != 20 {
-			t.Errorf("Expected HighKey to be 30, got %v", inode.HighKey)
-		}
-		for i, key := range keys {
-			if inode.Entries[i].Key != key {
-				t.Errorf("Expected Entries[%d].Key to be %v, got %v", i, key, inode.Entries[i].Key)
-			}
-			if inode.Entries[i].Value != values[i] {
-				t.Errorf("Expected Entries[%d].Value to be node %d, got %v", i, i+1, inode.Entries[i].Value)
-			}
-		}
-		//继续插入，节点会分裂
-		batch2Keys := []interface{}{12, 18}
-		batch2Values := []*Node{NewNode(12), NewNode(18)}
+		// 插入后刚好装满，不分裂
+		batch2Keys := []interface{}{22, 24}
+		batch2Values := []*Node{NewNode(22), NewNode(24)}
 		batch2Num := 2
 
 		newNodes, err = inode.BatchInsert(batch2Keys, nodeInterfaceSliceForNodes(batch2Values), batch2Num)
 		if err != nil {
 			t.Fatalf("BatchInsert failed: %v", err)
 		}
-		if newNodes != nil || len(newNodes) != 0 {
+		if len(newNodes) != 0 {
 			t.Errorf("Expected insert in-place, got %v", newNodes)
 		}
-		if inode.count != 4 { // adjust based on BatchInsert logic
-			t.Errorf("Expected new node count to be 4, got %d", inode.count)
+		if inode.count != 5 {
+			t.Errorf("Expected count to be 5, got %d", inode.count)
+		}
+		for i, key := range []int{10, 20, 22, 24, 30} {
+			if inode.Entries[i].Key != key {
+				t.Errorf("Expected Entries[%d].Key to be %v, got %v", i, key, inode.Entries[i].Key)
+			}
 		}
 	})
 }
@@ -298,8 +294,8 @@ func TestINode_BatchMigrate(t *testing.T) {
 	if updatedIdx != 2 {
 		t.Errorf("Expected migrateIdx to be 2, got %d", updatedIdx)
 	}
-	if inode.count != 2 {
-		t.Errorf("Expected count to be 2, got %d", inode.count)
+	if inode.count != 1 { // 第一个条目成为 leftmostPtr
+		t.Errorf("Expected count to be 1, got %d", inode.count)
 	}
 	if inode.leftmostPtr != migrate[0].Value {
 		t.Errorf("Expected leftmostPtr to be node 1, got %v", inode.leftmostPtr)
@@ -336,12 +332,13 @@ func TestINode_BatchKvPair(t *testing.T) {
 	if inode.count != 2 {
 		t.Errorf("Expected count to be 2, got %d", inode.count)
 	}
-	if inode.HighKey != 20 {
-		t.Errorf("Expected HighKey to be 20, got %v", inode.HighKey)
+	if inode.HighKey != 30 { // 达到批量大小时下一个键成为 HighKey
+		t.Errorf("Expected HighKey to be 30, got %v", inode.HighKey)
 	}
 
-	// 执行剩余插入
-	newIdx, reached, err = inode.BatchKvPair(keys, nodeInterfaceSliceForNodes(values), newIdx, num, batchSize)
+	// 剩余的键值对放入下一个节点
+	next := NewINodeForInsertInBatch(1)
+	newIdx, reached, err = next.BatchKvPair(keys, nodeInterfaceSliceForNodes(values), newIdx, num, batchSize)
 	if err != nil {
 		t.Fatalf("BatchKvPair failed on second call: %v", err)
 	}
@@ -351,11 +348,8 @@ func TestINode_BatchKvPair(t *testing.T) {
 	if reached {
 		t.Errorf("Expected reached to be false, got true")
 	}
-	if inode.count != 3 {
-		t.Errorf("Expected count to be 3, got %d", inode.count)
-	}
-	if inode.HighKey != 30 {
-		t.Errorf("Expected HighKey to be 30, got %v", inode.HighKey)
+	if next.count != 1 || next.Entries[0].Key != 30 {
+		t.Errorf("Expected the next node to hold key 30, got %d entries", next.count)
 	}
 }
 
@@ -371,10 +365,7 @@ func TestINode_BatchBuffer(t *testing.T) {
 	batchSize := 2
 	bufIdx := 0
 
-	newBufIdx, reached, err := inode.BatchBuffer(buf, bufIdx, bufNum, batchSize)
-	if err != nil {
-		t.Fatalf("BatchBuffer failed: %v", err)
-	}
+	newBufIdx, reached := inode.BatchBuffer(buf, bufIdx, bufNum, batchSize)
 	if newBufIdx != 2 {
 		t.Errorf("Expected bufIdx to be 2, got %d", newBufIdx)
 	}
@@ -384,33 +375,29 @@ func TestINode_BatchBuffer(t *testing.T) {
 	if inode.count != 2 {
 		t.Errorf("Expected count to be 2, got %d", inode.count)
 	}
-	if inode.HighKey != 20 {
-		t.Errorf("Expected HighKey to be 20, got %v", inode.HighKey)
+	if inode.HighKey != 30 { // 达到批量大小时下一个键成为 HighKey
+		t.Errorf("Expected HighKey to be 30, got %v", inode.HighKey)
 	}
 
-	// 执行剩余插入
-	newBufIdx, reached, err = inode.BatchBuffer(buf, newBufIdx, bufNum, batchSize)
-	if err != nil {
-		t.Fatalf("BatchBuffer failed on second call: %v", err)
-	}
+	// 剩余的条目放入下一个节点
+	next := NewINodeForInsertInBatch(1)
+	newBufIdx, reached = next.BatchBuffer(buf, newBufIdx, bufNum, batchSize)
 	if newBufIdx != 3 {
 		t.Errorf("Expected bufIdx to be 3, got %d", newBufIdx)
 	}
 	if reached {
 		t.Errorf("Expected reached to be false, got true")
 	}
-	if inode.count != 3 {
-		t.Errorf("Expected count to be 3, got %d", inode.count)
-	}
-	if inode.HighKey != 30 {
-		t.Errorf("Expected HighKey to be 30, got %v", inode.HighKey)
+	if next.count != 1 || next.Entries[0].Key != 30 {
+		t.Errorf("Expected the next node to hold key 30, got %d entries", next.count)
 	}
 }
 
 // TestINode_SplitAndBatchInsert 测试分裂后批量插入
 func TestINode_SplitAndBatchInsert(t *testing.T) {
 	inode := NewINodeForInsertInBatch(1)
-	// 假设 batchSize = 2, cardinality = 4 (PageSize assumptions)
+	inode.Cardinality = 4
+	inode.HighKey = 70
 	keys := []interface{}{10, 20, 30, 40}
 	values := []*Node{NewNode(1), NewNode(2), NewNode(3), NewNode(4)}
 	num := 4
@@ -427,8 +414,8 @@ func TestINode_SplitAndBatchInsert(t *testing.T) {
 	if inode.count != 4 {
 		t.Errorf("Expected count to be 4, got %d", inode.count)
 	}
-	if inode.HighKey != 40 {
-		t.Errorf("Expected HighKey to be 40, got %v", inode.HighKey)
+	if inode.HighKey != 70 {
+		t.Errorf("Expected HighKey to stay 70, got %v", inode.HighKey)
 	}
 
 	// 插入第5条，导致节点分裂
@@ -441,20 +428,20 @@ func TestINode_SplitAndBatchInsert(t *testing.T) {
 		t.Fatalf("BatchInsert after split failed: %v", err)
 	}
 
-	if newNodes == nil || len(newNodes) != 1 {
-		t.Errorf("Expected one new node after split, got %v", newNodes)
+	if len(newNodes) != 1 {
+		t.Fatalf("Expected one new node after split, got %v", newNodes)
 	}
-	if inode.count != 2 {
-		t.Errorf("Expected original node count to be 2 after split, got %d", inode.count)
+	if inode.count != 4 {
+		t.Errorf("Expected original node count to be 4 after split, got %d", inode.count)
 	}
-	if inode.HighKey != 30 {
-		t.Errorf("Expected original node HighKey to be 30 after split, got %v", inode.HighKey)
+	if inode.HighKey != 50 {
+		t.Errorf("Expected original node HighKey to be 50 after split, got %v", inode.HighKey)
 	}
-	if newNodes[0].count != 4 {
-		t.Errorf("Expected new node count to be 4, got %d", newNodes[0].count)
+	if newNodes[0].GetCount() != 1 || newNodes[0].GetLeftmostPtr() != valuesSplit[0] {
+		t.Errorf("Expected new node to hold node 5 and one entry, got %d entries", newNodes[0].GetCount())
 	}
-	if newNodes[0].HighKey != 60 {
-		t.Errorf("Expected new node HighKey to be 60, got %v", newNodes[0].HighKey)
+	if newNodes[0].GetHighKey() != 70 {
+		t.Errorf("Expected new node HighKey to be 70, got %v", newNodes[0].GetHighKey())
 	}
 }
 
@@ -515,10 +502,10 @@ func TestINode_ScanNode(t *testing.T) {
 		t.Errorf("Expected ScanNode(35) to return sibling node, got %v", node)
 	}
 
-	// 测试 key equal to HighKey
+	// 测试 key equal to HighKey：条目的键是它左侧孩子的上界，30 仍属于键 20 的孩子
 	node = inode.ScanNode(30)
-	if node == nil || node != values[2] {
-		t.Errorf("Expected ScanNode(30) to return node 3, got %v", node)
+	if node == nil || node != values[1] {
+		t.Errorf("Expected ScanNode(30) to return node 2, got %v", node)
 	}
 }
 
@@ -538,10 +525,10 @@ func ExampleINode_Print() {
 	inode.Print()
 	// Output:
 	// LeftmostPtr: <nil>
-	// [0] Key: 10, Value: &blinkhash.MockNode{...}
-	// [1] Key: 20, Value: &blinkhash.MockNode{...}
-	// [2] Key: 30, Value: &blinkhash.MockNode{...}
-	// HighKey: 30
+	// [0] Key: 10, Value: &{0 <nil> <nil> 0 1}
+	// [1] Key: 20, Value: &{0 <nil> <nil> 0 2}
+	// [2] Key: 30, Value: &{0 <nil> <nil> 0 3}
+	// HighKey: <nil>
 }
 
 // Similarly, other methods like BatchInsertWithMigrationAndMovement can have their own test cases.
@@ -0,0 +1,333 @@
+package blinkhash
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// LNodeAppend 只追加的数组叶子，针对严格递增的键(例如时间戳)。
+//
+// 键大于叶子中最后一个键时直接追加到末尾，不需要查找位置、移动条目或计算哈希；
+// 最右叶子的 HighKey 就是最后一个键，顺序写入总是走这条路径。
+// 乱序的键返回 NeedConvert，由 bt.convert 把叶子转换为 LNodeBTree 后重试。
+// 叶子满时左侧保留全部条目，新键放入新的右叶子，顺序写入留下的叶子都是满的。
+type LNodeAppend struct {
+	Node
+	nodeOptions
+	HighKey     interface{}
+	Cardinality int
+	contents    *leafContents // 定长的键数组和值数组，见 value_array.go
+
+	left NodeInterface // 左邻叶子，转换时需要锁住它修改兄弟指针，由 linkLeft 维护
+}
+
+// NewLNodeAppend 创建一个空的 LNodeAppend 节点
+func NewLNodeAppend(level int) *LNodeAppend {
+	return &LNodeAppend{
+		Node:        Node{level: level},
+		Cardinality: LNodeBTreeCardinality,
+		contents:    newLeafContents(LNodeBTreeCardinality),
+	}
+}
+
+func (la *LNodeAppend) GetHighKey() interface{} {
+	return la.HighKey
+}
+
+func (la *LNodeAppend) SetHighKey(key interface{}) { la.HighKey = key }
+
+// Print 打印追加叶子的信息和全部条目
+func (la *LNodeAppend) Print() {
+	fmt.Printf("LNodeAppend Information:\n")
+	fmt.Printf("HighKey: %v\n", la.HighKey)
+	fmt.Printf("Cardinality: %d\n", la.Cardinality)
+	la.Node.Print()
+	fmt.Printf("Entries:\n")
+	c := la.contents
+	for i, key := range c.sortedKeys() {
+		fmt.Printf("\tEntry %d: Key = %v, Value = %v\n", i, key, c.values.get(i))
+	}
+}
+
+// SanityCheck 检查键严格递增并且不超过上界
+func (la *LNodeAppend) SanityCheck(_highKey interface{}, first bool) {
+	keys := la.contents.sortedKeys()
+	for i, key := range keys {
+		if i > 0 && key <= keys[i-1] {
+			fmt.Printf("lnode_append:: key order is not preserved: [%d] %d after %d\n", i, key, keys[i-1])
+		}
+		if la.siblingPtr != nil && compareIntKeys(key, la.HighKey) > 0 {
+			fmt.Printf("lnode_append:: %d (%v) is higher than high Key %v\n", i, key, la.HighKey)
+		}
+		if !first && compareIntKeys(key, _highKey) <= 0 {
+			fmt.Printf("lnode_append:: %d (%v) is not higher than previous high Key %v\n", i, key, _highKey)
+		}
+	}
+	if la.siblingPtr != nil {
+		la.siblingPtr.SanityCheck(la.HighKey, false)
+	}
+}
+
+// inOrder 返回 key 是否可以直接追加。乐观读取时调用方需要在加锁后再检查一次
+func (la *LNodeAppend) inOrder(key int) bool {
+	keys := la.contents.sortedKeys()
+	return len(keys) == 0 || key > keys[len(keys)-1]
+}
+
+// Insert
+//
+//	@Description: 实现Insertable接口：有序的键追加到末尾，乱序的键返回 NeedConvert。
+//	满时保持写锁返回 NeedSplit，与 LNodeBTree 相同
+//	@receiver la
+//	@param key
+//	@param value
+//	@param version
+//	@return int
+func (la *LNodeAppend) Insert(key interface{}, value interface{}, version uint64) int {
+	k := key.(int)
+	// 转换需要叶子处于未加锁的状态，在加锁之前判断
+	if !la.inOrder(k) {
+		return NeedConvert
+	}
+	success, needRestart := la.TryUpgradeWriteLock(version)
+	if needRestart || !success {
+		return NeedRestart
+	}
+	if !la.inOrder(k) {
+		la.WriteUnlock()
+		return NeedRestart
+	}
+	if la.contents.len() >= la.Cardinality && la.purgeLocked() == 0 {
+		return NeedSplit
+	}
+	la.appendLocked(k, value)
+	la.WriteUnlock()
+	return InsertSuccess
+}
+
+// InsertSorted 在一次写锁内追加按键排好序的条目，只追加容量允许、大于最后一个键
+// 且不超过 HighKey(最右叶子不受限制) 的前缀，返回追加的条目数。
+// 第一个条目乱序时返回 NeedConvert，一个也追加不了时返回 NeedSplit，两种情况都不持有锁
+func (la *LNodeAppend) InsertSorted(entries []Entry, version uint64) (int, int) {
+	if !la.inOrder(entries[0].Key.(int)) {
+		return 0, NeedConvert
+	}
+	success, needRestart := la.TryUpgradeWriteLock(version)
+	if needRestart || !success {
+		return 0, NeedRestart
+	}
+	if la.contents.len()+len(entries) > la.Cardinality {
+		la.purgeLocked()
+	}
+	n := 0
+	for room := la.Cardinality - la.contents.len(); n < len(entries) && n < room; n++ {
+		k := entries[n].Key.(int)
+		if !la.inOrder(k) || (la.siblingPtr != nil && compareIntKeys(k, la.HighKey) > 0) {
+			break
+		}
+		la.appendLocked(k, entries[n].Value)
+	}
+	la.WriteUnlock()
+	if n == 0 {
+		return 0, NeedSplit
+	}
+	return n, InsertSuccess
+}
+
+func (la *LNodeAppend) appendLocked(key int, value interface{}) {
+	la.contents.appendEntry(key, value)
+	la.count++
+	if compareIntKeys(key, la.HighKey) > 0 {
+		la.HighKey = key
+	}
+}
+
+// Split
+//
+//	@Description: 实现Splittable接口。调用方持有写锁并且 key 大于叶子中所有的键：
+//	当前叶子保留全部条目，key 放入新的右叶子
+//	@receiver la
+//	@param key
+//	@param value
+//	@param version
+//	@return Splittable
+//	@return interface{}
+func (la *LNodeAppend) Split(key interface{}, value interface{}, version uint64) (Splittable, interface{}) {
+	keys := la.contents.sortedKeys()
+	if len(keys) == 0 {
+		panic("Split: cannot split a node with zero entries")
+	}
+	splitKey := interface{}(keys[len(keys)-1])
+	newLeaf := NewLNodeAppend(la.level)
+	newLeaf.opts = la.opts
+	newLeaf.siblingPtr = la.siblingPtr
+	newLeaf.HighKey = la.HighKey
+	newLeaf.left = la
+	newLeaf.appendLocked(key.(int), value)
+	linkLeft(newLeaf.siblingPtr, newLeaf)
+
+	la.siblingPtr = newLeaf
+	la.HighKey = splitKey
+	return newLeaf, splitKey
+}
+
+// Convert 把叶子转换为一个内容相同的 LNodeBTree，与 LNodeHash.Convert 的约定相同：
+// 成功时叶子和新叶子都保持写锁，由 bt.convert 安装到父节点；失败时不持有任何锁
+func (la *LNodeAppend) Convert(version uint64) ([]*LNodeBTree, int, error) {
+	success, needRestart := la.TryUpgradeWriteLock(version)
+	if needRestart || !success {
+		return nil, 0, fmt.Errorf("failed to write-lock append leaf")
+	}
+	left := la.left
+	if left != nil {
+		if !left.TryWriteLock() {
+			la.WriteUnlock()
+			return nil, 0, fmt.Errorf("failed to write-lock left sibling")
+		}
+		if left.GetSiblingPtr() != NodeInterface(la) {
+			left.WriteUnlock()
+			la.WriteUnlock()
+			return nil, 0, fmt.Errorf("left sibling no longer points to the leaf")
+		}
+	}
+
+	leaf := NewLNodeBTreeWithSibling(la.siblingPtr, la.count, la.level)
+	leaf.opts = la.opts
+	leaf.contents.Store(la.contents.copyRange(0, la.contents.len(), leaf.Cardinality))
+	leaf.HighKey = la.HighKey
+	leaf.written = 1
+	leaf.TryWriteLock()
+	linkLeft(la.siblingPtr, leaf)
+
+	if left != nil {
+		left.(LeafNodeInterface).SetSibling(leaf)
+		left.WriteUnlock()
+	}
+	return []*LNodeBTree{leaf}, 1, nil
+}
+
+// Update 原地修改值，不改变键的顺序
+func (la *LNodeAppend) Update(key interface{}, value interface{}, version uint64) int {
+	success, needRestart := la.TryUpgradeWriteLock(version)
+	if needRestart || !success {
+		return NeedRestart
+	}
+	pos := la.findPos(key)
+	if pos >= 0 {
+		la.contents.values.set(pos, value)
+	}
+	la.WriteUnlock()
+	if pos < 0 {
+		return UpdateFailure
+	}
+	return UpdateSuccess
+}
+
+// Remove 删除条目，剩下的键仍然有序，之后仍然只能追加大于最后一个键的键
+func (la *LNodeAppend) Remove(key interface{}, version uint64) int {
+	success, needRestart := la.TryUpgradeWriteLock(version)
+	if needRestart || !success {
+		return NeedRestart
+	}
+	pos := la.findPos(key)
+	if pos < 0 {
+		la.WriteUnlock()
+		return KeyNotFound
+	}
+	la.contents.removeRange(pos, pos+1)
+	la.count--
+	la.WriteUnlock()
+	return RemoveSuccess
+}
+
+// removeRangeLocked 删除位置 [from, to) 的条目，调用方持有写锁
+func (la *LNodeAppend) removeRangeLocked(from, to int) {
+	la.contents.removeRange(from, to)
+	la.count -= int32(to - from)
+}
+
+// findPos 返回 key 在 keys 中的位置，不存在时返回 -1
+func (la *LNodeAppend) findPos(key interface{}) int {
+	k, ok := intKey(key)
+	if !ok {
+		return -1
+	}
+	keys := la.contents.sortedKeys()
+	pos := lowerBoundInts(keys, k, la.options().leafSearch())
+	if pos < len(keys) && keys[pos] == k {
+		return pos
+	}
+	return -1
+}
+
+func (la *LNodeAppend) Find(key interface{}) (interface{}, bool) {
+	if pos := la.findPos(key); pos >= 0 {
+		return la.contents.values.get(pos), true
+	}
+	return nil, false
+}
+
+func (la *LNodeAppend) RangeLookUp(key interface{}, upTo int, continued bool, version uint64) ([]interface{}, int, int) {
+	entries, retCode, count := la.RangeLookUpEntries(key, upTo, continued, version)
+	return entryValues(entries), retCode, count
+}
+
+// RangeLookUpEntries 与 LNodeBTree 相同：乐观读取时数组是定长的，读到的条目数不会越界，
+// 读到的内容由调用方通过版本校验决定是否丢弃
+func (la *LNodeAppend) RangeLookUpEntries(key interface{}, upTo int, continued bool, version uint64) ([]Entry, int, int) {
+	c := la.contents
+	keys := c.sortedKeys()
+	n := len(keys)
+	start := 0
+	if !continued {
+		keyInt, ok := key.(int)
+		if !ok {
+			panic("RangeLookUpEntries: key is not of type int")
+		}
+		start = lowerBoundInts(keys, keyInt, la.options().leafSearch())
+	}
+	end := n
+	if end-start > upTo {
+		end = start + upTo
+	}
+	if end <= start {
+		return nil, 0, 0
+	}
+	collected := c.entries(start, end)
+	return collected, 0, len(collected)
+}
+
+func (la *LNodeAppend) GetEntries() []Entry {
+	c := la.contents
+	return c.entries(0, c.len())
+}
+
+func (la *LNodeAppend) Utilization() float64 {
+	return float64(la.contents.len()) / float64(la.Cardinality)
+}
+
+// Footprint 与 LNodeBTree 相同，按槽位计算键值数据
+func (la *LNodeAppend) Footprint(metrics *FootprintMetrics) {
+	cnt := la.contents.len()
+	slotSize := uint64(unsafe.Sizeof(int(0)) * 2)
+	metrics.KeyDataOccupied += slotSize * uint64(cnt)
+	if cnt < la.Cardinality {
+		metrics.KeyDataUnoccupied += slotSize * uint64(la.Cardinality-cnt)
+	}
+}
+
+func (la *LNodeAppend) GetNode() *Node {
+	return &la.Node
+}
+
+func (la *LNodeAppend) GetType() NodeType {
+	return AppendNode
+}
+
+func (la *LNodeAppend) GetCardinality() int {
+	return la.Cardinality
+}
+
+func (la *LNodeAppend) SetSibling(sibling LeafNodeInterface) {
+	la.siblingPtr = sibling
+}
@@ -0,0 +1,174 @@
+package blinkhash
+
+import (
+	"math"
+	"sync"
+	"testing"
+)
+
+// newTestTree 创建一棵默认配置的树，appendLeaves 为 true 时以追加叶子作为根叶子
+func newTestTree(appendLeaves bool) *BTree {
+	opts := DefaultTreeOptions
+	opts.AppendLeaves = appendLeaves
+	return NewBTreeWithOptions(opts)
+}
+
+// leafTypes 统计叶子链上各类叶子的数量
+func leafTypes(bt *BTree) map[NodeType]int {
+	types := make(map[NodeType]int)
+	for leaf := bt.firstLeaf(); leaf != nil; leaf = nextLeaf(leaf) {
+		types[leaf.GetType()]++
+	}
+	return types
+}
+
+func TestAppend_Sequential(t *testing.T) {
+	const n = 5000
+	tree := newTestTree(true)
+	ti := NewThreadInfo(tree.GetEpoche())
+	for k := 0; k < n; k++ {
+		tree.Insert(k*2, k, ti)
+	}
+	types := leafTypes(tree)
+	if len(types) != 1 || types[AppendNode] != ceilDiv(n, LNodeBTreeCardinality) {
+		t.Fatalf("Expected only full append leaves, got %v", types)
+	}
+	for k := 0; k < n; k++ {
+		if got := tree.Lookup(k*2, ti); got != k {
+			t.Fatalf("key %d: expected %d, got %v", k*2, k, got)
+		}
+		if got := tree.Lookup(k*2+1, ti); got != nil {
+			t.Fatalf("key %d: expected a missing key, got %v", k*2+1, got)
+		}
+	}
+	entries := tree.RangeLookupEntries(7, n, ti)
+	if len(entries) != n-4 || entries[0].Key != 8 {
+		t.Fatalf("Expected %d entries from key 8, got %d", n-4, len(entries))
+	}
+
+	var m FootprintMetrics
+	tree.Footprint(&m)
+	if m.KeyDataUnoccupied >= m.KeyDataOccupied/uint64(LNodeBTreeCardinality) {
+		t.Errorf("Expected sequential appends to leave full leaves, got %+v", m)
+	}
+
+	if !tree.Update(10, "ten", ti) || !tree.Remove(12, ti) || tree.Remove(12, ti) {
+		t.Errorf("Expected updates and removes to work in place")
+	}
+	if got := leafTypes(tree)[AppendNode]; got != types[AppendNode] {
+		t.Errorf("Expected updates and removes to keep append leaves, got %d of %d", got, types[AppendNode])
+	}
+	if tree.Lookup(10, ti) != "ten" || tree.Lookup(12, ti) != nil {
+		t.Errorf("Expected the update and the remove to be visible")
+	}
+}
+
+func TestAppend_OutOfOrder(t *testing.T) {
+	const n = 3000
+	tree := newTestTree(true)
+	ti := NewThreadInfo(tree.GetEpoche())
+	for k := 0; k < n; k++ {
+		tree.Insert(k*2, k, ti)
+	}
+	// 乱序的键把所在的叶子转换为 B 树叶子，第一个叶子同时是父节点的最左孩子
+	for _, k := range []int{1, 1001, 3001, -1, n*2 - 1} {
+		tree.Insert(k, -k, ti)
+	}
+	types := leafTypes(tree)
+	if types[BTreeNode] == 0 || types[AppendNode] == 0 {
+		t.Fatalf("Expected out-of-order leaves to be converted, got %v", types)
+	}
+	for k := 0; k < n; k++ {
+		if got := tree.Lookup(k*2, ti); got != k {
+			t.Fatalf("key %d: expected %d, got %v", k*2, k, got)
+		}
+	}
+	for _, k := range []int{1, 1001, 3001, -1, n*2 - 1} {
+		if got := tree.Lookup(k, ti); got != -k {
+			t.Errorf("key %d: expected %d, got %v", k, -k, got)
+		}
+	}
+	if got := len(tree.RangeLookup(math.MinInt, 2*n, ti)); got != n+5 {
+		t.Errorf("Expected %d entries, got %d", n+5, got)
+	}
+
+	// 单个叶子的树：根叶子被转换
+	root := NewBTree()
+	root.Insert(5, 5, ti)
+	root.Insert(3, 3, ti)
+	if root.root.GetType() == AppendNode || root.Lookup(3, ti) != 3 || root.Lookup(5, ti) != 5 {
+		t.Errorf("Expected the root leaf to be converted")
+	}
+}
+
+func TestAppend_BatchAndIngest(t *testing.T) {
+	tree := newTestTree(true)
+	ti := NewThreadInfo(tree.GetEpoche())
+	for k := 0; k < 2000; k++ {
+		tree.IngestInsert(k, k, ti)
+	}
+	tree.FlushIngest(ti)
+	if types := leafTypes(tree); len(types) != 1 || types[AppendNode] == 0 {
+		t.Fatalf("Expected ingested keys to stay in append leaves, got %v", types)
+	}
+
+	wb := tree.NewWriteBatch()
+	for k := 0; k < 2000; k += 100 {
+		wb.Put(k, "batch")
+	}
+	wb.Delete(1)
+	wb.Commit(ti)
+	for k := 0; k < 2000; k++ {
+		want := interface{}(k)
+		switch {
+		case k == 1:
+			want = nil
+		case k%100 == 0:
+			want = "batch"
+		}
+		if got := tree.Lookup(k, ti); got != want {
+			t.Fatalf("key %d: expected %v, got %v", k, want, got)
+		}
+	}
+}
+
+// 多个线程各自插入递增的键，线程之间的键交错，部分叶子会在并发插入时被转换
+func TestAppend_Concurrent(t *testing.T) {
+	const perThread, threads = 2000, 8
+	tree := newTestTree(true)
+	var wg sync.WaitGroup
+	for w := 0; w < threads; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			ti := NewThreadInfo(tree.GetEpoche())
+			for i := 0; i < perThread; i++ {
+				tree.Insert(i*threads+w, w, ti)
+			}
+		}(w)
+	}
+	wg.Wait()
+	ti := NewThreadInfo(tree.GetEpoche())
+	entries := tree.RangeLookupEntries(math.MinInt, 2*perThread*threads, ti)
+	if len(entries) != perThread*threads {
+		t.Fatalf("Expected %d entries, got %d", perThread*threads, len(entries))
+	}
+	for i, e := range entries {
+		if e.Key != i || e.Value != i%threads {
+			t.Fatalf("entry %d: got %v=%v", i, e.Key, e.Value)
+		}
+	}
+}
+
+// BenchmarkAppendInsert 比较顺序插入时默认的哈希根叶子与追加叶子
+func BenchmarkAppendInsert(b *testing.B) {
+	for _, appendLeaves := range []bool{false, true} {
+		b.Run(map[bool]string{false: "default", true: "append"}[appendLeaves], func(b *testing.B) {
+			tree := newTestTree(appendLeaves)
+			ti := NewThreadInfo(tree.GetEpoche())
+			for i := 0; i < b.N; i++ {
+				tree.Insert(i, i, ti)
+			}
+		})
+	}
+}
@@ -2,21 +2,31 @@ package blinkhash
 
 import (
 	"fmt"
+	"sync/atomic"
 	"unsafe"
 )
 
 type LNodeBTree struct {
 	Node
+	nodeOptions
 	Type        NodeType
 	HighKey     interface{}
 	Cardinality int
-	Entries     []Entry
+	contents    atomic.Pointer[leafContents] // 定长的键数组和值数组，int 值不装箱，见 value_array.go；换出和换入整体替换
+
+	// 分层存储，见 tier.go。pool 为 nil 时叶子不参与换出
+	pool  *bufferPool
+	stub  *leafStub // 非 nil 时内容已换出，contents 为 evictedContents，只在写锁内访问
+	clean *leafStub // 换入后未修改时页文件中仍有效的副本
+	ref   uint32    // CLOCK 的访问位，原子访问
+
+	written uint32 // 上一次 CompactLeaves 之后是否写入过，见 compress.go，原子访问
 }
 
 // NewLNodeBTree 创建一个新的 LNodeBTree 节点
 func NewLNodeBTree(level int) *LNodeBTree {
 	cardinality := LNodeBTreeCardinality
-	return &LNodeBTree{
+	lb := &LNodeBTree{
 		Node: Node{
 			lock:        0,
 			siblingPtr:  nil,
@@ -27,14 +37,15 @@ func NewLNodeBTree(level int) *LNodeBTree {
 		Type:        BTreeNode,
 		HighKey:     nil, // 需要在 Split 中设置
 		Cardinality: cardinality,
-		Entries:     make([]Entry, 0, LeafBTreeSize),
 	}
+	lb.contents.Store(newLeafContents(cardinality))
+	return lb
 }
 
 // NewLNodeBTreeWithLevel 创建一个新的 LNodeBTree 节点，指定层级
 func NewLNodeBTreeWithLevel(level int) *LNodeBTree {
 	cardinality := LNodeBTreeCardinality
-	return &LNodeBTree{
+	lb := &LNodeBTree{
 		Node: Node{
 			lock:        0,
 			siblingPtr:  nil,
@@ -45,14 +56,15 @@ func NewLNodeBTreeWithLevel(level int) *LNodeBTree {
 		Type:        BTreeNode,
 		HighKey:     nil, // 需要在 Split 中设置
 		Cardinality: cardinality,
-		Entries:     make([]Entry, LeafBTreeSize),
 	}
+	lb.contents.Store(newLeafContents(cardinality))
+	return lb
 }
 
 // NewLNodeBTreeWithSibling 创建一个新的 LNodeBTree 节点，并设置兄弟节点、计数和层级
 func NewLNodeBTreeWithSibling(sibling NodeInterface, count int32, level int) *LNodeBTree {
 	cardinality := LNodeBTreeCardinality
-	return &LNodeBTree{
+	lb := &LNodeBTree{
 		Node: Node{
 			lock:        0,
 			siblingPtr:  sibling,
@@ -63,8 +75,20 @@ func NewLNodeBTreeWithSibling(sibling NodeInterface, count int32, level int) *LN
 		Type:        BTreeNode,
 		HighKey:     nil, // 需要在 Split 中设置
 		Cardinality: cardinality,
-		Entries:     make([]Entry, count, LeafBTreeSize),
 	}
+	lb.contents.Store(newLeafContents(cardinality))
+	return lb
+}
+
+// loaded 返回叶子当前的内容，换出时为 evictedContents。
+// 乐观的读者只读取一次，之后只使用读到的这一份内容
+func (lb *LNodeBTree) loaded() *leafContents {
+	return lb.contents.Load()
+}
+
+// evicted 判断叶子内容是否已换出
+func (lb *LNodeBTree) evicted() bool {
+	return lb.loaded() == evictedContents
 }
 
 // TODO 实现Node Interface接口：
@@ -81,8 +105,9 @@ func (lb *LNodeBTree) Print() {
 	fmt.Printf("Cardinality: %d\n", lb.Cardinality)
 	lb.Node.Print()
 	fmt.Printf("Entries:\n")
-	for i, entry := range lb.Entries {
-		fmt.Printf("\tEntry %d: Key = %v, Value = %v\n", i, entry.Key, entry.Value)
+	c := lb.loaded()
+	for i, key := range c.sortedKeys() {
+		fmt.Printf("\tEntry %d: Key = %v, Value = %v\n", i, key, c.values.get(i))
 	}
 }
 
@@ -96,14 +121,10 @@ func (lb *LNodeBTree) SanityCheck(_highKey interface{}, first bool) {
 	fmt.Printf("我是LNodeBTree 调用 SanityCheck:\n")
 	// 检查键值是否有序
 	count := int(lb.count)
+	keys := lb.loaded().keys[:]
 	for i := 0; i < count-1; i++ {
 		for j := i + 1; j < count; j++ {
-			keyInt, ok := lb.Entries[i].Key.(int)
-			if !ok {
-				fmt.Printf("Error: Entry key is not an int: %v\n", lb.Entries[i].Key)
-				continue
-			}
-
+			keyInt := keys[i]
 			highKeyInt, ok := lb.HighKey.(int)
 			if !ok {
 				fmt.Printf("Error: HighKey is not an int: %v\n", lb.HighKey)
@@ -111,21 +132,21 @@ func (lb *LNodeBTree) SanityCheck(_highKey interface{}, first bool) {
 			}
 			if keyInt > highKeyInt { // 假设key是int类型
 				fmt.Printf("lnode_t::key order is not preserved!!\n")
-				fmt.Printf("[%d].key: %v\t[%d].key: %v\n", i, lb.Entries[i].Key, j, lb.Entries[j].Key)
+				fmt.Printf("[%d].key: %v\t[%d].key: %v\n", i, keys[i], j, keys[j])
 			}
 		}
 	}
 
 	// 检查 sibling 和 highKey 的关系
 	for i := 0; i < count; i++ {
-		entryKey, ok1 := lb.Entries[i].Key.(int)
+		entryKey := keys[i]
 		highKeyInt, ok2 := lb.HighKey.(int)
-		if !ok1 || !ok2 {
+		if !ok2 {
 			fmt.Printf("Error: Entry key or HighKey is not int type\n")
 			continue
 		}
 		if lb.siblingPtr != nil && entryKey > highKeyInt {
-			fmt.Printf("%d lnode_t:: (%v) is higher than high Key %v\n", i, lb.Entries[i].Key, lb.HighKey)
+			fmt.Printf("%d lnode_t:: (%v) is higher than high Key %v\n", i, entryKey, lb.HighKey)
 		}
 		if !first {
 			prevHighKeyInt, ok := _highKey.(int)
@@ -134,7 +155,7 @@ func (lb *LNodeBTree) SanityCheck(_highKey interface{}, first bool) {
 				continue
 			}
 			if lb.siblingPtr != nil && entryKey < prevHighKeyInt {
-				fmt.Printf("lnode_t:: %d (%v) is smaller than previous high Key %v\n", i, lb.Entries[i].Key, _highKey)
+				fmt.Printf("lnode_t:: %d (%v) is smaller than previous high Key %v\n", i, entryKey, _highKey)
 				fmt.Printf("--------- node_address %v , current high_Key %v\n", lb, lb.HighKey)
 			}
 		}
@@ -156,35 +177,38 @@ func (lb *LNodeBTree) SanityCheck(_highKey interface{}, first bool) {
 //	@return Splittable
 //	@return interface{}
 func (lb *LNodeBTree) Split(key interface{}, value interface{}, version uint64) (Splittable, interface{}) {
-	half := len(lb.Entries) / 2
-	if half == 0 {
+	c := lb.loaded()
+	n := c.len()
+	if n == 0 {
 		panic("Split: cannot split a node with zero entries")
 	}
-	splitKey := lb.Entries[half-1].Key // 确定拆分键
-	newCnt := int32(len(lb.Entries) - half)
+	// 由分裂策略决定左节点保留的条目数，顺序追加时新节点可以为空
+	half := leafSplitPoint(lb.options(), n, c.keys[n-1], key, lb.siblingPtr == nil)
+	splitKey := interface{}(c.keys[half-1]) // 确定拆分键
+	newCnt := int32(n - half)
 	// 创建新的兄弟节点
 	newLeaf := NewLNodeBTreeWithSibling(lb.siblingPtr, newCnt, lb.level)
+	newLeaf.opts = lb.opts
 	newLeaf.HighKey = lb.HighKey
 
 	// 拷贝后半部分到新叶节点
-	copy(newLeaf.Entries, lb.Entries[half:half+int(newCnt)])
+	newLeaf.contents.Store(c.copyRange(half, n, maxInt(newLeaf.Cardinality, n-half+1)))
 	newLeaf.count = newCnt
 
 	// 更新当前节点
 	lb.siblingPtr = newLeaf
 	lb.HighKey = splitKey
 	lb.count = int32(half)
-	lb.Entries = lb.Entries[:half]
+	c.truncate(half)
 	// 根据键值确定插入位置
 	if compareIntKeys(splitKey, key) < 0 {
 		newLeaf.InsertAfterSplit(key, value)
 	} else {
 		lb.InsertAfterSplit(key, value)
 	}
-	siblingPtr := newLeaf.siblingPtr
-	if hashNode, ok := siblingPtr.(*LNodeHash); ok {
-		hashNode.LeftSiblingPtr = newLeaf
-	}
+	linkLeft(newLeaf.siblingPtr, newLeaf)
+	newLeaf.written = 1
+	lb.adopt(newLeaf)
 	//return &newLeaf.Node
 	//fmt.Println("我是LNodeBTree，调用Split")
 	return newLeaf, splitKey
@@ -193,12 +217,8 @@ func (lb *LNodeBTree) Split(key interface{}, value interface{}, version uint64)
 func (lb *LNodeBTree) InsertAfterSplit(key, value interface{}) {
 	pos := lb.FindLowerBound(key)
 
-	// 将元素向后移动，为新元素腾出位置
-	// 在 Go 中，我们可以使用切片和 append 函数来处理这个问题
-	lb.Entries = append(lb.Entries, Entry{})
-	copy(lb.Entries[pos+1:], lb.Entries[pos:len(lb.Entries)-1]) // 复制 pos 位置后的切片
-	// 在找到的位置插入新的键值对
-	lb.Entries[pos] = Entry{Key: key, Value: value}
+	// 将元素向后移动，为新元素腾出位置，并在找到的位置插入新的键值对
+	lb.insertAt(pos, key.(int), value)
 	// 更新元素计数
 	lb.count++
 }
@@ -221,9 +241,14 @@ func (lb *LNodeBTree) Insert(key interface{}, value interface{}, version uint64)
 		// 如果未能升级写锁成功，也需要处理
 		return NeedRestart
 	}
-	// 检查是否有足够空间进行插入
-	if len(lb.Entries) >= lb.Cardinality {
-		lb.WriteUnlock() // 释放写锁
+	if lb.loadForWrite() != nil {
+		// 内容无法换入，树已停止写入，调用方重启时发现错误后返回
+		lb.WriteUnlock()
+		return NeedRestart
+	}
+	// 检查是否有足够空间进行插入，满时先删除过期的条目
+	if lb.loaded().len() >= lb.Cardinality && lb.purgeLocked() == 0 {
+		// 保持写锁返回，由调用方在锁内完成 Split 后再释放
 		return NeedSplit // 表示需要分裂
 	}
 	// 执行插入逻辑
@@ -232,20 +257,120 @@ func (lb *LNodeBTree) Insert(key interface{}, value interface{}, version uint64)
 	if pos < 0 {
 		pos = 0
 	}
-	if pos > len(lb.Entries) {
-		pos = len(lb.Entries)
+	if pos > lb.loaded().len() {
+		pos = lb.loaded().len()
 	}
 
-	// 使用Go的切片操作来插入数据
-	lb.Entries = append(lb.Entries, Entry{}) // 扩展切片以防止越界
-	copy(lb.Entries[pos+1:], lb.Entries[pos:])
-	lb.Entries[pos] = Entry{Key: key, Value: value}
+	// 键和值两个数组同时后移
+	lb.insertAt(pos, key.(int), value)
 	// 更新计数
 	lb.count++
+	if compareIntKeys(key, lb.HighKey) > 0 {
+		lb.HighKey = key
+	}
 	lb.WriteUnlock() // 插入完成后释放写锁
 	return InsertSuccess
 }
 
+// InsertSorted
+//
+//	@Description: 在一次写锁内批量插入按键排好序的条目。只插入容量允许、
+//	且不超过 HighKey(最右叶子不受限制) 的前缀，返回插入的条目数；
+//	一个也插不进去时释放写锁并返回 NeedSplit，由调用方走普通 Insert 完成分裂。
+//	@receiver lb
+//	@param entries
+//	@param version
+//	@return int
+//	@return int
+func (lb *LNodeBTree) InsertSorted(entries []Entry, version uint64) (int, int) {
+	success, needRestart := lb.TryUpgradeWriteLock(version)
+	if needRestart || !success {
+		return 0, NeedRestart
+	}
+	if lb.loadForWrite() != nil {
+		lb.WriteUnlock()
+		return 0, NeedRestart
+	}
+	if lb.loaded().len()+len(entries) > lb.Cardinality {
+		lb.purgeLocked()
+	}
+
+	n := 0
+	room := lb.Cardinality - lb.loaded().len()
+	for n < len(entries) && n < room {
+		if lb.siblingPtr != nil && compareIntKeys(entries[n].Key, lb.HighKey) > 0 {
+			break
+		}
+		n++
+	}
+	if n == 0 {
+		lb.WriteUnlock()
+		return 0, NeedSplit
+	}
+
+	lb.mergeSorted(entries[:n])
+	if compareIntKeys(entries[n-1].Key, lb.HighKey) > 0 {
+		lb.HighKey = entries[n-1].Key
+	}
+	lb.WriteUnlock()
+	return n, InsertSuccess
+}
+
+// reserve 确保叶子内容还能再放入 extra 个条目，容量不够时换成更大的内容后返回。
+// 调用方持有写锁，旧内容保持不变，乐观的读者仍可读完后再由版本校验丢弃
+func (lb *LNodeBTree) reserve(extra int) *leafContents {
+	c := lb.loaded()
+	if n := c.len(); n+extra > c.capacity() {
+		c = c.copyRange(0, n, maxInt(lb.Cardinality, n+extra))
+		lb.contents.Store(c)
+	}
+	return c
+}
+
+// insertAt 在 pos 处插入键值，键和值数组同时后移
+func (lb *LNodeBTree) insertAt(pos int, key int, value interface{}) {
+	lb.reserve(1).insertAt(pos, key, value)
+}
+
+// appendEntries 把有序条目追加到末尾
+func (lb *LNodeBTree) appendEntries(entries []Entry) {
+	c := lb.reserve(len(entries))
+	for _, entry := range entries {
+		c.appendEntry(entry.Key.(int), entry.Value)
+	}
+	lb.count += int32(len(entries))
+}
+
+// mergeSorted 将有序条目归并进叶子，顺序追加时直接追加到末尾
+func (lb *LNodeBTree) mergeSorted(entries []Entry) {
+	old := lb.loaded()
+	cnt := old.len()
+	if cnt == 0 || entries[0].Key.(int) >= old.keys[cnt-1] {
+		lb.appendEntries(entries)
+		return
+	}
+
+	c := newLeafContents(maxInt(lb.Cardinality, cnt+len(entries)))
+	i, j := 0, 0
+	for i < cnt && j < len(entries) {
+		if entries[j].Key.(int) < old.keys[i] {
+			c.appendEntry(entries[j].Key.(int), entries[j].Value)
+			j++
+		} else {
+			c.appendFrom(old, i)
+			i++
+		}
+	}
+	for ; i < cnt; i++ {
+		c.appendFrom(old, i)
+	}
+	for ; j < len(entries); j++ {
+		c.appendEntry(entries[j].Key.(int), entries[j].Value)
+	}
+	lb.contents.Store(c)
+	lb.count = int32(c.len())
+}
+
 // Update
 //
 //	@Description: 实现Updatable接口定义的更新方法
@@ -255,8 +380,13 @@ func (lb *LNodeBTree) Insert(key interface{}, value interface{}, version uint64)
 //	@param version
 //	@return int
 func (lb *LNodeBTree) Update(key interface{}, value interface{}, version uint64) int {
-	needRestart, _ := lb.Node.TryUpgradeWriteLock(version)
-	if needRestart {
+	success, needRestart := lb.Node.TryUpgradeWriteLock(version)
+	if needRestart || !success {
+		// 未拿到写锁，不能解锁
+		return NeedRestart
+	}
+	if lb.loadForWrite() != nil {
+		lb.WriteUnlock()
 		return NeedRestart
 	}
 
@@ -274,15 +404,46 @@ func (lb *LNodeBTree) Update(key interface{}, value interface{}, version uint64)
 
 // updateLinear searches for the key and updates the value if found
 func (lb *LNodeBTree) updateLinear(key interface{}, value interface{}) bool {
-	for i, entry := range lb.Entries {
-		if compareIntKeys(entry.Key, key) == 0 {
-			lb.Entries[i].Value = value
-			return true
-		}
+	if pos := lb.findPos(key); pos >= 0 {
+		lb.loaded().values.set(pos, value)
+		return true
 	}
 	return false
 }
 
+// putLocked 在调用方持有写锁时插入或覆盖 key，返回 key 是否为新插入
+func (lb *LNodeBTree) putLocked(key int, value interface{}) bool {
+	c := lb.loaded()
+	pos := lowerBoundInts(c.sortedKeys(), key, lb.options().leafSearch())
+	if pos < c.len() && c.keys[pos] == key {
+		c.values.set(pos, value)
+		return false
+	}
+	lb.insertAt(pos, key, value)
+	lb.count++
+	if compareIntKeys(key, lb.HighKey) > 0 {
+		lb.HighKey = key
+	}
+	return true
+}
+
+// removeLocked 在调用方持有写锁时删除 key，返回 key 是否存在
+func (lb *LNodeBTree) removeLocked(key int) bool {
+	pos := lb.findPos(key)
+	if pos < 0 {
+		return false
+	}
+	lb.loaded().removeRange(pos, pos+1)
+	lb.count--
+	return true
+}
+
+// removeRangeLocked 删除位置 [from, to) 的条目，调用方持有写锁并且叶子已换入
+func (lb *LNodeBTree) removeRangeLocked(from, to int) {
+	lb.loaded().removeRange(from, to)
+	lb.count -= int32(to - from)
+}
+
 // Remove
 //
 //	@Description: 实现Removable接口定义的方法
@@ -291,19 +452,23 @@ func (lb *LNodeBTree) updateLinear(key interface{}, value interface{}) bool {
 //	@param version
 //	@return int
 func (lb *LNodeBTree) Remove(key interface{}, version uint64) int {
-	needRestart, _ := lb.TryUpgradeWriteLock(version)
-	if needRestart {
+	success, needRestart := lb.TryUpgradeWriteLock(version)
+	if needRestart || !success {
+		return NeedRestart
+	}
+	if lb.loadForWrite() != nil {
+		lb.WriteUnlock()
 		return NeedRestart
 	}
 
 	if lb.count > 0 {
-		pos := lb.findPosLinear(key)
+		pos := lb.findPos(key)
 		if pos == -1 {
 			lb.WriteUnlock()
 			return KeyNotFound // Key not found
 		}
 		// Remove the entry at pos by shifting
-		lb.Entries = append(lb.Entries[:pos], lb.Entries[pos+1:]...)
+		lb.loaded().removeRange(pos, pos+1)
 		lb.count--
 
 		lb.WriteUnlock()
@@ -314,12 +479,21 @@ func (lb *LNodeBTree) Remove(key interface{}, version uint64) int {
 	return KeyNotFound
 }
 
-// findPosLinear finds the position of the key in Entries
-func (lb *LNodeBTree) findPosLinear(key interface{}) int {
-	for i, entry := range lb.Entries {
-		if compareIntKeys(entry.Key, key) == 0 {
-			return i
-		}
+// findPos 返回 key 在 keys 中的位置，不存在时返回 -1
+func (lb *LNodeBTree) findPos(key interface{}) int {
+	return lb.findIn(lb.loaded(), key)
+}
+
+// findIn 返回 key 在内容 c 中的位置，不存在时返回 -1
+func (lb *LNodeBTree) findIn(c *leafContents, key interface{}) int {
+	k, ok := intKey(key)
+	if !ok {
+		return -1
+	}
+	keys := c.sortedKeys()
+	pos := lowerBoundInts(keys, k, lb.options().leafSearch())
+	if pos < len(keys) && keys[pos] == k {
+		return pos
 	}
 	return -1 // Not found
 }
@@ -335,42 +509,54 @@ func (lb *LNodeBTree) findPosLinear(key interface{}) int {
 //	@param continued
 //	@return int
 func (lb *LNodeBTree) RangeLookUp(key interface{}, upTo int, continued bool, version uint64) ([]interface{}, int, int) {
+	entries, retCode, count := lb.RangeLookUpEntries(key, upTo, continued, version)
+	return entryValues(entries), retCode, count
+}
+
+// RangeLookUpEntries
+//
+//	@Description: 实现EntryRangeLookuper接口，返回带键的条目
+//	@receiver lb
+//	@param key 起始键(包含)
+//	@param upTo 最多收集的条目数
+//	@param continued 是否与上一个叶子连续，连续时从头开始收集
+//	@param version
+//	@return []Entry
+//	@return int
+//	@return int
+func (lb *LNodeBTree) RangeLookUpEntries(key interface{}, upTo int, continued bool, version uint64) ([]Entry, int, int) {
 	// LNodeBTree 不需要 version 做并发检测，这里忽略
 	// retCode 默认 0 表示正常, NeedRestart/NeedConvert 不适用此实现
-
-	collected := make([]interface{}, 0, upTo)
-	currentCount := 0
-
-	// 如果 continued == true，表示我们之前已经搜到一部分了，这次无视 key，直接遍历
-	if continued {
-		if int(lb.count) > len(lb.Entries) {
-			panic(fmt.Sprintf("lb.count: %d len(lb.Entries): %d", lb.count, len(lb.Entries)))
-		}
-		for i := 0; i < int(lb.count); i++ {
-			collected = append(collected, lb.Entries[i].Value)
-			currentCount++
-			if currentCount == upTo {
-				return collected, 0, currentCount
-			}
-		}
-		return collected, 0, currentCount
-	} else {
-		// 未连续查找，则先找到 key 的起点
-		pos := lb.FindLowerBound(key)
-		// 假设: FindLowerBound 返回小于等于 key 的位置,
-		// 我们希望从 pos+1 开始收集
-		if int(lb.count) > len(lb.Entries) {
-			panic(fmt.Sprintf("lb.count: %d len(lb.Entries): %d", lb.count, len(lb.Entries)))
-		}
-		for i := pos + 1; i < int(lb.count); i++ {
-			collected = append(collected, lb.Entries[i].Value)
-			currentCount++
-			if currentCount == upTo {
-				return collected, 0, currentCount
-			}
+	// 乐观读取时其他线程可能正在移动数组，读到的条目数可能与数组内容暂时不一致。
+	// 数组是定长的，任何时候读到的条目数都不会越界，读到的内容由调用方通过版本校验决定是否丢弃
+	c := lb.loaded()
+	if c == evictedContents {
+		lb.fault()
+		return nil, 0, 0
+	}
+	lb.touch()
+	keys := c.sortedKeys()
+	n := len(keys)
+
+	// 如果 continued == true，表示我们之前已经搜到一部分了，这次无视 key，直接从头遍历；
+	// 否则从第一个 >= key 的位置开始收集
+	start := 0
+	if !continued {
+		keyInt, ok := key.(int)
+		if !ok {
+			panic("RangeLookUpEntries: key is not of type int")
 		}
-		return collected, 0, currentCount
+		start = lowerBoundInts(keys, keyInt, lb.options().leafSearch())
+	}
+	end := n
+	if end-start > upTo {
+		end = start + upTo
 	}
+	if end <= start {
+		return nil, 0, 0
+	}
+	collected := c.entries(start, end)
+	return collected, 0, len(collected)
 }
 
 // Find
@@ -381,13 +567,17 @@ func (lb *LNodeBTree) RangeLookUp(key interface{}, upTo int, continued bool, ver
 //	@return interface{}
 //	@return bool
 func (lb *LNodeBTree) Find(key interface{}) (interface{}, bool) {
-	//TODO implement me
-	// 假设 LEAF_BTREE_SIZE 是一个全局常量
-	if LeafHashSize < 2048 {
-		return lb.findLinear(key)
-	} else {
-		return lb.findBinary(key)
+	// 查找策略由 TreeOptions.LNodeBTreeSearch 决定，见 search.go
+	c := lb.loaded()
+	if c == evictedContents {
+		lb.fault()
+		return nil, false
 	}
+	lb.touch()
+	if i := lb.findIn(c, key); i >= 0 {
+		return c.values.get(i), true
+	}
+	return nil, false // 代替 C++ 中的返回 0，更符合 Go 的惯例
 }
 
 // Utilization
@@ -397,91 +587,21 @@ func (lb *LNodeBTree) Find(key interface{}) (interface{}, bool) {
 //	@return float64
 func (lb *LNodeBTree) Utilization() float64 {
 	// 返回B树节点的利用率计算
-	return float64(len(lb.Entries)) / float64(lb.Cardinality)
-}
-
-// lowerboundLinear
-//
-//	@Description: 工具函数，线性查找
-//	@receiver b
-//	@param key
-//	@return int
-func (lb *LNodeBTree) lowerboundLinear(key interface{}) int {
-	keyInt, ok := key.(int)
-	if !ok {
-		panic("FindLowerBoundLinear: key is not of type int")
-	}
-	for i, entry := range lb.Entries {
-		entryKey, ok := entry.Key.(int)
-		if !ok {
-			continue
-		}
-		if keyInt <= entryKey {
-			return i
-		}
-	}
-	return len(lb.Entries) // 插入到末尾
-}
-
-// lowerboundBinary
-//
-//	@Description: 工具函数，二分查找key
-//	@receiver b
-//	@param key
-//	@return int
-func (lb *LNodeBTree) lowerboundBinary(key interface{}) int {
-	lower := 0
-	upper := len(lb.Entries)
-	for lower < upper {
-		mid := (upper-lower)/2 + lower
-		if key.(int) < lb.Entries[mid].Key.(int) {
-			upper = mid
-		} else if key.(int) > lb.Entries[mid].Key.(int) {
-			lower = mid + 1
-		} else {
-			return mid
-		}
-	}
-	return lower
+	return float64(lb.loaded().len()) / float64(lb.Cardinality)
 }
 
 // FindLowerBound
 //
-//	@Description: 工具函数，查找下界
+//	@Description: 工具函数，查找第一个不小于 key 的位置，查找策略由 TreeOptions.LNodeBTreeSearch 决定
 //	@receiver b
 //	@param key
 //	@return int
 func (lb *LNodeBTree) FindLowerBound(key interface{}) int {
-	if LeafBTreeSize < 2048 {
-		return lb.lowerboundLinear(key)
-	} else {
-		return lb.lowerboundBinary(key)
-	}
-}
-
-func (lb *LNodeBTree) findLinear(key interface{}) (interface{}, bool) {
-	for i := 0; i < len(lb.Entries); i++ {
-		if key == lb.Entries[i].Key {
-			return lb.Entries[i].Value, true
-		}
-	}
-	return nil, false // 代替 C++ 中的返回 0，更符合 Go 的惯例
-}
-
-func (lb *LNodeBTree) findBinary(key interface{}) (interface{}, bool) {
-	lower := 0
-	upper := len(lb.Entries)
-	for lower < upper {
-		mid := (upper-lower)/2 + lower
-		if compareIntKeys(key, lb.Entries[mid].Key) < 0 {
-			upper = mid
-		} else if compareIntKeys(key, lb.Entries[mid].Key) > 0 {
-			lower = mid + 1
-		} else {
-			return lb.Entries[mid].Value, true
-		}
+	keyInt, ok := key.(int)
+	if !ok {
+		panic("FindLowerBound: key is not of type int")
 	}
-	return nil, false // 代替 C++ 中的返回 0
+	return lowerBoundInts(lb.loaded().sortedKeys(), keyInt, lb.options().leafSearch())
 }
 
 // batchInsert
@@ -495,25 +615,22 @@ func (lb *LNodeBTree) findBinary(key interface{}) (interface{}, bool) {
 func (lb *LNodeBTree) batchInsert(buf []Entry, batchSize int, from *int, to int) {
 	// 如果 from + batch_size < to，则拷贝 batch_size 个条目
 	if *from+batchSize < to {
-		lb.Entries = append(lb.Entries, buf[*from:*from+batchSize]...)
+		lb.appendEntries(buf[*from : *from+batchSize])
 		*from += batchSize
-		lb.count += int32(batchSize)
 	} else {
 		// 否则只拷贝 (to - from) 个条目
-		lb.Entries = append(lb.Entries, buf[*from:to]...)
-		lb.count += int32(to - *from)
+		lb.appendEntries(buf[*from:to])
 		*from = to
 	}
 	// 更新 HighKey
-	lb.HighKey = lb.Entries[lb.count-1].Key
+	lb.HighKey = lb.loaded().keys[lb.count-1]
 }
 
 // BatchInsert 批量插入条目到 B-tree 节点
 func (lb *LNodeBTree) BatchInsert(entries []Entry) {
-	lb.Entries = append(lb.Entries, entries...)
-	lb.count += int32(len(entries))
+	lb.appendEntries(entries)
 	if lb.count > 0 {
-		lb.HighKey = lb.Entries[lb.count-1].Key
+		lb.HighKey = lb.loaded().keys[lb.count-1]
 	}
 }
 
@@ -522,8 +639,9 @@ func (lb *LNodeBTree) Footprint(metrics *FootprintMetrics) {
 	// 实现具体的内存占用计算逻辑
 	cnt := lb.count
 	invalidNum := lb.Cardinality - int(cnt)
-	metrics.KeyDataOccupied += uint64(unsafe.Sizeof(Entry{})) * uint64(cnt)
-	metrics.KeyDataUnoccupied += uint64(unsafe.Sizeof(Entry{})) * uint64(invalidNum)
+	slotSize := uint64(unsafe.Sizeof(int(0)) * 2) // 键和值各一个 int
+	metrics.KeyDataOccupied += slotSize * uint64(cnt)
+	metrics.KeyDataUnoccupied += slotSize * uint64(invalidNum)
 
 }
 
@@ -536,7 +654,17 @@ func (lb *LNodeBTree) GetType() NodeType {
 }
 
 func (lb *LNodeBTree) GetEntries() []Entry {
-	return lb.Entries
+	c := lb.loaded()
+	if c == evictedContents {
+		lb.fault()
+		return nil
+	}
+	return c.entries(0, c.len())
+}
+
+// Len 返回叶子中的条目数
+func (lb *LNodeBTree) Len() int {
+	return lb.loaded().len()
 }
 func (lb *LNodeBTree) SetHighKey(key interface{}) { lb.HighKey = key }
 
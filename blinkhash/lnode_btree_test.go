@@ -13,9 +13,9 @@ func TestLNodeBTree_Insert(t *testing.T) {
 	lnBTree.Cardinality = 5
 	lnBTree.count = 3
 	lnBTree.HighKey = 10
-	lnBTree.Entries = append(lnBTree.Entries, Entry{Key: 1, Value: "value1"})
-	lnBTree.Entries = append(lnBTree.Entries, Entry{Key: 3, Value: "value3"})
-	lnBTree.Entries = append(lnBTree.Entries, Entry{Key: 5, Value: "value5"})
+	lnBTree.loaded().appendEntry(1, "value1")
+	lnBTree.loaded().appendEntry(3, "value3")
+	lnBTree.loaded().appendEntry(5, "value5")
 
 	// 创建另一个 LNodeBTree 节点，层级为 2
 	lnBTree2 := NewLNodeBTreeWithLevel(2)
@@ -23,9 +23,9 @@ func TestLNodeBTree_Insert(t *testing.T) {
 	lnBTree.Cardinality = 5
 	lnBTree2.count = 3
 	lnBTree2.HighKey = 12
-	lnBTree2.Entries = append(lnBTree2.Entries, Entry{Key: 7, Value: "value7"})
-	lnBTree2.Entries = append(lnBTree2.Entries, Entry{Key: 8, Value: "value8"})
-	lnBTree2.Entries = append(lnBTree2.Entries, Entry{Key: 9, Value: "value9"})
+	lnBTree2.loaded().appendEntry(7, "value7")
+	lnBTree2.loaded().appendEntry(8, "value8")
+	lnBTree2.loaded().appendEntry(9, "value9")
 
 	// 执行插入操作：插入键值对 (4, "value4")
 	result := lnBTree.Insert(4, "value4", 12345)
@@ -72,9 +72,9 @@ func TestLNodeBTree_AllMethods(t *testing.T) {
 	lnBTree.Cardinality = 5
 	lnBTree.count = 3
 	lnBTree.HighKey = 10
-	lnBTree.Entries = append(lnBTree.Entries, Entry{Key: 1, Value: "value1"})
-	lnBTree.Entries = append(lnBTree.Entries, Entry{Key: 3, Value: "value3"})
-	lnBTree.Entries = append(lnBTree.Entries, Entry{Key: 5, Value: "value5"})
+	lnBTree.loaded().appendEntry(1, "value1")
+	lnBTree.loaded().appendEntry(3, "value3")
+	lnBTree.loaded().appendEntry(5, "value5")
 
 	// 创建另一个 LNodeBTree 节点，层级为 2
 	lnBTree2 := NewLNodeBTreeWithLevel(2)
@@ -82,35 +82,26 @@ func TestLNodeBTree_AllMethods(t *testing.T) {
 	lnBTree2.Cardinality = 5
 	lnBTree2.count = 3
 	lnBTree2.HighKey = 12
-	lnBTree2.Entries = append(lnBTree2.Entries, Entry{Key: 7, Value: "value7"})
-	lnBTree2.Entries = append(lnBTree2.Entries, Entry{Key: 8, Value: "value8"})
-	lnBTree2.Entries = append(lnBTree2.Entries, Entry{Key: 9, Value: "value9"})
+	lnBTree2.loaded().appendEntry(7, "value7")
+	lnBTree2.loaded().appendEntry(8, "value8")
+	lnBTree2.loaded().appendEntry(9, "value9")
 
 	// 创建一个 LNodeHash 节点，设置兄弟节点为 nil，计数为 3，层级为 2
 	lnHash := NewLNodeHashWithSibling(nil, 3, 2)
 	lnHash.lock = 54321
 	lnHash.HighKey = 15
-	// 初始化 Buckets（根据实际需求进行初始化，这里仅添加示例数据）
-	lnHash.Buckets = append(lnHash.Buckets, Bucket{
-		lock:         1,
-		state:        1,
-		fingerprints: []uint8{0x1},
-		entries:      []Entry{{Key: "a", Value: "A"}},
-	})
-	lnHash.Buckets = append(lnHash.Buckets, Bucket{
-		lock:         2,
-		state:        2,
-		fingerprints: []uint8{0x2},
-		entries:      []Entry{{Key: "b", Value: "B"}},
-	})
-	lnHash.Buckets = append(lnHash.Buckets, Bucket{
-		lock:         3,
-		state:        3,
-		fingerprints: []uint8{0x3},
-		entries:      []Entry{{Key: "c", Value: "C"}},
-	})
+	// 初始化桶（根据实际需求进行初始化，这里仅添加示例数据）
+	for i, value := range []string{"A", "B", "C"} {
+		lnHash.bucketArray()[i].Insert(i+1, value)
+	}
 	lnHash.count = 3
 
+	// version 返回 lnBTree 当前的版本，写操作需要带着最新的版本才不会重启
+	version := func() uint64 {
+		v, _ := lnBTree.TryReadLock()
+		return v
+	}
+
 	// 设置兄弟节点指针
 	lnBTree.siblingPtr = lnBTree2
 	lnBTree2.siblingPtr = lnHash
@@ -162,7 +153,7 @@ func TestLNodeBTree_AllMethods(t *testing.T) {
 	// 子测试：Update
 	t.Run("Update", func(t *testing.T) {
 		// 更新一个存在的键：键 3 -> "value3_updated"
-		updateResult := lnBTree.Update(3, "value3_updated", 12346)
+		updateResult := lnBTree.Update(3, "value3_updated", version())
 		if updateResult != UpdateSuccess {
 			t.Errorf("Expected Update to return UpdateSuccess, got %s", getStatusName(updateResult))
 		}
@@ -176,7 +167,7 @@ func TestLNodeBTree_AllMethods(t *testing.T) {
 		}
 
 		// 更新一个不存在的键：键 100 -> "value100"
-		updateResult = lnBTree.Update(100, "value100", 12347)
+		updateResult = lnBTree.Update(100, "value100", version())
 		if updateResult != UpdateFailure {
 			t.Errorf("Expected Update to return UpdateFailure for non-existing key, got %s", getStatusName(updateResult))
 		}
@@ -185,7 +176,7 @@ func TestLNodeBTree_AllMethods(t *testing.T) {
 	// 子测试：Remove
 	t.Run("Remove", func(t *testing.T) {
 		// 删除一个存在的键：键 1
-		removeResult := lnBTree.Remove(1, 12348)
+		removeResult := lnBTree.Remove(1, version())
 		if removeResult != RemoveSuccess {
 			t.Errorf("Expected Remove to return RemoveSuccess, got %s", getStatusName(removeResult))
 		}
@@ -197,7 +188,7 @@ func TestLNodeBTree_AllMethods(t *testing.T) {
 		}
 
 		// 删除一个不存在的键：键 100
-		removeResult = lnBTree.Remove(100, 12349)
+		removeResult = lnBTree.Remove(100, version())
 		if removeResult != KeyNotFound {
 			t.Errorf("Expected Remove to return KeyNotFound for non-existing key, got %s", getStatusName(removeResult))
 		}
@@ -207,9 +198,9 @@ func TestLNodeBTree_AllMethods(t *testing.T) {
 	t.Run("Find", func(t *testing.T) {
 		// 查找一个存在的键：键 3
 		value, found := lnBTree.Find(3)
-		lnBTree.Insert(1, "value1", 12365)
-		lnBTree.Insert(4, "value4", 12369)
-		lnBTree.Insert(5, "value5", 12373)
+		lnBTree.Insert(1, "value1", version())
+		lnBTree.Insert(4, "value4", version())
+		lnBTree.Insert(5, "value5", version())
 		if !found {
 			t.Errorf("Expected to find key 3, but it was not found")
 		} else if value != "value3_updated" {
@@ -225,13 +216,12 @@ func TestLNodeBTree_AllMethods(t *testing.T) {
 
 	// 子测试：RangeLookUp
 	t.Run("RangeLookUp", func(t *testing.T) {
-		var buffer []interface{}
-		// 非连续查找：从键 3 开始，获取 2 个值
-		rangeResult := lnBTree.RangeLookUp(3, &buffer, 0, 2, false)
+		// 非连续查找：从键 3 开始(包含键 3)，获取 2 个值
+		buffer, _, rangeResult := lnBTree.RangeLookUp(3, 2, false, 0)
 		if rangeResult != 2 {
 			t.Errorf("Expected RangeLookUp to return 2, got %d", rangeResult)
 		}
-		expectedValues := []interface{}{"value4", "value5"}
+		expectedValues := []interface{}{"value3_updated", "value4"}
 		for i, val := range buffer {
 			if val != expectedValues[i] {
 				t.Errorf("Expected buffer[%d] to be '%v', got '%v'", i, expectedValues[i], val)
@@ -239,8 +229,7 @@ func TestLNodeBTree_AllMethods(t *testing.T) {
 		}
 
 		// 连续查找：获取前 2 个值
-		buffer = []interface{}{}
-		rangeResult = lnBTree.RangeLookUp(0, &buffer, 0, 2, true)
+		buffer, _, rangeResult = lnBTree.RangeLookUp(0, 2, true, 0)
 		if rangeResult != 2 {
 			t.Errorf("Expected RangeLookUp to return 2, got %d", rangeResult)
 		}
@@ -251,13 +240,12 @@ func TestLNodeBTree_AllMethods(t *testing.T) {
 			}
 		}
 
-		// 超出范围查找：请求 10 个值，但只有 2 个
-		buffer = []interface{}{}
-		rangeResult = lnBTree.RangeLookUp(3, &buffer, 0, 10, false)
-		if rangeResult != 2 {
-			t.Errorf("Expected RangeLookUp to return 2 (available), got %d", rangeResult)
+		// 超出范围查找：请求 10 个值，但只有 3 个
+		buffer, _, rangeResult = lnBTree.RangeLookUp(3, 10, false, 0)
+		if rangeResult != 3 {
+			t.Errorf("Expected RangeLookUp to return 3 (available), got %d", rangeResult)
 		}
-		expectedValues = []interface{}{"value4", "value5"}
+		expectedValues = []interface{}{"value3_updated", "value4", "value5"}
 		for i, val := range buffer {
 			if val != expectedValues[i] {
 				t.Errorf("Expected buffer[%d] to be '%v', got '%v'", i, expectedValues[i], val)
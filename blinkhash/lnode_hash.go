@@ -2,6 +2,7 @@ package blinkhash
 
 import (
 	"fmt"
+	"math"
 	"math/rand"
 	"runtime"
 	"sort"
@@ -11,11 +12,15 @@ import (
 
 type LNodeHash struct {
 	Node
+	nodeOptions
 	Type           NodeType
 	Cardinality    int
 	HighKey        interface{}
-	Buckets        []Bucket
+	buckets        atomic.Pointer[[]Bucket] // 桶数组，扩容时整体替换，见 bucketArray
 	LeftSiblingPtr NodeInterface
+	moves          uint64 // 布谷鸟迁移计数，迁移进行中为奇数，供无锁读校验
+	mover          uint32 // 迁移锁，同一时刻只有一条迁移路径修改 moves，见 lnode_hash_cuckoo.go
+	stash          Bucket // 溢出区，候选桶满且无法迁移时暂存条目，见 lnode_hash_stash.go
 }
 
 // NewLNodeHash
@@ -26,7 +31,7 @@ type LNodeHash struct {
 //
 
 func NewLNodeHash(level int) *LNodeHash {
-	cardinality := LNodeHashCardinality
+	cardinality := builtinTreeOptions.hashInitialCardinality()
 	lnHash := &LNodeHash{
 		Node: Node{
 			lock:        0,
@@ -38,19 +43,22 @@ func NewLNodeHash(level int) *LNodeHash {
 		Type:           HashNode,
 		HighKey:        nil, // 需要在 Split 中设置
 		Cardinality:    cardinality,
-		Buckets:        make([]Bucket, cardinality),
 		LeftSiblingPtr: nil,
 	}
 	// 初始化每个桶的指纹和条目
-	for i := 0; i < lnHash.Cardinality; i++ {
-		lnHash.Buckets[i] = *NewBucket()
-	}
+	lnHash.buckets.Store(newBucketArray(cardinality))
+	lnHash.stash = newStashBucket(lnHash.options().hashStashSize())
 	return lnHash
 }
 
 // NewLNodeHashWithSibling 创建一个新的 LNodeHash 节点，并设置兄弟节点、计数和层级
 func NewLNodeHashWithSibling(sibling NodeInterface, count int32, level int) *LNodeHash {
-	cardinality := LNodeHashCardinality
+	return newLNodeHashWithCardinality(sibling, count, level, builtinTreeOptions.hashInitialCardinality(), nil)
+}
+
+// newLNodeHashWithCardinality 创建指定桶数、属于配置 opts 的 LNodeHash，opts 可以为 nil。
+// 分裂时右节点与左节点保持相同的桶数，迁移的条目才能留在原来的桶下标上
+func newLNodeHashWithCardinality(sibling NodeInterface, count int32, level int, cardinality int, opts *TreeOptions) *LNodeHash {
 	newHashNode := &LNodeHash{
 		Node: Node{
 			lock:        0,
@@ -62,14 +70,29 @@ func NewLNodeHashWithSibling(sibling NodeInterface, count int32, level int) *LNo
 		Type:           HashNode,
 		HighKey:        nil, // 需要在 Split 中设置
 		Cardinality:    cardinality,
-		Buckets:        make([]Bucket, cardinality),
 		LeftSiblingPtr: nil,
 	}
-	for i := 0; i < newHashNode.Cardinality; i++ {
-		newHashNode.Buckets[i] = *NewBucket()
-	}
+	newHashNode.opts = opts
+	newHashNode.buckets.Store(newBucketArray(cardinality))
+	newHashNode.stash = newStashBucket(newHashNode.options().hashStashSize())
 	return newHashNode
 }
+
+// newBucketArray 创建 n 个空桶
+func newBucketArray(n int) *[]Bucket {
+	buckets := make([]Bucket, n)
+	for i := range buckets {
+		buckets[i].size = EntryNum
+	}
+	return &buckets
+}
+
+// bucketArray 返回当前的桶数组。扩容通过原子指针整体发布新数组，
+// 无锁的读者拿到的总是某一个完整的数组，不会读到新旧混合的切片头
+func (lh *LNodeHash) bucketArray() []Bucket {
+	return *lh.buckets.Load()
+}
+
 func (lh *LNodeHash) GetHighKey() interface{} {
 	return lh.HighKey
 }
@@ -86,7 +109,7 @@ func (lh *LNodeHash) Print() {
 	fmt.Printf("Cardinality: %d\n", lh.Cardinality)
 	lh.Node.Print()
 	fmt.Printf("Buckets:\n")
-	for i, bucket := range lh.Buckets {
+	for i, bucket := range lh.bucketArray() {
 		fmt.Printf("\tBucket %d information: \n", i)
 		bucket.Print()
 	}
@@ -117,11 +140,15 @@ func (lh *LNodeHash) TrySplitLock(version uint64) bool {
 		return false
 	}
 
-	for i := 0; i < lh.Cardinality; i++ {
-		for !lh.Buckets[i].TryLock() {
+	buckets := lh.bucketArray()
+	for i := range buckets {
+		for !buckets[i].TryLock() {
 			runtime.Gosched() // 防止紧密循环
 		}
 	}
+	for !lh.stash.TryLock() {
+		runtime.Gosched()
+	}
 	return true
 }
 
@@ -132,28 +159,36 @@ func (lh *LNodeHash) TryConvertLock(version uint64) bool {
 		return false
 	}
 
-	for i := 0; i < lh.Cardinality; i++ {
-		for !lh.Buckets[i].TryLock() {
+	buckets := lh.bucketArray()
+	for i := range buckets {
+		for !buckets[i].TryLock() {
 			runtime.Gosched()
 		}
 	}
+	for !lh.stash.TryLock() {
+		runtime.Gosched()
+	}
 	return true
 }
 
 // WriteUnlock 释放分裂时的锁，等同于 C++ 的 split_unlock
 func (lh *LNodeHash) WriteUnlock() {
 	lh.Node.WriteUnlock()
-	for i := 0; i < lh.Cardinality; i++ {
-		lh.Buckets[i].Unlock()
+	buckets := lh.bucketArray()
+	for i := range buckets {
+		buckets[i].Unlock()
 	}
+	lh.stash.Unlock()
 }
 
 // SplitUnlockObsolete 以过时方式释放分裂锁，等同于 C++ 的 split_unlock_obsolete
 func (lh *LNodeHash) SplitUnlockObsolete() {
 	lh.WriteUnlockObsolete()
-	for i := 0; i < lh.Cardinality; i++ {
-		lh.Buckets[i].Unlock()
+	buckets := lh.bucketArray()
+	for i := range buckets {
+		buckets[i].Unlock()
 	}
+	lh.stash.Unlock()
 }
 
 // TryWriteLock 尝试获取写锁，等同于 C++ 的 try_writelock
@@ -164,8 +199,9 @@ func (lh *LNodeHash) TryWriteLock() bool {
 // ConvertUnlock 释放转换锁定，等同于 C++ 的 convert_unlock
 func (lh *LNodeHash) ConvertUnlock() {
 	lh.WriteUnlock()
-	for i := 0; i < lh.Cardinality; i++ {
-		lh.Buckets[i].Unlock()
+	buckets := lh.bucketArray()
+	for i := range buckets {
+		buckets[i].Unlock()
 	}
 }
 
@@ -183,6 +219,12 @@ func (lh *LNodeHash) Hash(key interface{}) uint8 {
 	return uint8(keyInt % 256)
 }
 
+// hashKey 使用第 k 个哈希函数计算 key 的哈希值，
+// Insert/Find/Update/Remove/Split 必须使用同一套哈希才能定位到同一组桶
+func (lh *LNodeHash) hashKey(key interface{}, k int) uint64 {
+	return h(key, k, 0)
+}
+
 // Insert 实现 Insertable 接口
 // @Description: 实现 Insertable 接口的插入方法
 // @receiver lh
@@ -192,10 +234,32 @@ func (lh *LNodeHash) Hash(key interface{}) uint8 {
 // @return int
 
 func (lh *LNodeHash) Insert(key interface{}, value interface{}, version uint64) int {
+	ret := lh.insert(key, value, version)
+	if ret != NeedSplit {
+		return ret
+	}
+	// 候选桶都已满时先尝试布谷鸟迁移腾出空位，再尝试溢出区，都失败才分裂
+	if lh.options().cuckooMaxDepth() > 0 {
+		ret = lh.displace(key, version)
+		if ret == NeedRestart {
+			return ret
+		}
+		if ret == InsertSuccess {
+			if ret = lh.insert(key, value, version); ret != NeedSplit {
+				return ret
+			}
+		}
+	}
+	return lh.insertStash(key, value, version)
+}
+
+// insert 在 key 的候选桶中寻找空槽位插入，不做迁移
+func (lh *LNodeHash) insert(key interface{}, value interface{}, version uint64) int {
+	buckets := lh.bucketArray() // 扩容会替换桶数组，使用快照计算下标
 	//fmt.Println("我是LNodeHash，调用Insert")
 	// 根据 FINGERPRINT 设置初始化 empty
 	for k := 0; k < HashFuncsNum; k++ {
-		hashKey := h(key, k, 0) // 使用默认 seed
+		hashKey := lh.hashKey(key, k)
 
 		var fingerprint uint8
 		if FINGERPRINT {
@@ -203,22 +267,22 @@ func (lh *LNodeHash) Insert(key interface{}, value interface{}, version uint64)
 		}
 
 		for j := 0; j < NumSlot; j++ {
-			loc := int((hashKey + uint64(j)) % uint64(lh.Cardinality))
-			if !lh.Buckets[loc].TryLock() {
+			loc := int((hashKey + uint64(j)) % uint64(len(buckets)))
+			if !buckets[loc].TryLock() {
 				return NeedRestart // 返回 -1
 			}
 
 			// 获取节点的当前版本
 			currentVersion, needRestart := lh.GetVersion()
 			if needRestart || version != currentVersion {
-				lh.Buckets[loc].Unlock()
+				buckets[loc].Unlock()
 				return NeedRestart // 返回 -1
 			}
 
 			// 如果启用了 LINKED
-			if LINKED && lh.Buckets[loc].state != STABLE {
+			if LINKED && buckets[loc].state != STABLE {
 				if !lh.StabilizeBucket(loc) {
-					lh.Buckets[loc].Unlock()
+					buckets[loc].Unlock()
 					return NeedRestart
 				}
 			}
@@ -226,15 +290,16 @@ func (lh *LNodeHash) Insert(key interface{}, value interface{}, version uint64)
 			// 尝试在槽位中插入
 			success := false
 			if FINGERPRINT {
-				success = lh.Buckets[loc].InsertWithFingerprint(key, value, fingerprint, EmptyFingerprint)
+				success = buckets[loc].InsertWithFingerprint(key, value, fingerprint, EmptyFingerprint)
 			} else {
-				success = lh.Buckets[loc].Insert(key, value)
+				success = buckets[loc].Insert(key, value)
 			}
 
 			if success {
-				lh.Buckets[loc].Unlock()
-				// 成功插入后递增计数
+				// 在释放桶锁之前递增计数，条目对读者可见时计数一定已经包含它，
+				// 一致性范围查询依赖这一点判断空叶子仍然为空
 				atomic.AddInt32(&lh.count, 1) // 假设 Count 是 int32 类型
+				buckets[loc].Unlock()
 				// 如果新插入的 key > 当前节点的 HighKey，则更新
 				// 注意根据你的 compareIntKeys 或其他比较函数来做判断
 				if compareIntKeys(key, lh.HighKey) > 0 {
@@ -244,7 +309,7 @@ func (lh *LNodeHash) Insert(key interface{}, value interface{}, version uint64)
 			}
 
 			// 插入失败，解锁并继续
-			lh.Buckets[loc].Unlock()
+			buckets[loc].Unlock()
 		}
 	}
 
@@ -264,8 +329,21 @@ func (lh *LNodeHash) Insert(key interface{}, value interface{}, version uint64)
 //
 // 分裂函数
 func (lh *LNodeHash) Split(key interface{}, value interface{}, version uint64) (Splittable, interface{}) {
+	// 还能扩容时先在分裂锁内翻倍桶数组，成功后释放锁并返回 nil，
+	// 调用方重启后在扩容后的叶子中重新插入；扩容失败时继续持锁分裂
+	locked := false
+	if lh.canGrow() {
+		if !lh.TrySplitLock(version) {
+			return nil, nil
+		}
+		if lh.growLocked() {
+			lh.WriteUnlock()
+			return nil, nil
+		}
+		locked = true
+	}
 
-	newRight := NewLNodeHashWithSibling(lh.siblingPtr, 0, lh.level)
+	newRight := newLNodeHashWithCardinality(lh.siblingPtr, 0, lh.level, lh.Cardinality, lh.opts)
 	// 初始化newRight的buckets
 	newRight.HighKey = lh.HighKey
 	newRight.LeftSiblingPtr = lh
@@ -277,82 +355,77 @@ func (lh *LNodeHash) Split(key interface{}, value interface{}, version uint64) (
 
 	var targets [HashFuncsNum]targetT
 	for k := 0; k < HashFuncsNum; k++ {
-		hv := h(key, 0, uint64(k))
+		hv := lh.hashKey(key, k)
 		loc := hv % uint64(lh.Cardinality)
 		fp := lh.Hash(hv) | 1
 		targets[k] = targetT{loc: loc, fingerprint: uint64(fp)}
 	}
 
 	// 如果LINKED启用，这里做stabilize_all检查（假设成功）
-	if LINKED {
+	if LINKED && !locked {
 		if !lh.StabilizeAll(version) {
 			return nil, nil
 		}
 	}
 	// 尝试上分裂锁
-	if !lh.TrySplitLock(version) {
+	if !locked && !lh.TrySplitLock(version) {
 		return nil, nil
 	}
 
+	buckets, rightBuckets := lh.bucketArray(), newRight.bucketArray()
 	// 收集keys用于找到splitKey
 	temp := make([]interface{}, 0, lh.Cardinality*EntryNum)
 	if FINGERPRINT {
 		// 收集所有有fingerprint的key
 		for i := 0; i < lh.Cardinality; i++ {
-			temp = append(temp, lh.Buckets[i].CollectAllKeysWithFingerprint(EmptyFingerprint)...)
+			temp = append(temp, buckets[i].CollectAllKeysWithFingerprint(EmptyFingerprint)...)
 		}
+		temp = append(temp, lh.stash.CollectAllKeysWithFingerprint(EmptyFingerprint)...)
 	} else {
 		for i := 0; i < lh.Cardinality; i++ {
-			temp = append(temp, lh.Buckets[i].CollectAllKeys()...)
+			temp = append(temp, buckets[i].CollectAllKeys()...)
 		}
+		temp = append(temp, lh.stash.CollectAllKeys()...)
 	}
 
-	// 找中值key作为splitKey
-	medianIndex := lh.findMedian(temp)
-	medianKey := temp[medianIndex]
+	// 由分裂策略在排好序的keys中选出splitKey，默认为中值
+	if len(temp) == 0 {
+		lh.WriteUnlock()
+		return nil, nil
+	}
+	sort.Slice(temp, func(i, j int) bool {
+		return compareIntKeys(temp[i], temp[j]) < 0
+	})
+	left := leafSplitPoint(lh.options(), len(temp), temp[len(temp)-1], key, lh.siblingPtr == nil)
+	medianKey := temp[left-1]
 	splitKey := medianKey
 	lh.HighKey = medianKey
 
-	// 迁移keys到newRight
-	if FINGERPRINT {
-		// fingerprint版本
-		for j := 0; j < lh.Cardinality; j++ {
-			for i := 0; i < EntryNum; i++ {
-				if lh.Buckets[j].fingerprints != nil && lh.Buckets[j].fingerprints[i] != 0 {
-					// slot occupied
-					if lh.Buckets[j].entries[i].Key.(int) > medianKey.(int) {
-						// migrate to newRight
-						newRight.Buckets[j].entries[i] = lh.Buckets[j].entries[i]
-						newRight.Buckets[j].fingerprints[i] = lh.Buckets[j].fingerprints[i]
-						lh.Buckets[j].fingerprints[i] = 0
-						lh.Buckets[j].entries[i] = Entry{Key: nil, Value: nil}
-						// 更新 count
-						lh.DecrementCount()
-						newRight.IncrementCount()
-					}
-				}
-			}
-		}
-	} else {
-		// baseline无fingerprint版本
-		for j := 0; j < lh.Cardinality; j++ {
-			for i := 0; i < EntryNum; i++ {
-				e := lh.Buckets[j].entries[i]
-				if !IsEmptyKey(e.Key) && e.Key.(int) > medianKey.(int) {
-					newRight.Buckets[j].entries[i] = e
-					lh.Buckets[j].entries[i] = Entry{Key: nil, Value: nil}
-					// 更新 count
-					lh.DecrementCount()
-					newRight.IncrementCount()
-				}
+	// 迁移keys到newRight，指纹最低位标记槽位占用，有无FINGERPRINT都适用
+	median := medianKey.(int)
+	for j := 0; j < lh.Cardinality; j++ {
+		bucket := &buckets[j]
+		for i := 0; i < EntryNum; i++ {
+			if bucket.occupied(i) && bucket.keys[i] > median {
+				// migrate to newRight
+				bucket.moveSlot(i, &rightBuckets[j], i, bucket.fingerprints[i])
+				// 更新 count
+				lh.DecrementCount()
+				newRight.IncrementCount()
 			}
 		}
 	}
 
+	// 溢出区中的条目同样按 medianKey 迁移，分裂后两侧都腾出了空间，尽量放回候选桶
+	lh.splitStash(newRight, medianKey)
+	lh.drainStashLocked()
+	newRight.drainStashLocked()
+
 	// 在分裂后插入新key
 	var targetNode = lh
+	targetBuckets := buckets
 	if key.(int) > medianKey.(int) {
-		targetNode = newRight
+		targetNode, targetBuckets = newRight, rightBuckets
 	}
 
 	needInsert := true
@@ -369,40 +442,35 @@ InsertLoop:
 					// LINKED + FINGERPRINT逻辑
 					// 遍历entry_num
 					for i := 0; i < EntryNum && needInsert; i++ {
-						fpOld := targetNode.Buckets[loc].fingerprints[i]
+						fpOld := targetBuckets[loc].fingerprints[i]
 						if fpOld != 0 {
 							// slot occupied,检查是否需要迁移
-							entryKey := targetNode.Buckets[loc].entries[i].Key
-							if entryKey.(int) > medianKey.(int) && targetNode == lh {
+							entryKey := targetBuckets[loc].keys[i]
+							if entryKey > median && targetNode == lh {
 								// 需要迁移到newRight
-								newRight.Buckets[loc].entries[i] = targetNode.Buckets[loc].entries[i]
-								newRight.Buckets[loc].fingerprints[i] = fpOld
+								rightBuckets[loc].setSlot(i, entryKey, targetBuckets[loc].values.get(i), fpOld)
 								if needInsert {
 									// 在当前节点插入？
 									if key.(int) <= medianKey.(int) {
-										targetNode.Buckets[loc].fingerprints[i] = uint8(targets[m].fingerprint)
-										targetNode.Buckets[loc].entries[i].Key = key
-										targetNode.Buckets[loc].entries[i].Value = value
+										targetBuckets[loc].setSlot(i, key.(int), value, uint8(targets[m].fingerprint))
 										needInsert = false
 										// 更新 count
 										lh.IncrementCount()
 									} else {
 										// 重置迁移key的fingerprint
-										targetNode.Buckets[loc].fingerprints[i] = 0
+										targetBuckets[loc].fingerprints[i] = 0
 									}
 								} else {
-									targetNode.Buckets[loc].fingerprints[i] = 0
+									targetBuckets[loc].fingerprints[i] = 0
 								}
 							} else {
 								// slot不需要迁移
 								if needInsert {
 									if medianKey.(int) < key.(int) && targetNode == lh {
 										// 插入到newRight
-										newRight.Buckets[loc].fingerprints[i] = uint8(targets[m].fingerprint)
-										newRight.Buckets[loc].entries[i].Key = key
-										newRight.Buckets[loc].entries[i].Value = value
+										rightBuckets[loc].setSlot(i, key.(int), value, uint8(targets[m].fingerprint))
 										needInsert = false
-										lh.IncrementCount()
+										newRight.IncrementCount()
 									}
 								}
 							}
@@ -411,16 +479,12 @@ InsertLoop:
 							if needInsert {
 								if medianKey.(int) < key.(int) && targetNode == lh {
 									// 插入到newRight
-									newRight.Buckets[loc].fingerprints[i] = uint8(targets[m].fingerprint)
-									newRight.Buckets[loc].entries[i].Key = key
-									newRight.Buckets[loc].entries[i].Value = value
-									lh.IncrementCount()
+									rightBuckets[loc].setSlot(i, key.(int), value, uint8(targets[m].fingerprint))
+									newRight.IncrementCount()
 								} else {
 									// 插入到当前
-									targetNode.Buckets[loc].fingerprints[i] = uint8(targets[m].fingerprint)
-									targetNode.Buckets[loc].entries[i].Key = key
-									targetNode.Buckets[loc].entries[i].Value = value
-									lh.IncrementCount()
+									targetBuckets[loc].setSlot(i, key.(int), value, uint8(targets[m].fingerprint))
+									targetNode.IncrementCount()
 								}
 								needInsert = false
 
@@ -430,12 +494,10 @@ InsertLoop:
 				} else {
 					// 非LINKED + FINGERPRINT逻辑（简化对应C++ baseline fingerprint插入逻辑）
 					for i := 0; i < EntryNum && needInsert; i++ {
-						if targetNode.Buckets[loc].fingerprints[i] == 0 {
-							targetNode.Buckets[loc].fingerprints[i] = uint8(targets[m].fingerprint)
-							targetNode.Buckets[loc].entries[i].Key = key
-							targetNode.Buckets[loc].entries[i].Value = value
+						if targetBuckets[loc].fingerprints[i] == 0 {
+							targetBuckets[loc].setSlot(i, key.(int), value, uint8(targets[m].fingerprint))
 							needInsert = false
-							lh.IncrementCount()
+							targetNode.IncrementCount()
 							break InsertLoop
 						}
 					}
@@ -445,17 +507,15 @@ InsertLoop:
 				if LINKED {
 					// LINKED但无fingerprint逻辑
 					for i := 0; i < EntryNum && needInsert; i++ {
-						if IsEmptyKey(targetNode.Buckets[loc].entries[i].Key) {
+						if !targetBuckets[loc].occupied(i) {
 							// empty slot
 							if medianKey.(int) < key.(int) && targetNode == lh {
 								// 插入到newRight
-								newRight.Buckets[loc].entries[i].Key = key
-								newRight.Buckets[loc].entries[i].Value = value
+								rightBuckets[loc].setSlot(i, key.(int), value, occupiedFingerprint)
 								needInsert = false
 								newRight.IncrementCount()
 							} else {
-								targetNode.Buckets[loc].entries[i].Key = key
-								targetNode.Buckets[loc].entries[i].Value = value
+								targetBuckets[loc].setSlot(i, key.(int), value, occupiedFingerprint)
 								needInsert = false
 								lh.IncrementCount()
 							}
@@ -470,14 +530,13 @@ InsertLoop:
 				} else {
 					// 非LINKED且非FINGERPRINT baseline逻辑
 					for i := 0; i < EntryNum && needInsert; i++ {
-						if IsEmptyKey(targetNode.Buckets[loc].entries[i].Key) {
-							targetNode.Buckets[loc].entries[i].Key = key
-							targetNode.Buckets[loc].entries[i].Value = value
+						if !targetBuckets[loc].occupied(i) {
+							targetBuckets[loc].setSlot(i, key.(int), value, occupiedFingerprint)
 							needInsert = false
 							if compareIntKeys(key, targetNode.HighKey) > 0 {
 								targetNode.HighKey = key
 							}
-							lh.IncrementCount()
+							targetNode.IncrementCount()
 							break InsertLoop
 						}
 					}
@@ -489,11 +548,13 @@ InsertLoop:
 	oldSibling := lh.siblingPtr
 	lh.siblingPtr = newRight
 	if oldSibling != nil {
-		if oldSiblingNode, ok := oldSibling.(*LNodeHash); ok {
-			oldSiblingNode.LeftSiblingPtr = newRight
-		}
+		linkLeft(oldSibling, newRight)
 	}
 
+	if needInsert {
+		// 候选桶仍然已满时放入目标节点的溢出区
+		needInsert = !targetNode.insertStashLocked(key, value)
+	}
 	if needInsert {
 		fmt.Printf("insert after split failed -- key: %v\n", key)
 	}
@@ -519,9 +580,11 @@ InsertLoop:
 //	@param version
 //	@return int
 func (lh *LNodeHash) Update(key, value interface{}, vstart uint64) int {
+	buckets := lh.bucketArray() // 扩容会替换桶数组，使用快照计算下标
+	moves := atomic.LoadUint64(&lh.moves)
 	for k := 0; k < HashFuncsNum; k++ {
 		// 假设 h 函数接受 key和seed来计算hash
-		hashKey := h(key, 0, uint64(k))
+		hashKey := lh.hashKey(key, k)
 
 		var fingerprint uint8
 		if FINGERPRINT {
@@ -530,21 +593,21 @@ func (lh *LNodeHash) Update(key, value interface{}, vstart uint64) int {
 		}
 
 		for j := 0; j < NumSlot; j++ {
-			loc := (hashKey + uint64(j)) % uint64(lh.Cardinality)
-			if !lh.Buckets[loc].TryLock() {
+			loc := (hashKey + uint64(j)) % uint64(len(buckets))
+			if !buckets[loc].TryLock() {
 				return -1
 			}
 
 			vend, needRestart := lh.GetVersion()
 			if needRestart || (vstart != vend) {
-				lh.Buckets[loc].Unlock()
+				buckets[loc].Unlock()
 				return -1
 			}
 
 			if LINKED {
-				if lh.Buckets[loc].state != STABLE {
+				if buckets[loc].state != STABLE {
 					if !lh.StabilizeBucket(int(loc)) {
-						lh.Buckets[loc].Unlock()
+						buckets[loc].Unlock()
 						return -1
 					}
 				}
@@ -553,12 +616,12 @@ func (lh *LNodeHash) Update(key, value interface{}, vstart uint64) int {
 			// 根据FINGERPRINT判断调用不同的update逻辑
 			var updated bool
 			if FINGERPRINT {
-				updated = lh.Buckets[loc].UpdateWithFingerprint(key, value, fingerprint)
+				updated = buckets[loc].UpdateWithFingerprint(key, value, fingerprint)
 			} else {
-				updated = lh.Buckets[loc].Update(key, value)
+				updated = buckets[loc].Update(key, value)
 			}
 
-			lh.Buckets[loc].Unlock()
+			buckets[loc].Unlock()
 
 			if updated {
 				// 成功更新
@@ -567,7 +630,14 @@ func (lh *LNodeHash) Update(key, value interface{}, vstart uint64) int {
 			// 如果没有更新成功（此位置没有该key），尝试下一个槽位或下一个hash函数
 		}
 	}
-	// 所有hash函数与槽位尝试完毕依然没有找到key
+	// 所有hash函数与槽位尝试完毕依然没有找到key，再检查溢出区
+	if ret := lh.updateStash(key, value, vstart); ret != UpdateFailure {
+		return ret
+	}
+	if moves&1 == 1 || atomic.LoadUint64(&lh.moves) != moves {
+		// 查找期间发生了布谷鸟迁移，key 可能被移到了已经检查过的桶中
+		return -1
+	}
 	return UpdateFailure
 }
 
@@ -579,8 +649,10 @@ func (lh *LNodeHash) Update(key, value interface{}, vstart uint64) int {
 //	@param version
 //	@return int
 func (lh *LNodeHash) Remove(key interface{}, vstart uint64) int {
+	buckets := lh.bucketArray() // 扩容会替换桶数组，使用快照计算下标
+	moves := atomic.LoadUint64(&lh.moves)
 	for k := 0; k < HashFuncsNum; k++ {
-		hashKey := h(key, 0, uint64(k))
+		hashKey := lh.hashKey(key, k)
 
 		var fingerprint uint8
 		if FINGERPRINT {
@@ -589,21 +661,21 @@ func (lh *LNodeHash) Remove(key interface{}, vstart uint64) int {
 		}
 
 		for j := 0; j < NumSlot; j++ {
-			loc := (hashKey + uint64(j)) % uint64(lh.Cardinality)
-			if !lh.Buckets[loc].TryLock() {
+			loc := (hashKey + uint64(j)) % uint64(len(buckets))
+			if !buckets[loc].TryLock() {
 				return -1
 			}
 
 			vend, needRestart := lh.GetVersion()
 			if needRestart || (vstart != vend) {
-				lh.Buckets[loc].Unlock()
+				buckets[loc].Unlock()
 				return -1
 			}
 
 			if LINKED {
-				if lh.Buckets[loc].state != STABLE {
+				if buckets[loc].state != STABLE {
 					if !lh.StabilizeBucket(int(loc)) {
-						lh.Buckets[loc].Unlock()
+						buckets[loc].Unlock()
 						return -1
 					}
 				}
@@ -611,21 +683,29 @@ func (lh *LNodeHash) Remove(key interface{}, vstart uint64) int {
 
 			var removed bool
 			if FINGERPRINT {
-				removed = lh.Buckets[loc].RemoveWithFingerprint(key, fingerprint)
+				removed = buckets[loc].RemoveWithFingerprint(key, fingerprint)
 			} else {
-				removed = lh.Buckets[loc].Remove(key)
+				removed = buckets[loc].Remove(key)
 			}
 
-			lh.Buckets[loc].Unlock()
+			buckets[loc].Unlock()
 
 			if removed {
 				// 成功删除
+				atomic.AddInt32(&lh.count, -1)
 				return 0
 			}
 			// 如果本位置没找到key，继续尝试下一个槽位或下一个hash函数
 		}
 	}
-	// 遍历完所有hash函数与槽位仍未找到key
+	// 遍历完所有hash函数与槽位仍未找到key，再检查溢出区
+	if ret := lh.removeStash(key, vstart); ret != 1 {
+		return ret
+	}
+	if moves&1 == 1 || atomic.LoadUint64(&lh.moves) != moves {
+		// 查找期间发生了布谷鸟迁移，key 可能被移到了已经检查过的桶中
+		return -1
+	}
 	return 1
 }
 
@@ -637,9 +717,33 @@ func (lh *LNodeHash) Remove(key interface{}, vstart uint64) int {
 //	@return interface{}
 //	@return bool
 func (lh *LNodeHash) Find(key interface{}) (interface{}, bool) {
-	var found bool
+	for {
+		moves := atomic.LoadUint64(&lh.moves)
+		if moves&1 == 1 {
+			runtime.Gosched()
+			continue
+		}
+		val, found, retry := lh.find(key)
+		if retry {
+			// 节点被锁定(分裂、转换)时交给上层通过节点版本校验重启
+			if _, needRestart := lh.GetVersion(); needRestart {
+				return nil, false
+			}
+			runtime.Gosched()
+			continue
+		}
+		// 找到的结果总是有效的；没找到时需要确认期间没有发生迁移
+		if found || atomic.LoadUint64(&lh.moves) == moves {
+			return val, found
+		}
+	}
+}
+
+// find 依次检查 key 的候选桶，桶被锁定或版本变化时返回 retry
+func (lh *LNodeHash) find(key interface{}) (val interface{}, found bool, retry bool) {
+	buckets := lh.bucketArray() // 扩容会替换桶数组，使用快照计算下标
 	for k := 0; k < HashFuncsNum; k++ {
-		hashKey := h(key, 0, uint64(k))
+		hashKey := lh.hashKey(key, k)
 
 		var fingerprint uint8
 		if FINGERPRINT {
@@ -648,53 +752,44 @@ func (lh *LNodeHash) Find(key interface{}) (interface{}, bool) {
 		}
 
 		for j := 0; j < NumSlot; j++ {
-			loc := (hashKey + uint64(j)) % uint64(lh.Cardinality)
-
-			bucketVstart, needRestart := lh.Buckets[loc].getVersion()
+			loc := (hashKey + uint64(j)) % uint64(len(buckets))
 
+			bucketVstart, needRestart := buckets[loc].getVersion()
 			if needRestart {
-				lh.Buckets[loc].Unlock()
-				return nil, found
+				return nil, false, true
 			}
-			if LINKED && lh.Buckets[loc].state != STABLE {
-				if !lh.Buckets[loc].upgradeLock(bucketVstart) {
-					needRestart = true
-					return nil, found
+			if LINKED && buckets[loc].state != STABLE {
+				if !buckets[loc].upgradeLock(bucketVstart) {
+					return nil, false, true
 				}
 
 				if !lh.StabilizeBucket(int(loc)) {
-					lh.Buckets[loc].Unlock()
-					needRestart = true
-					return nil, found
+					buckets[loc].Unlock()
+					return nil, false, true
 				}
 
-				lh.Buckets[loc].Unlock()
+				buckets[loc].Unlock()
 				bucketVstart += 0b100
 			}
 
 			var ret interface{}
-
 			if FINGERPRINT {
-				ret, found = lh.Buckets[loc].FindWithFingerprint(key, fingerprint)
+				ret, found = buckets[loc].FindWithFingerprint(key, fingerprint)
 			} else {
-				ret, found = lh.Buckets[loc].Find(key)
+				ret, found = buckets[loc].Find(key)
 			}
 
-			if found {
-				bucketVend, needRestart := lh.Buckets[loc].getVersion()
-				if needRestart || (bucketVstart != bucketVend) {
-					return nil, found
-				}
-				return ret, found
-			}
-
-			bucketVend, needRestart := lh.Buckets[loc].getVersion()
+			bucketVend, needRestart := buckets[loc].getVersion()
 			if needRestart || (bucketVstart != bucketVend) {
-				return nil, found
+				return nil, false, true
+			}
+			if found {
+				return ret, true, false
 			}
 		}
 	}
-	return nil, found // 没找到key
+	// 候选桶中没有时再检查溢出区
+	return lh.findStash(key)
 }
 
 // RangeLookUp
@@ -708,45 +803,89 @@ func (lh *LNodeHash) Find(key interface{}) (interface{}, bool) {
 //	@param continued
 //	@return int
 func (lh *LNodeHash) RangeLookUp(key interface{}, upTo int, continued bool, version uint64) ([]interface{}, int, int) {
+	entries, retCode, count := lh.RangeLookUpEntries(key, upTo, continued, version)
+	return entryValues(entries), retCode, count
+}
+
+// RangeLookUpEntries
+//
+//	@Description: 实现EntryRangeLookuper接口，返回按键排序的条目
+//	@receiver lh
+//	@param key 起始键(包含)
+//	@param upTo 最多收集的条目数
+//	@param continued
+//	@param version
+//	@return []Entry
+//	@return int
+//	@return int
+func (lh *LNodeHash) RangeLookUpEntries(key interface{}, upTo int, continued bool, version uint64) ([]Entry, int, int) {
+	buckets := lh.bucketArray() // 扩容会替换桶数组，使用快照计算下标
 	if Adaption {
+		if atomic.LoadInt32(&lh.count) == 0 {
+			// 空叶子无需转换，直接跳过
+			return nil, 0, 0
+		}
 		return nil, NeedConvert, 0
 	}
 
 	// 收集条目
+	moves := atomic.LoadUint64(&lh.moves)
+	if moves&1 == 1 {
+		return nil, NeedRestart, 0
+	}
 	var collectedEntries []Entry
-	for j := 0; j < lh.Cardinality; j++ {
-		bucketVstart, nr := lh.Buckets[j].getVersion()
+	for j := 0; j < len(buckets); j++ {
+		bucketVstart, nr := buckets[j].getVersion()
 		if nr {
 			return nil, NeedRestart, 0
 		}
 
 		// 如果 LINKED & bucket 不稳定，需要 Stabilize
-		if LINKED && lh.Buckets[j].state != STABLE {
-			if !lh.Buckets[j].upgradeLock(bucketVstart) {
+		if LINKED && buckets[j].state != STABLE {
+			if !buckets[j].upgradeLock(bucketVstart) {
 				return nil, NeedRestart, 0
 			}
 			if !lh.StabilizeBucket(j) {
-				lh.Buckets[j].Unlock()
+				buckets[j].Unlock()
 				return nil, NeedRestart, 0
 			}
-			lh.Buckets[j].Unlock()
+			buckets[j].Unlock()
 			bucketVstart += 0b100
 		}
 
 		var entries []Entry
 		if FINGERPRINT {
-			entries = lh.Buckets[j].CollectWithFingerprint(key, EmptyFingerprint)
+			entries = buckets[j].CollectWithFingerprint(key, EmptyFingerprint)
 		} else {
-			entries = lh.Buckets[j].Collect(key)
+			entries = buckets[j].Collect(key)
 		}
 		collectedEntries = append(collectedEntries, entries...)
 
-		bucketVend, nr := lh.Buckets[j].getVersion()
+		bucketVend, nr := buckets[j].getVersion()
 		if nr || (bucketVstart != bucketVend) {
 			return nil, NeedRestart, 0
 		}
 	}
 
+	if atomic.LoadUint64(&lh.moves) != moves {
+		// 扫描期间有条目在桶之间迁移，可能重复或遗漏
+		return nil, NeedRestart, 0
+	}
+
+	// 溢出区
+	stashVstart, nr := lh.stash.getVersion()
+	if nr {
+		return nil, NeedRestart, 0
+	}
+	if FINGERPRINT {
+		collectedEntries = append(collectedEntries, lh.stash.CollectWithFingerprint(key, EmptyFingerprint)...)
+	} else {
+		collectedEntries = append(collectedEntries, lh.stash.Collect(key)...)
+	}
+	if stashVend, nr := lh.stash.getVersion(); nr || stashVstart != stashVend {
+		return nil, NeedRestart, 0
+	}
+
 	// 如果 continued == true, 我们的逻辑其实跟 LNodeHash
 	// 是否要从 key 再次搜？由你决定
 	// 这里暂时与 continued 无关, 只要 key <= entry 就搜
@@ -757,12 +896,10 @@ func (lh *LNodeHash) RangeLookUp(key interface{}, upTo int, continued bool, vers
 	})
 
 	// 截取 upTo 条
-	collected := make([]interface{}, 0, upTo)
-	for i := 0; i < len(collectedEntries) && i < upTo; i++ {
-		collected = append(collected, collectedEntries[i].Value)
+	if len(collectedEntries) > upTo {
+		collectedEntries = collectedEntries[:upTo]
 	}
-
-	return collected, 0, len(collected)
+	return collectedEntries, 0, len(collectedEntries)
 }
 
 // Utilization
@@ -771,23 +908,19 @@ func (lh *LNodeHash) RangeLookUp(key interface{}, upTo int, continued bool, vers
 //	@receiver b
 //	@return float64
 func (lh *LNodeHash) Utilization() float64 {
-	// 简单计算利用率：非空key数量/总空间
-	totalEntries := lh.Cardinality * EntryNum
+	buckets := lh.bucketArray() // 扩容会替换桶数组，使用快照计算下标
+	// 简单计算利用率：非空key数量/总空间，包含溢出区
+	totalEntries := len(buckets)*EntryNum + lh.StashCapacity()
 	count := 0
-	for i := 0; i < lh.Cardinality; i++ {
+	for i := 0; i < len(buckets); i++ {
 		for j := 0; j < EntryNum; j++ {
-			if FINGERPRINT {
-				if lh.Buckets[i].fingerprints[j]&0b1 != 0b1 {
-					count++
-				}
-			} else {
-				if lh.Buckets[i].entries[j].Key != nil {
-					count++
-				}
+			if buckets[i].occupied(j) {
+				count++
 			}
 
 		}
 	}
+	count += lh.StashCount()
 	return float64(count) / float64(totalEntries)
 }
 
@@ -803,50 +936,52 @@ func (lh *LNodeHash) StabilizeAll(version uint64) bool {
 		return false
 	}
 
-	for loc := 0; loc < lh.Cardinality; loc++ {
+	buckets := lh.bucketArray()
+	for loc := range buckets {
+		bucket := &buckets[loc]
 		// 如果bucket已经是STABLE则无需处理
-		if lh.Buckets[loc].state == STABLE {
+		if bucket.state == STABLE {
 			continue
 		}
 
 		// 尝试锁定当前bucket
-		if !lh.Buckets[loc].TryLock() {
+		if !bucket.TryLock() {
 			return false
 		}
 
 		// 检查当前版本是否匹配，防止并发修改
 		curVersion, needRestart := lh.GetVersion()
 		if needRestart || (version != curVersion) {
-			lh.Buckets[loc].Unlock()
+			bucket.Unlock()
 			return false
 		}
 
-		switch lh.Buckets[loc].state {
+		switch bucket.state {
 		case LINKED_LEFT:
 			// 从左兄弟迁移
 			left, ok := lh.LeftSiblingPtr.(*LNodeHash)
 			if !ok {
 				fmt.Println("StabilizeAll: left sibling is not LNodeHash")
-				lh.Buckets[loc].Unlock()
+				bucket.Unlock()
 				return false
 			}
 
 			leftVStart, needRestart := left.GetVersion()
 			if needRestart {
-				lh.Buckets[loc].Unlock()
+				bucket.Unlock()
 				return false
 			}
 
-			leftBucket := &left.Buckets[loc]
+			leftBucket := &left.bucketArray()[loc]
 			if !leftBucket.TryLock() {
-				lh.Buckets[loc].Unlock()
+				bucket.Unlock()
 				return false
 			}
 
 			leftVEnd, needRestart := left.GetVersion()
 			if needRestart || (leftVStart != leftVEnd) {
 				leftBucket.Unlock()
-				lh.Buckets[loc].Unlock()
+				bucket.Unlock()
 				return false
 			}
 
@@ -857,35 +992,29 @@ func (lh *LNodeHash) StabilizeAll(version uint64) bool {
 				if !ok1 || !ok2 {
 					fmt.Println("StabilizeAll: type assertion for high keys failed")
 					leftBucket.Unlock()
-					lh.Buckets[loc].Unlock()
+					bucket.Unlock()
 					return false
 				}
 
-				for i := 0; i < len(leftBucket.entries); i++ {
+				for i := 0; i < leftBucket.slots(); i++ {
 					if leftBucket.fingerprints[i] != EmptyFingerprint {
-						entryKey, ok3 := leftBucket.entries[i].Key.(int)
-						if !ok3 {
-							fmt.Println("StabilizeAll: type assertion for entry key failed")
-							continue
-						}
+						entryKey := leftBucket.keys[i]
 						// 如果left节点的high_key < entryKey 则迁移到当前节点
 						if leftHighKey < entryKey {
-							lh.Buckets[loc].fingerprints[i] = leftBucket.fingerprints[i]
-							lh.Buckets[loc].entries[i] = leftBucket.entries[i]
-							leftBucket.fingerprints[i] = EmptyFingerprint
+							leftBucket.moveSlot(i, bucket, i, leftBucket.fingerprints[i])
 						}
 					}
 				}
 				// 更新状态
-				lh.Buckets[loc].state = STABLE
+				bucket.state = STABLE
 				leftBucket.state = STABLE
 				leftBucket.Unlock()
-				lh.Buckets[loc].Unlock()
+				bucket.Unlock()
 			} else {
 				fmt.Printf("[StabilizeAll]: something wrong!\n")
-				fmt.Printf("\t current bucket state: %v, \t left bucket state: %v\n", lh.Buckets[loc].state, leftBucket.state)
+				fmt.Printf("\t current bucket state: %v, \t left bucket state: %v\n", bucket.state, leftBucket.state)
 				leftBucket.Unlock()
-				lh.Buckets[loc].Unlock()
+				bucket.Unlock()
 				return false
 			}
 
@@ -894,26 +1023,26 @@ func (lh *LNodeHash) StabilizeAll(version uint64) bool {
 			right, ok := lh.siblingPtr.(*LNodeHash)
 			if !ok {
 				fmt.Println("StabilizeAll: right sibling is not LNodeHash")
-				lh.Buckets[loc].Unlock()
+				bucket.Unlock()
 				return false
 			}
 
 			rightVStart, needRestart := right.GetVersion()
 			if needRestart {
-				lh.Buckets[loc].Unlock()
+				bucket.Unlock()
 				return false
 			}
 
-			rightBucket := &right.Buckets[loc]
+			rightBucket := &right.bucketArray()[loc]
 			if !rightBucket.TryLock() {
-				lh.Buckets[loc].Unlock()
+				bucket.Unlock()
 				return false
 			}
 
 			rightVEnd, needRestart := right.GetVersion()
 			if needRestart || (rightVStart != rightVEnd) {
 				rightBucket.Unlock()
-				lh.Buckets[loc].Unlock()
+				bucket.Unlock()
 				return false
 			}
 
@@ -923,41 +1052,35 @@ func (lh *LNodeHash) StabilizeAll(version uint64) bool {
 				if !ok1 || !ok2 {
 					fmt.Println("StabilizeAll: type assertion for high keys failed")
 					rightBucket.Unlock()
-					lh.Buckets[loc].Unlock()
+					bucket.Unlock()
 					return false
 				}
 
-				for i := 0; i < len(lh.Buckets[loc].entries); i++ {
-					if lh.Buckets[loc].fingerprints[i] != EmptyFingerprint {
-						entryKey, ok3 := lh.Buckets[loc].entries[i].Key.(int)
-						if !ok3 {
-							fmt.Println("StabilizeAll: type assertion for entry key failed")
-							continue
-						}
+				for i := 0; i < bucket.slots(); i++ {
+					if bucket.fingerprints[i] != EmptyFingerprint {
+						entryKey := bucket.keys[i]
 						if currentHighKey < entryKey {
-							rightBucket.fingerprints[i] = lh.Buckets[loc].fingerprints[i]
-							rightBucket.entries[i] = lh.Buckets[loc].entries[i]
-							lh.Buckets[loc].fingerprints[i] = EmptyFingerprint
+							bucket.moveSlot(i, rightBucket, i, bucket.fingerprints[i])
 						}
 					}
 				}
 
-				lh.Buckets[loc].state = STABLE
+				bucket.state = STABLE
 				rightBucket.state = STABLE
 				rightBucket.Unlock()
-				lh.Buckets[loc].Unlock()
+				bucket.Unlock()
 			} else {
 				fmt.Printf("[StabilizeAll]: something wrong!\n")
-				fmt.Printf("\t current bucket state: %v, \t right bucket state: %v\n", lh.Buckets[loc].state, rightBucket.state)
+				fmt.Printf("\t current bucket state: %v, \t right bucket state: %v\n", bucket.state, rightBucket.state)
 				rightBucket.Unlock()
-				lh.Buckets[loc].Unlock()
+				bucket.Unlock()
 				return false
 			}
 
 		default:
 			// 未知状态
-			fmt.Printf("[StabilizeAll]: unknown bucket state: %v\n", lh.Buckets[loc].state)
-			lh.Buckets[loc].Unlock()
+			fmt.Printf("[StabilizeAll]: unknown bucket state: %v\n", bucket.state)
+			bucket.Unlock()
 			return false
 		}
 	}
@@ -978,7 +1101,8 @@ func (lh *LNodeHash) StabilizeBucket(loc int) bool {
 	}
 
 	// 检查当前桶的状态
-	switch lh.Buckets[loc].state {
+	bucket := &lh.bucketArray()[loc]
+	switch bucket.state {
 	case LINKED_LEFT:
 		// 处理 LINKED_LEFT 状态，尝试从左兄弟桶迁移数据
 		left, ok := lh.LeftSiblingPtr.(*LNodeHash)
@@ -992,7 +1116,7 @@ func (lh *LNodeHash) StabilizeBucket(loc int) bool {
 			return false
 		}
 
-		leftBucket := &left.Buckets[loc]
+		leftBucket := &left.bucketArray()[loc]
 		if !leftBucket.TryLock() {
 			return false
 		}
@@ -1005,30 +1129,28 @@ func (lh *LNodeHash) StabilizeBucket(loc int) bool {
 
 		if leftBucket.state == LINKED_RIGHT {
 			// 迁移数据
-			for i := 0; i < len(leftBucket.entries); i++ {
+			for i := 0; i < leftBucket.slots(); i++ {
 				if leftBucket.fingerprints[i] != EmptyFingerprint {
 					currentHighKey, ok1 := lh.HighKey.(int)
 					_, ok2 := left.HighKey.(int)
-					entryKey, ok3 := leftBucket.entries[i].Key.(int)
-					if !ok1 || !ok2 || !ok3 {
+					entryKey := leftBucket.keys[i]
+					if !ok1 || !ok2 {
 						fmt.Println("StabilizeBucket: type assertion failed")
 						continue
 					}
 					if currentHighKey < entryKey {
-						lh.Buckets[loc].fingerprints[i] = leftBucket.fingerprints[i]
-						lh.Buckets[loc].entries[i] = leftBucket.entries[i]
-						leftBucket.fingerprints[i] = EmptyFingerprint
+						leftBucket.moveSlot(i, bucket, i, leftBucket.fingerprints[i])
 					}
 				}
 			}
 
 			// 更新状态
-			lh.Buckets[loc].state = STABLE
+			bucket.state = STABLE
 			leftBucket.state = STABLE
 			leftBucket.Unlock()
 		} else {
 			fmt.Printf("[StabilizeBucket]: something wrong!\n")
-			fmt.Printf("\t current bucket state: %v, \t left bucket state: %v\n", lh.Buckets[loc].state, leftBucket.state)
+			fmt.Printf("\t current bucket state: %v, \t left bucket state: %v\n", bucket.state, leftBucket.state)
 			leftBucket.Unlock()
 			return false
 		}
@@ -1046,7 +1168,7 @@ func (lh *LNodeHash) StabilizeBucket(loc int) bool {
 			return false
 		}
 
-		rightBucket := &right.Buckets[loc]
+		rightBucket := &right.bucketArray()[loc]
 		if !rightBucket.TryLock() {
 			return false
 		}
@@ -1059,37 +1181,35 @@ func (lh *LNodeHash) StabilizeBucket(loc int) bool {
 
 		if rightBucket.state == LINKED_LEFT {
 			// 迁移数据
-			for i := 0; i < len(lh.Buckets[loc].entries); i++ {
-				if lh.Buckets[loc].fingerprints[i] != 0 {
+			for i := 0; i < bucket.slots(); i++ {
+				if bucket.fingerprints[i] != 0 {
 					currentHighKey, ok1 := lh.HighKey.(int)
-					entryKey, ok2 := lh.Buckets[loc].entries[i].Key.(int)
+					entryKey := bucket.keys[i]
 					_, ok3 := right.HighKey.(int)
-					if !ok1 || !ok2 || !ok3 {
+					if !ok1 || !ok3 {
 						fmt.Println("StabilizeBucket: type assertion failed")
 						continue
 					}
 					if currentHighKey < entryKey {
-						rightBucket.fingerprints[i] = lh.Buckets[loc].fingerprints[i]
-						rightBucket.entries[i] = lh.Buckets[loc].entries[i]
-						lh.Buckets[loc].fingerprints[i] = 0
+						bucket.moveSlot(i, rightBucket, i, bucket.fingerprints[i])
 					}
 				}
 			}
 
 			// 更新状态
-			lh.Buckets[loc].state = STABLE
+			bucket.state = STABLE
 			rightBucket.state = STABLE
 			rightBucket.Unlock()
 		} else {
 			fmt.Printf("[StabilizeBucket]: something wrong!\n")
-			fmt.Printf("\t current bucket state: %v, \t right bucket state: %v\n", lh.Buckets[loc].state, rightBucket.state)
+			fmt.Printf("\t current bucket state: %v, \t right bucket state: %v\n", bucket.state, rightBucket.state)
 			rightBucket.Unlock()
 			return false
 		}
 
 	default:
 		// 不需要处理的状态
-		fmt.Printf("[StabilizeBucket]: unknown bucket state: %v\n", lh.Buckets[loc].state)
+		fmt.Printf("[StabilizeBucket]: unknown bucket state: %v\n", bucket.state)
 		return false
 	}
 
@@ -1170,7 +1290,7 @@ func (lh *LNodeHash) medianUtil(keys []interface{}, left, right, k int, a, b *in
 // Convert 将当前哈希节点转换为 B-tree 节点集合
 func (lh *LNodeHash) Convert(version uint64) ([]*LNodeBTree, int, error) {
 	buf := make([]Entry, 0, lh.Cardinality*EntryNum)
-	key := 0
+	key := math.MinInt // 收集全部条目，C++ 中键是无符号数，从 0 开始收集会丢掉负的键
 	// 如果启用了 LINKED，进行稳定化
 	if LINKED {
 		if !lh.StabilizeAll(version) {
@@ -1193,15 +1313,24 @@ func (lh *LNodeHash) Convert(version uint64) ([]*LNodeBTree, int, error) {
 	}
 
 	// 收集所有桶中的条目
-	for i := 0; i < lh.Cardinality; i++ {
+	buckets := lh.bucketArray()
+	for i := range buckets {
 		var collected []Entry
 		if FINGERPRINT {
-			collected = lh.Buckets[i].CollectWithFingerprint(key, EmptyFingerprint)
+			collected = buckets[i].CollectWithFingerprint(key, EmptyFingerprint)
 		} else {
-			collected = lh.Buckets[i].Collect(key)
+			collected = buckets[i].Collect(key)
 		}
 		buf = append(buf, collected...)
 	}
+	if FINGERPRINT {
+		buf = append(buf, lh.stash.CollectWithFingerprint(key, EmptyFingerprint)...)
+	} else {
+		buf = append(buf, lh.stash.Collect(key)...)
+	}
+	if lh.expires() {
+		buf = dropExpired(buf) // 转换时顺便删除过期的条目
+	}
 	idx := len(buf)
 
 	// 按键排序条目
@@ -1209,10 +1338,11 @@ func (lh *LNodeHash) Convert(version uint64) ([]*LNodeBTree, int, error) {
 		return compareIntKeys(buf[i].Key, buf[j].Key) < 0
 	})
 	FillSize := int(FillFactor * float64(lh.Cardinality))
-	// 确定批次大小和叶节点数量
-	batchSize := FillSize // 根据实际情况定义 FillSize
+	// 确定批次大小和叶节点数量，转换出的叶子按批次大小一次分配数组，扩容过的叶子转换时每个叶子不超过 LeafBTreeSize 个条目
+	batchSize := minInt(FillSize, LeafBTreeSize)
 	num := idx / batchSize
-	if idx%batchSize != 0 {
+	if idx%batchSize != 0 || num == 0 {
+		// 空叶子转换为一个空的 B 树叶子，WriteBatch 需要能锁住它
 		num += 1
 	}
 
@@ -1220,6 +1350,7 @@ func (lh *LNodeHash) Convert(version uint64) ([]*LNodeBTree, int, error) {
 	leaves := make([]*LNodeBTree, num)
 	for i := 0; i < num; i++ {
 		leaves[i] = NewLNodeBTree(lh.level)
+		leaves[i].opts = lh.opts
 	}
 
 	// 将条目插入到叶节点并设置兄弟指针
@@ -1259,9 +1390,7 @@ func (lh *LNodeHash) Convert(version uint64) ([]*LNodeBTree, int, error) {
 	// 更新右兄弟节点的左兄弟指针
 	right := lh.siblingPtr
 	if right != nil {
-		if rightHash, ok := right.(*LNodeHash); ok && rightHash.Type == HashNode {
-			rightHash.LeftSiblingPtr = leaves[num-1]
-		}
+		linkLeft(right, leaves[num-1])
 	}
 
 	return leaves, num, nil
@@ -1289,7 +1418,18 @@ func (lh *LNodeHash) Footprint(metrics *FootprintMetrics) {
 	// 示例：
 	metrics.StructuralDataOccupied += uint64(unsafe.Sizeof(*lh))
 	// 根据需求调整
-	for i := 0; i < lh.Cardinality; i++ {
-		lh.Buckets[i].Footprint(metrics)
+	buckets := lh.bucketArray()
+	for i := range buckets {
+		buckets[i].Footprint(metrics)
+	}
+	lh.stash.Footprint(metrics)
+	metrics.HashLeaves++
+	metrics.HashBuckets += uint64(lh.Cardinality)
+	metrics.HashSlots += uint64(lh.Cardinality*EntryNum + lh.StashCapacity())
+	stashed := lh.StashCount()
+	metrics.StashOccupied += uint64(stashed)
+	metrics.StashCapacity += uint64(lh.StashCapacity())
+	if stashed > 0 {
+		metrics.StashedLeaves++
 	}
 }
@@ -0,0 +1,166 @@
+package blinkhash
+
+import (
+	"sync/atomic"
+)
+
+const (
+	defaultCuckooMaxDepth  = 3
+	defaultCuckooMaxSearch = 128
+)
+
+// cuckooMaxDepth 返回迁移路径的最大长度，0 表示关闭迁移，见 TreeOptions.CuckooMaxDepth
+func (o *TreeOptions) cuckooMaxDepth() int {
+	if o.CuckooMaxDepth < 0 {
+		return 0
+	}
+	if o.CuckooMaxDepth == 0 {
+		return defaultCuckooMaxDepth
+	}
+	return o.CuckooMaxDepth
+}
+
+// cuckooMaxSearch 返回单次路径搜索最多访问的桶数，见 TreeOptions.CuckooMaxSearch
+func (o *TreeOptions) cuckooMaxSearch() int {
+	if o.CuckooMaxSearch <= 0 {
+		return defaultCuckooMaxSearch
+	}
+	return o.CuckooMaxSearch
+}
+
+// cuckooStep 迁移路径上的一步：把 bucket 中 slot 位置的条目移动到 next 桶
+type cuckooStep struct {
+	bucket int
+	parent int // 上一步在搜索队列中的下标，-1 表示 key 自身的候选桶
+	slot   int // 父桶中被移入当前桶的槽位
+	key    int
+	k      int // 被移动条目在当前桶所使用的哈希函数，用于重新计算指纹
+	depth  int
+}
+
+// displace 在 key 的候选桶都已满时，尝试沿一条不超过 TreeOptions.CuckooMaxDepth 的路径
+// 把已有条目移动到它们的其他候选桶，为 key 腾出一个槽位。
+//
+// 路径搜索不加锁(BFS)，找到后从路径末端开始逐步迁移，每一步只锁定源桶和目标桶，
+// 并重新校验节点版本和条目位置；任何一步失败都返回 NeedRestart，由上层重试。
+// 找不到路径时返回 NeedSplit。
+func (lh *LNodeHash) displace(key interface{}, version uint64) int {
+	buckets := lh.bucketArray() // 扩容会替换桶数组，使用快照计算下标
+	maxDepth, maxSearch := lh.options().cuckooMaxDepth(), lh.options().cuckooMaxSearch()
+	queue := make([]cuckooStep, 0, maxSearch)
+	visited := make(map[int]struct{}, maxSearch)
+	for k := 0; k < HashFuncsNum; k++ {
+		hashKey := lh.hashKey(key, k)
+		for j := 0; j < NumSlot; j++ {
+			loc := int((hashKey + uint64(j)) % uint64(len(buckets)))
+			if _, ok := visited[loc]; ok {
+				continue
+			}
+			visited[loc] = struct{}{}
+			queue = append(queue, cuckooStep{bucket: loc, parent: -1, k: k})
+		}
+	}
+
+	for head := 0; head < len(queue); head++ {
+		cur := queue[head]
+		if cur.depth >= maxDepth {
+			continue
+		}
+		bucket := &buckets[cur.bucket]
+		for slot := 0; slot < EntryNum; slot++ {
+			if bucketSlotEmpty(bucket, slot) {
+				continue
+			}
+			entryKey := bucket.keys[slot]
+			for k := 0; k < HashFuncsNum; k++ {
+				hashKey := lh.hashKey(entryKey, k)
+				for j := 0; j < NumSlot; j++ {
+					loc := int((hashKey + uint64(j)) % uint64(len(buckets)))
+					if _, ok := visited[loc]; ok {
+						continue
+					}
+					next := cuckooStep{bucket: loc, parent: head, slot: slot, key: entryKey, k: k, depth: cur.depth + 1}
+					if bucketFreeSlot(&buckets[loc]) >= 0 {
+						return lh.applyDisplacement(buckets, queue, next, version)
+					}
+					if len(queue) < maxSearch {
+						visited[loc] = struct{}{}
+						queue = append(queue, next)
+					}
+				}
+			}
+		}
+	}
+	return NeedSplit
+}
+
+// applyDisplacement 从路径末端向起点依次迁移条目，最终在 key 的某个候选桶中留出空位。
+//
+// moves 是只允许一个写者的顺序锁：两条路径同时迁移时计数可能在两者都未完成时回到偶数，
+// 读者会误以为没有迁移发生。因此整条路径在叶子的迁移锁内执行，拿不到锁时由上层重试
+func (lh *LNodeHash) applyDisplacement(buckets []Bucket, queue []cuckooStep, last cuckooStep, version uint64) int {
+	if !atomic.CompareAndSwapUint32(&lh.mover, 0, 1) {
+		return NeedRestart
+	}
+	defer atomic.StoreUint32(&lh.mover, 0)
+	for step := last; step.parent >= 0; step = queue[step.parent] {
+		src := queue[step.parent].bucket
+		if !lh.moveEntry(buckets, src, step.slot, step.key, step.bucket, step.k, version) {
+			return NeedRestart
+		}
+	}
+	return InsertSuccess
+}
+
+// moveEntry 在锁定源桶和目标桶后，把 src 桶 slot 处的 key 移动到 dst 桶的空槽位。
+// buckets 是搜索路径时的桶数组快照，扩容后旧数组的桶保持锁定，迁移会失败重启。
+// 调用方持有迁移锁，迁移期间 moves 计数为奇数，无锁的 Find 据此判断是否需要重读。
+func (lh *LNodeHash) moveEntry(buckets []Bucket, src, slot, key, dst, k int, version uint64) bool {
+	if !buckets[src].TryLock() {
+		return false
+	}
+	defer buckets[src].Unlock()
+	if !buckets[dst].TryLock() {
+		return false
+	}
+	defer buckets[dst].Unlock()
+
+	currentVersion, needRestart := lh.GetVersion()
+	if needRestart || version != currentVersion {
+		return false
+	}
+	if LINKED && (buckets[src].state != STABLE || buckets[dst].state != STABLE) {
+		return false
+	}
+
+	from := &buckets[src]
+	if bucketSlotEmpty(from, slot) || from.keys[slot] != key {
+		// 搜索路径之后该槽位已被修改
+		return false
+	}
+	to := &buckets[dst]
+	free := bucketFreeSlot(to)
+	if free < 0 {
+		return false
+	}
+
+	atomic.AddUint64(&lh.moves, 1)
+	// 先写入目标桶再清空源桶，迁移过程中条目至少在一个桶中可见
+	fingerprint := uint8(occupiedFingerprint)
+	if FINGERPRINT {
+		fingerprint = lh.Hash(lh.hashKey(key, k)) | 1
+	}
+	from.moveSlot(slot, to, free, fingerprint)
+	atomic.AddUint64(&lh.moves, 1)
+	return true
+}
+
+// bucketSlotEmpty 判断桶中的槽位是否为空，与 Insert 使用相同的判定方式
+func bucketSlotEmpty(b *Bucket, slot int) bool {
+	return !b.occupied(slot)
+}
+
+// bucketFreeSlot 返回桶中第一个空槽位，没有空槽位时返回 -1
+func bucketFreeSlot(b *Bucket) int {
+	return b.matchSlot(EmptyFingerprint)
+}
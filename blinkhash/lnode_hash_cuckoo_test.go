@@ -0,0 +1,179 @@
+package blinkhash
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// newSmallLNodeHash 创建一个只有 cardinality 个桶的哈希叶子，便于快速填满。opts 为 nil 时使用内置配置
+func newSmallLNodeHash(cardinality int, opts *TreeOptions) *LNodeHash {
+	return newLNodeHashWithCardinality(nil, 0, 0, cardinality, opts)
+}
+
+// fillUntilSplit 向叶子插入随机键直到返回 NeedSplit，返回成功插入的键
+func fillUntilSplit(t *testing.T, lh *LNodeHash) []int {
+	t.Helper()
+	r := rand.New(rand.NewSource(7))
+	var keys []int
+	for {
+		key := r.Intn(1 << 30)
+		ret := lh.Insert(key, key, 0)
+		if ret == NeedSplit {
+			return keys
+		}
+		if ret != InsertSuccess {
+			t.Fatalf("Unexpected insert result %d", ret)
+		}
+		keys = append(keys, key)
+	}
+}
+
+func TestLNodeHash_CuckooLoadFactor(t *testing.T) {
+	// 不使用溢出区，只衡量迁移本身的效果
+	plain := newSmallLNodeHash(64, &TreeOptions{CuckooMaxDepth: -1, HashStashSize: -1})
+	plainKeys := fillUntilSplit(t, plain)
+
+	cuckoo := newSmallLNodeHash(64, &TreeOptions{CuckooMaxDepth: 3, HashStashSize: -1})
+	cuckooKeys := fillUntilSplit(t, cuckoo)
+
+	plainUtil, cuckooUtil := plain.Utilization(), cuckoo.Utilization()
+	if cuckooUtil <= plainUtil || cuckooUtil < 0.9 {
+		t.Errorf("Expected displacement to raise load factor, got %.2f without and %.2f with", plainUtil, cuckooUtil)
+	}
+	if int(cuckoo.GetCount()) != len(cuckooKeys) {
+		t.Errorf("Expected count %d, got %d", len(cuckooKeys), cuckoo.GetCount())
+	}
+	// 迁移后的条目仍能通过 Find/Update/Remove 定位
+	for _, key := range cuckooKeys {
+		if val, found := cuckoo.Find(key); !found || val != key {
+			t.Fatalf("Expected to find key %d after displacement, got %v %v", key, val, found)
+		}
+	}
+	if ret := cuckoo.Update(cuckooKeys[0], -1, 0); ret != UpdateSuccess {
+		t.Errorf("Expected update to succeed, got %d", ret)
+	}
+	if ret := cuckoo.Remove(cuckooKeys[1], 0); ret != 0 {
+		t.Errorf("Expected remove to succeed, got %d", ret)
+	}
+	if _, found := cuckoo.Find(cuckooKeys[1]); found {
+		t.Errorf("Expected key %d to be removed", cuckooKeys[1])
+	}
+	t.Logf("load factor: %.3f (%d keys) without displacement, %.3f (%d keys) with", plainUtil, len(plainKeys), cuckooUtil, len(cuckooKeys))
+}
+
+// 迁移路径持有叶子的迁移锁，另一条路径正在迁移时返回 NeedRestart，moves 只有一个写者
+func TestLNodeHash_CuckooMoverLock(t *testing.T) {
+	lh := newSmallLNodeHash(64, nil)
+	for key := 1; key < 64*EntryNum; key += 2 {
+		lh.Insert(key, key, 0)
+	}
+	lh.mover = 1
+	if ret := lh.displace(2, 0); ret != NeedRestart {
+		t.Fatalf("Expected displacement to wait for the other mover, got %d", ret)
+	}
+	if lh.moves != 0 {
+		t.Fatalf("Expected no moves while another mover holds the lock, got %d", lh.moves)
+	}
+	lh.mover = 0
+	if ret := lh.displace(2, 0); ret != InsertSuccess {
+		t.Fatalf("Expected displacement to succeed, got %d", ret)
+	}
+	if lh.mover != 0 || lh.moves == 0 || lh.moves&1 != 0 {
+		t.Errorf("Expected the mover lock released and an even move count, got %d and %d", lh.mover, lh.moves)
+	}
+}
+
+// 多个写者同时在一个叶子中迁移条目，读者查找已有的键不会误报不存在
+func TestLNodeHash_CuckooConcurrentMovers(t *testing.T) {
+	opts := &TreeOptions{HashStashSize: -1} // 候选桶满时只能迁移
+
+	for round := 0; round < 5; round++ {
+		lh := newSmallLNodeHash(64, opts)
+		r := rand.New(rand.NewSource(int64(round)))
+		var resident []int
+		for len(resident) < 64*EntryNum/2 {
+			key := r.Intn(1<<30)<<1 | 1
+			if lh.Insert(key, key, 0) == InsertSuccess {
+				resident = append(resident, key)
+			}
+		}
+
+		var done int32
+		var writers, readers sync.WaitGroup
+		errs := make(chan string, 4)
+		for w := 0; w < 4; w++ {
+			writers.Add(1)
+			go func(w int) {
+				defer writers.Done()
+				r := rand.New(rand.NewSource(int64(round*8 + w)))
+				for {
+					key := r.Intn(1<<30) << 1 // 偶数键不与已有的键重复
+					version, needRestart := lh.TryReadLock()
+					if needRestart {
+						continue
+					}
+					if lh.Insert(key, key, version) == NeedSplit {
+						return
+					}
+				}
+			}(w)
+		}
+		for rd := 0; rd < 4; rd++ {
+			readers.Add(1)
+			go func(rd int) {
+				defer readers.Done()
+				for i := rd; atomic.LoadInt32(&done) == 0; i = (i + 7) % len(resident) {
+					if val, found := lh.Find(resident[i]); !found || val != resident[i] {
+						errs <- fmt.Sprintf("key %d missing while entries were being displaced", resident[i])
+						return
+					}
+				}
+			}(rd)
+		}
+		writers.Wait()
+		atomic.StoreInt32(&done, 1)
+		readers.Wait()
+		close(errs)
+		for err := range errs {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBTree_CuckooConcurrentInsertLookup(t *testing.T) {
+	tree := NewBTree()
+	numThreads, perThread := 8, 20000
+
+	var wg sync.WaitGroup
+	for tid := 0; tid < numThreads; tid++ {
+		wg.Add(1)
+		go func(tid int) {
+			defer wg.Done()
+			ti := NewThreadInfo(tree.GetEpoche())
+			r := rand.New(rand.NewSource(int64(tid)))
+			for i := 0; i < perThread; i++ {
+				key := r.Intn(1<<24)<<4 | tid<<1 | 1
+				tree.Insert(key, key, ti)
+				if got := tree.Lookup(key, ti); got != key {
+					t.Errorf("Expected to find key %d right after insert, got %v", key, got)
+					return
+				}
+			}
+		}(tid)
+	}
+	wg.Wait()
+
+	ti := NewThreadInfo(tree.GetEpoche())
+	for tid := 0; tid < numThreads; tid++ {
+		r := rand.New(rand.NewSource(int64(tid)))
+		for i := 0; i < perThread; i++ {
+			key := r.Intn(1<<24)<<4 | tid<<1 | 1
+			if got := tree.Lookup(key, ti); got != key {
+				t.Fatalf("Expected to find key %d, got %v", key, got)
+			}
+		}
+	}
+}
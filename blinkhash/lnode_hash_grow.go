@@ -0,0 +1,98 @@
+package blinkhash
+
+// defaultHashInitialCardinality 新建哈希叶子默认的初始桶数。
+// 叶子从较小的桶数组开始，写满后在分裂锁内翻倍扩容，
+// 直到 TreeOptions.HashMaxCardinality 才真正分裂。
+const defaultHashInitialCardinality = 64
+
+// hashMaxCardinality 返回哈希叶子扩容的上限，见 TreeOptions.HashMaxCardinality
+func (o *TreeOptions) hashMaxCardinality() int {
+	if o.HashMaxCardinality <= 0 {
+		return LNodeHashCardinality
+	}
+	return o.HashMaxCardinality
+}
+
+// hashInitialCardinality 返回合法的初始桶数，见 TreeOptions.HashInitialCardinality
+func (o *TreeOptions) hashInitialCardinality() int {
+	cardinality := o.HashInitialCardinality
+	if cardinality <= 0 {
+		cardinality = defaultHashInitialCardinality
+	}
+	if max := o.hashMaxCardinality(); cardinality > max {
+		cardinality = max
+	}
+	return cardinality
+}
+
+// canGrow 判断叶子是否还能扩容
+func (lh *LNodeHash) canGrow() bool {
+	return lh.Cardinality < lh.options().hashMaxCardinality()
+}
+
+// growLocked 把桶数组扩大一倍(不超过 TreeOptions.HashMaxCardinality)并重新散列全部条目，
+// 调用方必须持有分裂锁。
+//
+// 新数组构建完成后才通过原子指针发布，无锁的读者看到的要么是旧数组，要么是完整的新数组。
+// 旧数组的桶保持锁定：仍在旧数组上操作的
+// 线程会因拿不到桶锁或节点版本变化而重启。放不进新数组的条目进入溢出区，
+// 溢出区也放不下时放弃扩容并返回 false，叶子保持不变。
+func (lh *LNodeHash) growLocked() bool {
+	cardinality := lh.Cardinality * 2
+	if max := lh.options().hashMaxCardinality(); cardinality > max {
+		cardinality = max
+	}
+	grown := newBucketArray(cardinality)
+	buckets := *grown
+
+	var overflow []Entry
+	place := func(entry Entry) {
+		for k := 0; k < HashFuncsNum; k++ {
+			hashKey := lh.hashKey(entry.Key, k)
+			for j := 0; j < NumSlot; j++ {
+				loc := (hashKey + uint64(j)) % uint64(cardinality)
+				var placed bool
+				if FINGERPRINT {
+					placed = buckets[loc].InsertWithFingerprint(entry.Key, entry.Value, lh.Hash(hashKey)|1, EmptyFingerprint)
+				} else {
+					placed = buckets[loc].Insert(entry.Key, entry.Value)
+				}
+				if placed {
+					return
+				}
+			}
+		}
+		overflow = append(overflow, entry)
+	}
+	old := lh.bucketArray()
+	for i := range old {
+		for slot := 0; slot < old[i].slots(); slot++ {
+			if !bucketSlotEmpty(&old[i], slot) {
+				place(old[i].entry(slot))
+			}
+		}
+	}
+	for slot := 0; slot < lh.stash.slots(); slot++ {
+		if !bucketSlotEmpty(&lh.stash, slot) {
+			place(lh.stash.entry(slot))
+		}
+	}
+	if len(overflow) > lh.StashCapacity() {
+		return false
+	}
+
+	// 溢出区原地重建，保留其锁字，读者能通过版本号发现变化
+	for slot := 0; slot < lh.stash.slots(); slot++ {
+		lh.stash.clearSlot(slot)
+	}
+	for _, entry := range overflow {
+		if FINGERPRINT {
+			lh.stash.InsertWithFingerprint(entry.Key, entry.Value, lh.stashFingerprint(entry.Key), EmptyFingerprint)
+		} else {
+			lh.stash.Insert(entry.Key, entry.Value)
+		}
+	}
+	lh.buckets.Store(grown)
+	lh.Cardinality = cardinality
+	return true
+}
@@ -0,0 +1,221 @@
+package blinkhash
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func hashFootprint(tree *BTree) FootprintMetrics {
+	var metrics FootprintMetrics
+	tree.Footprint(&metrics)
+	return metrics
+}
+
+func TestLNodeHash_GrowBeforeSplit(t *testing.T) {
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+
+	initial := DefaultTreeOptions.hashInitialCardinality()
+	metrics := hashFootprint(tree)
+	if metrics.HashBuckets != uint64(initial) {
+		t.Fatalf("Expected a new leaf to start with %d buckets, got %d", initial, metrics.HashBuckets)
+	}
+
+	// 插入量按最大桶数计算：叶子的容量随桶的内存布局变化
+	n := DefaultTreeOptions.hashMaxCardinality() * EntryNum / 4
+	for k := 1; k <= n; k++ {
+		tree.Insert(k*7919, k, ti)
+	}
+	// 叶子扩容而不是分裂，树中仍然只有一个叶子
+	if tree.root.GetLevel() != 0 {
+		t.Errorf("Expected the root leaf to grow instead of splitting, height %d", tree.GetHeight())
+	}
+	metrics = hashFootprint(tree)
+	if metrics.HashLeaves != 1 || metrics.HashSlots < uint64(n) {
+		t.Errorf("Expected one leaf with at least %d slots, got %+v", n, metrics)
+	}
+	if metrics.HashBuckets&(metrics.HashBuckets-1) != 0 {
+		t.Errorf("Expected bucket count to double from %d, got %d", initial, metrics.HashBuckets)
+	}
+	for k := 1; k <= n; k++ {
+		if got := tree.Lookup(k*7919, ti); got != k {
+			t.Fatalf("Expected to find key %d after growing, got %v", k*7919, got)
+		}
+	}
+}
+
+func TestLNodeHash_GrowUpToMaxThenSplit(t *testing.T) {
+	tree := NewBTreeWithOptions(TreeOptions{HashMaxCardinality: 256})
+	ti := NewThreadInfo(tree.GetEpoche())
+	n := 30000
+	for k := 1; k <= n; k++ {
+		tree.Insert(k*7919, k, ti)
+	}
+	if tree.root.GetLevel() == 0 {
+		t.Fatalf("Expected leaves to split after reaching max cardinality")
+	}
+
+	metrics := hashFootprint(tree)
+	if metrics.HashBuckets > metrics.HashLeaves*256 {
+		t.Errorf("Expected at most 256 buckets per leaf, got %d buckets in %d leaves", metrics.HashBuckets, metrics.HashLeaves)
+	}
+	for k := 1; k <= n; k++ {
+		if got := tree.Lookup(k*7919, ti); got != k {
+			t.Fatalf("Expected to find key %d, got %v", k*7919, got)
+		}
+	}
+}
+
+func TestLNodeHash_GrowKeepsStash(t *testing.T) {
+	lh, keys := fillStashedLeaf(t, &TreeOptions{CuckooMaxDepth: -1, HashStashSize: 8})
+	if !lh.TrySplitLock(0) {
+		t.Fatal("Expected to acquire split lock")
+	}
+	if !lh.growLocked() {
+		t.Fatal("Expected growth to succeed")
+	}
+	lh.WriteUnlock()
+
+	if lh.Cardinality != 32 || len(lh.bucketArray()) != 32 {
+		t.Errorf("Expected 32 buckets after growing, got %d", lh.Cardinality)
+	}
+	for _, key := range keys {
+		if val, found := lh.Find(key); !found || val != key {
+			t.Fatalf("Expected to find key %d after rehash, got %v %v", key, val, found)
+		}
+	}
+	if got := int(lh.GetCount()); got != len(keys) {
+		t.Errorf("Expected count %d after rehash, got %d", len(keys), got)
+	}
+}
+
+// 扩容后的根叶子转换出的 B 树叶子超过一个内部节点的容量，需要建立多层内部节点
+// 无锁的查找与扩容并发进行，读者看到的总是完整的旧桶数组或新桶数组
+func TestLNodeHash_GrowConcurrentLookup(t *testing.T) {
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	const resident = 200
+	for k := 0; k < resident; k++ {
+		tree.Insert(k, k, ti)
+	}
+	var done int32
+	var wg sync.WaitGroup
+	errs := make(chan string, 4)
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			ti := NewThreadInfo(tree.GetEpoche())
+			for k := r; atomic.LoadInt32(&done) == 0; k = (k + 13) % resident {
+				if got := tree.Lookup(k, ti); got != k {
+					errs <- fmt.Sprintf("key %d: expected %d while leaves grow, got %v", k, k, got)
+					return
+				}
+			}
+		}(r)
+	}
+	// 随机的键让根叶子一直扩容到上限
+	rnd := rand.New(rand.NewSource(3))
+	for i := 0; i < 50000; i++ {
+		k := resident + rnd.Intn(1<<30)
+		tree.Insert(k, k, ti)
+	}
+	atomic.StoreInt32(&done, 1)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if metrics := hashFootprint(tree); metrics.HashBuckets <= uint64(DefaultTreeOptions.hashInitialCardinality()) {
+		t.Errorf("Expected the leaves to grow, got %d buckets", metrics.HashBuckets)
+	}
+}
+
+func TestLNodeHash_ConvertGrownRoot(t *testing.T) {
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	n := 5000
+	for k := 1; k <= n; k++ {
+		tree.Insert(k, k, ti)
+	}
+	if tree.root.GetLevel() != 0 {
+		t.Skipf("Expected a grown root leaf, got height %d", tree.height())
+	}
+	if got := len(tree.RangeLookup(1, n, ti)); got != n {
+		t.Fatalf("Expected %d entries from range lookup, got %d", n, got)
+	}
+	for cur := tree.root; cur.GetLevel() > 0; cur = cur.GetLeftmostPtr() {
+		for node := cur; node != nil; node = node.GetSiblingPtr() {
+			in := node.(*INode)
+			if int(in.count) > in.Cardinality {
+				t.Fatalf("Level %d node holds %d entries, cardinality is %d", in.level, in.count, in.Cardinality)
+			}
+		}
+	}
+	for k := 1; k <= n; k++ {
+		tree.Insert(n+k, n+k, ti)
+	}
+	for k := 1; k <= 2*n; k++ {
+		if got := tree.Lookup(k, ti); got != k {
+			t.Fatalf("key %d: expected %d, got %v", k, k, got)
+		}
+	}
+}
+
+// 非根的扩容叶子转换出的 B 树叶子超过父节点剩余容量，父节点需要分裂出多个兄弟
+func TestLNodeHash_ConvertGrownLeaf(t *testing.T) {
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	n := 100000
+	for k := 1; k <= n; k++ {
+		tree.Insert(k, k, ti)
+	}
+	if got := len(tree.RangeLookup(1, n, ti)); got != n {
+		t.Fatalf("Expected %d entries from range lookup, got %d", n, got)
+	}
+	for k := 1; k <= n; k++ {
+		if got := tree.Lookup(k, ti); got != k {
+			t.Fatalf("key %d: expected %d, got %v", k, k, got)
+		}
+	}
+}
+
+// 转换时收集全部条目，负的键不能丢
+func TestLNodeHash_ConvertNegativeKeys(t *testing.T) {
+	lh := newSmallLNodeHash(64, nil)
+	for k := -20; k < 20; k++ {
+		if ret := lh.Insert(k, k, 0); ret != InsertSuccess {
+			t.Fatalf("Unexpected insert result %d for key %d", ret, k)
+		}
+	}
+	leaves, num, err := lh.Convert(0)
+	if err != nil {
+		t.Fatalf("Unexpected convert error: %v", err)
+	}
+	var converted []Entry
+	for i := 0; i < num; i++ {
+		converted = append(converted, leaves[i].GetEntries()...)
+	}
+	if len(converted) != 40 || converted[0].Key != -20 || converted[39].Key != 19 {
+		t.Fatalf("Expected keys -20..19 after convert, got %d entries", len(converted))
+	}
+
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	for k := -1000; k < 1000; k++ {
+		tree.Insert(k, k, ti)
+	}
+	tree.ConvertAll(ti)
+	if got := len(tree.RangeLookupEntries(math.MinInt, 4000, ti)); got != 2000 {
+		t.Errorf("Expected 2000 entries after converting the tree, got %d", got)
+	}
+	for k := -1000; k < 1000; k++ {
+		if got := tree.Lookup(k, ti); got != k {
+			t.Fatalf("key %d: expected %d, got %v", k, k, got)
+		}
+	}
+}
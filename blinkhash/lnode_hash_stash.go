@@ -0,0 +1,198 @@
+package blinkhash
+
+import (
+	"sync/atomic"
+)
+
+// hashStashSize 返回每个哈希叶子溢出区的槽位数，0 表示不使用溢出区，见 TreeOptions.HashStashSize。
+//
+// 键分布倾斜时少数桶很快被填满，即使布谷鸟迁移也找不到空位，
+// 叶子会在整体利用率很低时就被迫分裂。溢出区吸收这些条目，
+// 只有溢出区也满了才分裂。
+func (o *TreeOptions) hashStashSize() int {
+	if o.HashStashSize < 0 {
+		return 0
+	}
+	if o.HashStashSize == 0 {
+		return EntryNum
+	}
+	return o.HashStashSize
+}
+
+// newStashBucket 创建一个有 size 个槽位的溢出桶，超过 EntryNum 时只有 EntryNum 个
+func newStashBucket(size int) Bucket {
+	return *newBucketWithSlots(size)
+}
+
+// stashFingerprint 溢出区中的条目统一使用第 0 个哈希函数计算指纹
+func (lh *LNodeHash) stashFingerprint(key interface{}) uint8 {
+	return lh.Hash(lh.hashKey(key, 0)) | 1
+}
+
+// insertStash 候选桶都已满时把条目放入溢出区，溢出区也满时返回 NeedSplit
+func (lh *LNodeHash) insertStash(key, value interface{}, version uint64) int {
+	if lh.stash.slots() == 0 {
+		return NeedSplit
+	}
+	if !lh.stash.TryLock() {
+		return NeedRestart
+	}
+	defer lh.stash.Unlock()
+
+	currentVersion, needRestart := lh.GetVersion()
+	if needRestart || version != currentVersion {
+		return NeedRestart
+	}
+	if !lh.insertStashLocked(key, value) {
+		return NeedSplit
+	}
+	return InsertSuccess
+}
+
+// insertStashLocked 在已持有溢出区锁(或分裂锁)时写入条目
+func (lh *LNodeHash) insertStashLocked(key, value interface{}) bool {
+	var success bool
+	if FINGERPRINT {
+		success = lh.stash.InsertWithFingerprint(key, value, lh.stashFingerprint(key), EmptyFingerprint)
+	} else {
+		success = lh.stash.Insert(key, value)
+	}
+	if !success {
+		return false
+	}
+	atomic.AddInt32(&lh.count, 1)
+	if compareIntKeys(key, lh.HighKey) > 0 {
+		lh.HighKey = key
+	}
+	return true
+}
+
+// findStash 在溢出区中查找 key，溢出区被锁定或版本变化时返回 retry
+func (lh *LNodeHash) findStash(key interface{}) (val interface{}, found bool, retry bool) {
+	if lh.stash.slots() == 0 {
+		return nil, false, false
+	}
+	vstart, needRestart := lh.stash.getVersion()
+	if needRestart {
+		return nil, false, true
+	}
+	if FINGERPRINT {
+		val, found = lh.stash.FindWithFingerprint(key, lh.stashFingerprint(key))
+	} else {
+		val, found = lh.stash.Find(key)
+	}
+	vend, needRestart := lh.stash.getVersion()
+	if needRestart || vstart != vend {
+		return nil, false, true
+	}
+	return val, found, false
+}
+
+// updateStash 在溢出区中更新 key，返回 UpdateSuccess、UpdateFailure 或 NeedRestart
+func (lh *LNodeHash) updateStash(key, value interface{}, version uint64) int {
+	if lh.stash.slots() == 0 {
+		return UpdateFailure
+	}
+	if !lh.stash.TryLock() {
+		return NeedRestart
+	}
+	defer lh.stash.Unlock()
+
+	currentVersion, needRestart := lh.GetVersion()
+	if needRestart || version != currentVersion {
+		return NeedRestart
+	}
+	var updated bool
+	if FINGERPRINT {
+		updated = lh.stash.UpdateWithFingerprint(key, value, lh.stashFingerprint(key))
+	} else {
+		updated = lh.stash.Update(key, value)
+	}
+	if updated {
+		return UpdateSuccess
+	}
+	return UpdateFailure
+}
+
+// removeStash 从溢出区中移除 key，返回 0 表示成功、1 表示不存在、NeedRestart 表示需要重启
+func (lh *LNodeHash) removeStash(key interface{}, version uint64) int {
+	if lh.stash.slots() == 0 {
+		return 1
+	}
+	if !lh.stash.TryLock() {
+		return NeedRestart
+	}
+	defer lh.stash.Unlock()
+
+	currentVersion, needRestart := lh.GetVersion()
+	if needRestart || version != currentVersion {
+		return NeedRestart
+	}
+	var removed bool
+	if FINGERPRINT {
+		removed = lh.stash.RemoveWithFingerprint(key, lh.stashFingerprint(key))
+	} else {
+		removed = lh.stash.Remove(key)
+	}
+	if removed {
+		atomic.AddInt32(&lh.count, -1)
+		return 0
+	}
+	return 1
+}
+
+// splitStash 分裂时把溢出区中大于 medianKey 的条目迁移到 newRight 的溢出区，调用方持有分裂锁
+func (lh *LNodeHash) splitStash(newRight *LNodeHash, medianKey interface{}) {
+	median := medianKey.(int)
+	for i := 0; i < lh.stash.slots(); i++ {
+		if bucketSlotEmpty(&lh.stash, i) || lh.stash.keys[i] <= median {
+			continue
+		}
+		lh.stash.moveSlot(i, &newRight.stash, i, lh.stash.fingerprints[i])
+		lh.DecrementCount()
+		newRight.IncrementCount()
+	}
+}
+
+// drainStashLocked 把溢出区中的条目尽量放回它们的候选桶。
+// 调用方必须持有全部桶锁(分裂锁)，或者节点尚未对其他线程可见。
+func (lh *LNodeHash) drainStashLocked() {
+	buckets := lh.bucketArray()
+	for i := 0; i < lh.stash.slots(); i++ {
+		if bucketSlotEmpty(&lh.stash, i) {
+			continue
+		}
+		key := lh.stash.keys[i]
+		placed := false
+		for k := 0; k < HashFuncsNum && !placed; k++ {
+			hashKey := lh.hashKey(key, k)
+			for j := 0; j < NumSlot && !placed; j++ {
+				loc := int((hashKey + uint64(j)) % uint64(len(buckets)))
+				if free := bucketFreeSlot(&buckets[loc]); free >= 0 {
+					fingerprint := uint8(occupiedFingerprint)
+					if FINGERPRINT {
+						fingerprint = lh.Hash(hashKey) | 1
+					}
+					lh.stash.moveSlot(i, &buckets[loc], free, fingerprint)
+					placed = true
+				}
+			}
+		}
+	}
+}
+
+// StashCount 返回溢出区中的条目数
+func (lh *LNodeHash) StashCount() int {
+	count := 0
+	for i := 0; i < lh.stash.slots(); i++ {
+		if !bucketSlotEmpty(&lh.stash, i) {
+			count++
+		}
+	}
+	return count
+}
+
+// StashCapacity 返回溢出区的槽位数
+func (lh *LNodeHash) StashCapacity() int {
+	return lh.stash.slots()
+}
@@ -0,0 +1,98 @@
+package blinkhash
+
+import (
+	"testing"
+)
+
+// fillStashedLeaf 使用配置 opts 填满一个小哈希叶子，opts 应关闭布谷鸟迁移，保证溢出区被用满
+func fillStashedLeaf(t *testing.T, opts *TreeOptions) (*LNodeHash, []int) {
+	t.Helper()
+	lh := newSmallLNodeHash(16, opts)
+	keys := fillUntilSplit(t, lh)
+	if lh.StashCount() != lh.StashCapacity() {
+		t.Fatalf("Expected a full stash, got %d/%d", lh.StashCount(), lh.StashCapacity())
+	}
+	return lh, keys
+}
+
+func TestLNodeHash_StashAbsorbsOverflow(t *testing.T) {
+	_, plainKeys := func() (*LNodeHash, []int) {
+		lh := newSmallLNodeHash(16, &TreeOptions{CuckooMaxDepth: -1, HashStashSize: -1})
+		return lh, fillUntilSplit(t, lh)
+	}()
+
+	lh, keys := fillStashedLeaf(t, &TreeOptions{CuckooMaxDepth: -1, HashStashSize: 8})
+	if len(keys) < len(plainKeys)+8 {
+		t.Errorf("Expected stash to absorb 8 more keys, got %d vs %d", len(keys), len(plainKeys))
+	}
+	for _, key := range keys {
+		if val, found := lh.Find(key); !found || val != key {
+			t.Fatalf("Expected to find key %d, got %v %v", key, val, found)
+		}
+	}
+
+	stashed := lh.stash.keys[0]
+	if ret := lh.Update(stashed, -stashed, 0); ret != UpdateSuccess {
+		t.Errorf("Expected update of stashed key to succeed, got %d", ret)
+	}
+	if val, _ := lh.Find(stashed); val != -stashed {
+		t.Errorf("Expected updated value %d, got %v", -stashed, val)
+	}
+	if ret := lh.Remove(stashed, 0); ret != 0 {
+		t.Errorf("Expected remove of stashed key to succeed, got %d", ret)
+	}
+	if _, found := lh.Find(stashed); found || lh.StashCount() != 7 {
+		t.Errorf("Expected stashed key to be removed, stash holds %d", lh.StashCount())
+	}
+	if int(lh.GetCount()) != len(keys)-1 {
+		t.Errorf("Expected count %d, got %d", len(keys)-1, lh.GetCount())
+	}
+
+	var metrics FootprintMetrics
+	lh.Footprint(&metrics)
+	if metrics.StashOccupied != 7 || metrics.StashCapacity != 8 || metrics.StashedLeaves != 1 {
+		t.Errorf("Unexpected stash metrics %+v", metrics)
+	}
+}
+
+func TestLNodeHash_StashSplitAndConvert(t *testing.T) {
+	opts := &TreeOptions{CuckooMaxDepth: -1, HashStashSize: 8, HashMaxCardinality: 16} // 不扩容，直接分裂
+
+	lh, keys := fillStashedLeaf(t, opts)
+	newKey := 1 << 31
+	splittable, splitKey := lh.Split(newKey, newKey, 0)
+	if splittable == nil {
+		t.Fatal("Expected split to succeed")
+	}
+	right := splittable.(*LNodeHash)
+	lh.WriteUnlock()
+
+	if got := int(lh.GetCount() + right.GetCount()); got != len(keys)+1 {
+		t.Errorf("Expected %d entries after split, got %d", len(keys)+1, got)
+	}
+	for _, key := range append(keys, newKey) {
+		node := lh
+		if compareIntKeys(key, splitKey) > 0 {
+			node = right
+		}
+		if val, found := node.Find(key); !found || val != key {
+			_, inLeft := lh.Find(key)
+			_, inRight := right.Find(key)
+			t.Fatalf("Expected key %d on its side of split key %v (left %v, right %v)", key, splitKey, inLeft, inRight)
+		}
+	}
+
+	// 转换为 LNodeBTree 时溢出区中的条目也要带上
+	lh2, keys2 := fillStashedLeaf(t, opts)
+	leaves, num, err := lh2.Convert(0)
+	if err != nil {
+		t.Fatalf("Unexpected convert error: %v", err)
+	}
+	total := 0
+	for i := 0; i < num; i++ {
+		total += leaves[i].Len()
+	}
+	if total != len(keys2) {
+		t.Errorf("Expected %d converted entries, got %d", len(keys2), total)
+	}
+}
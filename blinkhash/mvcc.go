@@ -0,0 +1,634 @@
+package blinkhash
+
+import (
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// versionStripes 旧版本按键分片存放的分片数，每个分片一把锁
+const versionStripes = 256
+
+// snapshotScanBatch 快照范围查询每轮从树中读取的最少条目数
+const snapshotScanBatch = 64
+
+// versionRecord 一次写入之前 key 的状态(前像)。
+// ts 为写入的提交时间戳，0 表示写入尚未提交。value 保留过期时间，快照读取时才判断是否过期
+type versionRecord struct {
+	ts     uint64
+	value  interface{}
+	exists bool
+	older  *versionRecord
+	pruned uint64 // 以这个 minActive 修剪过从本记录开始的链，再次修剪时可以在此停下
+}
+
+type versionStripe struct {
+	mu       sync.Mutex
+	chains   map[int]*versionRecord // key -> 最新的记录，沿 older 时间戳递减
+	keys     []int                  // chains 中的键，有序，范围读取按区间二分查找
+	inflight int64                  // 不持有分片锁进行中的写入数，原子访问
+}
+
+// setChain 把 rec 设为 k 的版本链头，新出现的键按顺序加入 keys。
+// 时间序列的写入键大多递增，插入位置通常在末尾
+func (st *versionStripe) setChain(k int, rec *versionRecord) {
+	if _, ok := st.chains[k]; !ok {
+		i := sort.SearchInts(st.keys, k)
+		st.keys = append(st.keys, 0)
+		copy(st.keys[i+1:], st.keys[i:])
+		st.keys[i] = k
+	}
+	st.chains[k] = rec
+}
+
+// dropChain 删除 k 的版本链
+func (st *versionStripe) dropChain(k int) {
+	delete(st.chains, k)
+	if i := sort.SearchInts(st.keys, k); i < len(st.keys) && st.keys[i] == k {
+		st.keys = append(st.keys[:i], st.keys[i+1:]...)
+	}
+}
+
+// compactKeys 在从 chains 批量删除键之后同步 keys
+func (st *versionStripe) compactKeys() {
+	n := 0
+	for _, k := range st.keys {
+		if _, ok := st.chains[k]; ok {
+			st.keys[n] = k
+			n++
+		}
+	}
+	st.keys = st.keys[:n]
+}
+
+// versionStore 保存快照读取所需的旧版本。
+//
+// 版本链挂在按键分片的表上，而不是挂在叶子里：叶子会分裂、扩容、从哈希叶子转换为
+// B 树叶子，条目还会被布谷鸟迁移和溢出区搬动，把版本链跟着条目搬运会牵涉所有这些路径。
+// 写入 key 时持有它所在分片的锁，有快照存在时先记录 key 的前像，再修改树，最后提交时间戳；
+// 快照在读取树之后再读取版本链，最老的一个晚于快照(或尚未提交)的记录就是快照时刻的状态，
+// 没有这样的记录时树中的当前值就是快照时刻的值。
+//
+// 旧版本只能在持有分片锁时访问，摘除后不会再被读到，直接交给 Go 的 GC 回收，
+// 不需要像节点那样经过 Epoche 的删除列表等待读者离开。
+// 判断能否摘除的依据是最老的活跃快照时间戳，而不是线程的 epoch。
+//
+// 没有快照时写入不需要前像，也就不加分片锁：写入先在分片的 inflight 上登记，
+// 再确认既没有快照也没有 lockAll 的持有者，之后直接修改树。lockAll 先设置 exclusive，
+// 再等待所有分片的 inflight 归零，此后到达的写入都会看到 exclusive 或活跃的快照而改走加锁的路径。
+// 写日志的树中，写入在 walOrder 上排好与日志一致的顺序之后才到达这里，之后与不写日志的写入相同，
+// 见 wal.go
+type versionStore struct {
+	clock     uint64 // 逻辑时钟，每提交一次记录了前像的写入加一
+	active    int64  // 活跃快照数
+	minActive uint64 // 最老的活跃快照时间戳，没有快照时为 math.MaxUint64
+	exclusive int32  // lockAll 持有期间为 1，写入都经过分片锁
+
+	mu        sync.Mutex     // 保护 snapshots
+	snapshots map[uint64]int // 活跃快照时间戳 -> 个数
+	all       sync.Mutex     // 同一时刻只有一个 lockAll 的持有者
+	stripes   [versionStripes]versionStripe
+}
+
+func newVersionStore() *versionStore {
+	vs := &versionStore{
+		minActive: math.MaxUint64,
+		snapshots: make(map[uint64]int),
+	}
+	for i := range vs.stripes {
+		vs.stripes[i].chains = make(map[int]*versionRecord)
+	}
+	return vs
+}
+
+func stripeIndex(key int) int {
+	return int(uint64(key) * 0x9E3779B97F4A7C15 >> 56 % versionStripes)
+}
+
+func (vs *versionStore) stripe(key int) *versionStripe {
+	return &vs.stripes[stripeIndex(key)]
+}
+
+// recording 返回是否有活跃快照，即写入是否需要记录前像
+func (vs *versionStore) recording() bool {
+	return atomic.LoadInt64(&vs.active) > 0
+}
+
+// versionWrite 一次进行中的写入，beginWrite 之后修改树，end 时提交时间戳并释放分片锁
+type versionWrite struct {
+	vs       *versionStore
+	stripe   *versionStripe
+	key      int
+	rec      *versionRecord
+	lockFree bool // 没有加分片锁，只在 inflight 上登记
+}
+
+// enterLockFree 尝试以不加锁的方式开始一次写入：没有快照、没有 lockAll 的持有者时
+// 在 st.inflight 上登记并返回 true。先登记再检查，与 lockAll 先设置 exclusive 再等待登记归零相对应
+func (vs *versionStore) enterLockFree(st *versionStripe) bool {
+	atomic.AddInt64(&st.inflight, 1)
+	if atomic.LoadInt32(&vs.exclusive) == 0 && !vs.recording() {
+		return true
+	}
+	atomic.AddInt64(&st.inflight, -1)
+	return false
+}
+
+// beginWrite 开始写入 key。没有快照时不加锁，见 versionStore；
+// 否则锁住分片，有活跃快照时把 key 当前的状态记录为未提交的前像
+func (vs *versionStore) beginWrite(bt *BTree, key interface{}, ti *ThreadInfo) versionWrite {
+	k := key.(int)
+	st := vs.stripe(k)
+	w := versionWrite{vs: vs, stripe: st, key: k}
+	if w.lockFree = vs.enterLockFree(st); w.lockFree {
+		return w
+	}
+	st.mu.Lock()
+	if vs.recording() {
+		value, exists := bt.lookupRaw(k, ti)
+		w.rec = &versionRecord{value: value, exists: exists, older: st.chains[k]}
+		pruneChain(w.rec, atomic.LoadUint64(&vs.minActive))
+		st.setChain(k, w.rec)
+	}
+	return w
+}
+
+func (w *versionWrite) end() {
+	if w.lockFree {
+		atomic.AddInt64(&w.stripe.inflight, -1)
+		return
+	}
+	if w.rec != nil {
+		w.rec.ts = atomic.AddUint64(&w.vs.clock, 1)
+	}
+	w.stripe.mu.Unlock()
+}
+
+// versionBatch 一次进行中的多键写入，所有键的前像在 end 时以同一个时间戳提交，
+// 快照要么看到整批写入，要么一个也看不到
+type versionBatch struct {
+	vs       *versionStore
+	stripes  []int
+	written  []int // 写入涉及的键
+	keys     []int // 记录了前像的键，与 recs 一一对应
+	recs     []*versionRecord
+	lockFree bool // 与 versionWrite 相同，只在第一个分片的 inflight 上登记
+}
+
+// beginBatch 开始写入 keys(已去重)。没有快照时与 beginWrite 一样不加锁，lockAll 等待所有分片，
+// 登记在一个分片上就够了；否则按下标顺序锁住所有分片，有活跃快照时记录每个键的前像。
+// 单键写入只锁一个分片，lockAll 同样按下标顺序加锁，因此不会死锁
+func (vs *versionStore) beginBatch(bt *BTree, keys []int, ti *ThreadInfo) versionBatch {
+	b := versionBatch{vs: vs, stripes: batchStripes(keys), written: keys}
+	if b.lockFree = len(b.stripes) > 0 && vs.enterLockFree(&vs.stripes[b.stripes[0]]); b.lockFree {
+		return b
+	}
+	b.lockStripes()
+	b.record(bt, ti)
+	return b
+}
+
+// batchStripes 返回 keys 所在的分片下标，从小到大排列
+func batchStripes(keys []int) []int {
+	var stripes []int
+	seen := make(map[int]bool, len(keys))
+	for _, k := range keys {
+		if idx := stripeIndex(k); !seen[idx] {
+			seen[idx] = true
+			stripes = append(stripes, idx)
+		}
+	}
+	sort.Ints(stripes)
+	return stripes
+}
+
+// record 在持有分片锁时为每个键记录前像
+func (b *versionBatch) record(bt *BTree, ti *ThreadInfo) {
+	vs := b.vs
+	if !vs.recording() {
+		return
+	}
+	minActive := atomic.LoadUint64(&vs.minActive)
+	for _, k := range b.written {
+		st := vs.stripe(k)
+		value, exists := bt.lookupRaw(k, ti)
+		rec := &versionRecord{value: value, exists: exists, older: st.chains[k]}
+		pruneChain(rec, minActive)
+		st.setChain(k, rec)
+		b.keys = append(b.keys, k)
+		b.recs = append(b.recs, rec)
+	}
+}
+
+func (b *versionBatch) end() {
+	if len(b.recs) > 0 {
+		ts := atomic.AddUint64(&b.vs.clock, 1)
+		for _, rec := range b.recs {
+			rec.ts = ts
+		}
+	}
+	b.unlock()
+}
+
+// abort 撤销记录的前像并释放分片锁，用于没有修改树就放弃的写入。
+// 持有分片锁期间没有其他写入，这些记录仍在各自版本链的头部。
+func (b *versionBatch) abort() {
+	for i, k := range b.keys {
+		st := b.vs.stripe(k)
+		if older := b.recs[i].older; older != nil {
+			st.chains[k] = older
+		} else {
+			st.dropChain(k)
+		}
+	}
+	b.unlock()
+}
+
+func (b *versionBatch) unlock() {
+	if b.lockFree {
+		atomic.AddInt64(&b.vs.stripes[b.stripes[0]].inflight, -1)
+		return
+	}
+	b.unlockStripes()
+}
+
+func (b *versionBatch) lockStripes() {
+	for _, idx := range b.stripes {
+		b.vs.stripes[idx].mu.Lock()
+	}
+}
+
+func (b *versionBatch) unlockStripes() {
+	for _, idx := range b.stripes {
+		b.vs.stripes[idx].mu.Unlock()
+	}
+}
+
+// recordRemovedLocked 在调用方通过 lockAll 挡住所有写入时，把即将被批量删除的条目记录为未提交的前像，
+// 追加到 recs。截断这类删除不经过按键的写入，修改树之后由 commitLocked 以同一个时间戳提交
+func (vs *versionStore) recordRemovedLocked(recs []*versionRecord, entries []Entry) []*versionRecord {
+	minActive := atomic.LoadUint64(&vs.minActive)
+	now := ttlNow()
+	for _, e := range entries {
+		if expired(e.Value, now) {
+			// 已过期的条目本来就不可见，不需要前像
+			continue
+		}
+		k := e.Key.(int)
+		st := vs.stripe(k)
+		rec := &versionRecord{value: e.Value, exists: true, older: st.chains[k]}
+		pruneChain(rec, minActive)
+		st.setChain(k, rec)
+		recs = append(recs, rec)
+	}
+	return recs
+}
+
+// commitLocked 以同一个时间戳提交 recordRemovedLocked 记录的前像
+func (vs *versionStore) commitLocked(recs []*versionRecord) {
+	if len(recs) == 0 {
+		return
+	}
+	ts := atomic.AddUint64(&vs.clock, 1)
+	for _, rec := range recs {
+		rec.ts = ts
+	}
+}
+
+// pruneChain 摘除 rec 之后所有不再被任何快照需要的记录：
+// 时间戳不晚于最老快照的记录不会被任何快照选中。
+// 长时间存在的快照期间热点键的链会很长，最老快照不变时只需检查上次修剪之后加入的记录
+func pruneChain(rec *versionRecord, minActive uint64) {
+	rec.pruned = minActive
+	for ; rec != nil; rec = rec.older {
+		older := rec.older
+		if older == nil || (older.pruned == minActive && older.ts != 0 && older.ts > minActive) {
+			return
+		}
+		if older.ts != 0 && older.ts <= minActive {
+			rec.older = nil
+			return
+		}
+	}
+}
+
+// asOf 返回 rec 开始的版本链在时间戳 ts 时 key 的状态，ok 为 false 表示应使用树中的当前值
+func asOf(rec *versionRecord, ts uint64) (value interface{}, exists bool, ok bool) {
+	for ; rec != nil && (rec.ts == 0 || rec.ts > ts); rec = rec.older {
+		value, exists, ok = rec.value, rec.exists, true
+	}
+	return
+}
+
+// Snapshot 固定在某个逻辑时间戳上的只读视图，创建之后的写入对它不可见。
+// 快照存在期间写入需要额外记录旧版本，使用完毕后必须调用 Release。
+type Snapshot struct {
+	tree     *BTree
+	ts       uint64
+	maxKey   int // 创建时树中的最大键，范围读取不必越过它；无法确定时为 math.MaxInt
+	released int32
+}
+
+// Snapshot 创建当前时刻的快照。
+// 创建时通过 lockWrites 挡住所有写入，保证没有写入处于修改了树却还没提交时间戳的中间状态，
+// 也没有已经写了日志却还没修改树的写入。
+func (bt *BTree) Snapshot() *Snapshot {
+	bt.lockWrites()
+	defer bt.unlockWrites()
+	return bt.snapshotLocked()
+}
+
+// snapshotLocked 在调用方已通过 lockAll 挡住所有写入时创建快照
+func (bt *BTree) snapshotLocked() *Snapshot {
+	vs := bt.versions
+	ts := atomic.LoadUint64(&vs.clock)
+	vs.mu.Lock()
+	vs.snapshots[ts]++
+	vs.updateMinActiveLocked()
+	vs.mu.Unlock()
+	atomic.AddInt64(&vs.active, 1)
+	return &Snapshot{tree: bt, ts: ts, maxKey: bt.rightmostKey()}
+}
+
+// rightmostKey 返回最右叶子中的最大键，最右叶子为空时返回 math.MaxInt。
+// 持续追加写入时快照的范围读取以它为界，否则会一直追赶快照之后插入的键
+func (bt *BTree) rightmostKey() int {
+	eg := NewEpocheGuard(NewThreadInfo(bt.epoche))
+	defer eg.Release()
+	for {
+		leaf, version := bt.findLeaf(math.MaxInt)
+		maxKey, found := math.MinInt, false
+		for _, e := range leaf.GetEntries() {
+			if k := e.Key.(int); !found || k > maxKey {
+				maxKey, found = k, true
+			}
+		}
+		if endVersion, needRestart := leaf.GetVersion(); needRestart || endVersion != version {
+			continue
+		}
+		if !found {
+			return math.MaxInt
+		}
+		return maxKey
+	}
+}
+
+// lockAll 锁住所有分片，并等待不加锁的写入结束，此时没有修改了树却还没提交时间戳的写入。
+// 已经写了日志、还没到达这里的写入不受影响，需要同时挡住它们时使用 BTree.lockWrites
+func (vs *versionStore) lockAll() {
+	vs.all.Lock()
+	atomic.StoreInt32(&vs.exclusive, 1)
+	for i := range vs.stripes {
+		st := &vs.stripes[i]
+		st.mu.Lock()
+		for atomic.LoadInt64(&st.inflight) != 0 {
+			runtime.Gosched()
+		}
+	}
+}
+
+func (vs *versionStore) unlockAll() {
+	for i := range vs.stripes {
+		vs.stripes[i].mu.Unlock()
+	}
+	atomic.StoreInt32(&vs.exclusive, 0)
+	vs.all.Unlock()
+}
+
+func (vs *versionStore) updateMinActiveLocked() {
+	min := uint64(math.MaxUint64)
+	for ts := range vs.snapshots {
+		if ts < min {
+			min = ts
+		}
+	}
+	atomic.StoreUint64(&vs.minActive, min)
+}
+
+// Timestamp 返回快照的逻辑时间戳
+func (s *Snapshot) Timestamp() uint64 {
+	return s.ts
+}
+
+// Release 释放快照，并摘除不再被任何快照需要的旧版本。重复调用是安全的。
+func (s *Snapshot) Release() {
+	if !atomic.CompareAndSwapInt32(&s.released, 0, 1) {
+		return
+	}
+	vs := s.tree.versions
+	vs.mu.Lock()
+	if vs.snapshots[s.ts]--; vs.snapshots[s.ts] == 0 {
+		delete(vs.snapshots, s.ts)
+	}
+	vs.updateMinActiveLocked()
+	vs.mu.Unlock()
+	atomic.AddInt64(&vs.active, -1)
+	vs.prune()
+}
+
+// prune 遍历所有分片，摘除不再被需要的旧版本
+func (vs *versionStore) prune() {
+	minActive := atomic.LoadUint64(&vs.minActive)
+	for i := range vs.stripes {
+		st := &vs.stripes[i]
+		st.mu.Lock()
+		dropped := false
+		for k, rec := range st.chains {
+			if rec.ts != 0 && rec.ts <= minActive {
+				delete(st.chains, k)
+				dropped = true
+				continue
+			}
+			pruneChain(rec, minActive)
+		}
+		if dropped {
+			st.compactKeys()
+		}
+		st.mu.Unlock()
+	}
+}
+
+// VersionCount 返回当前保存的旧版本数，用于观察快照的内存开销
+func (bt *BTree) VersionCount() int {
+	vs := bt.versions
+	n := 0
+	for i := range vs.stripes {
+		st := &vs.stripes[i]
+		st.mu.Lock()
+		for _, rec := range st.chains {
+			for ; rec != nil; rec = rec.older {
+				n++
+			}
+		}
+		st.mu.Unlock()
+	}
+	return n
+}
+
+func (s *Snapshot) checkLive() {
+	if atomic.LoadInt32(&s.released) != 0 {
+		panic("blinkhash: use of released snapshot")
+	}
+}
+
+// Lookup 返回快照时刻 key 的值，不存在时返回 nil
+func (s *Snapshot) Lookup(key interface{}, ti *ThreadInfo) interface{} {
+	s.checkLive()
+	k := key.(int)
+	st := s.tree.versions.stripe(k)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	value, _ := s.tree.lookup(key, ti)
+	if old, exists, ok := asOf(st.chains[k], s.ts); ok {
+		if !exists {
+			return nil
+		}
+		if old, live := liveValue(old, ttlNow()); live {
+			return old
+		}
+		return nil
+	}
+	return value
+}
+
+// RangeLookup 返回快照时刻从 minKey 开始的最多 rng 个值
+func (s *Snapshot) RangeLookup(minKey interface{}, rng int, ti *ThreadInfo) []interface{} {
+	return entryValues(s.RangeLookupEntries(minKey, rng, ti))
+}
+
+// RangeLookupEntries 与 RangeLookup 相同，但返回带键的条目，结果按键有序，包含 minKey 本身。
+//
+// 每轮先从树中读取一批条目，再读取这批条目所覆盖键区间内的版本链修正结果：
+// 快照之后插入的键被去掉，快照之后删除的键补回来。版本链必须在树之后读取，
+// 这样读取树之后发生的写入一定已经留下了前像。
+func (s *Snapshot) RangeLookupEntries(minKey interface{}, rng int, ti *ThreadInfo) []Entry {
+	return s.rangeLookupEntries(minKey, rng, false, ti)
+}
+
+// rangeLookupEntries 实现 RangeLookupEntries。raw 为 true 时值保留过期时间，过期的条目同样返回，
+// 用于把快照写入检查点、树文件和冻结文件
+func (s *Snapshot) rangeLookupEntries(minKey interface{}, rng int, raw bool, ti *ThreadInfo) []Entry {
+	s.checkLive()
+	var results []Entry
+	lo := minKey.(int)
+	for len(results) < rng {
+		// 每轮读取的条目数不超过 scanPreallocMax，rng 可能远大于实际条目数
+		batch := rng - len(results)
+		if batch < snapshotScanBatch {
+			batch = snapshotScanBatch
+		} else if batch > scanPreallocMax {
+			batch = scanPreallocMax
+		}
+		// 先读出带过期时间的条目，前像同样带着过期时间，修正之后再统一去掉
+		current := s.tree.rangeLookupEntries(lo, batch, true, ti)
+		hi, exhausted := math.MaxInt, len(current) < batch
+		if !exhausted {
+			hi = current[len(current)-1].Key.(int)
+		}
+		if hi > s.maxKey {
+			// 快照时刻不存在大于 maxKey 的键，之后追加的键不必再读
+			n := sort.Search(len(current), func(i int) bool { return current[i].Key.(int) > s.maxKey })
+			current, hi, exhausted = current[:n], s.maxKey, true
+		}
+		window := s.resolve(current, lo, hi)
+		if !raw && s.tree.expires() {
+			window = appendLive(nil, window, ttlNow())
+		}
+		if need := rng - len(results); len(window) > need {
+			window = window[:need]
+		}
+		results = append(results, window...)
+		if exhausted || hi == math.MaxInt {
+			break
+		}
+		lo = hi + 1
+	}
+	return results
+}
+
+// resolve 用版本链把 current(树中 [lo, hi] 内的条目)修正为快照时刻的状态
+func (s *Snapshot) resolve(current []Entry, lo, hi int) []Entry {
+	type override struct {
+		value  interface{}
+		exists bool
+	}
+	overrides := make(map[int]override)
+	vs := s.tree.versions
+	for i := range vs.stripes {
+		st := &vs.stripes[i]
+		st.mu.Lock()
+		for j := sort.SearchInts(st.keys, lo); j < len(st.keys) && st.keys[j] <= hi; j++ {
+			k := st.keys[j]
+			if value, exists, ok := asOf(st.chains[k], s.ts); ok {
+				overrides[k] = override{value: value, exists: exists}
+			}
+		}
+		st.mu.Unlock()
+	}
+	if len(overrides) == 0 {
+		return current
+	}
+
+	window := make([]Entry, 0, len(current)+len(overrides))
+	for _, e := range current {
+		if _, ok := overrides[e.Key.(int)]; !ok {
+			window = append(window, e)
+		}
+	}
+	for k, o := range overrides {
+		if o.exists {
+			window = append(window, Entry{Key: k, Value: o.value})
+		}
+	}
+	sort.Slice(window, func(i, j int) bool {
+		return window[i].Key.(int) < window[j].Key.(int)
+	})
+	return window
+}
+
+// SnapshotIterator 按键顺序遍历快照
+type SnapshotIterator struct {
+	snap *Snapshot
+	raw  bool // 值保留过期时间，见 rawIterator
+	ti   *ThreadInfo
+	buf  []Entry
+	pos  int
+	next int  // 下一批的起始键
+	done bool // 树中已没有更多条目
+}
+
+// Iterator 返回从 minKey 开始按键顺序遍历快照的迭代器
+func (s *Snapshot) Iterator(minKey interface{}, ti *ThreadInfo) *SnapshotIterator {
+	return &SnapshotIterator{snap: s, ti: ti, next: minKey.(int)}
+}
+
+// rawIterator 与 Iterator 相同，但值保留过期时间，已经过期的条目同样返回
+func (s *Snapshot) rawIterator(minKey interface{}, ti *ThreadInfo) *SnapshotIterator {
+	return &SnapshotIterator{snap: s, raw: true, ti: ti, next: minKey.(int)}
+}
+
+// Next 返回下一个条目，遍历结束时 ok 为 false
+func (it *SnapshotIterator) Next() (entry Entry, ok bool) {
+	for it.pos == len(it.buf) {
+		if it.done {
+			return Entry{}, false
+		}
+		it.buf = it.snap.rangeLookupEntries(it.next, snapshotScanBatch, it.raw, it.ti)
+		it.pos = 0
+		if len(it.buf) < snapshotScanBatch {
+			it.done = true
+		}
+		if n := len(it.buf); n > 0 {
+			last := it.buf[n-1].Key.(int)
+			if last == math.MaxInt {
+				it.done = true
+			}
+			it.next = last + 1
+		}
+	}
+	entry = it.buf[it.pos]
+	it.pos++
+	return entry, true
+}
@@ -0,0 +1,247 @@
+package blinkhash
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSnapshot_Lookup(t *testing.T) {
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	n := 5000
+	for k := 1; k <= n; k++ {
+		tree.Insert(k, k, ti)
+	}
+	snap := tree.Snapshot()
+	defer snap.Release()
+
+	for k := 1; k <= n; k++ {
+		switch k % 3 {
+		case 0:
+			tree.Update(k, -k, ti)
+		case 1:
+			tree.Remove(k, ti)
+		}
+		tree.Insert(n+k, k, ti)
+	}
+	// 同一个键在快照之后多次修改，快照仍看到最早的值
+	tree.Update(3, 0, ti)
+	tree.Update(3, 1, ti)
+
+	for k := 1; k <= n; k++ {
+		if got := snap.Lookup(k, ti); got != k {
+			t.Fatalf("key %d: expected %d in snapshot, got %v", k, k, got)
+		}
+		if got := snap.Lookup(n+k, ti); got != nil {
+			t.Fatalf("key %d: expected key inserted after the snapshot to be invisible, got %v", n+k, got)
+		}
+	}
+	if got := tree.Lookup(6, ti); got != -6 {
+		t.Errorf("Expected the tree to see the update, got %v", got)
+	}
+	if got := tree.Lookup(1, ti); got != nil {
+		t.Errorf("Expected the tree to see the removal, got %v", got)
+	}
+}
+
+func TestSnapshot_NilValue(t *testing.T) {
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	tree.Insert(1, nil, ti)
+	snap := tree.Snapshot()
+	defer snap.Release()
+	tree.Update(1, 10, ti)
+	tree.Insert(2, 20, ti)
+	// 值为 nil 的键在快照中存在，快照之后插入的键不存在
+	got := snap.RangeLookupEntries(0, 10, ti)
+	if len(got) != 1 || got[0].Key != 1 || got[0].Value != nil {
+		t.Fatalf("Expected only key 1 with a nil value, got %v", got)
+	}
+}
+
+func TestSnapshot_RangeLookup(t *testing.T) {
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	n := 20000
+	for k := 1; k <= n; k++ {
+		tree.Insert(k*2, k, ti)
+	}
+	snap := tree.Snapshot()
+	defer snap.Release()
+	// 快照之后在每两个键之间插入，并删除一半旧键
+	for k := 1; k <= n; k++ {
+		tree.Insert(k*2+1, -k, ti)
+		if k%2 == 0 {
+			tree.Remove(k*2, ti)
+		}
+	}
+
+	got := snap.RangeLookupEntries(201, 5000, ti)
+	if len(got) != 5000 {
+		t.Fatalf("Expected 5000 entries, got %d", len(got))
+	}
+	for i, e := range got {
+		if e.Key != (i+101)*2 || e.Value != i+101 {
+			t.Fatalf("entry %d: expected key %d, got %v", i, (i+101)*2, e)
+		}
+	}
+	if got := len(snap.RangeLookup(0, 1<<30, ti)); got != n {
+		t.Errorf("Expected %d values in the whole snapshot, got %d", n, got)
+	}
+
+	it := snap.Iterator(0, ti)
+	count := 0
+	for e, ok := it.Next(); ok; e, ok = it.Next() {
+		count++
+		if e.Key != count*2 || e.Value != count {
+			t.Fatalf("iterator entry %d: expected key %d, got %v", count, count*2, e)
+		}
+	}
+	if count != n {
+		t.Errorf("Expected iterator to return %d entries, got %d", n, count)
+	}
+}
+
+// 写线程先插入低区间的键再插入高区间的键，快照中高区间的键数不会超过低区间，
+// 并且同一个快照反复读取的结果不变
+func TestSnapshot_ConcurrentWriters(t *testing.T) {
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	const low, high, pairs = 1_000_000, 2_000_000, 20000
+	for k := 1; k <= 5000; k++ {
+		tree.Insert(low+pairs+k*10, 0, ti)
+	}
+
+	var stop atomic.Bool
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		wti := NewThreadInfo(tree.GetEpoche())
+		for i := 0; i < pairs && !stop.Load(); i++ {
+			tree.Insert(low+i, i, wti)
+			tree.Insert(high+i, i, wti)
+			if i%5 == 0 {
+				tree.Remove(low+pairs+(i%5000+1)*10, wti)
+			}
+		}
+	}()
+
+	for round := 0; round < 50; round++ {
+		snap := tree.Snapshot()
+		first := snap.RangeLookupEntries(low, 1<<30, ti)
+		lows, highs := 0, 0
+		for _, e := range first {
+			k := e.Key.(int)
+			switch {
+			case k < low+pairs:
+				lows++
+			case k >= high:
+				highs++
+			}
+		}
+		if highs > lows {
+			stop.Store(true)
+			wg.Wait()
+			t.Fatalf("snapshot %d saw %d high keys but only %d low keys", round, highs, lows)
+		}
+		second := snap.RangeLookupEntries(low, 1<<30, ti)
+		if len(first) != len(second) {
+			stop.Store(true)
+			wg.Wait()
+			t.Fatalf("snapshot %d changed from %d to %d entries", round, len(first), len(second))
+		}
+		snap.Release()
+	}
+	stop.Store(true)
+	wg.Wait()
+}
+
+func TestSnapshot_Release(t *testing.T) {
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	for k := 1; k <= 1000; k++ {
+		tree.Insert(k, k, ti)
+	}
+	if got := tree.VersionCount(); got != 0 {
+		t.Fatalf("Expected no versions without snapshots, got %d", got)
+	}
+
+	older := tree.Snapshot()
+	for k := 1; k <= 1000; k++ {
+		tree.Update(k, -k, ti)
+	}
+	newer := tree.Snapshot()
+	for k := 1; k <= 1000; k++ {
+		tree.Update(k, 0, ti)
+	}
+	if got := tree.VersionCount(); got != 2000 {
+		t.Fatalf("Expected 2000 versions with two snapshots, got %d", got)
+	}
+
+	// 释放较老的快照后，只有较新的快照需要的版本保留下来
+	older.Release()
+	older.Release()
+	if got := tree.VersionCount(); got != 1000 {
+		t.Fatalf("Expected 1000 versions after releasing the older snapshot, got %d", got)
+	}
+	if got := newer.Lookup(7, ti); got != -7 {
+		t.Fatalf("Expected newer snapshot to see -7, got %v", got)
+	}
+	newer.Release()
+	if got := tree.VersionCount(); got != 0 {
+		t.Fatalf("Expected no versions after releasing all snapshots, got %d", got)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected lookup on a released snapshot to panic")
+		}
+	}()
+	newer.Lookup(7, ti)
+}
+
+func TestSnapshot_Ingest(t *testing.T) {
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	for k := 1; k <= 1000; k++ {
+		tree.Insert(k, k, ti)
+	}
+	snap := tree.Snapshot()
+	defer snap.Release()
+	// 存在快照时摄入模式逐条写入并记录旧版本
+	for k := 1; k <= 2000; k++ {
+		tree.IngestInsert(k, -k, ti)
+	}
+	tree.FlushIngest(ti)
+	if got := len(snap.RangeLookup(0, 1<<30, ti)); got != 1000 {
+		t.Fatalf("Expected 1000 entries in the snapshot, got %d", got)
+	}
+	if got := snap.Lookup(500, ti); got != 500 {
+		t.Errorf("Expected 500 in the snapshot, got %v", got)
+	}
+}
+
+// BenchmarkSnapshotWrite 比较存在与不存在快照时更新的开销
+func BenchmarkSnapshotWrite(b *testing.B) {
+	for _, withSnapshot := range []bool{false, true} {
+		b.Run(fmt.Sprintf("snapshot=%v", withSnapshot), func(b *testing.B) {
+			tree := NewBTree()
+			ti := NewThreadInfo(tree.GetEpoche())
+			n := 1000000
+			for k := 1; k <= n; k++ {
+				tree.Insert(k, k, ti)
+			}
+			if withSnapshot {
+				snap := tree.Snapshot()
+				defer snap.Release()
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				tree.Update(i%n+1, i, ti)
+			}
+		})
+	}
+}
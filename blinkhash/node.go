@@ -15,6 +15,25 @@ type Node struct {
 	level       int
 }
 
+// nodeOptions 节点所属树的配置，嵌入在各类节点中，新节点从分裂或转换它的节点继承。
+// 不放在 Node 中，以免改变由 Node 大小推出的节点容量
+type nodeOptions struct {
+	opts *TreeOptions
+}
+
+// options 返回节点所属树的配置，不属于任何树的节点使用内置的默认配置
+func (o *nodeOptions) options() *TreeOptions {
+	if o.opts == nil {
+		return &builtinTreeOptions
+	}
+	return o.opts
+}
+
+// optionsOf 返回节点 n 所属树的配置
+func optionsOf(n NodeInterface) *TreeOptions {
+	return n.(interface{ options() *TreeOptions }).options()
+}
+
 func (n *Node) GetSiblingPtr() NodeInterface { return n.siblingPtr }
 
 func (n *Node) GetLeftmostPtr() NodeInterface { return n.leftmostPtr }
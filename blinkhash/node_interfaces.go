@@ -54,6 +54,7 @@ type LeafNodeInterface interface {
 	Removable
 	Finder
 	RangeLookuper
+	EntryRangeLookuper
 	Utilizer
 	NodeGetter
 	FootPrinter
@@ -104,6 +105,12 @@ type RangeLookuper interface {
 	RangeLookUp(key interface{}, upTo int, continued bool, version uint64) (collected []interface{}, retCode int, newCount int)
 }
 
+// EntryRangeLookuper 与 RangeLookuper 相同，但返回带键的条目，
+// 供需要按键合并多个来源的调用方使用(例如 ReorderBuffer)
+type EntryRangeLookuper interface {
+	RangeLookUpEntries(key interface{}, upTo int, continued bool, version uint64) (collected []Entry, retCode int, newCount int)
+}
+
 // Utilizer 接口定义利用率方法
 type Utilizer interface {
 	Utilization() float64
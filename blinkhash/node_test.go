@@ -99,10 +99,10 @@ func TestBatchBuffer(t *testing.T) {
 	inode := NewINode(1, nil, nil, nil)
 
 	// 执行 BatchBuffer
-	inode.BatchBuffer(buf, bufIdx, bufNum, batchSize)
+	bufIdx, _ = inode.BatchBuffer(buf, bufIdx, bufNum, batchSize)
 
 	// 验证结果
-	if inode.count != batchSize {
+	if int(inode.count) != batchSize {
 		t.Errorf("Expected count to be %d, got %d", batchSize, inode.count)
 	}
 	if inode.HighKey != "key3" {
@@ -0,0 +1,234 @@
+package blinkhash
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultReorderFlushBatch 封存条目达到该数量时由 Put 自动刷新
+const DefaultReorderFlushBatch = 256
+
+// ReorderBuffer 位于 BTree 之前的乱序写缓冲，容忍有限的乱序到达。
+//
+// 时序数据常因网络延迟或多路汇聚而轻微乱序，直接写入会打断最右叶子上的
+// 顺序追加。ReorderBuffer 按键暂存最近的数据：键不大于 已见最大键-Lateness
+// 的条目视为"封存"，不会再有更早的数据落在它们之间，封存的条目按序通过
+// 批量路径写入树中。到达时已经落后于窗口的条目直接走普通 Insert，不会丢失。
+//
+// 缓冲中的数据对 Lookup 和 RangeLookup 可见；刷新时持有写锁，
+// 读操作不会在刷新过程中看到重复或缺失的条目。键必须是 int。
+type ReorderBuffer struct {
+	tree       *BTree
+	Lateness   int // 允许的最大乱序程度(键的差值)
+	FlushBatch int // 封存条目达到该数量时自动刷新，<=0 表示只在显式 Flush 时刷新
+
+	mu        sync.RWMutex
+	pending   []Entry // 尚未刷新的条目，按键有序
+	maxKey    int     // 已见到的最大键
+	hasMax    bool
+	lateCount int
+	ti        *ThreadInfo // 刷新使用的线程信息，受 mu 保护
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewReorderBuffer 创建一个写入 tree 的乱序缓冲，lateness 为允许的乱序窗口
+func NewReorderBuffer(tree *BTree, lateness int) *ReorderBuffer {
+	return &ReorderBuffer{
+		tree:       tree,
+		Lateness:   lateness,
+		FlushBatch: DefaultReorderFlushBatch,
+		ti:         NewThreadInfo(tree.GetEpoche()),
+	}
+}
+
+// Put 写入一个键值对
+func (rb *ReorderBuffer) Put(key, value interface{}) {
+	k := key.(int)
+
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	if rb.hasMax && k < rb.maxKey-rb.Lateness {
+		// 超出乱序窗口，直接写入树中
+		rb.lateCount++
+		rb.tree.Insert(key, value, rb.ti)
+		return
+	}
+	if !rb.hasMax || k > rb.maxKey {
+		rb.maxKey = k
+		rb.hasMax = true
+	}
+
+	// 相同的键插在已有条目之后，保持到达顺序
+	pos := sort.Search(len(rb.pending), func(i int) bool {
+		return rb.pending[i].Key.(int) > k
+	})
+	rb.pending = append(rb.pending, Entry{})
+	copy(rb.pending[pos+1:], rb.pending[pos:])
+	rb.pending[pos] = Entry{Key: key, Value: value}
+
+	if rb.FlushBatch > 0 {
+		if sealed := rb.sealedCount(); sealed >= rb.FlushBatch {
+			rb.flush(sealed)
+		}
+	}
+}
+
+// Flush 将缓冲中的全部条目写入树，不论是否已封存
+func (rb *ReorderBuffer) Flush() {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.flush(len(rb.pending))
+}
+
+// FlushSealed 只写入已封存的条目，返回写入的条目数
+func (rb *ReorderBuffer) FlushSealed() int {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	sealed := rb.sealedCount()
+	rb.flush(sealed)
+	return sealed
+}
+
+// StartAutoFlush 启动后台协程，每隔 interval 刷新一次已封存的条目
+func (rb *ReorderBuffer) StartAutoFlush(interval time.Duration) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	if rb.stop != nil {
+		return
+	}
+	rb.stop = make(chan struct{})
+	rb.done = make(chan struct{})
+	go func(stop, done chan struct{}) {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				rb.FlushSealed()
+			case <-stop:
+				return
+			}
+		}
+	}(rb.stop, rb.done)
+}
+
+// Close 停止后台刷新并写入缓冲中的全部条目
+func (rb *ReorderBuffer) Close() {
+	rb.mu.Lock()
+	stop, done := rb.stop, rb.done
+	rb.stop, rb.done = nil, nil
+	rb.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+	rb.Flush()
+}
+
+// Pending 返回缓冲中尚未刷新的条目数
+func (rb *ReorderBuffer) Pending() int {
+	rb.mu.RLock()
+	defer rb.mu.RUnlock()
+	return len(rb.pending)
+}
+
+// LateCount 返回超出乱序窗口、直接写入树中的条目数
+func (rb *ReorderBuffer) LateCount() int {
+	rb.mu.RLock()
+	defer rb.mu.RUnlock()
+	return rb.lateCount
+}
+
+// Lookup 查找 key，缓冲中的条目比树中的更新，优先返回
+func (rb *ReorderBuffer) Lookup(key interface{}, ti *ThreadInfo) interface{} {
+	k := key.(int)
+
+	rb.mu.RLock()
+	defer rb.mu.RUnlock()
+
+	pos := sort.Search(len(rb.pending), func(i int) bool {
+		return rb.pending[i].Key.(int) > k
+	})
+	if pos > 0 && rb.pending[pos-1].Key.(int) == k {
+		return rb.pending[pos-1].Value
+	}
+	return rb.tree.Lookup(key, ti)
+}
+
+// RangeLookup 从 minKey 开始收集至多 rng 个值，合并缓冲与树中的数据
+func (rb *ReorderBuffer) RangeLookup(minKey interface{}, rng int, ti *ThreadInfo) []interface{} {
+	return entryValues(rb.RangeLookupEntries(minKey, rng, ti))
+}
+
+// RangeLookupEntries 与 RangeLookup 相同，但返回带键的条目
+func (rb *ReorderBuffer) RangeLookupEntries(minKey interface{}, rng int, ti *ThreadInfo) []Entry {
+	k := minKey.(int)
+
+	rb.mu.RLock()
+	defer rb.mu.RUnlock()
+
+	pos := sort.Search(len(rb.pending), func(i int) bool {
+		return rb.pending[i].Key.(int) >= k
+	})
+	buffered := rb.pending[pos:]
+	stored := rb.tree.RangeLookupEntries(minKey, rng, ti)
+
+	// 归并两个有序序列。与 Lookup 相同，缓冲中的条目比树中的更新：键相同时只返回缓冲中的条目，
+	// 缓冲中同一个键的多个条目只返回最后到达的一个
+	results := make([]Entry, 0, rng)
+	i, j := 0, 0
+	for len(results) < rng && (i < len(stored) || j < len(buffered)) {
+		for j+1 < len(buffered) && compareIntKeys(buffered[j].Key, buffered[j+1].Key) == 0 {
+			j++
+		}
+		cmp := -1
+		if j < len(buffered) {
+			cmp = 1
+			if i < len(stored) {
+				cmp = compareIntKeys(stored[i].Key, buffered[j].Key)
+			}
+		}
+		if cmp < 0 {
+			results = append(results, stored[i])
+			i++
+			continue
+		}
+		if cmp == 0 {
+			i++
+		}
+		results = append(results, buffered[j])
+		j++
+	}
+	return results
+}
+
+// sealedCount 返回已封存的条目数，即键不大于 maxKey-Lateness 的前缀长度
+func (rb *ReorderBuffer) sealedCount() int {
+	if !rb.hasMax {
+		return 0
+	}
+	bound := rb.maxKey - rb.Lateness
+	return sort.Search(len(rb.pending), func(i int) bool {
+		return rb.pending[i].Key.(int) > bound
+	})
+}
+
+// flush 把 pending 的前 n 个条目写入树中，调用方需持有写锁。
+// 先写入树再从缓冲中移除，持有写锁期间读操作不会看到中间状态。
+func (rb *ReorderBuffer) flush(n int) {
+	if n == 0 {
+		return
+	}
+	rb.tree.insertSorted(rb.pending[:n], rb.ti)
+	rest := copy(rb.pending, rb.pending[n:])
+	for i := rest; i < len(rb.pending); i++ {
+		rb.pending[i] = Entry{}
+	}
+	rb.pending = rb.pending[:rest]
+}
@@ -0,0 +1,165 @@
+package blinkhash
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// jitteredKeys 生成 from..to 的键，以 jitter 为块在块内随机打乱，
+// 每个键到达时最多落后已见最大键 jitter-1
+func jitteredKeys(from, to, jitter int) []int {
+	keys := make([]int, 0, to-from+1)
+	for k := from; k <= to; k++ {
+		keys = append(keys, k)
+	}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < len(keys); i += jitter {
+		end := i + jitter
+		if end > len(keys) {
+			end = len(keys)
+		}
+		block := keys[i:end]
+		r.Shuffle(len(block), func(a, b int) { block[a], block[b] = block[b], block[a] })
+	}
+	return keys
+}
+
+func checkSortedRange(t *testing.T, entries []Entry, n int) {
+	t.Helper()
+	if len(entries) != n {
+		t.Fatalf("Expected %d entries, got %d", n, len(entries))
+	}
+	for i, e := range entries {
+		if e.Key.(int) != i+1 || e.Value.(int) != i+1 {
+			t.Fatalf("Expected key %d at %d, got %v", i+1, i, e)
+		}
+	}
+}
+
+func TestReorderBuffer_OutOfOrderPut(t *testing.T) {
+	tree := newTimeStampTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	rb := NewReorderBuffer(tree, 64)
+	rb.FlushBatch = 0 // 只在显式 Flush 时刷新
+
+	n := 3000
+	for _, k := range jitteredKeys(101, n, 32) {
+		rb.Put(k, k)
+	}
+	if rb.LateCount() != 0 {
+		t.Errorf("Expected no late entries, got %d", rb.LateCount())
+	}
+	if rb.Pending() != n-100 {
+		t.Errorf("Expected %d pending entries, got %d", n-100, rb.Pending())
+	}
+
+	// 未刷新的数据对读操作可见
+	if got := rb.Lookup(2500, ti); got != 2500 {
+		t.Errorf("Expected to find buffered key 2500, got %v", got)
+	}
+	if got := rb.Lookup(50, ti); got != 50 {
+		t.Errorf("Expected to find stored key 50, got %v", got)
+	}
+	checkSortedRange(t, rb.RangeLookupEntries(1, n+10, ti), n)
+	if got := rb.RangeLookup(1000, 10, ti); len(got) != 10 || got[0] != 1000 {
+		t.Errorf("Expected 10 values starting at 1000, got %v", got)
+	}
+
+	rb.Flush()
+	if rb.Pending() != 0 {
+		t.Errorf("Expected empty buffer after flush, got %d", rb.Pending())
+	}
+	checkSortedRange(t, tree.RangeLookupEntries(1, n+10, ti), n)
+}
+
+// 缓冲中的条目覆盖树中相同的键，范围查询与 Lookup 看到相同的值
+func TestReorderBuffer_RangeOverridesTree(t *testing.T) {
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	for k := 1; k <= 20; k++ {
+		tree.Insert(k, k, ti)
+	}
+	rb := NewReorderBuffer(tree, 100)
+	rb.FlushBatch = 0
+	rb.Put(5, "new")
+	rb.Put(25, 25)
+	rb.Put(5, "newer")
+
+	entries := rb.RangeLookupEntries(1, 100, ti)
+	if len(entries) != 21 {
+		t.Fatalf("Expected 21 distinct keys, got %d: %v", len(entries), entries)
+	}
+	for i, e := range entries {
+		if want := rb.Lookup(e.Key, ti); e.Value != want {
+			t.Errorf("entry %d: expected %v for key %v like Lookup, got %v", i, want, e.Key, e.Value)
+		}
+	}
+	if entries[4].Value != "newer" || entries[20].Key != 25 {
+		t.Errorf("Expected the latest buffered value for key 5, got %v", entries[4])
+	}
+	if got := rb.RangeLookupEntries(5, 2, ti); len(got) != 2 || got[0].Value != "newer" || got[1].Key != 6 {
+		t.Errorf("Expected [5=newer 6=6], got %v", got)
+	}
+}
+
+func TestReorderBuffer_SealedFlushAndLateEntries(t *testing.T) {
+	tree := newTimeStampTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	rb := NewReorderBuffer(tree, 10)
+	rb.FlushBatch = 8
+
+	for k := 101; k <= 200; k++ {
+		rb.Put(k, k)
+	}
+	// 窗口内的条目仍留在缓冲中
+	if rb.Pending() > 10+rb.FlushBatch {
+		t.Errorf("Expected sealed entries to be flushed, %d still pending", rb.Pending())
+	}
+	if rb.FlushSealed(); rb.Pending() != 10 {
+		t.Errorf("Expected only the lateness window to stay buffered, got %d", rb.Pending())
+	}
+
+	// 早于窗口的条目直接写入树中
+	rb.Put(150, -150)
+	if rb.LateCount() != 1 {
+		t.Errorf("Expected 1 late entry, got %d", rb.LateCount())
+	}
+	if got := len(rb.RangeLookup(1, 1000, ti)); got != 201 {
+		t.Errorf("Expected 201 entries, got %d", got)
+	}
+}
+
+func TestReorderBuffer_AutoFlush(t *testing.T) {
+	tree := newTimeStampTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	rb := NewReorderBuffer(tree, 16)
+	rb.FlushBatch = 0
+	rb.StartAutoFlush(time.Millisecond)
+
+	n := 2000
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		readerTi := NewThreadInfo(tree.GetEpoche())
+		for i := 0; i < 200; i++ {
+			entries := rb.RangeLookupEntries(1, n, readerTi)
+			for j := 1; j < len(entries); j++ {
+				if entries[j].Key.(int) <= entries[j-1].Key.(int) {
+					t.Errorf("Range results out of order at %d", j)
+					return
+				}
+			}
+		}
+	}()
+	for _, k := range jitteredKeys(101, n, 8) {
+		rb.Put(k, k)
+	}
+	<-done
+
+	rb.Close()
+	if rb.Pending() != 0 {
+		t.Errorf("Expected empty buffer after close, got %d", rb.Pending())
+	}
+	checkSortedRange(t, tree.RangeLookupEntries(1, n+10, ti), n)
+}
@@ -0,0 +1,318 @@
+package blinkhash
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+)
+
+// 树文件的格式(所有定长整数均为小端)：
+//
+//	文件头
+//	  magic             8 字节 "BLHTREE\x00"
+//	  version           uint32，当前为 treeFormatVersion
+//	  leaf cardinality  uint32，写入方 LNodeBTree 的容量
+//	  inner cardinality uint32，写入方 INode 的容量
+//	  key codec         uvarint 长度 + 名称，总是 "int"
+//	  value codec       uvarint 长度 + 名称
+//	叶子段，按键的顺序排列，每段对应写入方的一个叶子
+//	  count             uvarint，段中的条目数，大于 0
+//	  size              uvarint，负载的字节数
+//	  payload           第一个键(varint)，之后每个键与前一个键的差(uvarint，大于 0)，然后是 count 个值，
+//	                    最后是带过期时间的条目数(uvarint)和每个这样的条目在段中的下标(uvarint，递增)与过期时间(varint)
+//	结尾
+//	  0                 uvarint，叶子段结束
+//	  entries           uint64，条目总数
+//	  runs              uint64，叶子段数
+//	  checksum          uint32，之前所有字节的 CRC32C
+//
+// 值按文件头记录的编解码器编码，读取时按名称查找(见 codec.go)。
+// 读取方的节点容量与文件头不同时按自己的容量重新划分叶子。
+// 版本 1 的叶子段没有过期时间部分
+const (
+	treeMagic         = "BLHTREE\x00"
+	treeFormatVersion = 2
+	treeMaxRun        = 1 << 30
+)
+
+// ErrBadTreeFile 输入不是完整的树文件：文件头、叶子段或校验和不符合格式
+var ErrBadTreeFile = errors.New("blinkhash: malformed tree file")
+
+// SaveTo 把树的全部条目按键的顺序写入 w，格式见上，值使用 TaggedCodec 编码。
+// 写入期间其他线程可以继续修改树，写出的是调用时刻的快照
+func (bt *BTree) SaveTo(w io.Writer) error {
+	return bt.SaveToWithCodec(w, TaggedCodec)
+}
+
+// SaveToWithCodec 与 SaveTo 相同，值使用 values 编码。
+// values 需要已经注册，LoadFrom 才能找到它；遇到无法编码的值时返回 ErrCodecType
+func (bt *BTree) SaveToWithCodec(w io.Writer, values Codec) error {
+	if _, err := codecByName(values.Name()); err != nil {
+		return err
+	}
+	snap := bt.Snapshot()
+	defer snap.Release()
+	return bt.saveSnapshot(w, snap, values)
+}
+
+// leafFill 批量构建时每个叶子放入的条目数
+func leafFill() int {
+	return maxInt(1, int(float64(LNodeBTreeCardinality)*FillFactor))
+}
+
+// checksumWriter 写入的同时累计 CRC32C
+type checksumWriter struct {
+	w   io.Writer
+	crc hash.Hash32
+}
+
+func (cw checksumWriter) Write(p []byte) (int, error) {
+	cw.crc.Write(p)
+	return cw.w.Write(p)
+}
+
+func (bt *BTree) saveSnapshot(w io.Writer, snap *Snapshot, values Codec) error {
+	cw := checksumWriter{w: w, crc: crc32.New(walCRC)}
+	header := append([]byte(treeMagic), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(header[len(treeMagic):], treeFormatVersion)
+	header = binary.LittleEndian.AppendUint32(header, uint32(LNodeBTreeCardinality))
+	header = binary.LittleEndian.AppendUint32(header, uint32(INodeCardinality))
+	header = appendCodecNames(header, IntCodec, values)
+	if _, err := cw.Write(header); err != nil {
+		return err
+	}
+
+	fill := leafFill()
+	run := make([]Entry, 0, fill)
+	var entries, runs uint64
+	var buf, payload []byte
+	flush := func() (err error) {
+		payload = payload[:0]
+		prev := 0
+		for i, e := range run {
+			if i == 0 {
+				payload = binary.AppendVarint(payload, int64(e.Key.(int)))
+			} else {
+				payload = binary.AppendUvarint(payload, uint64(e.Key.(int)-prev))
+			}
+			prev = e.Key.(int)
+		}
+		expiring := 0
+		for _, e := range run {
+			value := e.Value
+			if ev, ok := value.(*expiringValue); ok {
+				value = ev.value
+				expiring++
+			}
+			if payload, err = values.Append(payload, value); err != nil {
+				return fmt.Errorf("key %d: %w", e.Key, err)
+			}
+		}
+		payload = binary.AppendUvarint(payload, uint64(expiring))
+		for i, e := range run {
+			if ev, ok := e.Value.(*expiringValue); ok {
+				payload = binary.AppendUvarint(payload, uint64(i))
+				payload = binary.AppendVarint(payload, ev.deadline)
+			}
+		}
+		buf = binary.AppendUvarint(buf[:0], uint64(len(run)))
+		buf = binary.AppendUvarint(buf, uint64(len(payload)))
+		buf = append(buf, payload...)
+		entries += uint64(len(run))
+		runs++
+		run = run[:0]
+		_, err = cw.Write(buf)
+		return err
+	}
+
+	now := ttlNow()
+	it := snap.rawIterator(math.MinInt, NewThreadInfo(bt.epoche))
+	for e, ok := it.Next(); ok; e, ok = it.Next() {
+		if expired(e.Value, now) {
+			continue
+		}
+		run = append(run, e)
+		if len(run) == fill {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if len(run) > 0 {
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+
+	trailer := binary.AppendUvarint(buf[:0], 0)
+	trailer = binary.LittleEndian.AppendUint64(trailer, entries)
+	trailer = binary.LittleEndian.AppendUint64(trailer, runs)
+	if _, err := cw.Write(trailer); err != nil {
+		return err
+	}
+	_, err := w.Write(binary.LittleEndian.AppendUint32(nil, cw.crc.Sum32()))
+	return err
+}
+
+// checksumReader 读取的同时累计 CRC32C
+type checksumReader struct {
+	r   *bufio.Reader
+	crc uint32
+}
+
+func (cr *checksumReader) ReadByte() (byte, error) {
+	b, err := cr.r.ReadByte()
+	if err == nil {
+		cr.crc = crc32.Update(cr.crc, walCRC, []byte{b})
+	}
+	return b, err
+}
+
+func (cr *checksumReader) Read(p []byte) (int, error) {
+	n, err := io.ReadFull(cr.r, p)
+	cr.crc = crc32.Update(cr.crc, walCRC, p[:n])
+	return n, err
+}
+
+// LoadFrom 读取 SaveTo 写出的树。条目直接按顺序装入新叶子，再自底向上建立内部节点，
+// 不经过逐条 Insert。r 可能被读到树文件结尾之后。使用 DefaultTreeOptions
+func LoadFrom(r io.Reader) (*BTree, error) {
+	return LoadFromWithOptions(r, DefaultTreeOptions)
+}
+
+// LoadFromWithOptions 与 LoadFrom 相同，读出的树使用指定的配置
+func LoadFromWithOptions(r io.Reader, opts TreeOptions) (*BTree, error) {
+	cr := &checksumReader{r: bufio.NewReaderSize(r, 64<<10)}
+	header := make([]byte, len(treeMagic)+12)
+	if _, err := cr.Read(header); err != nil {
+		return nil, ErrBadTreeFile
+	}
+	if string(header[:len(treeMagic)]) != treeMagic {
+		return nil, ErrBadTreeFile
+	}
+	version := binary.LittleEndian.Uint32(header[len(treeMagic):])
+	if version != 1 && version != treeFormatVersion {
+		return nil, fmt.Errorf("blinkhash: unsupported tree format version %d", version)
+	}
+	values, err := readCodecNames(cr)
+	if err == errCorrupt {
+		return nil, ErrBadTreeFile
+	} else if err != nil {
+		return nil, err
+	}
+
+	bt := NewBTreeWithOptions(opts)
+	fill := leafFill()
+	var leaves []*LNodeBTree
+	var leaf *LNodeBTree
+	var entries, runs uint64
+	prev := 0
+	var payload []byte
+	for {
+		count, err := binary.ReadUvarint(cr)
+		if err != nil {
+			return nil, ErrBadTreeFile
+		}
+		if count == 0 {
+			break
+		}
+		size, err := binary.ReadUvarint(cr)
+		if err != nil || size > treeMaxRun || count > size {
+			return nil, ErrBadTreeFile
+		}
+		if uint64(cap(payload)) < size {
+			payload = make([]byte, size)
+		}
+		payload = payload[:size]
+		if _, err := cr.Read(payload); err != nil {
+			return nil, ErrBadTreeFile
+		}
+
+		d := &decoder{data: payload}
+		keys := make([]int, count)
+		for i := range keys {
+			if i == 0 {
+				keys[i] = int(d.varint())
+				if entries > 0 && keys[i] <= prev {
+					return nil, ErrBadTreeFile
+				}
+			} else {
+				delta := d.uvarint()
+				keys[i] = keys[i-1] + int(delta)
+				if delta == 0 || keys[i] <= keys[i-1] {
+					return nil, ErrBadTreeFile
+				}
+			}
+		}
+		runValues := make([]interface{}, count)
+		for i := range runValues {
+			runValues[i] = d.value(values)
+		}
+		if version >= 2 {
+			n, last := d.uvarint(), -1
+			for j := uint64(0); j < n && d.err == nil; j++ {
+				i, deadline := int(d.uvarint()), d.varint()
+				if i <= last || i >= len(runValues) {
+					return nil, ErrBadTreeFile
+				}
+				runValues[i] = &expiringValue{value: runValues[i], deadline: deadline}
+				last = i
+			}
+			if n > 0 {
+				bt.markExpiring()
+			}
+		}
+		if d.err != nil {
+			return nil, ErrBadTreeFile
+		}
+		for i, key := range keys {
+			value := runValues[i]
+			if leaf == nil || leaf.loaded().len() == fill {
+				leaf = NewLNodeBTree(0)
+				leaf.opts = bt.opts
+				if n := len(leaves); n > 0 {
+					leaves[n-1].siblingPtr = leaf
+				}
+				leaves = append(leaves, leaf)
+			}
+			leaf.reserve(1).appendEntry(key, value)
+			leaf.count++
+			leaf.HighKey = key
+		}
+		if d.err != nil || len(d.data) != 0 {
+			return nil, ErrBadTreeFile
+		}
+		prev = keys[count-1]
+		entries += count
+		runs++
+	}
+
+	trailer := make([]byte, 16)
+	if _, err := cr.Read(trailer); err != nil {
+		return nil, ErrBadTreeFile
+	}
+	sum := cr.crc
+	var checksum [4]byte
+	if _, err := io.ReadFull(cr.r, checksum[:]); err != nil {
+		return nil, ErrBadTreeFile
+	}
+	if binary.LittleEndian.Uint64(trailer) != entries || binary.LittleEndian.Uint64(trailer[8:]) != runs ||
+		binary.LittleEndian.Uint32(checksum[:]) != sum {
+		return nil, ErrBadTreeFile
+	}
+
+	if len(leaves) > 0 {
+		keys := make([]interface{}, len(leaves))
+		keys[0] = leaves[0].HighKey
+		for i := 1; i < len(leaves); i++ {
+			keys[i] = leaves[i-1].HighKey
+		}
+		bt.root = newRootForNodes(keys, nodeInterfaceSliceForBTreeNode(leaves))
+	}
+	return bt, nil
+}
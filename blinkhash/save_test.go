@@ -0,0 +1,192 @@
+package blinkhash
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSaveTo_RoundTrip(t *testing.T) {
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	for k := 0; k <= 10000; k++ {
+		tree.Insert(k*7, k, ti)
+	}
+	tree.Update(0, "zero", ti)
+	tree.Update(7, []byte("seven"), ti)
+	tree.Update(14, 1.5, ti)
+	tree.Update(21, false, ti)
+	tree.Update(28, nil, ti)
+	tree.Insert(math.MaxInt, "max", ti)
+
+	var buf bytes.Buffer
+	if err := tree.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadFrom(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lti := NewThreadInfo(loaded.GetEpoche())
+	expected := tree.RangeLookupEntries(math.MinInt, 20000, ti)
+	got := loaded.RangeLookupEntries(math.MinInt, 20000, lti)
+	if len(got) != len(expected) || len(got) != 10002 {
+		t.Fatalf("Expected %d entries, got %d", len(expected), len(got))
+	}
+	for i := range expected {
+		if got[i].Key != expected[i].Key {
+			t.Fatalf("entry %d: expected key %v, got %v", i, expected[i].Key, got[i].Key)
+		}
+		if b, ok := expected[i].Value.([]byte); ok {
+			if !bytes.Equal(b, got[i].Value.([]byte)) {
+				t.Fatalf("key %v: expected %v, got %v", got[i].Key, b, got[i].Value)
+			}
+		} else if got[i].Value != expected[i].Value {
+			t.Fatalf("key %v: expected %v, got %v", got[i].Key, expected[i].Value, got[i].Value)
+		}
+	}
+	for k := 5; k <= 10000; k += 97 {
+		if got := loaded.Lookup(k*7, lti); got != k {
+			t.Fatalf("key %d: expected %d, got %v", k*7, k, got)
+		}
+	}
+
+	// 批量构建的树可以继续正常写入
+	for k := 0; k <= 10000; k++ {
+		loaded.Insert(k*7+1, -k, lti)
+	}
+	loaded.Remove(7, lti)
+	if got := len(loaded.RangeLookup(math.MinInt, 30000, lti)); got != 20002 {
+		t.Errorf("Expected 20002 entries after further writes, got %d", got)
+	}
+	if got := loaded.Lookup(7*100+1, lti); got != -100 {
+		t.Errorf("Expected -100, got %v", got)
+	}
+}
+
+func TestSaveTo_Empty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewBTree().SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadFrom(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ti := NewThreadInfo(loaded.GetEpoche())
+	if got := len(loaded.RangeLookup(0, 10, ti)); got != 0 {
+		t.Fatalf("Expected an empty tree, got %d entries", got)
+	}
+	loaded.Insert(1, 1, ti)
+	if got := loaded.Lookup(1, ti); got != 1 {
+		t.Errorf("Expected 1, got %v", got)
+	}
+}
+
+func TestLoadFrom_Corrupt(t *testing.T) {
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	for k := 0; k < 1000; k++ {
+		tree.Insert(k, k, ti)
+	}
+	var buf bytes.Buffer
+	if err := tree.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	flipped := append([]byte(nil), data...)
+	flipped[len(flipped)/2] ^= 0x40
+	if _, err := LoadFrom(bytes.NewReader(flipped)); !errors.Is(err, ErrBadTreeFile) {
+		t.Errorf("Expected ErrBadTreeFile for a flipped bit, got %v", err)
+	}
+	if _, err := LoadFrom(bytes.NewReader(data[:len(data)-1])); !errors.Is(err, ErrBadTreeFile) {
+		t.Errorf("Expected ErrBadTreeFile for a truncated file, got %v", err)
+	}
+	if _, err := LoadFrom(bytes.NewReader([]byte("not a tree file at all"))); !errors.Is(err, ErrBadTreeFile) {
+		t.Errorf("Expected ErrBadTreeFile for garbage, got %v", err)
+	}
+}
+
+// 写线程每批写入一个数据点和指向它的 latest，保存出的文件中 latest 指向的点必须存在
+func TestSaveTo_ConcurrentWriters(t *testing.T) {
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	const latest, points, rounds = 0, 1_000_000, 20000
+	for k := 1; k <= 5000; k++ {
+		tree.Insert(points*2+k, k, ti)
+	}
+
+	var stop atomic.Bool
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		wti := NewThreadInfo(tree.GetEpoche())
+		wb := tree.NewWriteBatch()
+		for i := 1; i <= rounds && !stop.Load(); i++ {
+			wb.Reset()
+			wb.Put(points+i, i)
+			wb.Put(latest, i)
+			wb.Commit(wti)
+		}
+	}()
+
+	for save := 0; save < 20; save++ {
+		var buf bytes.Buffer
+		if err := tree.SaveTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := LoadFrom(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lti := NewThreadInfo(loaded.GetEpoche())
+		i, ok := loaded.Lookup(latest, lti).(int)
+		if !ok {
+			continue
+		}
+		if got := len(loaded.RangeLookup(points+1, points, lti)); got != i+5000 {
+			stop.Store(true)
+			wg.Wait()
+			t.Fatalf("latest is %d but the file holds %d points", i, got-5000)
+		}
+	}
+	stop.Store(true)
+	wg.Wait()
+}
+
+// BenchmarkLoadFrom 比较从文件批量构建与逐条 Insert 重建同一棵树的开销
+func BenchmarkLoadFrom(b *testing.B) {
+	const n = 100000
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	for k := 0; k < n; k++ {
+		tree.Insert(k, k, ti)
+	}
+	var buf bytes.Buffer
+	if err := tree.SaveTo(&buf); err != nil {
+		b.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	b.Run("load", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := LoadFrom(bytes.NewReader(data)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("insert", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			rebuilt := NewBTree()
+			rti := NewThreadInfo(rebuilt.GetEpoche())
+			for k := 0; k < n; k++ {
+				rebuilt.Insert(k, k, rti)
+			}
+		}
+	})
+}
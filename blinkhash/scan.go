@@ -0,0 +1,202 @@
+package blinkhash
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// ScanConsistency 范围查询的一致性级别
+type ScanConsistency int
+
+const (
+	// ScanPerLeaf 逐个叶子校验版本，与 RangeLookup 相同：每个叶子内的结果一致，
+	// 但不同叶子的结果可能来自不同时刻，一次扫描可能看到某个插入，
+	// 却错过另一个更早提交在其他叶子中的插入
+	ScanPerLeaf ScanConsistency = iota
+	// ScanSerializable 收集完成后重新校验所有访问过的叶子，任一叶子发生变化就重试，
+	// 返回的结果是校验时刻整个范围的一致快照，代价是写入频繁时需要多次重试
+	ScanSerializable
+)
+
+func (c ScanConsistency) String() string {
+	switch c {
+	case ScanPerLeaf:
+		return "per-leaf"
+	case ScanSerializable:
+		return "serializable"
+	}
+	return fmt.Sprintf("ScanConsistency(%d)", int(c))
+}
+
+// defaultScanSerializableRetries 一致性扫描默认的乐观重试次数
+const defaultScanSerializableRetries = 8
+
+// scanSerializableRetries 返回一致性扫描乐观重试的次数。超过后改为在收集每个叶子后
+// 将其写锁住直到扫描结束，避免写入持续不断时扫描一直无法完成，见 TreeOptions.ScanSerializableRetries
+func (o *TreeOptions) scanSerializableRetries() int {
+	if o.ScanSerializableRetries < 0 {
+		return 0
+	}
+	if o.ScanSerializableRetries == 0 {
+		return defaultScanSerializableRetries
+	}
+	return o.ScanSerializableRetries
+}
+
+// scanPreallocMax 一致性扫描结果预分配的最大条目数
+const scanPreallocMax = 4096
+
+// scannedLeaf 一致性扫描访问过的叶子及读取时的版本
+type scannedLeaf struct {
+	leaf    LeafNodeInterface
+	version uint64
+}
+
+// Scan 按 consistency 指定的一致性级别从 minKey 开始收集最多 rng 个值
+func (bt *BTree) Scan(minKey interface{}, rng int, consistency ScanConsistency, ti *ThreadInfo) []interface{} {
+	return entryValues(bt.ScanEntries(minKey, rng, consistency, ti))
+}
+
+// ScanEntries 与 Scan 相同，但返回带键的条目，结果按键有序，包含 minKey 本身
+func (bt *BTree) ScanEntries(minKey interface{}, rng int, consistency ScanConsistency, ti *ThreadInfo) []Entry {
+	switch consistency {
+	case ScanPerLeaf:
+		return bt.RangeLookupEntries(minKey, rng, ti)
+	case ScanSerializable:
+		return bt.scanSerializable(minKey, rng, ti)
+	}
+	panic(fmt.Sprintf("unknown scan consistency %v", consistency))
+}
+
+// scanSerializable 先乐观地收集并记录每个叶子的版本，最后统一校验：
+// 所有叶子的版本从读取到校验都没有变化，说明校验时刻范围内的内容与收集到的结果相同。
+// 叶子的写入、分裂和转换都在节点写锁内完成并增加版本，只有哈希叶子的插入只加桶锁，
+// 而哈希叶子非空时会先被转换为 B 树叶子，空哈希叶子额外校验计数仍为 0。
+func (bt *BTree) scanSerializable(minKey interface{}, rng int, ti *ThreadInfo) []Entry {
+	eg := NewEpocheGuard(ti)
+	defer eg.Release()
+
+	var visited []scannedLeaf
+	retries := bt.opts.scanSerializableRetries()
+	for attempt := 0; ; attempt++ {
+		pin := attempt >= retries
+		var results []Entry
+		var ok bool
+		results, visited, ok = bt.collectRange(minKey, rng, visited[:0], pin, ti)
+		if ok {
+			ok = validateScanned(visited, pin)
+		}
+		if pin {
+			unpinScanned(visited)
+			if bt.pool != nil {
+				// 锁住期间换入的叶子没有被换出，解锁后补上
+				bt.pool.maybeEvict()
+			}
+		}
+		if ok {
+			return results
+		}
+	}
+}
+
+// collectRange 从 minKey 所在叶子开始沿兄弟指针收集条目，把访问过的叶子记录到 visited。
+// pin 为 true 时收集完每个叶子后将其从读取时的版本升级为写锁，锁住的叶子同样记录在 visited 中，
+// 由调用方释放。任何版本变化或需要转换时返回 false，调用方重试。
+func (bt *BTree) collectRange(minKey interface{}, rng int, visited []scannedLeaf, pin bool, ti *ThreadInfo) ([]Entry, []scannedLeaf, bool) {
+	// rng 可能远大于实际条目数(例如扫描到末尾)，预分配不超过 scanPreallocMax
+	prealloc := rng
+	if prealloc > scanPreallocMax {
+		prealloc = scanPreallocMax
+	}
+	results := make([]Entry, 0, prealloc)
+	expires := bt.expires()
+	leaf, leafVersion := bt.findLeaf(minKey)
+	continued := false
+	for len(results) < rng {
+		locked := false
+		if lb, ok := leaf.(*LNodeBTree); ok && pin && lb.evicted() && bt.Err() == nil {
+			// 先锁住再换入，锁住的叶子不会被换出，范围跨越的叶子多于缓冲池容量时也不会反复换入换出。
+			// 换入失败时树已经出错，重试时不再换入，换出的叶子当作空叶子
+			if success, needRestart := lb.TryUpgradeWriteLock(leafVersion); !success || needRestart {
+				return nil, visited, false
+			}
+			if lb.pageInLocked() != nil {
+				lb.WriteUnlock()
+				return nil, visited, false
+			}
+			locked = true
+		}
+		collected, retCode, _ := leaf.RangeLookUpEntries(minKey, scanUpTo(rng-len(results), expires), continued, leafVersion)
+		if retCode == NeedConvert {
+			// 转换成功时旧叶子仍处于锁定状态，需要在这里释放；失败时 Convert 已自行解锁
+			if bt.convert(leaf, leafVersion, ti) {
+				leaf.WriteUnlock()
+			}
+			return nil, visited, false
+		}
+		if retCode == NeedRestart {
+			return nil, visited, false
+		}
+		continued = true
+
+		if pin && !locked {
+			if success, needRestart := leaf.TryUpgradeWriteLock(leafVersion); !success || needRestart {
+				return nil, visited, false
+			}
+		}
+		visited = append(visited, scannedLeaf{leaf: leaf, version: leafVersion})
+
+		sibling := leaf.GetSiblingPtr()
+		if !pin {
+			leafEndVersion, needRestart := leaf.GetVersion()
+			if needRestart || leafVersion != leafEndVersion {
+				return nil, visited, false
+			}
+		}
+		results = appendScanned(results, collected, rng, expires)
+		if len(results) >= rng || sibling == nil {
+			break
+		}
+
+		siblingVersion, needRestart := sibling.TryReadLock()
+		if needRestart {
+			return nil, visited, false
+		}
+		lf, ok := sibling.(LeafNodeInterface)
+		if !ok {
+			panic("expected LeafNodeInterface")
+		}
+		leaf, leafVersion = lf, siblingVersion
+	}
+	return results, visited, true
+}
+
+// validateScanned 确认访问过的叶子从读取到现在都没有变化。
+// 被锁住的叶子不会变化，只需要检查空哈希叶子：插入哈希叶子只加桶锁，
+// 锁住节点之前已经通过版本检查的插入仍可能完成。
+func validateScanned(visited []scannedLeaf, pinned bool) bool {
+	for _, s := range visited {
+		if !pinned {
+			version, needRestart := s.leaf.GetVersion()
+			if needRestart || version != s.version {
+				return false
+			}
+		}
+		if lh, ok := s.leaf.(*LNodeHash); ok && atomic.LoadInt32(&lh.count) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// unpinScanned 释放 collectRange 锁住的叶子。哈希叶子只释放节点锁，
+// LNodeHash.WriteUnlock 会同时释放桶锁，而这些桶锁可能属于正在插入的其他线程
+func unpinScanned(visited []scannedLeaf) {
+	for _, s := range visited {
+		if lh, ok := s.leaf.(*LNodeHash); ok {
+			lh.Node.WriteUnlock()
+		} else {
+			s.leaf.WriteUnlock()
+		}
+	}
+}
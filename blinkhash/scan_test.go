@@ -0,0 +1,113 @@
+package blinkhash
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestScan_MatchesRangeLookup(t *testing.T) {
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	n := 20000
+	for k := 1; k <= n; k++ {
+		tree.Insert(k*3, k, ti)
+	}
+	for _, c := range []ScanConsistency{ScanPerLeaf, ScanSerializable} {
+		got := tree.ScanEntries(301, 5000, c, ti)
+		if len(got) != 5000 {
+			t.Fatalf("%v: expected 5000 entries, got %d", c, len(got))
+		}
+		for i, e := range got {
+			if e.Key != (i+101)*3 || e.Value != i+101 {
+				t.Fatalf("%v: entry %d: expected key %d, got %v", c, i, (i+101)*3, e)
+			}
+		}
+	}
+	if got := tree.Scan(n*3+1, 10, ScanSerializable, ti); len(got) != 0 {
+		t.Errorf("Expected no values past the last key, got %v", got)
+	}
+}
+
+// 写线程先插入低区间的键再插入高区间的键，任何一致快照中高区间的键数都不会超过低区间
+func TestScan_SerializableSnapshot(t *testing.T) {
+	// 负数表示不做乐观重试，第一次收集就锁住叶子
+	for _, retries := range []int{defaultScanSerializableRetries, -1} {
+		t.Run(fmt.Sprintf("retries=%d", retries), func(t *testing.T) {
+			opts := DefaultTreeOptions
+			opts.ScanSerializableRetries = retries
+			tree := NewBTreeWithOptions(opts)
+			ti := NewThreadInfo(tree.GetEpoche())
+			const low, high, pairs = 1_000_000, 2_000_000, 20000
+			// 预先填充并转换为 B 树叶子，让两个区间相隔多个叶子
+			for k := 1; k <= 5000; k++ {
+				tree.Insert(low+pairs+k*10, 0, ti)
+			}
+			tree.RangeLookup(0, 5000, ti)
+
+			var stop atomic.Bool
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				wti := NewThreadInfo(tree.GetEpoche())
+				for i := 0; i < pairs && !stop.Load(); i++ {
+					tree.Insert(low+i, i, wti)
+					tree.Insert(high+i, i, wti)
+				}
+			}()
+
+			for scans := 0; scans < 200; scans++ {
+				lows, highs := 0, 0
+				for _, e := range tree.ScanEntries(low, 1<<30, ScanSerializable, ti) {
+					k := e.Key.(int)
+					switch {
+					case k < low+pairs:
+						lows++
+					case k >= high:
+						highs++
+					}
+				}
+				if highs > lows {
+					stop.Store(true)
+					wg.Wait()
+					t.Fatalf("scan %d saw %d high keys but only %d low keys", scans, highs, lows)
+				}
+			}
+			stop.Store(true)
+			wg.Wait()
+		})
+	}
+}
+
+func TestScan_EmptyHashLeafInsert(t *testing.T) {
+	// 空哈希叶子的插入只加桶锁，一致性扫描需要通过计数发现它
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	if got := tree.ScanEntries(0, 10, ScanSerializable, ti); len(got) != 0 {
+		t.Fatalf("Expected empty scan, got %v", got)
+	}
+	tree.Insert(5, 5, ti)
+	if got := tree.ScanEntries(0, 10, ScanSerializable, ti); len(got) != 1 || got[0].Key != 5 {
+		t.Fatalf("Expected to see key 5, got %v", got)
+	}
+}
+
+// BenchmarkScan 比较没有并发写入时两种一致性级别的开销
+func BenchmarkScan(b *testing.B) {
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	n := 1000000
+	for k := 1; k <= n; k++ {
+		tree.Insert(k, k, ti)
+	}
+	tree.RangeLookup(0, n, ti)
+	for _, c := range []ScanConsistency{ScanPerLeaf, ScanSerializable} {
+		b.Run(c.String(), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				tree.ScanEntries(i%(n-1000)+1, 1000, c, ti)
+			}
+		})
+	}
+}
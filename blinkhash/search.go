@@ -0,0 +1,241 @@
+package blinkhash
+
+import (
+	"fmt"
+)
+
+// SearchStrategy 节点内查找下界(第一个不小于 key 的位置)的策略
+type SearchStrategy int
+
+const (
+	SearchAuto          SearchStrategy = iota // 按节点大小和键类型自动选择
+	SearchLinear                              // 顺序扫描，小节点最快
+	SearchBinary                              // 无分支二分查找
+	SearchInterpolation                       // 插值查找，适合均匀分布的整数时间戳
+)
+
+func (s SearchStrategy) String() string {
+	switch s {
+	case SearchAuto:
+		return "auto"
+	case SearchLinear:
+		return "linear"
+	case SearchBinary:
+		return "binary"
+	case SearchInterpolation:
+		return "interpolation"
+	}
+	return fmt.Sprintf("SearchStrategy(%d)", int(s))
+}
+
+// defaultSearchLinearMax TreeOptions.SearchLinearMax 的默认值。
+// 由 BenchmarkLowerBound 得出：[]int 和 []Entry 都在 14 个键左右持平，32 个键时二分查找快约 40%。
+// 默认页大小下 INode 和 LNodeBTree 都只有 14 个键，仍然顺序扫描。
+const defaultSearchLinearMax = 16
+
+// defaultSearchInterpolationMin TreeOptions.SearchInterpolationMin 的默认值。
+// 均匀时间戳上 256 个键时插值查找约为二分查找的 2/3，4096 个键时约为 1/3。
+const defaultSearchInterpolationMin = 256
+
+// nodeSearch 节点内查找使用的策略和自动选择的阈值，由所属树的 TreeOptions 给出
+type nodeSearch struct {
+	strategy         SearchStrategy
+	linearMax        int
+	interpolationMin int
+}
+
+// search 返回使用 strategy 和本配置阈值的查找方式
+func (o *TreeOptions) search(strategy SearchStrategy) nodeSearch {
+	s := nodeSearch{strategy: strategy, linearMax: o.SearchLinearMax, interpolationMin: o.SearchInterpolationMin}
+	if s.linearMax <= 0 {
+		s.linearMax = defaultSearchLinearMax
+	}
+	if s.interpolationMin <= 0 {
+		s.interpolationMin = defaultSearchInterpolationMin
+	}
+	return s
+}
+
+// innerSearch 内部节点的查找方式，见 TreeOptions.INodeSearch
+func (o *TreeOptions) innerSearch() nodeSearch {
+	return o.search(o.INodeSearch)
+}
+
+// leafSearch B 树叶子和追加叶子的查找方式，见 TreeOptions.LNodeBTreeSearch
+func (o *TreeOptions) leafSearch() nodeSearch {
+	return o.search(o.LNodeBTreeSearch)
+}
+
+// interpolationProbes 插值查找最多猜测的次数，之后退回二分查找，
+// 键分布不均匀时最坏情况仍为 O(log n)
+const interpolationProbes = 2
+
+// interpolationWindow 插值猜测位置两侧各检查的键数
+const interpolationWindow = 8
+
+// chooseSearch 解析 SearchAuto：小节点顺序扫描，大节点的整数键使用插值查找，其余二分查找。
+// 插值查找需要直接读取 []int 键，intKeys 为 false(键是 interface{}) 时退回二分查找。
+func chooseSearch(s nodeSearch, n int, intKeys bool) SearchStrategy {
+	switch s.strategy {
+	case SearchAuto:
+		if n <= s.linearMax {
+			return SearchLinear
+		}
+		if intKeys && n >= s.interpolationMin {
+			return SearchInterpolation
+		}
+		return SearchBinary
+	case SearchInterpolation:
+		if !intKeys {
+			return SearchBinary
+		}
+	}
+	return s.strategy
+}
+
+// lowerBoundInts 在有序的 keys 中查找第一个不小于 key 的位置，不存在时返回 len(keys)
+func lowerBoundInts(keys []int, key int, s nodeSearch) int {
+	switch chooseSearch(s, len(keys), true) {
+	case SearchBinary:
+		return lowerBoundBinary(keys, key)
+	case SearchInterpolation:
+		if s.strategy == SearchAuto && !looksUniform(keys) {
+			return lowerBoundBinary(keys, key)
+		}
+		return lowerBoundInterpolation(keys, key, s.linearMax)
+	default:
+		return lowerBoundLinear(keys, key)
+	}
+}
+
+// looksUniform 抽查四分位处的键是否接近首尾之间的线性插值，
+// 自动选择时只对近似均匀分布的键使用插值查找
+func looksUniform(keys []int) bool {
+	n := len(keys)
+	first, last := float64(keys[0]), float64(keys[n-1])
+	span := last - first
+	if span <= 0 {
+		return false
+	}
+	for q := 1; q <= 3; q++ {
+		i := n * q / 4
+		expected := first + span*float64(i)/float64(n-1)
+		if d := float64(keys[i]) - expected; d > span/16 || d < -span/16 {
+			return false
+		}
+	}
+	return true
+}
+
+func lowerBoundLinear(keys []int, key int) int {
+	for i, k := range keys {
+		if k >= key {
+			return i
+		}
+	}
+	return len(keys)
+}
+
+// lowerBoundBinary 无分支二分查找：每轮只根据比较结果移动 base，
+// 循环次数只取决于长度，编译器可以把比较生成条件传送指令
+func lowerBoundBinary(keys []int, key int) int {
+	n := len(keys)
+	if n == 0 {
+		return 0
+	}
+	base := 0
+	for n > 1 {
+		half := n / 2
+		if keys[base+half-1] < key {
+			base += half
+		}
+		n -= half
+	}
+	if keys[base] < key {
+		base++
+	}
+	return base
+}
+
+// lowerBoundInterpolation 按键值在首尾之间的比例猜测位置，再检查猜测位置附近的窗口：
+// 结果落在窗口内时只在窗口内二分，否则丢弃窗口一侧继续猜测，最后交给二分查找。
+// 均匀分布的时间戳通常一次猜测就能定位，分布不均匀时每次猜测只多两次比较。
+// 剩余范围不超过 linearMax 个键时不再猜测
+func lowerBoundInterpolation(keys []int, key int, linearMax int) int {
+	lo, hi := 0, len(keys) // 结果位于 [lo, hi]
+	for probe := 0; probe < interpolationProbes && hi-lo > linearMax; probe++ {
+		first, last := keys[lo], keys[hi-1]
+		if key <= first {
+			return lo
+		}
+		if key > last {
+			return hi
+		}
+		// first < key <= last，用浮点数计算比例以避免整数溢出
+		pos := lo + int(float64(hi-1-lo)*(float64(key)-float64(first))/(float64(last)-float64(first)))
+		l, h := pos-interpolationWindow, pos+interpolationWindow
+		if l < lo {
+			l = lo
+		}
+		if h > hi-1 {
+			h = hi - 1
+		}
+		switch {
+		case keys[l] >= key:
+			hi = l
+		case keys[h] < key:
+			lo = h + 1
+		default:
+			// keys[l] < key <= keys[h]
+			return l + 1 + lowerBoundBinary(keys[l+1:h+1], key)
+		}
+	}
+	return lo + lowerBoundBinary(keys[lo:hi], key)
+}
+
+// lowerBoundEntries 在前 n 个有序条目中查找第一个键不小于 key 的位置，条目的键必须是 int
+func lowerBoundEntries(entries []Entry, key int, s nodeSearch) int {
+	switch chooseSearch(s, len(entries), false) {
+	case SearchBinary:
+		return lowerBoundEntriesBinary(entries, key)
+	default:
+		return lowerBoundEntriesLinear(entries, key)
+	}
+}
+
+func lowerBoundEntriesLinear(entries []Entry, key int) int {
+	for i := range entries {
+		if entryIntKey(entries[i]) >= key {
+			return i
+		}
+	}
+	return len(entries)
+}
+
+func lowerBoundEntriesBinary(entries []Entry, key int) int {
+	n := len(entries)
+	if n == 0 {
+		return 0
+	}
+	base := 0
+	for n > 1 {
+		half := n / 2
+		if entryIntKey(entries[base+half-1]) < key {
+			base += half
+		}
+		n -= half
+	}
+	if entryIntKey(entries[base]) < key {
+		base++
+	}
+	return base
+}
+
+// entryIntKey 取出条目的 int 键
+func entryIntKey(entry Entry) int {
+	k, ok := entry.Key.(int)
+	if !ok {
+		panic(fmt.Sprintf("entry key must be int, got %T", entry.Key))
+	}
+	return k
+}
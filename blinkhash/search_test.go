@@ -0,0 +1,148 @@
+package blinkhash
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// sortedKeys 生成 n 个有序键；skewed 为 true 时键集中在前端，模拟不均匀分布
+func sortedKeys(r *rand.Rand, n int, skewed bool) []int {
+	keys := make([]int, n)
+	for i := range keys {
+		if skewed {
+			keys[i] = int(r.ExpFloat64() * 1000)
+		} else {
+			keys[i] = r.Intn(n * 10)
+		}
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+func TestLowerBound_StrategiesAgree(t *testing.T) {
+	r := rand.New(rand.NewSource(17))
+	strategies := []SearchStrategy{SearchAuto, SearchLinear, SearchBinary, SearchInterpolation}
+	for _, n := range []int{0, 1, 2, 3, 14, 33, 300, 2000} {
+		for _, skewed := range []bool{false, true} {
+			keys := sortedKeys(r, n, skewed)
+			entries := make([]Entry, n)
+			for i, k := range keys {
+				entries[i] = Entry{Key: k}
+			}
+			probes := []int{-1, 1 << 40}
+			for i := 0; i < 200; i++ {
+				probes = append(probes, r.Intn(n*10+2)-1)
+			}
+			probes = append(probes, keys...)
+			for _, key := range probes {
+				expected := sort.SearchInts(keys, key)
+				for _, s := range strategies {
+					search := builtinTreeOptions.search(s)
+					if got := lowerBoundInts(keys, key, search); got != expected {
+						t.Fatalf("n=%d skewed=%v %v: lower bound of %d expected %d, got %d", n, skewed, s, key, expected, got)
+					}
+					if got := lowerBoundEntries(entries, key, search); got != expected {
+						t.Fatalf("n=%d skewed=%v %v entries: lower bound of %d expected %d, got %d", n, skewed, s, key, expected, got)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestChooseSearch(t *testing.T) {
+	tuned := &TreeOptions{SearchLinearMax: 64, SearchInterpolationMin: 1024}
+	cases := []struct {
+		opts     *TreeOptions
+		strategy SearchStrategy
+		n        int
+		intKeys  bool
+		expected SearchStrategy
+	}{
+		{&builtinTreeOptions, SearchAuto, LNodeBTreeCardinality, true, SearchLinear},
+		{&builtinTreeOptions, SearchAuto, INodeCardinality, false, SearchLinear},
+		{&builtinTreeOptions, SearchAuto, defaultSearchLinearMax + 1, true, SearchBinary},
+		{&builtinTreeOptions, SearchAuto, defaultSearchInterpolationMin, true, SearchInterpolation},
+		{&builtinTreeOptions, SearchAuto, defaultSearchInterpolationMin, false, SearchBinary},
+		{&builtinTreeOptions, SearchInterpolation, 8, false, SearchBinary},
+		{&builtinTreeOptions, SearchLinear, 4096, true, SearchLinear},
+		// 每棵树可以使用自己的阈值，零值取默认值
+		{tuned, SearchAuto, 64, true, SearchLinear},
+		{tuned, SearchAuto, defaultSearchInterpolationMin, true, SearchBinary},
+		{tuned, SearchAuto, 1024, true, SearchInterpolation},
+		{&TreeOptions{}, SearchAuto, defaultSearchLinearMax, true, SearchLinear},
+	}
+	for _, c := range cases {
+		if got := chooseSearch(c.opts.search(c.strategy), c.n, c.intKeys); got != c.expected {
+			t.Errorf("chooseSearch(%v, %d, %v): expected %v, got %v", c.strategy, c.n, c.intKeys, c.expected, got)
+		}
+	}
+}
+
+func TestBTree_SearchStrategies(t *testing.T) {
+	for _, s := range []SearchStrategy{SearchLinear, SearchBinary, SearchInterpolation} {
+		opts := DefaultTreeOptions
+		opts.INodeSearch, opts.LNodeBTreeSearch = s, s
+		tree := NewBTreeWithOptions(opts)
+		ti := NewThreadInfo(tree.GetEpoche())
+		n := 20000
+		for _, k := range rand.New(rand.NewSource(19)).Perm(n) {
+			tree.Insert(k+1, k+1, ti)
+		}
+		// 范围查询会把哈希叶子转换为 B 树叶子，之后的查找走 LNodeBTree.FindLowerBound
+		if got := len(tree.RangeLookup(1, n, ti)); got != n {
+			t.Fatalf("%v: expected %d entries from range lookup, got %d", s, n, got)
+		}
+		for k := 1; k <= n; k++ {
+			if got := tree.Lookup(k, ti); got != k {
+				t.Fatalf("%v: expected to find key %d, got %v", s, k, got)
+			}
+		}
+	}
+}
+
+// BenchmarkLowerBound 比较不同节点大小下各策略的耗时，用于确定 SearchLinearMax 和 SearchInterpolationMin 的默认值。
+// uniform 为等间隔时间戳加少量抖动，skewed 为指数分布。
+func BenchmarkLowerBound(b *testing.B) {
+	r := rand.New(rand.NewSource(23))
+	for _, n := range []int{4, 8, 14, 32, 64, 256, 1024, 4096} {
+		for _, dist := range []string{"uniform", "skewed"} {
+			keys := make([]int, n)
+			if dist == "uniform" {
+				ts := 1_700_000_000_000
+				for i := range keys {
+					ts += 1000 + r.Intn(10)
+					keys[i] = ts
+				}
+			} else {
+				keys = sortedKeys(r, n, true)
+			}
+			entries := make([]Entry, n)
+			for i, k := range keys {
+				entries[i] = Entry{Key: k}
+			}
+			probes := make([]int, 1024)
+			for i := range probes {
+				probes[i] = keys[r.Intn(n)]
+			}
+			for _, s := range []SearchStrategy{SearchLinear, SearchBinary, SearchInterpolation} {
+				search := builtinTreeOptions.search(s)
+				b.Run(fmt.Sprintf("ints/%s/n=%d/%v", dist, n, s), func(b *testing.B) {
+					for i := 0; i < b.N; i++ {
+						lowerBoundInts(keys, probes[i&1023], search)
+					}
+				})
+				if s == SearchInterpolation {
+					continue
+				}
+				b.Run(fmt.Sprintf("entries/%s/n=%d/%v", dist, n, s), func(b *testing.B) {
+					for i := 0; i < b.N; i++ {
+						lowerBoundEntries(entries, probes[i&1023], search)
+					}
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package blinkhash
+
+// SplitPolicy 决定叶子节点分裂时左节点保留多少条目。
+// LNodeBTree 和 LNodeHash 在分裂前都会先得到一组有序的键，
+// 再通过所属树的 TreeOptions.SplitPolicy 选出分裂点。
+type SplitPolicy interface {
+	// SplitPoint 返回左节点保留的条目数，取值范围为 [1, n]。
+	//   n:         叶子中已有的条目数
+	//   maxKey:    叶子中已有的最大键
+	//   key:       触发分裂的新键
+	//   rightmost: 该叶子是否为最右叶子(没有右兄弟)
+	SplitPoint(n int, maxKey interface{}, key interface{}, rightmost bool) int
+}
+
+// MedianSplitPolicy 总是在中位数处分裂，左右各保留一半
+type MedianSplitPolicy struct{}
+
+func (MedianSplitPolicy) SplitPoint(n int, maxKey interface{}, key interface{}, rightmost bool) int {
+	return medianSplitPoint(n)
+}
+
+// AppendAwareSplitPolicy 针对单调递增的键(例如时间戳)：
+// 当新键落在最右叶子且大于叶子中所有键时，认为是顺序追加，
+// 在末尾附近分裂，让左侧叶子保持满载；其余情况退化为中位数分裂。
+type AppendAwareSplitPolicy struct {
+	// LeftFill 顺序追加时左节点保留的比例，取值 (0, 1]，0 表示 1.0
+	LeftFill float64
+}
+
+func (p AppendAwareSplitPolicy) SplitPoint(n int, maxKey interface{}, key interface{}, rightmost bool) int {
+	if !rightmost || n == 0 || compareIntKeys(key, maxKey) <= 0 {
+		return medianSplitPoint(n)
+	}
+	fill := p.LeftFill
+	if fill <= 0 || fill > 1 {
+		fill = 1
+	}
+	left := int(float64(n) * fill)
+	if left < 1 {
+		left = 1
+	}
+	if left > n {
+		left = n
+	}
+	return left
+}
+
+func medianSplitPoint(n int) int {
+	if n/2 == 0 {
+		return 1
+	}
+	return n / 2
+}
+
+// leafSplitPoint 调用节点所属树的策略并把结果限制在合法范围内
+func leafSplitPoint(opts *TreeOptions, n int, maxKey interface{}, key interface{}, rightmost bool) int {
+	policy := opts.SplitPolicy
+	if policy == nil {
+		policy = MedianSplitPolicy{}
+	}
+	left := policy.SplitPoint(n, maxKey, key, rightmost)
+	if left < 1 {
+		left = 1
+	}
+	if left > n {
+		left = n
+	}
+	return left
+}
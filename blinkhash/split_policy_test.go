@@ -0,0 +1,157 @@
+package blinkhash
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestSplitPolicy_SplitPoint(t *testing.T) {
+	median := MedianSplitPolicy{}
+	if got := median.SplitPoint(10, 10, 11, true); got != 5 {
+		t.Errorf("Expected median split point 5, got %d", got)
+	}
+	if got := median.SplitPoint(1, 1, 2, true); got != 1 {
+		t.Errorf("Expected split point 1 for single entry, got %d", got)
+	}
+
+	appendAware := AppendAwareSplitPolicy{}
+	// 最右叶子上的顺序追加：左节点全部保留
+	if got := appendAware.SplitPoint(10, 10, 11, true); got != 10 {
+		t.Errorf("Expected append split point 10, got %d", got)
+	}
+	// 非最右叶子或随机插入：退化为中位数
+	if got := appendAware.SplitPoint(10, 10, 11, false); got != 5 {
+		t.Errorf("Expected median split point for inner leaf, got %d", got)
+	}
+	if got := appendAware.SplitPoint(10, 10, 3, true); got != 5 {
+		t.Errorf("Expected median split point for random insert, got %d", got)
+	}
+
+	partial := AppendAwareSplitPolicy{LeftFill: 0.9}
+	if got := partial.SplitPoint(10, 10, 11, true); got != 9 {
+		t.Errorf("Expected split point 9 with LeftFill 0.9, got %d", got)
+	}
+}
+
+func TestLNodeBTree_SplitAppendAware(t *testing.T) {
+	lnBTree := NewLNodeBTree(0)
+	lnBTree.opts = &TreeOptions{SplitPolicy: AppendAwareSplitPolicy{}}
+	lnBTree.Cardinality = 8
+	for i := 1; i <= 8; i++ {
+		lnBTree.BatchInsert([]Entry{{Key: i, Value: i}})
+	}
+	lnBTree.HighKey = 8
+
+	newLeaf, splitKey := lnBTree.Split(9, 9, 0)
+	right := newLeaf.(*LNodeBTree)
+	if splitKey != 8 {
+		t.Errorf("Expected splitKey 8, got %v", splitKey)
+	}
+	if lnBTree.count != 8 || lnBTree.Len() != 8 {
+		t.Errorf("Expected left leaf to stay full, got count=%d", lnBTree.count)
+	}
+	if right.count != 1 || right.GetEntries()[0].Key != 9 {
+		t.Errorf("Expected right leaf to hold only the new key, got %v", right.GetEntries())
+	}
+
+	// 插入到中间的键仍然按中位数分裂
+	inner := NewLNodeBTree(0)
+	for i := 1; i <= 8; i++ {
+		inner.BatchInsert([]Entry{{Key: i * 2, Value: i}})
+	}
+	_, splitKey = inner.Split(5, 5, 0)
+	if splitKey != 8 || inner.count != 5 {
+		t.Errorf("Expected median split at 8, got splitKey=%v count=%d", splitKey, inner.count)
+	}
+}
+
+func TestBTree_AppendAwareSplitUtilization(t *testing.T) {
+	leafUtil := func(policy SplitPolicy) float64 {
+		opts := DefaultTreeOptions
+		opts.SplitPolicy = policy
+		tree := NewBTreeWithOptions(opts)
+		ti := NewThreadInfo(tree.GetEpoche())
+		for k := 1; k <= 100; k++ {
+			tree.Insert(k, k, ti)
+		}
+		// 范围查询会把哈希叶子转换为 LNodeBTree
+		tree.RangeLookup(0, 10, ti)
+		for k := 101; k <= 2000; k++ {
+			tree.Insert(k, k, ti)
+		}
+		if got := tree.RangeLookup(0, 5000, ti); len(got) != 2000 {
+			t.Fatalf("Expected 2000 results, got %d", len(got))
+		}
+
+		// 跳过转换产生的第一个叶子和最右叶子，统计其余叶子的平均利用率
+		cur := tree.root
+		for cur.GetLevel() != 0 {
+			cur = cur.GetLeftmostPtr()
+		}
+		total, cnt := 0.0, 0
+		for leaf := cur.GetSiblingPtr(); leaf != nil && leaf.GetSiblingPtr() != nil; leaf = leaf.GetSiblingPtr() {
+			total += leaf.(LeafNodeInterface).Utilization()
+			cnt++
+		}
+		return total / float64(cnt)
+	}
+
+	median := leafUtil(MedianSplitPolicy{})
+	appendAware := leafUtil(AppendAwareSplitPolicy{})
+	if median > 0.6 {
+		t.Errorf("Expected median split to leave half-full leaves, got %.2f", median)
+	}
+	if appendAware < 0.99 {
+		t.Errorf("Expected append-aware split to leave full leaves, got %.2f", appendAware)
+	}
+}
+
+// 配置属于每一棵树：两棵配置不同的树并发写入，所有节点都继承所属树的配置
+func TestTreeOptions_PerTree(t *testing.T) {
+	appendOpts := DefaultTreeOptions
+	appendOpts.AppendLeaves = true
+	appendOpts.SplitPolicy = AppendAwareSplitPolicy{}
+	appendOpts.LNodeBTreeSearch = SearchBinary
+	trees := []*BTree{NewBTree(), NewBTreeWithOptions(appendOpts)}
+
+	var wg sync.WaitGroup
+	for _, tree := range trees {
+		wg.Add(1)
+		go func(tree *BTree) {
+			defer wg.Done()
+			ti := NewThreadInfo(tree.GetEpoche())
+			for k := 0; k < 20000; k++ {
+				tree.Insert(k, k, ti)
+			}
+			// 乱序的键把追加叶子转换为 B 树叶子
+			for k := -1; k > -2000; k-- {
+				tree.Insert(k, k, ti)
+			}
+			tree.ConvertAll(ti)
+		}(tree)
+	}
+	wg.Wait()
+
+	for i, tree := range trees {
+		if tree.opts.AppendLeaves != (i == 1) {
+			t.Fatalf("tree %d: unexpected options %+v", i, *tree.opts)
+		}
+		// 逐层沿兄弟指针遍历所有节点
+		for first := tree.root; first != nil; first = first.GetLeftmostPtr() {
+			for n := first; n != nil; n = n.GetSiblingPtr() {
+				if optionsOf(n) != tree.opts {
+					t.Fatalf("tree %d: node at level %d does not share the tree's options", i, n.GetLevel())
+				}
+			}
+			if first.GetLevel() == 0 {
+				break
+			}
+		}
+	}
+	if types := leafTypes(trees[1]); types[AppendNode] == 0 {
+		t.Errorf("Expected the append tree to keep append leaves, got %v", types)
+	}
+	if types := leafTypes(trees[0]); types[AppendNode] != 0 {
+		t.Errorf("Expected the default tree to have no append leaves, got %v", types)
+	}
+}
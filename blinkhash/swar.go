@@ -0,0 +1,106 @@
+package blinkhash
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// SWAR(SIMD within a register)指纹匹配。
+//
+// 桶的指纹是桶内的定长字节数组，按槽位读写单个指纹；匹配时按小端序每次取出 8 个字节作为一个 uint64，
+// 字节在字中的位置与平台的字节序无关。
+// 查找时一次比较 8 个指纹：把目标指纹广播到 8 个字节，与指纹字异或后
+// 相等的字节变为 0，再用 has-zero-byte 技巧得到匹配掩码，只检查掩码中的候选槽位。
+
+const (
+	swarLo   = 0x0101010101010101
+	swarHi   = 0x8080808080808080
+	swarLow7 = 0x7f7f7f7f7f7f7f7f
+)
+
+// swarBroadcast 把一个字节复制到 uint64 的 8 个字节中
+func swarBroadcast(b uint8) uint64 {
+	return uint64(b) * swarLo
+}
+
+// swarMatch 返回 word 中等于 b 的字节掩码，匹配字节的最高位为 1，其余为 0。
+// 使用不会产生借位的精确 has-zero-byte 形式，没有假阳性。
+func swarMatch(word uint64, b uint8) uint64 {
+	x := word ^ swarBroadcast(b)
+	y := (x & swarLow7) + swarLow7
+	return ^(y | x | swarLow7)
+}
+
+// swarNext 取出掩码中最低的匹配字节下标，并返回清除该位后的掩码
+func swarNext(mask uint64) (int, uint64) {
+	return bits.TrailingZeros64(mask) >> 3, mask & (mask - 1)
+}
+
+// fpWords 返回桶中需要比较的指纹字数
+func (b *Bucket) fpWords() int {
+	return (b.slots() + 7) >> 3
+}
+
+// fpWord 返回第 w 组的 8 个指纹，第 i 个槽位的指纹在第 i%8 个字节
+func (b *Bucket) fpWord(w int) uint64 {
+	return binary.LittleEndian.Uint64(b.fingerprints[w<<3:])
+}
+
+// findSlot 返回指纹等于 fp 且键等于 key 的槽位，不存在时返回 -1。
+// 每次比较 8 个指纹，只对匹配的槽位比较键。
+func (b *Bucket) findSlot(key interface{}, fp uint8) int {
+	k, ok := intKey(key)
+	if !ok {
+		return -1
+	}
+	n := b.slots()
+	for w := 0; w < b.fpWords(); w++ {
+		mask := swarMatch(b.fpWord(w), fp)
+		for mask != 0 {
+			var i int
+			i, mask = swarNext(mask)
+			slot := w<<3 | i
+			if slot >= n {
+				break // 最后一个字中超出槽位数的填充字节
+			}
+			if b.keys[slot] == k {
+				return slot
+			}
+		}
+	}
+	return -1
+}
+
+// findSlotScalar 逐字节比较指纹的 findSlot
+func (b *Bucket) findSlotScalar(key int, fp uint8) int {
+	for i := 0; i < b.slots(); i++ {
+		if b.fingerprints[i] == fp && b.keys[i] == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// matchSlot 返回第一个指纹等于 fp 的槽位，不存在时返回 -1，用于查找空槽位
+func (b *Bucket) matchSlot(fp uint8) int {
+	n := b.slots()
+	for w := 0; w < b.fpWords(); w++ {
+		if mask := swarMatch(b.fpWord(w), fp); mask != 0 {
+			i, _ := swarNext(mask)
+			if slot := w<<3 | i; slot < n {
+				return slot
+			}
+		}
+	}
+	return -1
+}
+
+// matchSlotScalar 逐字节比较指纹的 matchSlot
+func (b *Bucket) matchSlotScalar(fp uint8) int {
+	for i := 0; i < b.slots(); i++ {
+		if b.fingerprints[i] == fp {
+			return i
+		}
+	}
+	return -1
+}
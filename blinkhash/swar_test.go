@@ -0,0 +1,139 @@
+package blinkhash
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestSwarMatch(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	for n := 0; n < 10000; n++ {
+		word := r.Uint64()
+		// 让一部分字节与目标相等，覆盖相邻匹配和 0x80 等边界值
+		target := uint8(r.Intn(256))
+		for i := 0; i < 8; i++ {
+			if r.Intn(3) == 0 {
+				word = word&^(0xff<<(8*i)) | uint64(target)<<(8*i)
+			}
+		}
+		mask := swarMatch(word, target)
+		for i := 0; i < 8; i++ {
+			expected := uint8(word>>(8*i)) == target
+			if got := mask>>(8*i+7)&1 == 1; got != expected {
+				t.Fatalf("word %#x target %#x byte %d: expected %v, got %v (mask %#x)", word, target, i, expected, got, mask)
+			}
+		}
+		if mask&^swarHi != 0 {
+			t.Fatalf("Expected mask bits only at byte tops, got %#x", mask)
+		}
+	}
+}
+
+func TestBucket_FindSlotMatchesScalar(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+	for _, slots := range []int{EntryNum, 5, 13} {
+		b := newBucketWithSlots(slots)
+		for i := 0; i < slots; i++ {
+			if r.Intn(4) != 0 {
+				b.keys[i] = i
+				b.values.set(i, i)
+				b.fingerprints[i] = uint8(r.Intn(4))<<1 | 1
+			}
+		}
+		for fp := 0; fp < 256; fp++ {
+			if got, expected := b.matchSlot(uint8(fp)), b.matchSlotScalar(uint8(fp)); got != expected {
+				t.Fatalf("slots=%d fp=%d: expected matchSlot %d, got %d", slots, fp, expected, got)
+			}
+		}
+		for key := 0; key < slots+2; key++ {
+			for fp := 1; fp < 8; fp += 2 {
+				if got, expected := b.findSlot(key, uint8(fp)), b.findSlotScalar(key, uint8(fp)); got != expected {
+					t.Fatalf("slots=%d key=%d fp=%d: expected findSlot %d, got %d", slots, key, fp, expected, got)
+				}
+			}
+		}
+	}
+}
+
+// newBenchmarkBucket 创建一个装满的桶，指纹在 1..255 的奇数中随机分布
+func newBenchmarkBucket() *Bucket {
+	r := rand.New(rand.NewSource(11))
+	b := NewBucket()
+	for i := 0; i < EntryNum; i++ {
+		b.keys[i] = i
+		b.values.set(i, i)
+		b.fingerprints[i] = uint8(r.Intn(128))<<1 | 1
+	}
+	return b
+}
+
+func BenchmarkBucketFindWithFingerprint(b *testing.B) {
+	bucket := newBenchmarkBucket()
+	hit, hitFp := EntryNum-1, bucket.fingerprints[EntryNum-1]
+	b.Run("swar/hit", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			bucket.findSlot(hit, hitFp)
+		}
+	})
+	b.Run("scalar/hit", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			bucket.findSlotScalar(hit, hitFp)
+		}
+	})
+	b.Run("swar/miss", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			bucket.findSlot(-1, 0xfe)
+		}
+	})
+	b.Run("scalar/miss", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			bucket.findSlotScalar(-1, 0xfe)
+		}
+	})
+}
+
+func BenchmarkBucketFreeSlot(b *testing.B) {
+	bucket := newBenchmarkBucket()
+	bucket.fingerprints[EntryNum-1] = EmptyFingerprint
+	b.Run("swar", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			bucket.matchSlot(EmptyFingerprint)
+		}
+	})
+	b.Run("scalar", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			bucket.matchSlotScalar(EmptyFingerprint)
+		}
+	})
+}
+
+// BenchmarkLNodeHashFind 在接近满载的哈希叶子上查找，FINGERPRINT 打开时走 SWAR 指纹匹配
+func BenchmarkLNodeHashFind(b *testing.B) {
+	lh := newSmallLNodeHash(256, nil)
+	r := rand.New(rand.NewSource(13))
+	var keys []int
+	for {
+		key := r.Intn(1 << 30)
+		if lh.Insert(key, key, 0) != InsertSuccess {
+			break
+		}
+		keys = append(keys, key)
+	}
+	b.Run("hit", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			lh.Find(keys[i%len(keys)])
+		}
+	})
+	b.Run("miss", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			lh.Find(-i - 1)
+		}
+	})
+}
+
+func TestLNodeHash_BucketCount(t *testing.T) {
+	// 桶数不随 Bucket 的内存布局变化
+	if LNodeHashCardinality != 4095 {
+		t.Fatalf("Expected 4095 buckets per hash leaf, got %d", LNodeHashCardinality)
+	}
+}
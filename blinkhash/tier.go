@@ -0,0 +1,365 @@
+package blinkhash
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// 分层存储：内存中只保留有限个 B 树叶子的内容，其余叶子换出到页文件。
+//
+// 换出的叶子仍留在树中，保留节点锁、HighKey 和兄弟指针，只释放 keys 和 values，
+// 改为记录内容在页文件中的位置(stub)。叶子内容通过一个原子指针整体替换：换出时指向共用的
+// evictedContents，换入时指向读回的内容。换入和换出都在叶子的写锁内进行并增加版本，
+// 乐观读取的线程看到的就是一次普通的重启：读者读到 evictedContents 时先把叶子换入，
+// 然后返回空结果，由调用方的版本校验触发重试。
+//
+// 页文件读不回来(包括树关闭之后)时不会 panic：错误记录为树的致命错误(见 BTree.Err)，
+// 之后树停止写入，读者不再尝试换入，把换出的叶子当作空叶子。
+//
+// 叶子内容在页文件中的格式：
+//
+//	checksum  uint32，之后所有字节的 CRC32C
+//	count     uvarint
+//	keys      第一个键(varint)，之后每个键与前一个键的差(uvarint)
+//	values    count 个值，按 TierOptions.ValueCodec 编码
+//
+// 页文件只追加：换入后没有修改过的叶子再次换出时复用原来的位置，修改过的叶子写到文件末尾，
+// 旧内容占用的空间不回收。页文件只在本进程内有效，不用于恢复，持久化见 wal.go。
+//
+// 只有 B 树叶子会被换出，哈希叶子在范围查询把它们转换为 B 树叶子之后才参与。
+
+// TierOptions 分层存储的配置
+type TierOptions struct {
+	// MaxResidentLeaves 内存中最多保留内容的叶子数，超出时按 CLOCK 算法换出
+	MaxResidentLeaves int
+	// ValueCodec 换出时值使用的编解码器，为 nil 时使用 TaggedCodec。
+	// 值无法编码的叶子留在内存中
+	ValueCodec Codec
+}
+
+// DefaultTierOptions 默认的分层存储配置
+var DefaultTierOptions = TierOptions{
+	MaxResidentLeaves: 1 << 16,
+	ValueCodec:        TaggedCodec,
+}
+
+// TierStats 分层存储的计数
+type TierStats struct {
+	ResidentLeaves int64  // 内容在内存中的叶子数
+	EvictedLeaves  int64  // 当前换出的叶子数
+	PageIns        uint64 // 累计换入次数
+	Evictions      uint64 // 累计换出次数
+	FileBytes      int64  // 页文件的大小
+}
+
+// leafStub 叶子内容在页文件中的位置
+type leafStub struct {
+	off  int64
+	size int
+}
+
+// bufferPool 管理换出的叶子和页文件
+type bufferPool struct {
+	tree     *BTree
+	file     *os.File
+	values   Codec
+	capacity int64
+	resident int64 // 原子访问
+	evicted  int64 // 原子访问
+	pageIns  uint64
+	evicts   uint64
+
+	mu   sync.Mutex // 保护以下字段，同一时间只有一个线程在换出
+	hand LeafNodeInterface
+	size int64
+	buf  []byte
+}
+
+// errTierClosed 页文件已经关闭
+var errTierClosed = errors.New("blinkhash: tiered storage is closed")
+
+// EnableTiering 为树启用分层存储，path 为页文件，已存在时会被截断。
+// 需要在其他线程开始使用树之前调用，之后新建的 B 树叶子自动纳入管理
+func (bt *BTree) EnableTiering(path string, opts TierOptions) error {
+	if bt.pool != nil {
+		return errors.New("blinkhash: tiering is already enabled")
+	}
+	if opts.MaxResidentLeaves < 1 {
+		return fmt.Errorf("blinkhash: MaxResidentLeaves must be positive, got %d", opts.MaxResidentLeaves)
+	}
+	if opts.ValueCodec == nil {
+		opts.ValueCodec = TaggedCodec
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	p := &bufferPool{tree: bt, file: file, values: opts.ValueCodec, capacity: int64(opts.MaxResidentLeaves)}
+	for leaf := bt.firstLeaf(); leaf != nil; leaf = nextLeaf(leaf) {
+		if lb, ok := leaf.(*LNodeBTree); ok {
+			lb.pool = p
+			p.resident++
+		}
+	}
+	bt.pool = p
+	p.maybeEvict()
+	return nil
+}
+
+// TierStats 返回分层存储的计数，未启用时返回零值
+func (bt *BTree) TierStats() TierStats {
+	p := bt.pool
+	if p == nil {
+		return TierStats{}
+	}
+	p.mu.Lock()
+	size := p.size
+	p.mu.Unlock()
+	return TierStats{
+		ResidentLeaves: atomic.LoadInt64(&p.resident),
+		EvictedLeaves:  atomic.LoadInt64(&p.evicted),
+		PageIns:        atomic.LoadUint64(&p.pageIns),
+		Evictions:      atomic.LoadUint64(&p.evicts),
+		FileBytes:      size,
+	}
+}
+
+// closeTier 关闭页文件，之后的换入返回 errTierClosed
+func (bt *BTree) closeTier() error {
+	p := bt.pool
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.file == nil {
+		return nil
+	}
+	err := p.file.Close()
+	p.file = nil
+	return err
+}
+
+// firstLeaf 沿最左指针返回最左的叶子。不校验版本：调用方可能持有某个叶子的锁，
+// 经由 findLeaf 下探可能一直等待自己持有的锁
+func (bt *BTree) firstLeaf() LeafNodeInterface {
+	cur := bt.root
+	for cur.GetLevel() != 0 {
+		cur = cur.GetLeftmostPtr()
+	}
+	leaf, _ := cur.(LeafNodeInterface)
+	return leaf
+}
+
+func nextLeaf(leaf LeafNodeInterface) LeafNodeInterface {
+	next, _ := leaf.GetSiblingPtr().(LeafNodeInterface)
+	return next
+}
+
+// adopt 把 lb 分裂出的新叶子纳入管理，调用方持有 lb 的写锁，leaf 已经填好内容
+func (lb *LNodeBTree) adopt(leaf *LNodeBTree) {
+	if lb.pool != nil {
+		lb.pool.adopt(leaf)
+	}
+}
+
+func (p *bufferPool) adopt(leaf *LNodeBTree) {
+	leaf.pool = p
+	atomic.StoreUint32(&leaf.ref, 1)
+	atomic.AddInt64(&p.resident, 1)
+	p.maybeEvict()
+}
+
+// touch 记录叶子被访问过，CLOCK 的指针经过时给它第二次机会
+func (lb *LNodeBTree) touch() {
+	if lb.pool != nil && atomic.LoadUint32(&lb.ref) == 0 {
+		atomic.StoreUint32(&lb.ref, 1)
+	}
+}
+
+// fault 在乐观读取遇到换出的叶子时调用：加写锁换入后解锁，版本随之改变，调用方的校验会失败并重试。
+// 拿不到写锁说明其他线程持有它，版本同样会改变。树已经出错时不再换入，
+// 叶子的版本不变，读者把它当作空叶子，不会反复重试
+func (lb *LNodeBTree) fault() {
+	p := lb.pool
+	if p.tree.Err() != nil || !lb.TryWriteLock() {
+		return
+	}
+	err := lb.pageInLocked()
+	lb.WriteUnlock()
+	if err == nil {
+		p.maybeEvict()
+	}
+}
+
+// loadForWrite 在调用方持有写锁时确保叶子内容在内存中，并把它标记为已修改。
+// 换入失败时叶子保持换出，返回的错误已经记录为树的致命错误
+func (lb *LNodeBTree) loadForWrite() error {
+	if lb.pool == nil {
+		atomic.StoreUint32(&lb.written, 1)
+		return nil
+	}
+	paged := lb.stub != nil
+	if err := lb.pageInLocked(); err != nil {
+		return err
+	}
+	atomic.StoreUint32(&lb.written, 1)
+	lb.clean = nil
+	atomic.StoreUint32(&lb.ref, 1)
+	if paged {
+		lb.pool.maybeEvict()
+	}
+	return nil
+}
+
+// evictedContents 换出的叶子共用的空内容。乐观的读者可能在换出之后仍读取叶子，
+// 读到的是 0 个条目而不是 nil，结果由版本校验丢弃；写者总是先换入，不会修改它
+var evictedContents = newLeafContents(0)
+
+// pageInLocked 在持有写锁时从页文件读回叶子内容。读取或校验失败时叶子保持换出，
+// 错误记录为树的致命错误后返回
+func (lb *LNodeBTree) pageInLocked() error {
+	stub := lb.stub
+	if stub == nil {
+		return nil
+	}
+	p := lb.pool
+	data := make([]byte, stub.size)
+	err := p.readAt(data, stub.off)
+	var contents *leafContents
+	if err == nil {
+		contents, err = p.decodeLeaf(data, lb.Cardinality)
+	}
+	if err != nil {
+		err = fmt.Errorf("blinkhash: paging in leaf at %d: %w", stub.off, err)
+		p.tree.fail(err)
+		return err
+	}
+	lb.contents.Store(contents)
+	lb.stub, lb.clean = nil, stub
+	atomic.StoreUint32(&lb.ref, 1)
+	atomic.AddInt64(&p.evicted, -1)
+	atomic.AddInt64(&p.resident, 1)
+	atomic.AddUint64(&p.pageIns, 1)
+	return nil
+}
+
+func (p *bufferPool) readAt(data []byte, off int64) error {
+	p.mu.Lock()
+	file := p.file
+	p.mu.Unlock()
+	if file == nil {
+		return errTierClosed
+	}
+	_, err := file.ReadAt(data, off)
+	return err
+}
+
+func (p *bufferPool) encodeLeaf(buf []byte, lb *LNodeBTree) ([]byte, error) {
+	c := lb.loaded()
+	keys := c.sortedKeys()
+	buf = append(buf[:0], 0, 0, 0, 0)
+	buf = binary.AppendUvarint(buf, uint64(len(keys)))
+	for i, k := range keys {
+		if i == 0 {
+			buf = binary.AppendVarint(buf, int64(k))
+		} else {
+			buf = binary.AppendUvarint(buf, uint64(k-keys[i-1]))
+		}
+	}
+	for i := range keys {
+		var err error
+		if buf, err = p.values.Append(buf, c.values.get(i)); err != nil {
+			return buf, err
+		}
+	}
+	binary.LittleEndian.PutUint32(buf, crc32.Checksum(buf[4:], walCRC))
+	return buf, nil
+}
+
+func (p *bufferPool) decodeLeaf(data []byte, cardinality int) (*leafContents, error) {
+	if len(data) < 4 || crc32.Checksum(data[4:], walCRC) != binary.LittleEndian.Uint32(data) {
+		return nil, errCorrupt
+	}
+	d := &decoder{data: data[4:]}
+	count := d.uvarint()
+	if d.err != nil || count > uint64(len(d.data)) {
+		return nil, errCorrupt
+	}
+	c := newLeafContents(maxInt(cardinality, int(count)))
+	c.n = int(count)
+	for i := 0; i < c.n; i++ {
+		if i == 0 {
+			c.keys[i] = int(d.varint())
+		} else {
+			c.keys[i] = c.keys[i-1] + int(d.uvarint())
+		}
+	}
+	for i := 0; i < c.n; i++ {
+		c.values.set(i, d.value(p.values))
+	}
+	if d.err != nil || len(d.data) != 0 {
+		return nil, errCorrupt
+	}
+	return c, nil
+}
+
+// maybeEvict 内存中的叶子超过上限时换出一些叶子。已有线程在换出时直接返回。
+// 对其他叶子只尝试加写锁，不会等待，调用方持有叶子锁时也可以调用
+func (p *bufferPool) maybeEvict() {
+	if atomic.LoadInt64(&p.resident) <= p.capacity || !p.mu.TryLock() {
+		return
+	}
+	defer p.mu.Unlock()
+	if p.file == nil {
+		return
+	}
+	// 所有叶子都被锁住或刚被访问过时，转两圈之后放弃，等下一次再试
+	steps := 2 * (atomic.LoadInt64(&p.resident) + atomic.LoadInt64(&p.evicted) + 1)
+	for ; steps > 0 && atomic.LoadInt64(&p.resident) > p.capacity; steps-- {
+		if p.hand == nil {
+			p.hand = p.tree.firstLeaf()
+		}
+		leaf := p.hand
+		p.hand = nextLeaf(leaf)
+		if lb, ok := leaf.(*LNodeBTree); ok && lb.pool == p {
+			p.evictLocked(lb)
+		}
+	}
+}
+
+// evictLocked 在持有 p.mu 时尝试换出 lb
+func (p *bufferPool) evictLocked(lb *LNodeBTree) {
+	if lb.evicted() || atomic.SwapUint32(&lb.ref, 0) != 0 {
+		return
+	}
+	if !lb.TryWriteLock() {
+		return
+	}
+	defer lb.WriteUnlock()
+	if lb.stub != nil {
+		return
+	}
+	stub := lb.clean
+	if stub == nil {
+		var err error
+		if p.buf, err = p.encodeLeaf(p.buf, lb); err != nil {
+			return
+		}
+		if _, err := p.file.WriteAt(p.buf, p.size); err != nil {
+			return
+		}
+		stub = &leafStub{off: p.size, size: len(p.buf)}
+		p.size += int64(len(p.buf))
+	}
+	lb.contents.Store(evictedContents)
+	lb.stub, lb.clean = stub, nil
+	atomic.AddInt64(&p.resident, -1)
+	atomic.AddInt64(&p.evicted, 1)
+	atomic.AddUint64(&p.evicts, 1)
+}
@@ -0,0 +1,227 @@
+package blinkhash
+
+import (
+	"errors"
+	"math"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// newTieredTree 建立 n 个条目并把叶子全部转换为 B 树叶子，然后启用分层存储
+func newTieredTree(t testing.TB, n, maxResident int) (*BTree, *ThreadInfo) {
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	for k := 0; k < n; k++ {
+		tree.Insert(k, k, ti)
+	}
+	// 范围查询把哈希叶子转换为 B 树叶子
+	tree.RangeLookup(0, n, ti)
+	opts := DefaultTierOptions
+	opts.MaxResidentLeaves = maxResident
+	if err := tree.EnableTiering(filepath.Join(t.TempDir(), "pages"), opts); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tree.Close() })
+	return tree, ti
+}
+
+func TestTier_LookupAndScan(t *testing.T) {
+	const n = 5000
+	tree, ti := newTieredTree(t, n, 8)
+	stats := tree.TierStats()
+	if stats.ResidentLeaves > 8 || stats.EvictedLeaves == 0 || stats.FileBytes == 0 {
+		t.Fatalf("Expected most leaves to be evicted, got %+v", stats)
+	}
+
+	for k := 0; k < n; k += 7 {
+		if got := tree.Lookup(k, ti); got != k {
+			t.Fatalf("key %d: expected %d, got %v", k, k, got)
+		}
+	}
+	entries := tree.RangeLookupEntries(math.MinInt, 2*n, ti)
+	if len(entries) != n {
+		t.Fatalf("Expected %d entries, got %d", n, len(entries))
+	}
+	for i, e := range entries {
+		if e.Key != i || e.Value != i {
+			t.Fatalf("entry %d: got %v=%v", i, e.Key, e.Value)
+		}
+	}
+	// 一致性扫描锁住访问过的叶子后才能完成，锁住的叶子不会被换出
+	if got := len(tree.ScanEntries(math.MinInt, 2*n, ScanSerializable, ti)); got != n {
+		t.Fatalf("Expected a serializable scan of %d entries, got %d", n, got)
+	}
+	stats = tree.TierStats()
+	if stats.PageIns == 0 || stats.ResidentLeaves > 8 {
+		t.Errorf("Expected leaves to be paged in and evicted again, got %+v", stats)
+	}
+
+	// 只读访问不修改叶子，再次换出时复用页文件中原来的位置
+	size := stats.FileBytes
+	tree.RangeLookup(math.MinInt, 2*n, ti)
+	if got := tree.TierStats().FileBytes; got != size {
+		t.Errorf("Expected clean leaves to be evicted without rewriting, file grew from %d to %d", size, got)
+	}
+}
+
+func TestTier_Writes(t *testing.T) {
+	const n = 5000
+	tree, ti := newTieredTree(t, n, 4)
+	for k := 0; k < n; k += 3 {
+		tree.Update(k, "u", ti)
+	}
+	for k := 1; k < n; k += 3 {
+		tree.Remove(k, ti)
+	}
+	for k := n; k < 2*n; k++ {
+		tree.Insert(k, []byte{byte(k)}, ti)
+	}
+	wb := tree.NewWriteBatch()
+	for k := 2; k < n; k += 30 {
+		wb.Put(k, 1.5)
+	}
+	wb.Commit(ti)
+
+	for k := 0; k < 2*n; k++ {
+		got := tree.Lookup(k, ti)
+		switch {
+		case k >= n:
+			if b, ok := got.([]byte); !ok || b[0] != byte(k) {
+				t.Fatalf("key %d: expected an inserted value, got %v", k, got)
+			}
+		case k%3 == 0:
+			if got != "u" {
+				t.Fatalf("key %d: expected an updated value, got %v", k, got)
+			}
+		case k%3 == 1:
+			if got != nil {
+				t.Fatalf("key %d: expected a removed key, got %v", k, got)
+			}
+		case k%30 == 2:
+			if got != 1.5 {
+				t.Fatalf("key %d: expected a batch value, got %v", k, got)
+			}
+		default:
+			if got != k {
+				t.Fatalf("key %d: expected %d, got %v", k, k, got)
+			}
+		}
+	}
+	if stats := tree.TierStats(); stats.ResidentLeaves > 4 {
+		t.Errorf("Expected at most 4 resident leaves, got %+v", stats)
+	}
+}
+
+// 换入换出与并发的读写交错进行，乐观读取不会读到换出后的空叶子
+func TestTier_Concurrent(t *testing.T) {
+	const n, threads = 4000, 8
+	tree, _ := newTieredTree(t, n, 16)
+	var wg sync.WaitGroup
+	errs := make(chan string, threads)
+	for w := 0; w < threads; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			ti := NewThreadInfo(tree.GetEpoche())
+			for i := 0; i < 3000; i++ {
+				k := (i*7919 + w*131) % n
+				if w%2 == 0 {
+					// 写线程只写自己负责的键，值始终等于键
+					tree.Update(k-k%threads+w, k-k%threads+w, ti)
+					continue
+				}
+				if got := tree.Lookup(k, ti); got != k {
+					errs <- "lookup returned a wrong value"
+					return
+				}
+				if got := tree.RangeLookupEntries(k, 20, ti); len(got) == 0 || got[0].Key != k {
+					errs <- "range lookup missed its first key"
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	ti := NewThreadInfo(tree.GetEpoche())
+	if got := len(tree.RangeLookup(math.MinInt, 2*n, ti)); got != n {
+		t.Errorf("Expected %d entries, got %d", n, got)
+	}
+}
+
+// 页文件读不回来时不 panic：树记录错误并停止写入，读取把换出的叶子当作空叶子
+func TestTier_PageInFailure(t *testing.T) {
+	const n = 5000
+	tree, ti := newTieredTree(t, n, 4)
+	if err := tree.closeTier(); err != nil {
+		t.Fatal(err)
+	}
+	missing := 0
+	for k := 0; k < n; k += 7 {
+		if tree.Lookup(k, ti) != k {
+			missing++
+		}
+	}
+	if missing == 0 {
+		t.Fatal("Expected evicted leaves to be unreadable after the page file is closed")
+	}
+	if err := tree.Err(); !errors.Is(err, errTierClosed) {
+		t.Fatalf("Expected %v, got %v", errTierClosed, err)
+	}
+	if got := len(tree.ScanEntries(math.MinInt, 2*n, ScanSerializable, ti)); got >= n {
+		t.Errorf("Expected the scan to skip evicted leaves, got %d entries", got)
+	}
+
+	// 写入立即返回，不修改树，也不会反复重试换入
+	tree.Insert(2*n, 1, ti)
+	tree.Update(n-1, "u", ti)
+	wb := tree.NewWriteBatch()
+	wb.Put(2*n+1, 1)
+	wb.Commit(ti)
+	if got := tree.TruncateBefore(n/2, ti) + tree.DeleteRange(0, n, ti); got != 0 {
+		t.Errorf("Expected structural deletes to be rejected, removed %d", got)
+	}
+	if tree.Lookup(2*n, ti) != nil || tree.Lookup(2*n+1, ti) != nil {
+		t.Error("Expected writes to be rejected after the failure")
+	}
+	if got := tree.Lookup(n-1, ti); got == "u" {
+		t.Error("Expected the update to be rejected after the failure")
+	}
+	tx := tree.Begin(ti)
+	tx.Put(1, 1)
+	if err := tx.Commit(); !errors.Is(err, errTierClosed) {
+		t.Errorf("Expected the transaction to fail with %v, got %v", errTierClosed, err)
+	}
+}
+
+func TestTier_Snapshot(t *testing.T) {
+	tree, ti := newTieredTree(t, 3000, 4)
+	snap := tree.Snapshot()
+	defer snap.Release()
+	for k := 0; k < 3000; k += 2 {
+		tree.Remove(k, ti)
+	}
+	if got := len(snap.RangeLookup(math.MinInt, 5000, ti)); got != 3000 {
+		t.Errorf("Expected the snapshot to see 3000 entries, got %d", got)
+	}
+	if got := len(tree.RangeLookup(math.MinInt, 5000, ti)); got != 1500 {
+		t.Errorf("Expected 1500 live entries, got %d", got)
+	}
+}
+
+// BenchmarkTierLookup 比较全部驻留与只保留少量叶子时的随机点查询
+func BenchmarkTierLookup(b *testing.B) {
+	const n = 100000
+	for _, resident := range []int{1 << 20, 8} {
+		tree, ti := newTieredTree(b, n, resident)
+		b.Run(map[bool]string{true: "resident", false: "tiered"}[resident > n], func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				tree.Lookup(i*7919%n, ti)
+			}
+		})
+	}
+}
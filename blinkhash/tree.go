@@ -2,140 +2,191 @@ package blinkhash
 
 import (
 	"fmt"
-	"reflect"
+	"math"
 	"sync"
+	"sync/atomic"
 	"unsafe"
 )
 
 type BTree struct {
-	root   NodeInterface
-	epoche *Epoche
-	lock   sync.Mutex
+	root     NodeInterface
+	epoche   *Epoche
+	lock     sync.Mutex
+	versions *versionStore // 快照需要的旧版本，见 mvcc.go
+	wal      *wal          // 预写日志，只有 Open 打开的树才有，见 wal.go
+	pool     *bufferPool   // 分层存储，见 tier.go
+	opts     *TreeOptions  // 创建时的配置，树中的节点共享同一份
+
+	sweepStop, sweepDone chan struct{} // 后台清理过期条目的协程，由 lock 保护
+
+	failure atomic.Pointer[error] // 使树停止写入的第一个错误，见 Err
+}
+
+// TreeOptions 一棵树的配置，导出的字段创建后不再修改。
+// 每棵树保存自己的一份，节点通过 Node.opts 共享，不同的树可以使用不同的配置
+type TreeOptions struct {
+	// SplitPolicy 叶子分裂策略，为 nil 时使用中位数分裂，见 split_policy.go
+	SplitPolicy SplitPolicy
+	// AppendLeaves 为 true 时以 LNodeAppend 作为根叶子，适合键严格递增的数据流。
+	// 乱序写入的叶子会被转换为 LNodeBTree，见 lnode_append.go
+	AppendLeaves bool
+	// FingerHint 为 true 时 Insert、Lookup、Update 先尝试 ThreadInfo 记住的上一个叶子，见 finger.go
+	FingerHint bool
+	// INodeSearch 内部节点使用的查找策略，见 search.go
+	INodeSearch SearchStrategy
+	// LNodeBTreeSearch B 树叶子使用的查找策略
+	LNodeBTreeSearch SearchStrategy
+	// SearchLinearMax 自动选择查找策略时，键数不超过该值的节点使用顺序扫描，0 表示默认值 16
+	SearchLinearMax int
+	// SearchInterpolationMin 自动选择时，键数不少于该值且近似均匀分布的整数键节点使用插值查找，
+	// 0 表示默认值 256
+	SearchInterpolationMin int
+	// CompactMinFill 叶子的利用率达到该值才会被压缩，0 表示 1.0(只压缩已满的叶子)，见 compress.go
+	CompactMinFill float64
+	// CuckooMaxDepth 哈希叶子布谷鸟迁移路径的最大长度(迁移次数)，0 表示默认值 3，
+	// 负数关闭迁移，候选桶满即使用溢出区或分裂，见 lnode_hash_cuckoo.go
+	CuckooMaxDepth int
+	// CuckooMaxSearch 单次迁移路径搜索最多访问的桶数，0 表示默认值 128
+	CuckooMaxSearch int
+	// HashStashSize 每个哈希叶子溢出区的槽位数，0 表示默认值 EntryNum，负数不使用溢出区，
+	// 最多 EntryNum 个，见 lnode_hash_stash.go
+	HashStashSize int
+	// HashInitialCardinality 新建哈希叶子的初始桶数，0 表示默认值 64，超过 HashMaxCardinality 时取后者，
+	// 见 lnode_hash_grow.go
+	HashInitialCardinality int
+	// HashMaxCardinality 哈希叶子扩容的上限，0 表示与固定大小(LeafHashSize)的叶子一致
+	HashMaxCardinality int
+	// IngestBatchSize 摄入模式下每个 ThreadInfo 暂存区的大小，达到后自动刷新，0 表示默认值 64，见 ingest.go
+	IngestBatchSize int
+	// ScanSerializableRetries 一致性扫描乐观重试的次数，0 表示默认值 8，负数表示第一次就锁住叶子，见 scan.go
+	ScanSerializableRetries int
+
+	// expiring 树中写入过带过期时间的条目时为 1，原子访问，见 ttl.go。
+	// 不是配置：每棵树独有这一份，节点经由 opts 读到所属树的状态，不需要额外的指针
+	expiring int32
+}
+
+// builtinTreeOptions 内置的默认配置，不属于任何树的节点(例如测试中直接创建的节点)使用它
+var builtinTreeOptions = TreeOptions{
+	SplitPolicy:             MedianSplitPolicy{},
+	FingerHint:              true,
+	INodeSearch:             SearchAuto,
+	LNodeBTreeSearch:        SearchAuto,
+	SearchLinearMax:         defaultSearchLinearMax,
+	SearchInterpolationMin:  defaultSearchInterpolationMin,
+	CompactMinFill:          1.0,
+	CuckooMaxDepth:          defaultCuckooMaxDepth,
+	CuckooMaxSearch:         defaultCuckooMaxSearch,
+	HashStashSize:           EntryNum,
+	HashInitialCardinality:  defaultHashInitialCardinality,
+	HashMaxCardinality:      LNodeHashCardinality,
+	IngestBatchSize:         defaultIngestBatchSize,
+	ScanSerializableRetries: defaultScanSerializableRetries,
 }
 
+// DefaultTreeOptions NewBTree、LoadFrom 和没有指定 WALOptions.Tree 的 Open 使用的默认配置，
+// 修改它只影响之后创建的树
+var DefaultTreeOptions = builtinTreeOptions
+
 func NewBTree() *BTree {
+	return NewBTreeWithOptions(DefaultTreeOptions)
+}
+
+// NewBTreeWithOptions 创建一棵使用指定配置的空树
+func NewBTreeWithOptions(opts TreeOptions) *BTree {
+	if opts.SplitPolicy == nil {
+		opts.SplitPolicy = MedianSplitPolicy{}
+	}
+	if opts.CompactMinFill <= 0 {
+		opts.CompactMinFill = 1
+	}
+	opts.expiring = 0
+	var root NodeInterface
+	if opts.AppendLeaves {
+		leaf := NewLNodeAppend(0)
+		leaf.opts = &opts
+		root = leaf
+	} else {
+		// 假设默认根节点是一个哈希节点，溢出区的大小在创建时由配置决定
+		root = newLNodeHashWithCardinality(nil, 0, 0, opts.hashInitialCardinality(), &opts)
+	}
 	return &BTree{
-		root:   NewLNodeHash(0), // 假设默认根节点是一个哈希节点
-		epoche: NewEpoche(256),  // 设置 Epoche 的初始容量或阈值
-		lock:   sync.Mutex{},
+		root:     root,
+		epoche:   NewEpoche(256), // 设置 Epoche 的初始容量或阈值
+		lock:     sync.Mutex{},
+		versions: newVersionStore(),
+		opts:     &opts,
+	}
+}
+
+// Err 返回使树停止写入的第一个错误，例如换出的叶子无法从页文件读回。
+// 出错之后写入不再修改树，读取把无法换入的叶子当作空叶子
+func (bt *BTree) Err() error {
+	if err := bt.failure.Load(); err != nil {
+		return *err
 	}
+	return nil
+}
+
+// fail 记录致命错误，只保留第一个
+func (bt *BTree) fail(err error) {
+	bt.failure.CompareAndSwap(nil, &err)
 }
 
 // Insert inserts a key-value pair into the B-tree.
+// 打开了日志的树先写日志再修改树，日志出错或值不能用日志的编解码器编码时写入不生效，
+// 树进入失败状态，见 Err
 func (bt *BTree) Insert(key, value interface{}, ti *ThreadInfo) {
+	rec, err := bt.walValueRecord(walInsert, key, value)
+	if err != nil {
+		bt.fail(err)
+		return
+	}
+	turn, ok := bt.logWrite(rec, key.(int))
+	if !ok {
+		return
+	}
+	w := bt.versions.beginWrite(bt, key, ti)
+	bt.insert(key, value, ti)
+	w.end()
+	turn.done()
+}
+
+func (bt *BTree) insert(key, value interface{}, ti *ThreadInfo) {
 	// Create an EpocheGuard and ensure Release is called at the end.
 	epocheGuard := NewEpocheGuard(ti)
 	defer epocheGuard.Release()
 insertLoop: // 标签
 	for {
-		restart := false
-		cur := bt.root
-		stack := make([]INodeInterface, 0)
-
-		// Attempt to acquire read lock on the root node.
-		curVersion, needRestart := cur.TryReadLock()
-		if needRestart {
-			continue // Restart the insert process.
-		}
-		// Ensure to release the root lock if restarting
-		defer func() {
-			if restart {
-				cur.WriteUnlock()
-			}
-		}()
-		// Tree traversal to find the leaf node.
-		for cur.GetLevel() != 0 {
-			parent, ok := cur.(INodeInterface)
-			if !ok {
-				panic("Need INodeInterface")
-			}
-			child := parent.ScanNode(key)
-			if child == nil {
-				panic("ScanNode returned nil")
-			}
-			childVersion, needRestart := child.TryReadLock()
-
-			if needRestart {
-				parent.WriteUnlock()
-				restart = true
-				break
-			}
-
-			// Check version consistency.
-			curEndVersion, needRestart := cur.GetVersion()
-			if needRestart || curVersion != curEndVersion {
-				parent.WriteUnlock() // Release parent lock
-				child.WriteUnlock()  // Release child lock
-				restart = true
-				//为什么cpp中是goto，循环，但是在go中是break？
-				break
-			}
-
-			if child != parent.GetSiblingPtr() {
-				stack = append(stack, parent)
-			}
-
-			cur = child
-			curVersion = childVersion
-		}
-
-		if restart {
-			cur.WriteUnlock()
-			continue insertLoop // 跳转到最外层循环开始
+		if bt.Err() != nil {
+			return
 		}
-
-		leafNode, ok := cur.(LeafNodeInterface)
+		// 先尝试上一次访问的叶子，此时没有父节点栈，分裂时由 insertKey 重新查找父节点
+		var stack []INodeInterface
+		leafNode, leafVersion, ok := bt.fingerLeaf(key, ti)
 		if !ok {
-			panic("expected LeafNodeInterface")
-		}
-		leafVersion := curVersion
-
-		// Check if we need to traverse to the sibling leaf node.
-		for leafNode.GetSiblingPtr() != nil && compareIntKeys(leafNode.GetHighKey(), key) < 0 {
-			sibling, ok := leafNode.GetSiblingPtr().(LeafNodeInterface)
+			leafNode, leafVersion, stack, ok = bt.findLeafWithStack(key)
 			if !ok {
-				panic("expected *LNodeHash")
-			}
-
-			siblingVersion, needRestart := sibling.TryReadLock()
-			if needRestart {
-				leafNode.WriteUnlock() // Release leafNode lock
-				sibling.WriteUnlock()
-				restart = true
-				break
-			}
-
-			leafEndVersion, needRestart := leafNode.GetVersion()
-			if needRestart || leafVersion != leafEndVersion {
-				sibling.WriteUnlock()  // Release sibling lock
-				leafNode.WriteUnlock() // Release leafNode lock
-				restart = true
-				break
+				continue insertLoop
 			}
-
-			leafNode = sibling
-			leafVersion = siblingVersion
-		}
-
-		if restart {
-			leafNode.WriteUnlock()
-			continue insertLoop // 跳转到最外层循环开始
 		}
+		bt.rememberLeaf(ti, leafNode, key)
 
 		//这里的Insert，应该是调用Insertable，而不是调用LNodeHash中的Insert
 		//应该是根据leaf的类型来执行不同的Insert
 		// Attempt to insert into the leaf node.
 		ret := leafNode.Insert(key, value, leafVersion)
 		if ret == NeedRestart { // Leaf node has been split during insertion.
-			leafNode.WriteUnlock() // Release leafNode lock
-			continue               // Restart the insert process.
-		} else if ret == InsertSuccess { // Insertion succeeded.
-			//TODO: 刷新parent的HighKey
-			// 1) 先检查是否新插入的 key > leafNode.HighKey
-			if compareIntKeys(key, leafNode.GetHighKey()) > 0 {
-				leafNode.SetHighKey(key)
+			continue // 叶子没有拿到写锁，直接重启
+		} else if ret == NeedConvert {
+			// 追加叶子遇到乱序的键，转换为 B 树叶子后重试
+			if bt.convert(leafNode, leafVersion, ti) {
+				leafNode.WriteUnlock()
 			}
-			leafNode.WriteUnlock()
+			continue
+		} else if ret == InsertSuccess { // Insertion succeeded.
+			// 1) 叶子在自己的锁内更新 HighKey 并释放锁，这里不能再解锁叶子，
+			//    否则会释放其他线程持有的节点锁或桶锁
 
 			// 2) 从栈顶往上遍历父节点，看是否需要更新 HighKey
 			//    注意要对父节点做相应的加锁更新，避免并发问题
@@ -147,11 +198,9 @@ insertLoop: // 标签
 					// 这里简化写法：先不做复杂的 restart，直接尝试一下
 					continue
 				}
-				// 升级为写锁
+				// 升级为写锁，失败时并未持有锁，直接跳过
 				ok, needRestart := parent.TryUpgradeWriteLock(parentVersion)
 				if !ok || needRestart {
-					parent.WriteUnlock()
-					// 同理，这里也可以再 break / continue 进行更复杂的重启处理
 					continue
 				}
 
@@ -164,136 +213,212 @@ insertLoop: // 标签
 			}
 			return
 		} else { // Leaf node split.
+			// LNodeBTree 返回 NeedSplit 时仍持有写锁，LNodeHash 在 Split 内部加分裂锁
 			splittableLeaf, splitKey := leafNode.Split(key, value, leafVersion)
-			if splittableLeaf == nil { // 另一线程已分裂该叶子节点
-				leafNode.WriteUnlock() // Release leafNode lock
-				continue               // 重启插入过程
+			if splittableLeaf == nil { // 另一线程已分裂该叶子节点，未持有锁
+				continue // 重启插入过程
 			}
 
 			newNode, ok := splittableLeaf.(NodeInterface)
 			if !ok {
 				panic("expected LeafNodeInterface new leaf node")
 			}
+			bt.installSplit(leafNode, newNode, splitKey, stack)
+			return
+		}
+	}
+}
+
+// installSplit 把叶子分裂出的 newNode 以 splitKey 插入父节点，必要时逐层分裂父节点或创建新根。
+// 调用时 leafNode 仍持有写锁，锁住父节点后释放；stack 为下探时记录的父节点，可以为空。
+func (bt *BTree) installSplit(leafNode LeafNodeInterface, newNode NodeInterface, splitKey interface{}, stack []INodeInterface) {
+	if len(stack) > 0 {
+		stackIdx := len(stack) - 1
+		oldParent := stack[stackIdx]
+	parentRestart:
+		for stackIdx >= 0 {
+			oldParent = stack[stackIdx]
+			originalNode := leafNode
+			restartParent := false
+			// Attempt to acquire write lock on the parent node.
+			parentVersion, needRestart := oldParent.TryReadLock()
+			if needRestart {
+				restartParent = true
+			}
 
-			if len(stack) > 0 {
-				stackIdx := len(stack) - 1
-				oldParent := stack[stackIdx]
-			parentRestart:
-				for stackIdx >= 0 {
-					oldParent = stack[stackIdx]
-					originalNode := leafNode
-					restartParent := false
-					// Attempt to acquire write lock on the parent node.
-					parentVersion, needRestart := oldParent.TryReadLock()
-					if needRestart {
-						oldParent.WriteUnlock() // Release oldParent's read lock
-						restartParent = true
-					}
-
-					if restartParent {
-						continue parentRestart // 跳转到最外层循环开始 // Restart the insert process.
-					}
-
-					/*while 未实现部分*/
-					// 遍历父节点，直到找到一个合适的节点来插入新的分裂键
-					for oldParent.GetSiblingPtr() != nil && compareIntKeys(oldParent.GetHighKey(), splitKey) < 0 {
-						sibling := oldParent.GetSiblingPtr()
-						siblingVersion, needRestart := sibling.TryReadLock()
-						if needRestart {
-							oldParent.WriteUnlock()
-							restartParent = true
-							break // 跳出循环，准备重启
-						}
-
-						parentEndVersion, needRestart := oldParent.GetVersion()
-						if needRestart || parentVersion != parentEndVersion {
-							oldParent.WriteUnlock()
-							restartParent = true
-							break // 版本不一致，准备重启
-						}
-
-						// 更新当前父节点为兄弟节点
-						oldParent = sibling.(INodeInterface)
-						parentVersion = siblingVersion
-					}
-
-					// 检查是否需要重启父节点处理过程
-					if restartParent {
-						continue parentRestart
-					}
-					success, needRestart := oldParent.TryUpgradeWriteLock(oldParent.GetLock())
-					if !success || needRestart {
-						oldParent.WriteUnlock()
-						continue parentRestart
-					}
-					if originalNode.GetLevel() != 0 {
-						originalNode.WriteUnlock()
-					} else {
-						originalNode.(LeafNodeInterface).WriteUnlock()
-					}
-					//else {
-					//	originalNode.(LeafNodeInterface).WriteUnlock()
-					//}
-
-					if !oldParent.IsFull() { // Normal insert.
-						//重点关注这个splitKey
-						oldParent.Insert(splitKey, newNode, oldParent.GetLock())
-						// ——> 在这里判断若 splitKey > oldParent.HighKey，就更新
-						if compareIntKeys(newNode.GetHighKey(), oldParent.GetHighKey()) > 0 {
-							oldParent.SetHighKey(newNode.GetHighKey())
-						}
-						//bt.PrintTree()
-						oldParent.WriteUnlock()
-						return
-					}
-
-					// Internal node split.
-					newParent, newSplitKey := oldParent.Split()
-					if compareIntKeys(splitKey, newSplitKey) <= 0 {
-						oldParent.Insert(splitKey, newNode, oldParent.GetLock())
-						// 若 splitKey > oldParent.HighKey，更新 oldParent
-						if compareIntKeys(newNode.GetHighKey(), oldParent.GetHighKey()) > 0 {
-							oldParent.SetHighKey(newNode.GetHighKey())
-						}
-					} else {
-						newParent.Insert(splitKey, newNode, newParent.GetLock())
-						// 若 splitKey > newParent.HighKey，更新 newParent
-						if compareIntKeys(newNode.GetHighKey(), newParent.GetHighKey()) > 0 {
-							newParent.SetHighKey(newNode.GetHighKey())
-						}
-					}
+			if restartParent {
+				continue parentRestart // 跳转到最外层循环开始 // Restart the insert process.
+			}
 
-					oldParent.WriteUnlock()
-					if stackIdx > 0 {
-						splitKey = newSplitKey
-						stackIdx--
-						oldParent = stack[stackIdx]
-						newNode = newParent
-					} else { // set new root
-						if oldParent == bt.root {
-							newRoot := NewINodeForHeightGrowth(oldParent.GetHighKey(), oldParent, newParent, nil, oldParent.GetLevel()+1, newParent.GetHighKey())
-							bt.root = newRoot
-							oldParent.WriteUnlock()
-						} else {
-							bt.insertKey(newSplitKey, newParent, oldParent)
-						}
-						return
-					}
+			/*while 未实现部分*/
+			// 遍历父节点，直到找到一个合适的节点来插入新的分裂键
+			for oldParent.GetSiblingPtr() != nil && compareIntKeys(oldParent.GetHighKey(), splitKey) < 0 {
+				sibling := oldParent.GetSiblingPtr()
+				siblingVersion, needRestart := sibling.TryReadLock()
+				if needRestart {
+					restartParent = true
+					break // 跳出循环，准备重启
+				}
+
+				parentEndVersion, needRestart := oldParent.GetVersion()
+				if needRestart || parentVersion != parentEndVersion {
+					restartParent = true
+					break // 版本不一致，准备重启
+				}
+
+				// 更新当前父节点为兄弟节点
+				oldParent = sibling.(INodeInterface)
+				parentVersion = siblingVersion
+			}
+
+			// 检查是否需要重启父节点处理过程
+			if restartParent {
+				continue parentRestart
+			}
+			success, needRestart := oldParent.TryUpgradeWriteLock(parentVersion)
+			if !success || needRestart {
+				continue parentRestart
+			}
+			if originalNode.GetLevel() != 0 {
+				originalNode.WriteUnlock()
+			} else {
+				originalNode.(LeafNodeInterface).WriteUnlock()
+			}
+			//else {
+			//	originalNode.(LeafNodeInterface).WriteUnlock()
+			//}
+
+			if !oldParent.IsFull() { // Normal insert.
+				//重点关注这个splitKey
+				oldParent.Insert(splitKey, newNode, oldParent.GetLock())
+				// ——> 在这里判断若 splitKey > oldParent.HighKey，就更新
+				if compareIntKeys(newNode.GetHighKey(), oldParent.GetHighKey()) > 0 {
+					oldParent.SetHighKey(newNode.GetHighKey())
+				}
+				//bt.PrintTree()
+				oldParent.WriteUnlock()
+				return
+			}
+
+			// Internal node split.
+			newParent, newSplitKey := oldParent.Split()
+			if compareIntKeys(splitKey, newSplitKey) <= 0 {
+				oldParent.Insert(splitKey, newNode, oldParent.GetLock())
+				// 若 splitKey > oldParent.HighKey，更新 oldParent
+				if compareIntKeys(newNode.GetHighKey(), oldParent.GetHighKey()) > 0 {
+					oldParent.SetHighKey(newNode.GetHighKey())
 				}
 			} else {
-				// Set new root node.
-				if bt.root == leafNode { // Current node is root.
-					newRoot := NewINodeForHeightGrowth(splitKey, leafNode, newNode, nil, leafNode.GetLevel()+1, newNode.GetHighKey())
+				newParent.Insert(splitKey, newNode, newParent.GetLock())
+				// 若 splitKey > newParent.HighKey，更新 newParent
+				if compareIntKeys(newNode.GetHighKey(), newParent.GetHighKey()) > 0 {
+					newParent.SetHighKey(newNode.GetHighKey())
+				}
+			}
+
+			oldParent.WriteUnlock()
+			if stackIdx > 0 {
+				splitKey = newSplitKey
+				stackIdx--
+				oldParent = stack[stackIdx]
+				newNode = newParent
+			} else { // set new root
+				if oldParent == bt.root {
+					newRoot := NewINodeForHeightGrowth(oldParent.GetHighKey(), oldParent, newParent, nil, oldParent.GetLevel()+1, newParent.GetHighKey())
+					newRoot.opts = bt.opts
 					bt.root = newRoot
-				} else { // Another thread has already created a new root.
-					bt.insertKey(splitKey, newNode, leafNode)
+					oldParent.WriteUnlock()
+				} else {
+					bt.insertKey(newSplitKey, newParent, oldParent)
 				}
-				leafNode.WriteUnlock() // Ensure to release leafNode lock
 				return
 			}
+		}
+	} else {
+		// Set new root node.
+		if bt.root == leafNode { // Current node is root.
+			newRoot := NewINodeForHeightGrowth(splitKey, leafNode, newNode, nil, leafNode.GetLevel()+1, newNode.GetHighKey())
+			newRoot.opts = bt.opts
+			bt.root = newRoot
+			leafNode.WriteUnlock() // Ensure to release leafNode lock
+		} else {
+			// 另一线程已经创建了新根，或者叶子来自 finger 没有父节点栈。
+			// insertKey 锁住父节点后会释放 leafNode 的锁，这里不能再次解锁
+			bt.insertKey(splitKey, newNode, leafNode)
+		}
+		return
+	}
+}
+
+// findLeafWithStack 从根下探到 key 所在的叶子，同时记录沿途的父节点(不含经由兄弟指针右移的节点)，
+// 供叶子分裂时向上插入分裂键。版本校验失败时 ok 为 false，调用方重启即可，过程中不持有任何锁。
+func (bt *BTree) findLeafWithStack(key interface{}) (leafNode LeafNodeInterface, leafVersion uint64, stack []INodeInterface, ok bool) {
+	cur := bt.root
+	stack = make([]INodeInterface, 0)
+
+	// Attempt to acquire read lock on the root node.
+	curVersion, needRestart := cur.TryReadLock()
+	if needRestart {
+		return nil, 0, nil, false
+	}
+	// 下探过程只持有乐观读版本，重启时不需要也不能释放任何写锁，
+	// 否则会错误地释放其他线程持有的锁。
+	// Tree traversal to find the leaf node.
+	for cur.GetLevel() != 0 {
+		parent, ok := cur.(INodeInterface)
+		if !ok {
+			panic("Need INodeInterface")
+		}
+		child := parent.ScanNode(key)
+		if child == nil {
+			panic("ScanNode returned nil")
+		}
+		childVersion, needRestart := child.TryReadLock()
+		if needRestart {
+			return nil, 0, nil, false
+		}
+
+		// Check version consistency.
+		curEndVersion, needRestart := cur.GetVersion()
+		if needRestart || curVersion != curEndVersion {
+			return nil, 0, nil, false
+		}
+
+		if child != parent.GetSiblingPtr() {
+			stack = append(stack, parent)
+		}
+
+		cur = child
+		curVersion = childVersion
+	}
+
+	leafNode, ok = cur.(LeafNodeInterface)
+	if !ok {
+		panic("expected LeafNodeInterface")
+	}
+	leafVersion = curVersion
 
+	// Check if we need to traverse to the sibling leaf node.
+	for leafNode.GetSiblingPtr() != nil && compareIntKeys(leafNode.GetHighKey(), key) < 0 {
+		sibling, ok := leafNode.GetSiblingPtr().(LeafNodeInterface)
+		if !ok {
+			panic("expected *LNodeHash")
+		}
+
+		siblingVersion, needRestart := sibling.TryReadLock()
+		if needRestart {
+			return nil, 0, nil, false
 		}
+
+		leafEndVersion, needRestart := leafNode.GetVersion()
+		if needRestart || leafVersion != leafEndVersion {
+			return nil, 0, nil, false
+		}
+
+		leafNode = sibling
+		leafVersion = siblingVersion
 	}
+	return leafNode, leafVersion, stack, true
 }
 
 // insertKey is called when the root has been split by another thread.
@@ -312,37 +437,24 @@ insertLoop:
 
 		parentIF, ok := cur.(INodeInterface)
 		if !ok {
-			// 解锁后panic
-			cur.WriteUnlock()
 			panic("expected INodeInterface")
 		}
 		// 遍历树，找到 level = prev.level + 1 的内部节点
 		for parentIF.GetLevel() != prev.GetLevel()+1 {
 			child := parentIF.ScanNode(key)
 			if child == nil {
-				// 解锁 -> panic
-				parentIF.WriteUnlock()
-				cur.WriteUnlock()
 				panic("ScanNode returned nil")
 			}
+			// 下探过程只持有乐观读版本，重试时无需解锁
 			childVersion, cNeedRestart := child.TryReadLock()
 			if cNeedRestart {
-				// 解锁 -> 重试
-				parentIF.WriteUnlock()
-				cur.WriteUnlock()
 				continue insertLoop
 			}
 			// 版本一致性检查
 			curEndVersion, verNeedRestart := cur.GetVersion()
 			if verNeedRestart || (curVersionStart != curEndVersion) {
-				child.WriteUnlock()
-				parentIF.WriteUnlock()
-				cur.WriteUnlock()
 				continue insertLoop
 			}
-			// 下探：先解锁parent & cur
-			parentIF.WriteUnlock()
-			cur.WriteUnlock()
 
 			// 下探
 			cur = child
@@ -351,7 +463,6 @@ insertLoop:
 			// 更新 parentIF
 			pIF, ok := cur.(INodeInterface)
 			if !ok {
-				cur.WriteUnlock()
 				panic("expected INodeInterface in insertKey down path")
 			}
 			parentIF = pIF
@@ -361,22 +472,14 @@ insertLoop:
 			sibling := parentIF.GetSiblingPtr()
 			siblingVersionStart, sNeedRestart := sibling.TryReadLock()
 			if sNeedRestart {
-				// 解锁 -> 重试
-				parentIF.WriteUnlock()
-				cur.WriteUnlock()
 				continue insertLoop
 			}
 
 			parentEndVersion, pNeedRestart := parentIF.GetVersion()
 			if pNeedRestart || curVersionStart != parentEndVersion {
-				sibling.WriteUnlock()
-				parentIF.WriteUnlock()
-				cur.WriteUnlock()
 				continue insertLoop
 			}
 			// 下一个兄弟
-			parentIF.WriteUnlock()
-			cur.WriteUnlock()
 
 			parentIF = sibling.(INodeInterface)
 			cur = sibling
@@ -386,8 +489,7 @@ insertLoop:
 		// 尝试升级为写锁
 		success, needRestart := parentIF.TryUpgradeWriteLock(curVersionStart)
 		if needRestart || !success {
-			// 升级失败 -> 解锁 -> 重试
-			parentIF.WriteUnlock()
+			// 升级失败，未持有锁 -> 重试
 			continue
 		}
 
@@ -427,22 +529,23 @@ insertLoop:
 
 			if parentIF == bt.root {
 				// 创建新的根节点.newParent成为了INodeInterface
-				newRoot := NewINodeForHeightGrowth(splitKey, parentIF, newParent, nil, parentIF.GetLevel()+1, parentIF.GetHighKey())
+				newRoot := NewINodeForHeightGrowth(splitKey, parentIF, newParent, nil, parentIF.GetLevel()+1, newParent.GetHighKey())
+				newRoot.opts = bt.opts
 				bt.root = newRoot
 				parentIF.WriteUnlock()
 			} else {
-				// 递归插到更高层
-				parentIF.WriteUnlock()
-				bt.insertKey(splitKey, newParent, cur)
+				// 递归插到更高层，锁住上一层的父节点后才释放 parentIF
+				bt.insertKey(splitKey, newParent, parentIF)
 			}
+			// 分裂后 key 已经插入，不能再回到循环开头重复插入
+			return
 		}
 	}
 }
 
-func (bt *BTree) Lookup(key interface{}, ti *ThreadInfo) interface{} {
-	eg := NewEpocheGuardReadonly(ti)
-	defer eg.Release()
-
+// findLeaf 乐观地从根下探到 key 所在的叶子节点，返回叶子及其读版本。
+// 不持有任何锁，调用方需要在使用叶子后自行校验版本。
+func (bt *BTree) findLeaf(key interface{}) (LeafNodeInterface, uint64) {
 restart:
 	cur := bt.root
 	curVersion, needRestart := cur.TryReadLock()
@@ -450,7 +553,6 @@ restart:
 		goto restart
 	}
 
-	// traversal
 	for cur.GetLevel() != 0 {
 		parent, ok := cur.(INodeInterface)
 		if !ok {
@@ -471,17 +573,14 @@ restart:
 		curVersion = childVersion
 	}
 
-	// found leaf
 	leaf, ok := cur.(LeafNodeInterface)
 	if !ok {
 		panic("expected LeafNodeInterface")
 	}
 	leafVersion := curVersion
 
-	// move right if necessary
 	for leaf.GetSiblingPtr() != nil && compareIntKeys(leaf.GetHighKey(), key) < 0 {
 		sibling := leaf.GetSiblingPtr()
-
 		siblingVersion, needRestart := sibling.TryReadLock()
 		if needRestart {
 			goto restart
@@ -499,27 +598,67 @@ restart:
 		leaf = lf
 		leafVersion = siblingVersion
 	}
-	needRestart = false
-	val, needRestart := leaf.Find(key) // 封装leaf.find
-	if needRestart {
-		goto restart
-	}
+	return leaf, leafVersion
+}
 
-	leafEndVersion, needRestart := leaf.GetVersion()
-	if needRestart || (leafVersion != leafEndVersion) {
-		goto restart
+func (bt *BTree) Lookup(key interface{}, ti *ThreadInfo) interface{} {
+	val, _ := bt.lookup(key, ti)
+	return val
+}
+
+// lookup 与 Lookup 相同，另外返回 key 是否存在，用于区分不存在和值为 nil
+func (bt *BTree) lookup(key interface{}, ti *ThreadInfo) (interface{}, bool) {
+	val, found := bt.lookupRaw(key, ti)
+	if found && bt.expires() {
+		if val, found = liveValue(val, ttlNow()); !found {
+			val = nil
+		}
 	}
-	if val != nil {
-		return val
+	return val, found
+}
+
+// lookupRaw 与 lookup 相同，但值保留过期时间，过期的条目同样返回
+func (bt *BTree) lookupRaw(key interface{}, ti *ThreadInfo) (interface{}, bool) {
+	eg := NewEpocheGuardReadonly(ti)
+	defer eg.Release()
+
+	for {
+		leaf, leafVersion := bt.locateLeaf(key, ti)
+		val, found := leaf.Find(key) // Find 的第二个返回值表示是否找到，而不是需要重启
+
+		leafEndVersion, needRestart := leaf.GetVersion()
+		if needRestart || (leafVersion != leafEndVersion) {
+			continue
+		}
+		return val, found
 	}
-	return nil
 }
 
+// Remove 删除 key，返回 key 是否存在。日志在修改树之前写入，key 不存在时同样会写一条记录
 func (bt *BTree) Remove(key interface{}, ti *ThreadInfo) bool {
+	turn, ok := bt.logWrite(bt.walRecord(walRemove, key), key.(int))
+	if !ok {
+		return false
+	}
+	defer turn.done()
+	w := bt.versions.beginWrite(bt, key, ti)
+	defer w.end()
+	live := true
+	if bt.expires() {
+		// 过期的键同样删除，但对调用方来说它已经不存在
+		_, live = bt.lookup(key, ti)
+	}
+	return bt.remove(key, ti) && live
+}
+
+func (bt *BTree) remove(key interface{}, ti *ThreadInfo) bool {
 	eg := NewEpocheGuard(ti)
 	defer eg.Release()
 
 restart:
+	if bt.Err() != nil {
+		return false
+	}
 	cur := bt.root
 	curVersion, needRestart := cur.TryReadLock()
 	if needRestart {
@@ -584,65 +723,38 @@ restart:
 	}
 }
 
+// Update 修改已存在的 key 的值，返回 key 是否存在。与 Remove 相同，日志在修改树之前写入；
+// 值不能编码时与 Insert 相同
 func (bt *BTree) Update(key, value interface{}, ti *ThreadInfo) bool {
-	eg := NewEpocheGuardReadonly(ti)
-	defer eg.Release()
-restart:
-	cur := bt.root
-	curVersion, needRestart := cur.TryReadLock()
-	if needRestart {
-		goto restart
-	}
-
-	// traversal
-	for cur.GetLevel() != 0 {
-		parent, ok := cur.(INodeInterface)
-		if !ok {
-			panic("expected *INode")
-		}
-
-		child := parent.ScanNode(key)
-		childVersion, needRestart := child.TryReadLock()
-		if needRestart {
-			goto restart
-		}
-
-		parentEndVersion, needRestart := parent.GetVersion()
-		if needRestart || curVersion != parentEndVersion {
-			goto restart
-		}
-
-		cur = child
-		curVersion = childVersion
+	rec, err := bt.walValueRecord(walUpdate, key, value)
+	if err != nil {
+		bt.fail(err)
+		return false
 	}
-
-	// found leaf
-	leaf, ok := cur.(LeafNodeInterface)
+	turn, ok := bt.logWrite(rec, key.(int))
 	if !ok {
-		panic("expected LeafNodeInterface")
+		return false
 	}
-	leafVersion := curVersion
-
-	// move right if necessary
-	for leaf.GetSiblingPtr() != nil && compareIntKeys(leaf.GetHighKey(), key) < 0 {
-		sibling := leaf.GetSiblingPtr()
-		siblingVersion, needRestart := sibling.TryReadLock()
-		if needRestart {
-			goto restart
-		}
-
-		leafEndVersion, needRestart := leaf.GetVersion()
-		if needRestart || leafVersion != leafEndVersion {
-			goto restart
+	defer turn.done()
+	w := bt.versions.beginWrite(bt, key, ti)
+	defer w.end()
+	if bt.expires() {
+		// 过期的键不能被更新，否则会去掉过期时间让它重新可见
+		if _, live := bt.lookup(key, ti); !live {
+			return false
 		}
+	}
+	return bt.update(key, value, ti)
+}
 
-		lf, ok := sibling.(LeafNodeInterface)
-		if !ok {
-			panic("expected LeafNodeInterface")
-		}
-		leaf = lf
-		leafVersion = siblingVersion
+func (bt *BTree) update(key, value interface{}, ti *ThreadInfo) bool {
+	eg := NewEpocheGuardReadonly(ti)
+	defer eg.Release()
+restart:
+	if bt.Err() != nil {
+		return false
 	}
+	leaf, leafVersion := bt.locateLeaf(key, ti)
 
 	ret := leaf.Update(key, value, leafVersion) // leaf.update的封装调用
 	if ret == NeedRestart {
@@ -802,18 +914,11 @@ batchLoop:
 			parent.WriteUnlock()
 			bt.BatchInsert(splitKey, nodeInterfaceForINodeInterface(newNodes), newNum, parent, ti)
 		} else {
-			// 若就是 root, 可能需要多层调整
-			// 参考C++逻辑: NewRootForAdjustment
-			for parent.GetCardinality() < newNum {
-				newRoots, newSize := bt.NewRootForAdjustment(splitKey, nodeInterfaceForINodeInterface(newNodes), newNum)
-				newNodes = newRoots
-				newNum = newSize
-			}
-
-			// 生成新根
-			newRoot := NewINodeForInsertInBatch(newNodes[0].GetLevel() + 1)
-			newRoot.InsertForRoot(splitKey, nodeInterfaceForINodeInterface(newNodes), parent, newNum)
-			bt.root = newRoot
+			// 若就是 root, 新节点可能超过一个根节点的容量，需要多层调整
+			// splitKey[i] 是 newNodes[i] 左侧的分隔键，根节点 parent 位于最左侧
+			keys := append([]interface{}{nil}, splitKey...)
+			nodes := append([]NodeInterface{parent}, nodeInterfaceForINodeInterface(newNodes)...)
+			bt.root = newRootForNodes(keys, nodes)
 			parent.WriteUnlock()
 		}
 		return
@@ -837,7 +942,9 @@ func (bt *BTree) NewRootForAdjustment(key []interface{}, value []NodeInterface,
 	for i := 0; i < new_num; i++ {
 		// 假设level+1
 		level := value[0].GetLevel() + 1
-		new_roots[i] = NewINodeForInsertInBatch(level)
+		root := NewINodeForInsertInBatch(level)
+		root.opts = bt.opts
+		new_roots[i] = root
 		// 在C++中是 new_roots[i]->batch_insert(key, value, idx, num, batch_size);
 		// 在Go中需要INode实现batch_insert或batch_insert_last_level
 		// 根据之前逻辑实现:
@@ -849,6 +956,36 @@ func (bt *BTree) NewRootForAdjustment(key []interface{}, value []NodeInterface,
 	return new_roots, new_num
 }
 
+// newRootForNodes 为同一层从左到右相连的 nodes 逐层建立父节点，直到只剩一个根节点。
+// keys[i](i >= 1) 是 nodes[i-1] 与 nodes[i] 之间的分隔键，keys[0] 不使用；
+// 每个父节点最多容纳 INodeCardinality+1 个孩子，孩子在同层父节点之间平均分配。
+func newRootForNodes(keys []interface{}, nodes []NodeInterface) INodeInterface {
+	fanout := INodeCardinality + 1
+	for {
+		num := (len(nodes) + fanout - 1) / fanout
+		parents := make([]NodeInterface, num)
+		parentKeys := make([]interface{}, num)
+		for p := 0; p < num; p++ {
+			from, to := p*len(nodes)/num, (p+1)*len(nodes)/num
+			parent := NewINodeForInsertInBatch(nodes[0].GetLevel() + 1)
+			parent.opts = optionsOf(nodes[0])
+			parent.InsertForRoot(keys[from:to], nodes[from:to], nodes[from], to-from)
+			if highKey := nodes[to-1].GetHighKey(); highKey != nil {
+				parent.SetHighKey(highKey)
+			}
+			if p > 0 {
+				parents[p-1].(INodeInterface).SetSibling(parent)
+			}
+			parents[p] = parent
+			parentKeys[p] = keys[from]
+		}
+		if num == 1 {
+			return parents[0].(INodeInterface)
+		}
+		keys, nodes = parentKeys, parents
+	}
+}
+
 func nodeInterfaceForINodeInterface(nodes []INodeInterface) []NodeInterface {
 	res := make([]NodeInterface, len(nodes))
 	for i, n := range nodes {
@@ -867,190 +1004,107 @@ func nodeInterfaceSliceForBTreeNode(nodes []*LNodeBTree) []NodeInterface {
 // RangeLookup performs a range lookup starting from minKey, collecting up to rng items.
 // Returns the collected results in a slice.
 func (bt *BTree) RangeLookup(minKey interface{}, rng int, ti *ThreadInfo) []interface{} {
+	return entryValues(bt.RangeLookupEntries(minKey, rng, ti))
+}
+
+// RangeLookupEntries 与 RangeLookup 相同，但返回带键的条目，结果按键有序，包含 minKey 本身
+func (bt *BTree) RangeLookupEntries(minKey interface{}, rng int, ti *ThreadInfo) []Entry {
+	return bt.rangeLookupEntries(minKey, rng, false, ti)
+}
+
+// rangeLookupEntries 实现 RangeLookupEntries。raw 为 true 时值保留过期时间，过期的条目同样返回
+func (bt *BTree) rangeLookupEntries(minKey interface{}, rng int, raw bool, ti *ThreadInfo) []Entry {
 	eg := NewEpocheGuard(ti)
 	defer eg.Release()
-	//const maxAttempts = 100 // 你可以根据需求适当调大
-	//attempts := 0
+	results := make([]Entry, 0, minInt(rng, scanPreallocMax)) // 用来收集本次查询的结果
+	expires := !raw && bt.expires()
 rangeLoop:
 	for {
-		//attempts++
-		//if attempts > maxAttempts {
-		//	// 达到最大重试次数，防止无限循环
-		//	fmt.Printf("RangeLookup: too many attempts (%d). Abort this query.\n", attempts)
-		//	// 这里你可以选择返回空 slice、或返回nil、或抛出异常
-		//	return nil
-		//}
-		results := make([]interface{}, 0, rng) // 用来收集本次查询的结果
-		cur := bt.root
-		curVersion, needRestart := cur.TryReadLock()
-		if needRestart {
-			continue rangeLoop
-		}
-
-		// 1) 从根下探到叶子
-		for cur.GetLevel() != 0 {
-			parent, ok := cur.(INodeInterface)
-			if !ok {
-				cur.WriteUnlock()
-				panic(fmt.Sprintf("Need INodeInterface, got: %T (%v)", cur, reflect.TypeOf(cur)))
-			}
-			child := parent.ScanNode(minKey)
-			if child == nil {
-				parent.WriteUnlock()
-				cur.WriteUnlock()
-				panic("ScanNode returned nil")
-			}
-			childVersion, childNeedRestart := child.TryReadLock()
-			if childNeedRestart {
-				//fmt.Println("need loop, childVersion: ", childVersion)
-				parent.WriteUnlock()
-				cur.WriteUnlock()
-				continue rangeLoop
-			}
-
-			// Check version consistency
-			curEndVersion, curNeedRestart := cur.GetVersion()
-			if curNeedRestart || (curVersion != curEndVersion) {
-				child.WriteUnlock()
-				parent.WriteUnlock()
-				cur.WriteUnlock()
-				//fmt.Println("need loop, curEndVersion: ", curEndVersion)
-				continue rangeLoop
+		// 已经校验过的叶子的结果保留，重启时从最后一个键之后继续，
+		// 范围跨越的叶子多于分层存储的缓冲池容量时也能完成
+		if n := len(results); n > 0 {
+			last := results[n-1].Key.(int)
+			if last == math.MaxInt {
+				return results
 			}
-			// 释放父节点
-			parent.WriteUnlock()
-			cur.WriteUnlock()
-			// 下探到 child
-			cur = child
-			curVersion = childVersion
+			minKey = last + 1
 		}
 
-		// 2) 此时 cur 为叶节点
-		leaf, ok := cur.(LeafNodeInterface)
-		if !ok {
-			cur.WriteUnlock()
-			panic(fmt.Sprintf("expected LeafNodeInterface, got %T", cur))
-		}
-		leafVersion := curVersion
+		// 1) 从根下探到叶子
+		leaf, leafVersion := bt.findLeaf(minKey)
 		continued := false
 
-		// 3) 不断在当前或兄弟节点中收集，直到 results >= rng
+		// 2) 不断在当前或兄弟节点中收集，直到 results >= rng
 		for len(results) < rng {
-			// a) 如果当前叶节点的HighKey < minKey，则说明要去兄弟节点
-			for leaf.GetSiblingPtr() != nil && compareIntKeys(leaf.GetHighKey(), minKey) < 0 {
-				sibling := leaf.GetSiblingPtr()
-				siblingVersion, sibRestart := sibling.TryReadLock()
-				if sibRestart {
-					leaf.WriteUnlock()
-					//fmt.Println("need loop, siblingVersion: ", siblingVersion)
-					continue rangeLoop
-				}
-
-				leafEndVersion, leafNeedRestart := leaf.GetVersion()
-				if leafNeedRestart || (leafVersion != leafEndVersion) {
-					//fmt.Println("need loop, leafNeedRestart: ", leafNeedRestart)
-					sibling.WriteUnlock()
-					leaf.WriteUnlock()
-					continue rangeLoop
-				}
-				// 切换到 sibling
-				leaf.WriteUnlock()
-				lf, ok := sibling.(LeafNodeInterface)
-				if !ok {
-					sibling.WriteUnlock()
-					panic("expected LeafNodeInterface")
-				}
-				leaf = lf
-				leafVersion = siblingVersion
-			}
-
-			// b) 调用叶节点的 RangeLookUp
-			//    注意这里的 continued 可以根据需要来设置
-			//    初次进入可以传 continued=false，若需要多次在同节点内收集则可传true
-			collected, retCode, _ := leaf.RangeLookUp(minKey, rng-len(results), continued, leafVersion)
+			collected, retCode, _ := leaf.RangeLookUpEntries(minKey, scanUpTo(rng-len(results), expires), continued, leafVersion)
 			if retCode == NeedRestart {
-				leaf.WriteUnlock()
 				continue rangeLoop
 			} else if retCode == NeedConvert {
-				//fmt.Println("需要将LNodeHash转换为LNodeBtree,LeafNode:")
-				//printNode(leaf, "", false)
-				//bt.PrintTree()
-				bt.convert(leaf, leafVersion, ti)
-				//fmt.Println("转换完成，打印Tree")
-				//bt.PrintTree()
-				leaf.WriteUnlock()
+				// 转换成功时旧叶子仍处于锁定状态，需要在这里释放；失败时 Convert 已自行解锁
+				if bt.convert(leaf, leafVersion, ti) {
+					leaf.WriteUnlock()
+				}
 				continue rangeLoop
 			}
 			continued = true
-			// 追加收集结果
-			results = append(results, collected...)
 
-			// 如果已经收集够了 rng
-			if len(results) >= rng {
-				leaf.WriteUnlock()
-				return results
-			}
-
-			// c) 检查兄弟节点
+			// c) 读取兄弟指针并校验版本，保证收集到的结果一致
 			sibling := leaf.GetSiblingPtr()
 			leafEndVersion, needRestart := leaf.GetVersion()
 			if needRestart || (leafVersion != leafEndVersion) {
-				// 版本变化 -> 解锁 -> 重启
-				leaf.WriteUnlock()
 				continue rangeLoop
 			}
+			results = appendScanned(results, collected, rng, expires)
 
-			if sibling == nil {
-				// 到达最右叶子,结束
-				leaf.WriteUnlock()
+			if len(results) >= rng || sibling == nil {
 				return results
 			}
 
 			// 切换到 sibling
 			siblingVersion, sibRestart := sibling.TryReadLock()
 			if sibRestart {
-				leaf.WriteUnlock()
-				//fmt.Println("need loop, siblingVersion: ", siblingVersion)
-				continue rangeLoop
-			}
-			leafEndVersion, leafNeedRestart := leaf.GetVersion()
-			if leafNeedRestart || (leafVersion != leafEndVersion) {
-				sibling.WriteUnlock()
-				leaf.WriteUnlock()
-				//fmt.Println("need loop, leafNeedRestart: ", leafNeedRestart)
 				continue rangeLoop
 			}
-
-			leaf.WriteUnlock()
-
 			lf, ok := sibling.(LeafNodeInterface)
 			if !ok {
-				sibling.WriteUnlock()
 				panic("expected LeafNodeInterface")
 			}
 			leaf = lf
 			leafVersion = siblingVersion
 		}
-
-		// 如果循环退出时 results>=rng 或无兄弟 => 结束
-		leaf.WriteUnlock()
 		return results
 	}
 }
 
 // convert 对叶子节点进行转换，与C++一致
 func (bt *BTree) convert(leaf LeafNodeInterface, leafVersion uint64, ti *ThreadInfo) bool {
-	hashNode, ok := leaf.(*LNodeHash)
-	if !ok {
+	if c, ok := leaf.(*LNodeCompressed); ok {
+		// 压缩叶子解压后没有需要调用方释放的锁
+		c.thaw(leafVersion)
+		return false
+	}
+	var bTreeNodes []*LNodeBTree
+	var num int
+	var err error
+	switch l := leaf.(type) {
+	case *LNodeHash:
+		bTreeNodes, num, err = l.Convert(leafVersion)
+	case *LNodeAppend:
+		bTreeNodes, num, err = l.Convert(leafVersion)
+	default:
 		panic("Need leaf to be LNodeHashs")
 	}
-	bTreeNodes, num, err := hashNode.Convert(leafVersion)
 	if err != nil {
 		return false
 	}
 	if bTreeNodes == nil {
 		return false
 	}
+	if bt.pool != nil {
+		for _, n := range bTreeNodes[:num] {
+			bt.pool.adopt(n)
+		}
+	}
 	split_key := make([]interface{}, num)
 	split_key[0] = bTreeNodes[0].GetHighKey()
 	for i := 1; i < num; i++ {
@@ -1059,13 +1113,11 @@ func (bt *BTree) convert(leaf LeafNodeInterface, leafVersion uint64, ti *ThreadI
 
 	// 检查 prev 是否为根节点
 	if leaf == bt.root {
-		// 创建一个新的内部根节点
-		newRoot := NewINodeForInsertInBatch(bTreeNodes[0].GetLevel() + 1)
-		newRoot.InsertForRoot(split_key, nodeInterfaceSliceForBTreeNode(bTreeNodes), bTreeNodes[0], num)
-		bt.root = newRoot
+		// 创建新的内部根节点，原地扩容后的哈希叶子可能转换出超过一个内部节点容量的 B 树叶子
+		bt.root = newRootForNodes(split_key, nodeInterfaceSliceForBTreeNode(bTreeNodes))
 		// 释放旧根节点的锁并标记为待删除
 		bTreeNodes[0].WriteUnlock()
-		hashNode.WriteUnlockObsolete()
+		leaf.WriteUnlockObsolete()
 		ti.Epoche.MarkNodeForDeletion(leaf, ti)
 		return true
 	}
@@ -1090,7 +1142,7 @@ func (bt *BTree) ConvertAll(ti *ThreadInfo) {
 	// 在C++中没有明确的重启逻辑，此处暂不做重启处理
 
 	for {
-		if leaf.GetType() == BTreeNode {
+		if leaf.GetType() == BTreeNode || leaf.GetType() == CompressedNode {
 			if leaf.GetNode() == Empty {
 				return
 			}
@@ -1397,6 +1449,10 @@ func printNode(n NodeInterface, prefix string, isTail bool) {
 		nodeDesc = fmt.Sprintf("LNodeBTree(level=%d, highKey=%v, count=%d)", n.GetLevel(), n.GetHighKey(), n.GetCount())
 	case HashNode:
 		nodeDesc = fmt.Sprintf("LNodeHash(level=%d, highKey=%v, count=%d)", n.GetLevel(), n.GetHighKey(), n.GetCount())
+	case AppendNode:
+		nodeDesc = fmt.Sprintf("LNodeAppend(level=%d, highKey=%v, count=%d)", n.GetLevel(), n.GetHighKey(), n.GetCount())
+	case CompressedNode:
+		nodeDesc = fmt.Sprintf("LNodeCompressed(level=%d, highKey=%v, count=%d)", n.GetLevel(), n.GetHighKey(), n.GetCount())
 	default:
 		nodeDesc = fmt.Sprintf("UnknownNodeType(level=%d, highKey=%v, count=%d)", n.GetLevel(), n.GetHighKey(), n.GetCount())
 	}
@@ -0,0 +1,338 @@
+package blinkhash
+
+import (
+	"math"
+	"runtime"
+	"sync/atomic"
+)
+
+// TruncateBefore 删除所有小于 key 的条目，返回删除的条目数(包括已过期但还没有删除的条目)。
+//
+// 时间序列的保留策略是删除早于某个时刻的全部数据。逐个 Remove 每个键都要从根下探一次，
+// 还会留下空叶子；这里沿从根到 key 所在叶子的路径，把每层位于 key 左侧的孩子整棵摘下：
+// 路径上的内部节点改以覆盖 key 的孩子作为 leftmostPtr 并删去它之前的分隔键，
+// key 所在的叶子删去小于 key 的条目后成为最左的叶子，摘下的节点标记为过时后一起交给 Epoche。
+// 工作量与摘下的节点数成正比，不需要读取其中的条目，有活跃快照时除外：被删除的条目需要记录为前像。
+// 执行期间挡住所有写入(包括写日志和等待落盘)，读者通过节点版本发现变化后重试。树的高度不变
+func (bt *BTree) TruncateBefore(key interface{}, ti *ThreadInfo) int {
+	k := key.(int)
+	bt.lockWrites()
+	defer bt.unlockWrites()
+	if !bt.logLocked(bt.walRecord(walTruncate, k)) {
+		return 0
+	}
+	return bt.truncateBefore(k, ti)
+}
+
+// truncateBefore 在调用方挡住所有写入时执行截断，不写日志
+func (bt *BTree) truncateBefore(k int, ti *ThreadInfo) int {
+	eg := NewEpocheGuard(ti)
+	defer eg.Release()
+	for {
+		if bt.Err() != nil {
+			return 0
+		}
+		if t, ok := bt.lockTruncation(k, ti); ok {
+			return t.apply(bt, ti)
+		}
+		runtime.Gosched()
+	}
+}
+
+// detachment 一次结构删除锁住的节点：kept 为修改后保留的节点，detached 为整个摘下的节点。
+// 截断和范围删除都先锁住全部涉及的节点再修改，修改完成后由 release 统一释放
+type detachment struct {
+	kept     []NodeInterface
+	detached []NodeInterface
+}
+
+// baseNode 返回 n 内嵌的 Node。这里的节点都只锁了节点本身，
+// 解锁时绕过哈希叶子同时释放桶锁的 WriteUnlock
+func baseNode(n NodeInterface) *Node {
+	return n.(interface{ GetNode() *Node }).GetNode()
+}
+
+func (d *detachment) unlock() {
+	for _, n := range d.kept {
+		baseNode(n).WriteUnlock()
+	}
+	for _, n := range d.detached {
+		baseNode(n).WriteUnlock()
+	}
+}
+
+// lockKept 尝试锁住要保留的节点 n，失败时释放全部的锁
+func (d *detachment) lockKept(n NodeInterface) bool {
+	if !n.TryWriteLock() {
+		d.unlock()
+		return false
+	}
+	d.kept = append(d.kept, n)
+	return true
+}
+
+// lockDetached 尝试锁住要摘下的 nodes，失败时释放全部的锁
+func (d *detachment) lockDetached(nodes []NodeInterface) bool {
+	for _, n := range nodes {
+		if !n.TryWriteLock() {
+			d.unlock()
+			return false
+		}
+		d.detached = append(d.detached, n)
+	}
+	return true
+}
+
+// linked 检查 nodes 沿兄弟指针依次相连并且最后一个的兄弟是 last。
+// 不相连说明有分裂出的节点还没有挂到父节点上，调用方释放全部的锁后重试
+func linked(nodes []NodeInterface, last NodeInterface) bool {
+	for i, n := range nodes {
+		want := last
+		if i+1 < len(nodes) {
+			want = nodes[i+1]
+		}
+		if n.GetSiblingPtr() != want {
+			return false
+		}
+	}
+	return true
+}
+
+// covers 返回锁住的节点 n 是否覆盖 key，没有覆盖说明 n 分裂出的节点还没有挂到父节点上
+func covers(n NodeInterface, key int) bool {
+	return n.GetSiblingPtr() == nil || compareIntKeys(n.GetHighKey(), key) >= 0
+}
+
+// collect 统计摘下的叶子中的条目数，有活跃快照时把它们记录为前像
+func (d *detachment) collect(bt *BTree, recs []*versionRecord) (int, []*versionRecord) {
+	vs := bt.versions
+	recording := vs.recording()
+	removed := 0
+	for _, n := range d.detached {
+		if n.GetLevel() != 0 {
+			continue
+		}
+		removed += int(atomic.LoadInt32(&baseNode(n).count))
+		if recording {
+			recs = vs.recordRemovedLocked(recs, leafEntriesLocked(n))
+		}
+		if lb, ok := n.(*LNodeBTree); ok && lb.pool != nil {
+			if lb.stub != nil {
+				atomic.AddInt64(&lb.pool.evicted, -1)
+			} else {
+				atomic.AddInt64(&lb.pool.resident, -1)
+			}
+		}
+	}
+	return removed, recs
+}
+
+// trimLeaf 删除边界叶子中下标在 [from, to) 内的条目，返回删除的条目数
+func trimLeaf(bt *BTree, leaf NodeInterface, from, to int, recs []*versionRecord) (int, []*versionRecord) {
+	if from >= to {
+		return 0, recs
+	}
+	if bt.versions.recording() {
+		recs = bt.versions.recordRemovedLocked(recs, leafEntriesLocked(leaf)[from:to])
+	}
+	switch l := leaf.(type) {
+	case *LNodeBTree:
+		// leafSpanLocked 已经把叶子换入，这里只标记为已修改，不会失败
+		l.loadForWrite()
+		l.removeRangeLocked(from, to)
+	case *LNodeAppend:
+		l.removeRangeLocked(from, to)
+	}
+	return to - from, recs
+}
+
+// release 把摘下的节点标记为过时，释放全部的锁，提交前像后把摘下的节点交给 Epoche
+func (d *detachment) release(bt *BTree, recs []*versionRecord, ti *ThreadInfo) {
+	for _, n := range d.detached {
+		baseNode(n).WriteUnlockObsolete()
+	}
+	for _, n := range d.kept {
+		baseNode(n).WriteUnlock()
+	}
+	bt.versions.commitLocked(recs)
+	if len(d.detached) > 0 {
+		ti.Epoche.MarkNodesForDeletion(d.detached, ti)
+	}
+}
+
+// leafSpanLocked 返回调用方锁住的叶子中 [lo, hi) 内条目的下标范围。只有 B 树叶子和追加叶子
+// 可以原地删除条目：其他叶子中有要删除的条目或者 write 为 true(要修改叶子)时返回 false，
+// 由调用方释放全部的锁后用 prepareLeaf 转换或解压叶子再重试。换出的 B 树叶子在这里换入，
+// 换入失败时同样返回 false，调用方重试前发现树已经出错
+func leafSpanLocked(leaf NodeInterface, lo, hi int, write bool) (from, to int, ok bool) {
+	switch l := leaf.(type) {
+	case *LNodeBTree:
+		if l.pageInLocked() != nil {
+			return 0, 0, false
+		}
+		search := l.options().leafSearch()
+		keys := l.loaded().sortedKeys()
+		return lowerBoundInts(keys, lo, search), lowerBoundInts(keys, hi, search), true
+	case *LNodeAppend:
+		search := l.options().leafSearch()
+		keys := l.contents.sortedKeys()
+		return lowerBoundInts(keys, lo, search), lowerBoundInts(keys, hi, search), true
+	}
+	if write {
+		return 0, 0, false
+	}
+	for _, e := range leafEntriesLocked(leaf) {
+		if k := e.Key.(int); k >= lo && k < hi {
+			return 0, 0, false
+		}
+	}
+	return 0, 0, true
+}
+
+// prepareLeaf 把不能原地删除条目的叶子转换为 B 树叶子(压缩叶子解压)，调用方不持有任何锁
+func (bt *BTree) prepareLeaf(leaf NodeInterface, ti *ThreadInfo) {
+	l, ok := leaf.(LeafNodeInterface)
+	if !ok {
+		return
+	}
+	if version, needRestart := l.TryReadLock(); !needRestart && bt.convert(l, version, ti) {
+		l.WriteUnlock()
+	}
+}
+
+// truncation 一次截断锁住的节点。path 为从根到 key 所在叶子的路径(即 kept)，
+// slots[i] 为 path[i+1] 在 path[i] 中的下标(-1 表示 leftmostPtr)，
+// trim 为路径末端的叶子中小于 key 的条目数
+type truncation struct {
+	detachment
+	slots []int
+	trim  int
+}
+
+// lockTruncation 自顶向下锁住路径和要摘下的节点。其他写入都被挡住，剩下的结构修改
+// (读者触发的转换、压缩和解压、换出)都只尝试加锁，因此这里同样只尝试加锁，
+// 任何一个节点拿不到锁时释放全部的锁返回 false，由调用方重试。
+// 某一层的兄弟链与父节点中的孩子不一致，说明有分裂出的节点还没有挂到父节点上，同样重试。
+// key 所在的叶子是有小于 key 的条目的哈希叶子或压缩叶子时，先转换或解压再重试
+func (bt *BTree) lockTruncation(k int, ti *ThreadInfo) (*truncation, bool) {
+	t := &truncation{}
+	root := bt.root
+	if !t.lockKept(root) {
+		return nil, false
+	}
+	if bt.root != root {
+		t.unlock()
+		return nil, false
+	}
+
+	var level []NodeInterface // 当前层要摘下的节点，按键的顺序
+	cur := root
+	for cur.GetLevel() > 0 {
+		in, ok := cur.(*INode)
+		if !ok {
+			t.unlock()
+			return nil, false
+		}
+		slot := in.FindLowerBound(k)
+		var next []NodeInterface
+		for _, n := range level {
+			d := n.(*INode)
+			next = appendChildren(next, d, -1, int(d.count))
+		}
+		next = appendChildren(next, in, -1, slot)
+		child := childAt(in, slot)
+		if !t.lockDetached(next) || !t.lockKept(child) {
+			return nil, false
+		}
+		t.slots = append(t.slots, slot)
+		if !linked(next, child) || !covers(child, k) {
+			t.unlock()
+			return nil, false
+		}
+		level, cur = next, child
+	}
+
+	_, trim, ok := leafSpanLocked(cur, math.MinInt, k, false)
+	if !ok {
+		t.unlock()
+		bt.prepareLeaf(cur, ti)
+		return nil, false
+	}
+	t.trim = trim
+	return t, true
+}
+
+// childAt 返回内部节点 in 中下标为 slot 的孩子，-1 表示 leftmostPtr
+func childAt(in *INode, slot int) NodeInterface {
+	if slot < 0 {
+		return in.leftmostPtr
+	}
+	return in.Entries[slot].Value.(NodeInterface)
+}
+
+// appendChildren 把内部节点 in 中下标在 [from, to) 内的孩子按顺序追加到 dst，-1 表示 leftmostPtr
+func appendChildren(dst []NodeInterface, in *INode, from, to int) []NodeInterface {
+	for i := from; i < to; i++ {
+		dst = append(dst, childAt(in, i))
+	}
+	return dst
+}
+
+// apply 修改锁住的节点并释放所有的锁，返回删除的条目数
+func (t *truncation) apply(bt *BTree, ti *ThreadInfo) int {
+	removed, recs := t.collect(bt, nil)
+	leaf := t.kept[len(t.kept)-1]
+	trimmed, recs := trimLeaf(bt, leaf, 0, t.trim, recs)
+	for i, slot := range t.slots {
+		if slot >= 0 {
+			t.kept[i].(*INode).dropBefore(slot)
+		}
+	}
+	linkLeft(leaf, nil)
+	t.release(bt, recs, ti)
+	return removed + trimmed
+}
+
+// dropBefore 删除 slot 之前的孩子和分隔键，Entries[slot] 的孩子成为 leftmostPtr，调用方持有写锁
+func (in *INode) dropBefore(slot int) {
+	count := int(in.count)
+	in.leftmostPtr = in.Entries[slot].Value.(NodeInterface)
+	n := copy(in.Entries, in.Entries[slot+1:count])
+	in.clearTail(n, count)
+	in.count = int32(n)
+}
+
+// clearTail 清理缩小后 [n, count) 内不再使用的槽位，调用方持有写锁。
+// 乐观的读者可能仍按旧的 count 扫描这些槽位：槽位保留原来的键，孩子改为最后一个保留的孩子，
+// 读者既不会读到 nil，摘下的节点也不会因为仍被引用而无法回收，读到的结果由版本校验丢弃
+func (in *INode) clearTail(n, count int) {
+	last := in.leftmostPtr
+	if n > 0 {
+		last = in.Entries[n-1].Value.(NodeInterface)
+	}
+	for i := n; i < count; i++ {
+		in.Entries[i].Value = last
+	}
+}
+
+// leafEntriesLocked 返回调用方锁住的叶子中的全部条目，只有哈希叶子的条目不按键排序
+func leafEntriesLocked(leaf NodeInterface) []Entry {
+	switch l := leaf.(type) {
+	case *LNodeHash:
+		entries := l.stash.CollectAll()
+		buckets := l.bucketArray()
+		for i := range buckets {
+			entries = append(entries, buckets[i].CollectAll()...)
+		}
+		return entries
+	case *LNodeBTree:
+		// 换入失败时树已经停止写入，无法读回的条目不再记录为前像
+		if l.pageInLocked() != nil {
+			return nil
+		}
+		return l.GetEntries()
+	case LeafNodeInterface:
+		return l.GetEntries()
+	}
+	return nil
+}
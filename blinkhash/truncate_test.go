@@ -0,0 +1,221 @@
+package blinkhash
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// checkTruncated 检查树中恰好是 [from, to) 内步长为 step 的键，值等于键
+func checkTruncated(t *testing.T, tree *BTree, ti *ThreadInfo, from, to, step int) {
+	t.Helper()
+	want := 0
+	if to > from {
+		want = (to - from + step - 1) / step
+	}
+	entries := tree.RangeLookupEntries(math.MinInt, to+1, ti)
+	if len(entries) != want {
+		t.Fatalf("Expected %d entries in [%d, %d), got %d", want, from, to, len(entries))
+	}
+	for i, e := range entries {
+		if k := from + i*step; e.Key != k || e.Value != k {
+			t.Fatalf("entry %d: expected %d, got %v=%v", i, k, e.Key, e.Value)
+		}
+	}
+	if from > math.MinInt && tree.Lookup(from-step, ti) != nil {
+		t.Fatalf("Expected key %d to be truncated", from-step)
+	}
+	if leaf := tree.firstLeaf(); leaf.GetType() != HashNode && want > 0 {
+		if first := leaf.GetEntries(); len(first) > 0 && first[0].Key.(int) < from {
+			t.Fatalf("Expected the first leaf to start at %d, got %v", from, first[0].Key)
+		}
+	}
+}
+
+func TestTruncate_Basic(t *testing.T) {
+	for _, appendLeaves := range []bool{false, true} {
+		t.Run(map[bool]string{false: "btree", true: "append"}[appendLeaves], func(t *testing.T) {
+			const n = 20000
+			tree := newTestTree(appendLeaves)
+			ti := NewThreadInfo(tree.GetEpoche())
+			for k := 0; k < n; k += 2 {
+				tree.Insert(k, k, ti)
+			}
+			if !appendLeaves {
+				tree.ConvertAll(ti)
+			}
+			height := tree.GetHeight()
+			leaves := leafCount(tree)
+
+			if got := tree.TruncateBefore(-5, ti); got != 0 {
+				t.Errorf("Expected nothing below the first key, removed %d", got)
+			}
+			from := 0
+			for _, k := range []int{1, 2, 501, 4000, 4001, 12345} {
+				if got, want := tree.TruncateBefore(k, ti), (k+1)/2-(from+1)/2; got != want {
+					t.Fatalf("truncate before %d: expected %d removed, got %d", k, want, got)
+				}
+				from = k + k%2
+				checkTruncated(t, tree, ti, from, n, 2)
+			}
+			if got := leafCount(tree); got >= leaves/2 {
+				t.Errorf("Expected whole leaves to be detached, %d of %d left", got, leaves)
+			}
+			if tree.GetHeight() != height {
+				t.Errorf("Expected the height to stay %d, got %d", height, tree.GetHeight())
+			}
+
+			// 截断之后仍然可以写入任意的键，包括截断点之前的键
+			for _, k := range []int{-1, 3, 12345, n + 1} {
+				tree.Insert(k, -k, ti)
+				if got := tree.Lookup(k, ti); got != -k {
+					t.Errorf("key %d: expected %d after truncation, got %v", k, -k, got)
+				}
+			}
+			if got, want := tree.TruncateBefore(math.MaxInt, ti), (n-from)/2+4; got != want {
+				t.Errorf("Expected %d entries removed, got %d", want, got)
+			}
+			checkTruncated(t, tree, ti, 0, 0, 1)
+			tree.Insert(7, 7, ti)
+			checkTruncated(t, tree, ti, 7, 8, 1)
+		})
+	}
+}
+
+func TestTruncate_LeafTypes(t *testing.T) {
+	// 只有一个哈希叶子的树：根叶子先被转换
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	for k := 0; k < 100; k++ {
+		tree.Insert(k, k, ti)
+	}
+	if got := tree.TruncateBefore(40, ti); got != 40 {
+		t.Errorf("Expected 40 entries removed from the root leaf, got %d", got)
+	}
+	checkTruncated(t, tree, ti, 40, 100, 1)
+
+	// 边界落在压缩叶子中时先解压
+	ftree, fti := newFloatTree(10000)
+	if ftree.CompactLeaves(fti) == 0 {
+		t.Fatal("Expected some leaves to be compacted")
+	}
+	if got := ftree.TruncateBefore(50005, fti); got != 5001 {
+		t.Errorf("Expected 5001 entries removed, got %d", got)
+	}
+	if got := ftree.Lookup(50010, fti); got != float64(5001)/4 {
+		t.Errorf("Expected the boundary key to survive, got %v", got)
+	}
+	if got := len(ftree.RangeLookup(math.MinInt, 20000, fti)); got != 4999 {
+		t.Errorf("Expected 4999 entries left, got %d", got)
+	}
+}
+
+func TestTruncate_SnapshotAndWAL(t *testing.T) {
+	dir := t.TempDir()
+	tree, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ti := NewThreadInfo(tree.GetEpoche())
+	for k := 0; k < 5000; k++ {
+		tree.Insert(k, k, ti)
+	}
+	tree.ConvertAll(ti)
+	snap := tree.Snapshot()
+	if got := tree.TruncateBefore(3000, ti); got != 3000 {
+		t.Fatalf("Expected 3000 entries removed, got %d", got)
+	}
+	// 快照仍然看到截断之前的全部条目
+	if got := len(snap.RangeLookupEntries(0, 10000, ti)); got != 5000 {
+		t.Errorf("Expected the snapshot to keep 5000 entries, got %d", got)
+	}
+	if got := snap.Lookup(10, ti); got != 10 {
+		t.Errorf("Expected the snapshot to see truncated key 10, got %v", got)
+	}
+	snap.Release()
+	tree.Insert(1, "after", ti)
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	ti = NewThreadInfo(tree.GetEpoche())
+	entries := tree.RangeLookupEntries(math.MinInt, 10000, ti)
+	if len(entries) != 2001 || entries[0].Value != "after" || entries[1].Key != 3000 {
+		t.Errorf("Expected the truncation to be replayed, got %d entries", len(entries))
+	}
+}
+
+// 按保留策略不断截断头部，同时在尾部追加、读取窗口内的键
+func TestTruncate_Concurrent(t *testing.T) {
+	const n, step = 60000, 2000
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	for k := 0; k < n/2; k++ {
+		tree.Insert(k, k, ti)
+	}
+	tree.ConvertAll(ti)
+
+	var watermark int64 // 小于它的键可能已被截断
+	var wg sync.WaitGroup
+	errs := make(chan string, 8)
+	var next int64 = n / 2
+	for w := 0; w < 2; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ti := NewThreadInfo(tree.GetEpoche())
+			for {
+				k := int(atomic.AddInt64(&next, 1) - 1)
+				if k >= n {
+					return
+				}
+				tree.Insert(k, k, ti)
+			}
+		}()
+	}
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			ti := NewThreadInfo(tree.GetEpoche())
+			for i := 0; atomic.LoadInt64(&next) < n; i++ {
+				low := int(atomic.LoadInt64(&watermark)) + step
+				k := low + (i*7919+r)%(n/2-low+step)
+				if k >= n/2 {
+					continue
+				}
+				// 读取期间截断可能已经越过 k(读者等待截断释放锁时水位可以连续抬高多次)，
+				// 只有读完后水位仍不超过 k 时才能断定 k 应该存在
+				if got := tree.Lookup(k, ti); got != k && atomic.LoadInt64(&watermark) <= int64(k) {
+					errs <- "lookup missed a key above the watermark"
+					return
+				}
+				if got := tree.RangeLookupEntries(k, 50, ti); (len(got) == 0 || got[0].Key != k) && atomic.LoadInt64(&watermark) <= int64(k) {
+					errs <- "range lookup missed a key above the watermark"
+					return
+				}
+			}
+		}(r)
+	}
+	for cut := step; cut < n/2-step; cut += step {
+		// 先抬高水位，读者不再访问即将被截断的键
+		atomic.StoreInt64(&watermark, int64(cut))
+		tree.TruncateBefore(cut, ti)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	last := n/2 - step - (n/2-step)%step
+	if last == n/2-step {
+		last -= step
+	}
+	checkTruncated(t, tree, ti, last, n, 1)
+}
@@ -0,0 +1,287 @@
+package blinkhash
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// expiringValue 带过期时间的值，由 InsertWithTTL 写入，恢复时从日志、检查点和树文件中重建。
+//
+// 过期时间跟着值存放在 valueArray 的 boxed 中，不使用 TTL 的树没有任何额外开销：
+// 读路径和叶子的清理只在树使用过 TTL 时才检查(标志是每棵树自己的，节点经由 opts 读取)，清理只检查已经装箱的值。
+// 过期时间是绝对时间，日志(walInsertTTL)、检查点、SaveTo 和 Freeze 都记录它，恢复的条目在原来的时刻过期，
+// 写出时已经过期的条目不再写出。带 TTL 的叶子不能用 TaggedCodec 编码，分层存储不会换出它们
+type expiringValue struct {
+	value    interface{}
+	deadline int64 // ttlNow 的时间，到达后条目不可见
+}
+
+// ttlNow 返回当前时间，测试中替换为可控的时钟
+var ttlNow = func() int64 { return time.Now().UnixNano() }
+
+// InsertWithTTL 与 Insert 相同，但条目在 ttl 之后过期：过期的条目立即对 Lookup 和范围读取不可见，
+// 之后在叶子写入、转换或 SweepExpired 时被删除。Insert 和 Update 写入的值不会过期，
+// 对带 TTL 的键调用它们会去掉过期时间
+func (bt *BTree) InsertWithTTL(key, value interface{}, ttl time.Duration, ti *ThreadInfo) {
+	bt.markExpiring()
+	ev := &expiringValue{value: value, deadline: ttlNow() + int64(ttl)}
+	rec, err := bt.walValueRecord(walInsertTTL, key, ev)
+	if err != nil {
+		bt.fail(err)
+		return
+	}
+	turn, ok := bt.logWrite(rec, key.(int))
+	if !ok {
+		return
+	}
+	w := bt.versions.beginWrite(bt, key, ti)
+	bt.insert(key, ev, ti)
+	w.end()
+	turn.done()
+}
+
+// markExpiring 记录树中出现了带过期时间的条目，之后读取和叶子写入开始检查过期时间
+func (bt *BTree) markExpiring() {
+	atomic.StoreInt32(&bt.opts.expiring, 1)
+}
+
+// expires 返回树是否使用过 TTL
+func (bt *BTree) expires() bool {
+	return atomic.LoadInt32(&bt.opts.expiring) != 0
+}
+
+// expires 返回节点所属的树是否使用过 TTL，为 false 时叶子写入和转换不检查过期条目
+func (o *nodeOptions) expires() bool {
+	return atomic.LoadInt32(&o.options().expiring) != 0
+}
+
+// liveValue 去掉 value 的过期时间，已过期时第二个返回值为 false
+func liveValue(value interface{}, now int64) (interface{}, bool) {
+	if ev, ok := value.(*expiringValue); ok {
+		return ev.value, now < ev.deadline
+	}
+	return value, true
+}
+
+// expired 返回 value 在 now 是否已过期
+func expired(value interface{}, now int64) bool {
+	ev, ok := value.(*expiringValue)
+	return ok && now >= ev.deadline
+}
+
+// appendLive 把 src 中未过期的条目去掉过期时间后追加到 dst
+func appendLive(dst, src []Entry, now int64) []Entry {
+	for _, e := range src {
+		if v, ok := liveValue(e.Value, now); ok {
+			dst = append(dst, Entry{Key: e.Key, Value: v})
+		}
+	}
+	return dst
+}
+
+// dropExpired 原地删除 entries 中已过期的条目，保留的条目仍带着过期时间
+func dropExpired(entries []Entry) []Entry {
+	now, n := ttlNow(), 0
+	for _, e := range entries {
+		if !expired(e.Value, now) {
+			entries[n] = e
+			n++
+		}
+	}
+	for i := n; i < len(entries); i++ {
+		entries[i] = Entry{}
+	}
+	return entries[:n]
+}
+
+// purgeExpired 在调用方持有写锁时原地删除叶子内容中已过期的条目，返回删除的条目数。
+// 带过期时间的值一定已经装箱，没有 boxed 的叶子直接返回
+func purgeExpired(c *leafContents) int {
+	boxed := c.values.boxed
+	if boxed == nil {
+		return 0
+	}
+	now, n := ttlNow(), 0
+	for i := 0; i < c.n; i++ {
+		if expired((*boxed)[i], now) {
+			continue
+		}
+		if n != i {
+			c.keys[n] = c.keys[i]
+			c.values.copyTo(i, &c.values, n)
+		}
+		n++
+	}
+	removed := c.n - n
+	c.truncate(n)
+	return removed
+}
+
+// purgeLocked 删除叶子中已过期的条目，调用方持有写锁并且叶子已换入
+func (lb *LNodeBTree) purgeLocked() int {
+	if !lb.expires() {
+		return 0
+	}
+	removed := purgeExpired(lb.loaded())
+	lb.count -= int32(removed)
+	return removed
+}
+
+func (la *LNodeAppend) purgeLocked() int {
+	if !la.expires() {
+		return 0
+	}
+	removed := purgeExpired(la.contents)
+	la.count -= int32(removed)
+	return removed
+}
+
+// expiredCount 乐观地统计叶子中已过期的条目数，结果只用来决定是否加锁清理
+func expiredCount(leaf LeafNodeInterface, now int64) int {
+	var c *leafContents
+	switch l := leaf.(type) {
+	case *LNodeBTree:
+		c = l.loaded()
+	case *LNodeAppend:
+		c = l.contents
+	case *LNodeHash:
+		n := l.stash.expiredCount(now)
+		buckets := l.bucketArray()
+		for i := range buckets {
+			n += buckets[i].expiredCount(now)
+		}
+		return n
+	}
+	if c == nil || c.values.boxed == nil {
+		return 0
+	}
+	boxed, n := c.values.boxed, 0
+	for _, v := range (*boxed)[:c.len()] {
+		if expired(v, now) {
+			n++
+		}
+	}
+	return n
+}
+
+// expiredCount 统计桶中已过期的条目数
+func (b *Bucket) expiredCount(now int64) int {
+	boxed := b.values.boxed
+	if boxed == nil {
+		return 0
+	}
+	n := 0
+	for i, v := range boxed[:b.slots()] {
+		if b.occupied(i) && expired(v, now) {
+			n++
+		}
+	}
+	return n
+}
+
+// SweepExpired 沿叶子链删除已过期的条目，返回删除的条目数。
+// 可以与其他操作并发调用，遇到锁冲突的叶子跳过，留给下一次；换出的叶子在换入写入时清理。
+// 哈希叶子中有过期条目时转换为 B 树叶子，转换时丢弃过期的条目
+func (bt *BTree) SweepExpired(ti *ThreadInfo) int {
+	if !bt.expires() {
+		return 0
+	}
+	eg := NewEpocheGuard(ti)
+	defer eg.Release()
+
+	removed := 0
+	for leaf := bt.firstLeaf(); leaf != nil; leaf = nextLeaf(leaf) {
+		if lb, ok := leaf.(*LNodeBTree); ok && lb.evicted() {
+			continue
+		}
+		version, needRestart := leaf.TryReadLock()
+		if needRestart {
+			continue
+		}
+		n := expiredCount(leaf, ttlNow())
+		if n == 0 {
+			continue
+		}
+		switch l := leaf.(type) {
+		case *LNodeHash:
+			// 转换成功时旧叶子仍处于锁定状态，需要在这里释放；失败时 Convert 已自行解锁
+			if bt.convert(l, version, ti) {
+				l.WriteUnlock()
+				removed += n
+			}
+		case *LNodeBTree:
+			if success, needRestart := l.TryUpgradeWriteLock(version); success && !needRestart {
+				if l.stub == nil {
+					removed += l.purgeLocked()
+				}
+				l.WriteUnlock()
+			}
+		case *LNodeAppend:
+			if success, needRestart := l.TryUpgradeWriteLock(version); success && !needRestart {
+				removed += l.purgeLocked()
+				l.WriteUnlock()
+			}
+		}
+	}
+	return removed
+}
+
+// StartTTLSweeper 启动后台协程，每隔 interval 调用一次 SweepExpired，已经启动时不做任何事
+func (bt *BTree) StartTTLSweeper(interval time.Duration) {
+	bt.lock.Lock()
+	defer bt.lock.Unlock()
+	if bt.sweepStop != nil {
+		return
+	}
+	bt.sweepStop = make(chan struct{})
+	bt.sweepDone = make(chan struct{})
+	go func(stop, done chan struct{}) {
+		defer close(done)
+		ti := NewThreadInfo(bt.epoche)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				bt.SweepExpired(ti)
+			case <-stop:
+				return
+			}
+		}
+	}(bt.sweepStop, bt.sweepDone)
+}
+
+// StopTTLSweeper 停止后台清理并等待协程退出
+func (bt *BTree) StopTTLSweeper() {
+	bt.lock.Lock()
+	stop, done := bt.sweepStop, bt.sweepDone
+	bt.sweepStop, bt.sweepDone = nil, nil
+	bt.lock.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// scanUpTo 返回范围读取向一个叶子请求的条目数。使用 TTL 的树过滤掉过期的条目后结果会变少，
+// 需要读完叶子中剩下的全部条目，再由 appendScanned 截断到 rng
+func scanUpTo(want int, expires bool) int {
+	if expires {
+		return math.MaxInt
+	}
+	return want
+}
+
+// appendScanned 把从一个叶子读到的条目追加到 results，使用 TTL 的树去掉过期的条目并截断到 rng
+func appendScanned(results, collected []Entry, rng int, expires bool) []Entry {
+	if !expires {
+		return append(results, collected...)
+	}
+	results = appendLive(results, collected, ttlNow())
+	if len(results) > rng {
+		results = results[:rng]
+	}
+	return results
+}
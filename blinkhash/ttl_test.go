@@ -0,0 +1,315 @@
+package blinkhash
+
+import (
+	"bytes"
+	"math"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// withClock 把 ttlNow 换成手动推进的时钟，返回当前时间的指针
+func withClock(t testing.TB) *int64 {
+	old := ttlNow
+	now := int64(1)
+	ttlNow = func() int64 { return atomic.LoadInt64(&now) }
+	t.Cleanup(func() { ttlNow = old })
+	return &now
+}
+
+// storedEntries 返回叶子中实际保存的条目数，包括已过期但还没有删除的条目
+func storedEntries(bt *BTree) int {
+	n := 0
+	for leaf := bt.firstLeaf(); leaf != nil; leaf = nextLeaf(leaf) {
+		n += int(atomic.LoadInt32(&leaf.GetNode().count))
+	}
+	return n
+}
+
+// leafCount 返回叶子链上的叶子数
+func leafCount(bt *BTree) int {
+	n := 0
+	for leaf := bt.firstLeaf(); leaf != nil; leaf = nextLeaf(leaf) {
+		n++
+	}
+	return n
+}
+
+func TestTTL_Visibility(t *testing.T) {
+	now := withClock(t)
+	const n = 3000
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	// k%3 == 0 很快过期，k%3 == 1 一小时后过期，k%3 == 2 不过期
+	for k := 0; k < n; k++ {
+		switch k % 3 {
+		case 0:
+			tree.InsertWithTTL(k, k, 10*time.Second, ti)
+		case 1:
+			tree.InsertWithTTL(k, k, time.Hour, ti)
+		default:
+			tree.Insert(k, k, ti)
+		}
+	}
+	if got := tree.Lookup(3, ti); got != 3 {
+		t.Fatalf("Expected unexpired keys to be visible, got %v", got)
+	}
+	snap := tree.Snapshot()
+	defer snap.Release()
+
+	atomic.AddInt64(now, int64(10*time.Second))
+	for k := 0; k < n; k++ {
+		want := interface{}(k)
+		if k%3 == 0 {
+			want = nil
+		}
+		if got := tree.Lookup(k, ti); got != want {
+			t.Fatalf("key %d: expected %v, got %v", k, want, got)
+		}
+	}
+	entries := tree.RangeLookupEntries(0, 100, ti)
+	if len(entries) != 100 || entries[0].Key != 1 || entries[99].Key != 149 {
+		t.Fatalf("Expected 100 live entries from key 1, got %d", len(entries))
+	}
+	for _, e := range entries {
+		if e.Key.(int)%3 == 0 || e.Value != e.Key {
+			t.Fatalf("Expected only live values, got %v=%v", e.Key, e.Value)
+		}
+	}
+	live := n - n/3
+	if got := len(tree.RangeLookup(math.MinInt, 2*n, ti)); got != live {
+		t.Errorf("Expected %d live entries, got %d", live, got)
+	}
+	if got := len(tree.ScanEntries(0, 2*n, ScanSerializable, ti)); got != live {
+		t.Errorf("Expected %d live entries in a serializable scan, got %d", live, got)
+	}
+	// 快照读取的是树的当前内容，过期与否按读取时的时间判断
+	if got := len(snap.RangeLookupEntries(0, 2*n, ti)); got != live {
+		t.Errorf("Expected %d live entries in the snapshot, got %d", live, got)
+	}
+	if snap.Lookup(30, ti) != nil || snap.Lookup(31, ti) != 31 {
+		t.Errorf("Expected the snapshot to hide expired keys")
+	}
+	tx := tree.Begin(ti)
+	if tx.Lookup(30) != nil || tx.Lookup(31) != 31 || len(tx.RangeLookupEntries(0, 2*n)) != live {
+		t.Errorf("Expected the transaction to hide expired keys")
+	}
+	tx.Rollback()
+
+	// 过期的键不能更新，删除时返回 false，重新插入后不再过期
+	if tree.Update(30, "x", ti) || tree.Remove(33, ti) {
+		t.Errorf("Expected expired keys to be reported as missing")
+	}
+	if !tree.Update(31, "y", ti) || !tree.Remove(32, ti) {
+		t.Errorf("Expected live keys to be updated and removed")
+	}
+	tree.Insert(36, "again", ti)
+	atomic.AddInt64(now, int64(2*time.Hour))
+	if tree.Lookup(30, ti) != nil || tree.Lookup(36, ti) != "again" || tree.Lookup(31, ti) != "y" || tree.Lookup(34, ti) != nil {
+		t.Errorf("Expected updates and inserts to drop the TTL")
+	}
+}
+
+func TestTTL_LazyPurge(t *testing.T) {
+	now := withClock(t)
+	const n = 4000
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	for k := 0; k < n; k++ {
+		tree.InsertWithTTL(k*2, k, time.Second, ti)
+	}
+	tree.ConvertAll(ti)
+	atomic.AddInt64(now, int64(time.Second))
+
+	// 写入已满的叶子时先删除其中过期的条目，叶子数少于保存全部条目所需的数量
+	for k := 0; k < n; k++ {
+		tree.Insert(k*2+1, k, ti)
+	}
+	if stored := storedEntries(tree); stored >= 2*n {
+		t.Errorf("Expected writes to purge expired entries, %d entries stored", stored)
+	}
+	if leaves := leafCount(tree); leaves >= 2*n/LNodeBTreeCardinality {
+		t.Errorf("Expected purged leaves to be reused, got %d leaves", leaves)
+	}
+
+	wb := tree.NewWriteBatch()
+	wb.Put(0, "batch")
+	wb.Commit(ti)
+
+	stored := storedEntries(tree)
+	if removed := tree.SweepExpired(ti); removed != stored-n-1 {
+		t.Errorf("Expected the sweep to remove the remaining %d expired entries, removed %d", stored-n-1, removed)
+	}
+	if got := storedEntries(tree); got != n+1 {
+		t.Errorf("Expected %d entries after the sweep, got %d", n+1, got)
+	}
+	for k := 0; k < n; k++ {
+		if got := tree.Lookup(k*2+1, ti); got != k {
+			t.Fatalf("key %d: expected %d, got %v", k*2+1, k, got)
+		}
+	}
+	if tree.Lookup(0, ti) != "batch" || tree.Lookup(2, ti) != nil {
+		t.Errorf("Expected the batch write to replace the expired key")
+	}
+}
+
+func TestTTL_Sweep(t *testing.T) {
+	for _, appendLeaves := range []bool{false, true} {
+		t.Run(map[bool]string{false: "hash", true: "append"}[appendLeaves], func(t *testing.T) {
+			now := withClock(t)
+			const n = 3000
+			tree := newTestTree(appendLeaves)
+			ti := NewThreadInfo(tree.GetEpoche())
+			for k := 0; k < n; k++ {
+				ttl := time.Second
+				if k%2 == 0 {
+					ttl = time.Hour
+				}
+				tree.InsertWithTTL(k, k, ttl, ti)
+			}
+			if got := tree.SweepExpired(ti); got != 0 {
+				t.Fatalf("Expected nothing to sweep before expiry, removed %d", got)
+			}
+			atomic.AddInt64(now, int64(time.Second))
+			if got := tree.SweepExpired(ti); got != n/2 {
+				t.Errorf("Expected %d expired entries to be removed, removed %d", n/2, got)
+			}
+			if got := storedEntries(tree); got != n/2 {
+				t.Errorf("Expected %d entries left, got %d", n/2, got)
+			}
+			if got := len(tree.RangeLookup(0, n, ti)); got != n/2 {
+				t.Errorf("Expected %d live entries, got %d", n/2, got)
+			}
+		})
+	}
+
+	// 没有使用 TTL 的树不做任何事
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	tree.Insert(1, 1, ti)
+	if tree.expires() || tree.SweepExpired(ti) != 0 {
+		t.Errorf("Expected a tree without TTLs to skip the sweep")
+	}
+}
+
+func TestTTL_Sweeper(t *testing.T) {
+	now := withClock(t)
+	const n = 2000
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	for k := 0; k < n; k++ {
+		tree.InsertWithTTL(k, k, time.Second, ti)
+	}
+	tree.StartTTLSweeper(time.Millisecond)
+	tree.StartTTLSweeper(time.Millisecond)
+	defer tree.StopTTLSweeper()
+
+	atomic.AddInt64(now, int64(time.Second))
+	deadline := time.Now().Add(5 * time.Second)
+	for storedEntries(tree) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the sweeper to remove all entries, %d left", storedEntries(tree))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	tree.StopTTLSweeper()
+	tree.StopTTLSweeper()
+}
+
+// 过期时间随日志、检查点、树文件和冻结文件持久化，恢复的条目在原来的时刻过期
+func TestTTL_Persistence(t *testing.T) {
+	now := withClock(t)
+	const n = 1000
+	dir := t.TempDir()
+	tree, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ti := NewThreadInfo(tree.GetEpoche())
+	for k := 0; k < n; k++ {
+		if k%2 == 0 {
+			tree.InsertWithTTL(k, k, time.Hour, ti)
+		} else {
+			tree.Insert(k, k, ti)
+		}
+	}
+	tree.Close()
+
+	// 日志回放
+	tree, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	tree.Close()
+	// 检查点
+	tree, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	ti = NewThreadInfo(tree.GetEpoche())
+	var buf bytes.Buffer
+	if err := tree.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadFrom(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "frozen")
+	if err := tree.Freeze(path, TaggedCodec); err != nil {
+		t.Fatal(err)
+	}
+	ft, err := OpenFrozen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ft.Close()
+	if err := ft.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	readers := map[string]interface {
+		Lookup(key interface{}, ti *ThreadInfo) interface{}
+		RangeLookupEntries(minKey interface{}, rng int, ti *ThreadInfo) []Entry
+	}{"checkpoint": tree, "tree file": loaded, "frozen": ft}
+	for _, expiredNow := range []bool{false, true} {
+		if expiredNow {
+			atomic.AddInt64(now, int64(2*time.Hour))
+		}
+		for name, r := range readers {
+			if got := r.Lookup(2, ti); (got == nil) != expiredNow {
+				t.Errorf("%s: key 2 after %v: got %v", name, expiredNow, got)
+			}
+			if got := r.Lookup(3, ti); got != 3 {
+				t.Errorf("%s: expected key 3 to never expire, got %v", name, got)
+			}
+			want := n
+			if expiredNow {
+				want = n / 2
+			}
+			if got := len(r.RangeLookupEntries(0, 2*n, ti)); got != want {
+				t.Errorf("%s: expected %d live entries, got %d", name, want, got)
+			}
+		}
+	}
+	if NewBTree().expires() {
+		t.Error("Expected the TTL flag to belong to the trees that used TTL")
+	}
+
+	// 已经过期的条目不再写出
+	buf.Reset()
+	if err := tree.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err = LoadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := storedEntries(loaded); got != n/2 {
+		t.Errorf("Expected %d stored entries, got %d", n/2, got)
+	}
+}
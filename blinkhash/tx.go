@@ -0,0 +1,238 @@
+package blinkhash
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"sync/atomic"
+)
+
+// ErrConflict 事务读取过的叶子在提交前被其他线程修改，事务没有产生任何效果，可以重新执行
+var ErrConflict = errors.New("blinkhash: transaction conflict")
+
+// ErrTxDone 事务已经提交或回滚
+var ErrTxDone = errors.New("blinkhash: transaction already committed or rolled back")
+
+// Tx 乐观并发控制(OCC)的读写事务。
+//
+// 读取时记录访问过的叶子及其版本，写入只缓存在事务内，读取会先看到事务自己的写入。
+// Commit 与 WriteBatch 一样按键的顺序锁住写入涉及的叶子，然后校验读过的叶子版本都没有变化：
+// 通过时应用全部写入，事务等价于在校验时刻一次性执行；否则放弃全部写入并返回 ErrConflict。
+// 范围读取记录了覆盖该范围的所有叶子，范围内出现新键(幻读)同样会被发现。
+//
+// 哈希叶子的插入和更新只加桶锁，不改变节点版本，读取非空的哈希叶子前先把它转换为 B 树叶子；
+// 空哈希叶子额外校验计数仍为 0。
+// Tx 不是并发安全的，只能由创建它的线程使用。
+type Tx struct {
+	tree   *BTree
+	ti     *ThreadInfo
+	reads  []scannedLeaf
+	writes map[int]batchOp
+	done   bool
+}
+
+// Begin 开始一个事务，事务内的操作都使用 ti
+func (bt *BTree) Begin(ti *ThreadInfo) *Tx {
+	return &Tx{tree: bt, ti: ti, writes: make(map[int]batchOp)}
+}
+
+func (tx *Tx) checkActive() {
+	if tx.done {
+		panic(ErrTxDone)
+	}
+}
+
+// Lookup 返回 key 的值，不存在时返回 nil
+func (tx *Tx) Lookup(key interface{}) interface{} {
+	tx.checkActive()
+	if op, ok := tx.writes[key.(int)]; ok {
+		if op.delete {
+			return nil
+		}
+		return op.value
+	}
+
+	bt := tx.tree
+	eg := NewEpocheGuard(tx.ti)
+	defer eg.Release()
+	for {
+		leaf, version := bt.findLeaf(key)
+		if lh, ok := leaf.(*LNodeHash); ok && atomic.LoadInt32(&lh.count) != 0 {
+			// 转换成功时旧叶子仍处于锁定状态，需要在这里释放；失败时 Convert 已自行解锁
+			if bt.convert(leaf, version, tx.ti) {
+				leaf.WriteUnlock()
+			}
+			continue
+		}
+		val, found := leaf.Find(key)
+		if endVersion, needRestart := leaf.GetVersion(); needRestart || endVersion != version {
+			continue
+		}
+		tx.reads = append(tx.reads, scannedLeaf{leaf: leaf, version: version})
+		if !found {
+			return nil
+		}
+		if bt.expires() {
+			if val, found = liveValue(val, ttlNow()); !found {
+				return nil
+			}
+		}
+		return val
+	}
+}
+
+// RangeLookup 返回从 minKey 开始的最多 rng 个值，包含事务自己的写入
+func (tx *Tx) RangeLookup(minKey interface{}, rng int) []interface{} {
+	return entryValues(tx.RangeLookupEntries(minKey, rng))
+}
+
+// RangeLookupEntries 与 RangeLookup 相同，但返回带键的条目，结果按键有序，包含 minKey 本身
+func (tx *Tx) RangeLookupEntries(minKey interface{}, rng int) []Entry {
+	tx.checkActive()
+	lo := minKey.(int)
+	// 多读取与缓存的删除数相同的条目，删除之后仍有 rng 个
+	want := rng
+	for k, op := range tx.writes {
+		if op.delete && k >= lo && want < math.MaxInt {
+			want++
+		}
+	}
+
+	bt := tx.tree
+	eg := NewEpocheGuard(tx.ti)
+	defer eg.Release()
+	var entries []Entry
+	var visited []scannedLeaf
+	for {
+		var ok bool
+		entries, visited, ok = bt.collectRange(minKey, want, visited[:0], false, tx.ti)
+		if ok {
+			break
+		}
+	}
+	tx.reads = append(tx.reads, visited...)
+
+	// 读到的条目覆盖 [lo, hi]，用这个区间内的缓存写入修正结果
+	hi := math.MaxInt
+	if len(entries) == want {
+		hi = entries[len(entries)-1].Key.(int)
+	}
+	var pending []batchOp
+	for k, op := range tx.writes {
+		if k >= lo && k <= hi {
+			pending = append(pending, op)
+		}
+	}
+	if len(pending) > 0 {
+		sort.Slice(pending, func(i, j int) bool { return pending[i].key < pending[j].key })
+		entries = mergeBatchOps(entries, pending)
+	}
+	if len(entries) > rng {
+		entries = entries[:rng]
+	}
+	return entries
+}
+
+// Put 在事务内写入 key，key 已存在时覆盖原值
+func (tx *Tx) Put(key, value interface{}) {
+	tx.checkActive()
+	k := key.(int)
+	tx.writes[k] = batchOp{key: k, value: value}
+}
+
+// Delete 在事务内删除 key
+func (tx *Tx) Delete(key interface{}) {
+	tx.checkActive()
+	k := key.(int)
+	tx.writes[k] = batchOp{key: k, delete: true}
+}
+
+// Rollback 放弃事务的全部写入
+func (tx *Tx) Rollback() {
+	tx.done = true
+	tx.reads, tx.writes = nil, nil
+}
+
+// Commit 校验读取并应用写入，读取过的叶子发生变化时返回 ErrConflict，树已经出错时返回 BTree.Err。
+// 打开了日志的树在锁住叶子并校验之后写日志并等待落盘，日志出错时返回该错误，写入不生效；
+// 有值不能用日志的编解码器编码时返回 ErrCodecType，写入不生效，树不受影响
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	defer tx.Rollback()
+
+	ops := make([]batchOp, 0, len(tx.writes))
+	keys := make([]int, 0, len(tx.writes))
+	for k, op := range tx.writes {
+		ops = append(ops, op)
+		keys = append(keys, k)
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i].key < ops[j].key })
+
+	bt := tx.tree
+	rec, err := bt.walBatchRecord(ops)
+	if err != nil {
+		// 值不能编码时事务不生效，树不受影响
+		return err
+	}
+	// 事务提交之前不知道是否写日志，先挡住这些键上其他写日志的写入
+	hold := bt.holdWrites(keys)
+	defer hold.release()
+	vb := bt.versions.beginBatch(bt, keys, tx.ti)
+	// 锁住叶子并校验之后、修改树之前写日志
+	var logErr error
+	validate := func(groups []batchGroup) bool {
+		if !tx.validate(groups) {
+			return false
+		}
+		if !bt.logLocked(rec) {
+			logErr = bt.Err()
+			return false
+		}
+		return true
+	}
+	eg := NewEpocheGuard(tx.ti)
+	defer eg.Release()
+	for {
+		if err := bt.Err(); err != nil {
+			vb.abort()
+			return err
+		}
+		committed, retry := bt.tryCommitBatch(ops, validate, tx.ti)
+		if committed {
+			vb.end()
+			return nil
+		}
+		if logErr != nil {
+			vb.abort()
+			return logErr
+		}
+		if !retry {
+			vb.abort()
+			return ErrConflict
+		}
+	}
+}
+
+// validate 在写入涉及的叶子全部锁住后校验读取过的叶子。
+// 被自己锁住的叶子比较加锁时的版本，其余叶子比较当前版本，被其他线程锁住也视为冲突
+func (tx *Tx) validate(groups []batchGroup) bool {
+	locked := make(map[LeafNodeInterface]uint64, len(groups))
+	for _, g := range groups {
+		locked[g.leaf] = g.version
+	}
+	for _, r := range tx.reads {
+		if version, ok := locked[r.leaf]; ok {
+			if version != r.version {
+				return false
+			}
+		} else if version, needRestart := r.leaf.GetVersion(); needRestart || version != r.version {
+			return false
+		}
+		if lh, ok := r.leaf.(*LNodeHash); ok && atomic.LoadInt32(&lh.count) != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,178 @@
+package blinkhash
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+func TestTx_ReadYourWrites(t *testing.T) {
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	for k := 1; k <= 100; k++ {
+		tree.Insert(k*10, k, ti)
+	}
+
+	tx := tree.Begin(ti)
+	tx.Put(15, "a")
+	tx.Put(20, "b")
+	tx.Delete(30)
+	if got := tx.Lookup(15); got != "a" {
+		t.Fatalf("Expected the transaction to see its own insert, got %v", got)
+	}
+	if got := tx.Lookup(30); got != nil {
+		t.Fatalf("Expected the transaction to see its own delete, got %v", got)
+	}
+	got := tx.RangeLookupEntries(10, 4)
+	expected := []Entry{{Key: 10, Value: 1}, {Key: 15, Value: "a"}, {Key: 20, Value: "b"}, {Key: 40, Value: 4}}
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("Expected %v, got %v", expected, got)
+		}
+	}
+	// 提交前其他读者看不到事务的写入
+	if got := tree.Lookup(15, ti); got != nil {
+		t.Fatalf("Expected buffered write to be invisible before commit, got %v", got)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Unexpected commit error: %v", err)
+	}
+	if tree.Lookup(15, ti) != "a" || tree.Lookup(20, ti) != "b" || tree.Lookup(30, ti) != nil {
+		t.Fatalf("Expected committed writes to be visible")
+	}
+	if err := tx.Commit(); !errors.Is(err, ErrTxDone) {
+		t.Errorf("Expected ErrTxDone on second commit, got %v", err)
+	}
+
+	tx = tree.Begin(ti)
+	tx.Put(40, "c")
+	tx.Rollback()
+	if got := tree.Lookup(40, ti); got != 4 {
+		t.Errorf("Expected rolled back write to be discarded, got %v", got)
+	}
+}
+
+func TestTx_Conflict(t *testing.T) {
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	for k := 1; k <= 1000; k++ {
+		tree.Insert(k, k, ti)
+	}
+	snap := tree.Snapshot()
+	defer snap.Release()
+
+	tx := tree.Begin(ti)
+	v := tx.Lookup(500).(int)
+	tx.Put(500, v+1)
+	tx.Put(900, 0)
+	other := NewThreadInfo(tree.GetEpoche())
+	tree.Update(500, -500, other)
+	if err := tx.Commit(); !errors.Is(err, ErrConflict) {
+		t.Fatalf("Expected ErrConflict after a concurrent update, got %v", err)
+	}
+	if got := tree.Lookup(900, ti); got != 900 {
+		t.Fatalf("Expected a conflicting transaction to have no effect, got %v", got)
+	}
+	// 放弃的事务不留下旧版本，只有 Update 记录了一个
+	if got := tree.VersionCount(); got != 1 {
+		t.Errorf("Expected 1 version after the aborted commit, got %d", got)
+	}
+
+	// 范围读取之后范围内出现新键
+	tx = tree.Begin(ti)
+	if got := len(tx.RangeLookup(2000, 10)); got != 0 {
+		t.Fatalf("Expected empty range, got %d values", got)
+	}
+	tx.Put(1, 0)
+	tree.Insert(2005, 0, other)
+	if err := tx.Commit(); !errors.Is(err, ErrConflict) {
+		t.Fatalf("Expected ErrConflict after a phantom insert, got %v", err)
+	}
+
+	// 读取的键没有被修改时，其他键的写入不影响提交
+	tx = tree.Begin(ti)
+	tx.Lookup(100)
+	tx.Put(100, 0)
+	tree.Update(2005, 1, other)
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Unexpected commit error: %v", err)
+	}
+}
+
+func TestTx_EmptyHashLeaf(t *testing.T) {
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	tx := tree.Begin(ti)
+	if got := tx.Lookup(1); got != nil {
+		t.Fatalf("Expected missing key, got %v", got)
+	}
+	tx.Put(2, 2)
+	// 空哈希叶子的插入只加桶锁，需要通过计数发现
+	tree.Insert(1, 1, NewThreadInfo(tree.GetEpoche()))
+	if err := tx.Commit(); !errors.Is(err, ErrConflict) {
+		t.Fatalf("Expected ErrConflict after an insert into an empty hash leaf, got %v", err)
+	}
+}
+
+// 多个线程在账户之间转账，冲突时重试；只读事务看到的总额始终不变
+func TestTx_Transfers(t *testing.T) {
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	const accounts, balance = 200, 100
+	for k := 0; k < accounts; k++ {
+		tree.Insert(k, balance, ti)
+	}
+
+	threads, transfers := 4, 2000
+	var wg sync.WaitGroup
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			wti := NewThreadInfo(tree.GetEpoche())
+			r := rand.New(rand.NewSource(seed))
+			for n := 0; n < transfers; {
+				from, to := r.Intn(accounts), r.Intn(accounts)
+				if from == to {
+					continue
+				}
+				tx := tree.Begin(wti)
+				a, b := tx.Lookup(from).(int), tx.Lookup(to).(int)
+				tx.Put(from, a-1)
+				tx.Put(to, b+1)
+				if err := tx.Commit(); err == nil {
+					n++
+				}
+			}
+		}(int64(i))
+	}
+
+	var failure string
+	for audits := 0; audits < 200; audits++ {
+		tx := tree.Begin(ti)
+		sum := 0
+		for _, v := range tx.RangeLookup(0, accounts) {
+			sum += v.(int)
+		}
+		if err := tx.Commit(); err == nil && sum != accounts*balance {
+			failure = "read-only transaction committed with an inconsistent total"
+			break
+		}
+	}
+	wg.Wait()
+	if failure != "" {
+		t.Fatal(failure)
+	}
+
+	sum := 0
+	for k := 0; k < accounts; k++ {
+		sum += tree.Lookup(k, ti).(int)
+	}
+	if sum != accounts*balance {
+		t.Fatalf("Expected total %d after transfers, got %d", accounts*balance, sum)
+	}
+}
@@ -0,0 +1,256 @@
+package blinkhash
+
+// valueArray 以结构数组(SoA)方式存放叶子中的值，与 leafContents.keys 按下标一一对应。
+//
+// int 值直接写入 ints，不经过 interface 装箱，数组本身不含指针，GC 无需扫描；
+// 其他类型的值写入按需分配、与 ints 等长的 boxed，boxed[i] 非 nil 时以它为准。
+// ints 创建后长度不变，boxed 通过指针整体发布，乐观的读者不会读到半个切片头。
+// 只存 int 值的树永远不会分配 boxed。桶中的值使用容量为 EntryNum 的 slotValues，规则相同
+type valueArray struct {
+	ints  []int
+	boxed *[]interface{}
+}
+
+// slotValues 桶中按槽位存放的值，见 valueArray
+type slotValues struct {
+	ints  [EntryNum]int
+	boxed *[EntryNum]interface{}
+}
+
+// nilValue 在 boxed 中代表值 nil，与"未装箱"的 nil 槽位区分
+type nilValue struct{}
+
+// unboxed 返回 boxed 槽位中的值，槽位为空(值在 ints 中)时第二个返回值为 false
+func unboxed(slot interface{}) (interface{}, bool) {
+	switch val := slot.(type) {
+	case nil:
+		return nil, false
+	case nilValue:
+		return nil, true
+	default:
+		return val, true
+	}
+}
+
+// boxedSlot 返回非 int 的值写入 boxed 时的形式
+func boxedSlot(value interface{}) interface{} {
+	if value == nil {
+		return nilValue{}
+	}
+	return value
+}
+
+// get 返回第 i 个值。int 值只在这里交给调用方时转换为 interface 一次，
+// 叶子内部的移动和复制使用 copyTo，不经过 interface
+func (v *valueArray) get(i int) interface{} {
+	if boxed := v.boxed; boxed != nil {
+		if val, ok := unboxed((*boxed)[i]); ok {
+			return val
+		}
+	}
+	return v.ints[i]
+}
+
+// getInt 返回第 i 个值是否为 int 以及它的值，不装箱
+func (v *valueArray) getInt(i int) (int, bool) {
+	if boxed := v.boxed; boxed != nil && (*boxed)[i] != nil {
+		return 0, false
+	}
+	return v.ints[i], true
+}
+
+// set 写入第 i 个值，int 值不装箱
+func (v *valueArray) set(i int, value interface{}) {
+	if n, ok := value.(int); ok {
+		v.ints[i] = n
+		if v.boxed != nil {
+			(*v.boxed)[i] = nil
+		}
+		return
+	}
+	v.ints[i] = 0
+	(*v.box())[i] = boxedSlot(value)
+}
+
+// box 返回 boxed，第一次写入非 int 的值时按 ints 的长度分配
+func (v *valueArray) box() *[]interface{} {
+	if v.boxed == nil {
+		boxed := make([]interface{}, len(v.ints))
+		v.boxed = &boxed
+	}
+	return v.boxed
+}
+
+// copyTo 把第 i 个值原样复制到 dst 的第 j 个槽位
+func (v *valueArray) copyTo(i int, dst *valueArray, j int) {
+	dst.ints[j] = v.ints[i]
+	if v.boxed != nil && (*v.boxed)[i] != nil {
+		(*dst.box())[j] = (*v.boxed)[i]
+	} else if dst.boxed != nil {
+		(*dst.boxed)[j] = nil
+	}
+}
+
+// shift 把 [from, to) 的值整体移动到从 at 开始的位置，区间可以重叠
+func (v *valueArray) shift(at, from, to int) {
+	copy(v.ints[at:], v.ints[from:to])
+	if v.boxed != nil {
+		copy((*v.boxed)[at:], (*v.boxed)[from:to])
+	}
+}
+
+// clearRange 清空 [from, to) 的值，释放装箱值的引用
+func (v *valueArray) clearRange(from, to int) {
+	for i := from; i < to; i++ {
+		v.ints[i] = 0
+	}
+	if v.boxed != nil {
+		for i := from; i < to; i++ {
+			(*v.boxed)[i] = nil
+		}
+	}
+}
+
+// get 返回第 i 个槽位的值，见 valueArray.get
+func (v *slotValues) get(i int) interface{} {
+	if boxed := v.boxed; boxed != nil {
+		if val, ok := unboxed(boxed[i]); ok {
+			return val
+		}
+	}
+	return v.ints[i]
+}
+
+// set 写入第 i 个槽位的值，int 值不装箱
+func (v *slotValues) set(i int, value interface{}) {
+	if n, ok := value.(int); ok {
+		v.ints[i] = n
+		if v.boxed != nil {
+			v.boxed[i] = nil
+		}
+		return
+	}
+	if v.boxed == nil {
+		v.boxed = new([EntryNum]interface{})
+	}
+	v.ints[i] = 0
+	v.boxed[i] = boxedSlot(value)
+}
+
+// clear 清空第 i 个槽位的值，释放装箱值的引用
+func (v *slotValues) clear(i int) {
+	v.ints[i] = 0
+	if v.boxed != nil {
+		v.boxed[i] = nil
+	}
+}
+
+// moveTo 把第 i 个槽位的值原样移动到 dst 的第 j 个槽位
+func (v *slotValues) moveTo(i int, dst *slotValues, j int) {
+	dst.ints[j] = v.ints[i]
+	if v.boxed != nil && v.boxed[i] != nil {
+		if dst.boxed == nil {
+			dst.boxed = new([EntryNum]interface{})
+		}
+		dst.boxed[j] = v.boxed[i]
+	} else if dst.boxed != nil {
+		dst.boxed[j] = nil
+	}
+	v.clear(i)
+}
+
+// leafContents 排序叶子(LNodeBTree 和 LNodeAppend)的内容：定长的键数组和值数组，前 n 个槽位有效。
+//
+// 数组按叶子的 Cardinality 分配，只有哈希叶子转换、压缩叶子解冻等一次装入更多条目时才分配更大的数组。
+// 叶子通过一个指针持有内容，数组创建后长度不变，容量不够时整体换成更大的内容；
+// 乐观的读者读到的数组长度固定，count 和 n 暂时不一致时也不会越界，读到的内容由版本校验决定是否丢弃
+type leafContents struct {
+	n      int
+	keys   []int      // 有序的键，与 values 按下标一一对应
+	values valueArray // 值，int 值不装箱
+}
+
+// newLeafContents 创建能容纳 capacity 个条目的空叶子内容
+func newLeafContents(capacity int) *leafContents {
+	return &leafContents{
+		keys:   make([]int, capacity),
+		values: valueArray{ints: make([]int, capacity)},
+	}
+}
+
+// capacity 返回能容纳的条目数
+func (c *leafContents) capacity() int {
+	return len(c.keys)
+}
+
+// len 返回条目数。乐观读取时 n 可能正被修改，读到的值总是某个写入过的合法长度
+func (c *leafContents) len() int {
+	return c.n
+}
+
+// sortedKeys 返回有效的键，切片引用 keys 数组本身
+func (c *leafContents) sortedKeys() []int {
+	return c.keys[:c.n]
+}
+
+// entry 以 Entry 形式返回第 i 个键值
+func (c *leafContents) entry(i int) Entry {
+	return Entry{Key: c.keys[i], Value: c.values.get(i)}
+}
+
+// entries 以 Entry 形式返回 [from, to) 的键值
+func (c *leafContents) entries(from, to int) []Entry {
+	entries := make([]Entry, to-from)
+	for i := range entries {
+		entries[i] = c.entry(from + i)
+	}
+	return entries
+}
+
+// insertAt 在 pos 处插入键值，后面的条目依次后移
+func (c *leafContents) insertAt(pos, key int, value interface{}) {
+	copy(c.keys[pos+1:c.n+1], c.keys[pos:c.n])
+	c.values.shift(pos+1, pos, c.n)
+	c.keys[pos] = key
+	c.values.set(pos, value)
+	c.n++
+}
+
+// appendEntry 在末尾追加一个键值
+func (c *leafContents) appendEntry(key int, value interface{}) {
+	c.keys[c.n] = key
+	c.values.set(c.n, value)
+	c.n++
+}
+
+// appendFrom 把 src 的第 i 个条目原样追加到末尾，值不经过 interface
+func (c *leafContents) appendFrom(src *leafContents, i int) {
+	c.keys[c.n] = src.keys[i]
+	src.values.copyTo(i, &c.values, c.n)
+	c.n++
+}
+
+// removeRange 删除 [from, to) 的条目，后面的条目依次前移
+func (c *leafContents) removeRange(from, to int) {
+	n := copy(c.keys[from:c.n], c.keys[to:c.n])
+	c.values.shift(from, to, c.n)
+	c.truncate(from + n)
+}
+
+// truncate 截断到前 n 个条目，释放后面装箱值的引用
+func (c *leafContents) truncate(n int) {
+	for i := n; i < c.n; i++ {
+		c.keys[i] = 0
+	}
+	c.values.clearRange(n, c.n)
+	c.n = n
+}
+
+// copyRange 把 [from, to) 的条目复制到能容纳 capacity 个条目的新叶子内容
+func (c *leafContents) copyRange(from, to, capacity int) *leafContents {
+	out := newLeafContents(capacity)
+	for i := from; i < to; i++ {
+		out.appendFrom(c, i)
+	}
+	return out
+}
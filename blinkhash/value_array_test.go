@@ -0,0 +1,155 @@
+package blinkhash
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestValueArray_MixedValues(t *testing.T) {
+	c := newLeafContents(8)
+	c.appendEntry(0, 7)
+	if c.values.boxed != nil {
+		t.Fatalf("Expected int values to stay unboxed")
+	}
+	c.appendEntry(1, "seven")
+	c.appendEntry(2, nil)
+	c.appendEntry(3, 1<<40)
+	expected := []interface{}{7, "seven", nil, 1 << 40}
+	for i, e := range expected {
+		if got := c.values.get(i); got != e {
+			t.Errorf("slot %d: expected %v, got %v", i, e, got)
+		}
+	}
+
+	// 被 int 覆盖的槽位不再引用装箱值
+	c.values.set(1, 8)
+	if got, ok := c.values.getInt(1); !ok || got != 8 || (*c.values.boxed)[1] != nil {
+		t.Errorf("Expected slot 1 to hold unboxed 8, got %v", c.values.get(1))
+	}
+	if _, ok := c.values.getInt(0); !ok {
+		t.Errorf("Expected slot 0 to hold an int")
+	}
+	if _, ok := c.values.getInt(2); ok {
+		t.Errorf("Expected slot 2 to hold a boxed nil")
+	}
+
+	c.insertAt(1, 10, "x")
+	c.removeRange(3, 4)
+	expected = []interface{}{7, "x", 8, 1 << 40}
+	for i, e := range expected {
+		if got := c.values.get(i); got != e {
+			t.Errorf("after shift slot %d: expected %v, got %v", i, e, got)
+		}
+	}
+	tail := c.copyRange(1, 3, 4)
+	c.truncate(1)
+	if c.len() != 1 || tail.len() != 2 || tail.values.get(0) != "x" || tail.values.get(1) != 8 || (*c.values.boxed)[1] != nil {
+		t.Errorf("Expected copyRange/truncate to split values, got %d and %d", c.len(), tail.len())
+	}
+
+	tail.appendEntry(20, nil)
+	tail.appendEntry(21, 9)
+	tail.removeRange(0, 2)
+	if tail.len() != 2 || tail.values.get(0) != nil || tail.values.get(1) != 9 || (*tail.values.boxed)[2] != nil {
+		t.Errorf("Expected removeRange to shift the remaining values, got %d values", tail.len())
+	}
+	if keys := tail.sortedKeys(); len(keys) != 2 || keys[0] != 20 || keys[1] != 21 {
+		t.Errorf("Expected keys to shift with the values, got %v", keys)
+	}
+}
+
+func TestLeafContents_Capacity(t *testing.T) {
+	lb := NewLNodeBTree(0)
+	if got := lb.loaded().capacity(); got != LNodeBTreeCardinality {
+		t.Fatalf("Expected a new leaf to hold %d entries, got %d", LNodeBTreeCardinality, got)
+	}
+	entries := make([]Entry, LNodeBTreeCardinality*3)
+	for i := range entries {
+		entries[i] = Entry{Key: i, Value: i}
+	}
+	// 一次装入更多条目(如哈希叶子转换)时换成刚好够用的内容
+	lb.BatchInsert(entries)
+	c := lb.loaded()
+	if c.capacity() != len(entries) || c.len() != len(entries) || lb.HighKey != len(entries)-1 {
+		t.Fatalf("Expected contents to grow to %d entries, got capacity %d and %d entries", len(entries), c.capacity(), c.len())
+	}
+	for i := range entries {
+		if c.keys[i] != i || c.values.get(i) != i {
+			t.Fatalf("slot %d: expected %d, got %d=%v", i, i, c.keys[i], c.values.get(i))
+		}
+	}
+}
+
+func TestSlotValues_Move(t *testing.T) {
+	var src, dst slotValues
+	src.set(0, 5)
+	src.set(1, "five")
+	src.moveTo(0, &dst, 3)
+	src.moveTo(1, &dst, 4)
+	if dst.get(3) != 5 || dst.get(4) != "five" || src.get(0) != 0 || src.boxed[1] != nil {
+		t.Errorf("Expected values to move between slots, got %v %v", dst.get(3), dst.get(4))
+	}
+	src.moveTo(0, &dst, 4) // 空槽位覆盖装箱值
+	if dst.get(4) != 0 || dst.boxed[4] != nil {
+		t.Errorf("Expected an int move to clear the boxed slot, got %v", dst.get(4))
+	}
+}
+
+func TestBTree_NonIntValues(t *testing.T) {
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	n := 5000
+	for k := 1; k <= n; k++ {
+		var value interface{} = k
+		switch k % 3 {
+		case 1:
+			value = [2]int{k, -k}
+		case 2:
+			value = nil
+		}
+		tree.Insert(k, value, ti)
+	}
+	for k := 1; k <= n; k++ {
+		var expected interface{} = k
+		switch k % 3 {
+		case 1:
+			expected = [2]int{k, -k}
+		case 2:
+			expected = nil
+		}
+		if got := tree.Lookup(k, ti); got != expected {
+			t.Fatalf("key %d: expected %v, got %v", k, expected, got)
+		}
+	}
+}
+
+// BenchmarkBTree_GCPause 测量装满一棵树后一次完整 GC 的耗时，
+// 键值不装箱时 GC 需要扫描的对象和指针都少得多
+func BenchmarkBTree_GCPause(b *testing.B) {
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	for k := 1; k <= 1000000; k++ {
+		tree.Insert(k*7919, k, ti)
+	}
+	runtime.GC()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runtime.GC()
+	}
+	b.StopTimer()
+	runtime.KeepAlive(tree)
+}
+
+func BenchmarkBTree_Lookup(b *testing.B) {
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	n := 1000000
+	for k := 1; k <= n; k++ {
+		tree.Insert(k*7919, k, ti)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		k := i%n + 1
+		tree.Lookup(k*7919, ti)
+	}
+}
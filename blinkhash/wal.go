@@ -0,0 +1,964 @@
+package blinkhash
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WALSyncPolicy 预写日志的落盘策略
+type WALSyncPolicy int
+
+const (
+	// WALSyncAlways 写入在日志记录 fsync 之后才返回。并发写入的记录合并为一次 fsync(组提交)
+	WALSyncAlways WALSyncPolicy = iota
+	// WALSyncPeriodic 写入只把记录放入缓冲区，后台每隔 SyncInterval 写入文件并 fsync 一次，
+	// 进程崩溃最多丢失最近一个间隔内的写入
+	WALSyncPeriodic
+	// WALSyncNone 缓冲区满时写入文件，何时落盘由操作系统决定，只有 Sync、Checkpoint 和 Close 会 fsync
+	WALSyncNone
+)
+
+func (p WALSyncPolicy) String() string {
+	switch p {
+	case WALSyncAlways:
+		return "always"
+	case WALSyncPeriodic:
+		return "periodic"
+	case WALSyncNone:
+		return "none"
+	}
+	return fmt.Sprintf("WALSyncPolicy(%d)", int(p))
+}
+
+// WALOptions 预写日志的配置
+type WALOptions struct {
+	Sync WALSyncPolicy
+	// GroupCommitDelay WALSyncAlways 下发起 fsync 前等待更多写入加入本组的时间，0 表示不等待，
+	// 此时只合并 fsync 进行期间到达的写入
+	GroupCommitDelay time.Duration
+	// SyncInterval WALSyncPeriodic 的 fsync 间隔
+	SyncInterval time.Duration
+	// BufferSize 缓冲区超过该字节数时立即写入文件，WALSyncAlways 下不使用
+	BufferSize int
+	// ValueCodec 日志和检查点中值的编码，为 nil 时使用 TaggedCodec。
+	// 名称记录在每个文件的文件头中，已有文件总是用写入时的编解码器读取
+	ValueCodec Codec
+	// Tree 打开的树的配置，为 nil 时使用 DefaultTreeOptions
+	Tree *TreeOptions
+}
+
+// DefaultWALOptions Open 使用的默认配置
+var DefaultWALOptions = WALOptions{
+	Sync:         WALSyncAlways,
+	SyncInterval: 100 * time.Millisecond,
+	BufferSize:   64 << 10,
+}
+
+// 日志记录的类型
+const (
+	walInsert byte = iota + 1
+	walUpdate
+	walRemove
+	walBatch       // WriteBatch 或事务，回放时原子地应用
+	walTruncate    // TruncateBefore，只记录键
+	walDeleteRange // DeleteRange，记录两个边界
+	walInsertTTL   // InsertWithTTL，在键和值之间记录绝对的过期时间
+)
+
+// walMagic 日志文件的文件头，后跟 4 字节的格式版本和键、值编解码器的名称。
+// 版本 1 没有记录编解码器，值总是使用 TaggedCodec
+const walMagic = "BLHWAL\x00\x00"
+
+const walFormatVersion = 2
+
+// walFrameHeader 每条记录前的帧头：4 字节负载长度 + 4 字节负载的 CRC32C
+const walFrameHeader = 8
+
+// walMaxRecord 单条记录的最大长度，超过时按损坏处理，避免读到撕裂的帧头后分配巨大的缓冲区
+const walMaxRecord = 1 << 30
+
+var walCRC = crc32.MakeTable(crc32.Castagnoli)
+
+// errWALCorrupt 日志帧损坏。只有最后一个日志文件的尾部允许出现(写到一半时崩溃)
+var errWALCorrupt = errors.New("blinkhash: corrupt WAL record")
+
+// errWALClosed 日志已经关闭，Close 之后的写入以它失败
+var errWALClosed = errors.New("blinkhash: WAL is closed")
+
+// wal 追加写的日志文件及其组提交状态。
+//
+// 写入把记录追加到 buf 并取得一个递增的序号(LSN)。需要落盘的写入等待 synced 越过自己的序号：
+// 没有其他线程在刷盘时由它作为组长取走整个缓冲区，在不持有 mu 的情况下 write + fsync，
+// 期间到达的写入继续追加到新的缓冲区并等待，由下一个组长一次刷出。
+//
+// 日志目录中 wal-N.log 为第 N 个日志文件，checkpoint-N.dat 为 wal-N.log 开始之前整棵树的状态，格式与 SaveTo 相同。
+// 恢复时加载最新的检查点，再按顺序回放编号不小于它的日志。
+type wal struct {
+	dir    string
+	opts   WALOptions
+	values Codec
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	file     *os.File
+	seq      uint64 // 当前日志文件的编号
+	buf      []byte // 尚未写入文件的记录
+	spare    []byte // 刷盘时与 buf 交换，避免每次重新分配
+	appended uint64 // 最后一条追加的记录的序号
+	written  uint64 // 已写入文件的最大序号
+	synced   uint64 // 已 fsync 的最大序号
+	flushing bool
+	err      error // 第一次 IO 错误，之后的写入都以它失败
+
+	order walOrder // 同一个键上的记录顺序与修改树的顺序一致
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func walPath(dir string, seq uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("wal-%016d.log", seq))
+}
+
+func checkpointPath(dir string, seq uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("checkpoint-%016d.dat", seq))
+}
+
+// Open 打开 dir 中的树：加载最新的检查点并回放之后的日志，之后的写入追加到新的日志文件。
+// dir 不存在时创建一棵空树。使用 DefaultWALOptions。
+func Open(dir string) (*BTree, error) {
+	return OpenWithOptions(dir, DefaultWALOptions)
+}
+
+// OpenWithOptions 与 Open 相同，使用指定的日志配置
+func OpenWithOptions(dir string, opts WALOptions) (*BTree, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	logs, checkpoints, err := listWALDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	treeOpts := DefaultTreeOptions
+	if opts.Tree != nil {
+		treeOpts = *opts.Tree
+	}
+	bt := NewBTreeWithOptions(treeOpts)
+	var start uint64
+	if len(checkpoints) > 0 {
+		start = checkpoints[len(checkpoints)-1]
+		if bt, err = loadCheckpoint(checkpointPath(dir, start), treeOpts); err != nil {
+			return nil, err
+		}
+	}
+	ti := NewThreadInfo(bt.epoche)
+	next := start
+	for i, seq := range logs {
+		if seq < start {
+			// 上一次检查点完成后没来得及删除的旧日志
+			os.Remove(walPath(dir, seq))
+			continue
+		}
+		if err := bt.replayLog(walPath(dir, seq), i == len(logs)-1, ti); err != nil {
+			return nil, err
+		}
+		next = seq + 1
+	}
+	if next == 0 {
+		next = 1
+	}
+
+	w, err := openWAL(dir, next, opts)
+	if err != nil {
+		return nil, err
+	}
+	bt.wal = w
+	return bt, nil
+}
+
+// listWALDir 返回目录中日志和检查点文件的编号，按从小到大排序，并删除未完成的临时文件
+func listWALDir(dir string) (logs, checkpoints []uint64, err error) {
+	names, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, e := range names {
+		name := e.Name()
+		var seq uint64
+		switch {
+		case strings.HasSuffix(name, ".tmp"):
+			os.Remove(filepath.Join(dir, name))
+		case strings.HasPrefix(name, "wal-"):
+			if _, err := fmt.Sscanf(name, "wal-%d.log", &seq); err == nil {
+				logs = append(logs, seq)
+			}
+		case strings.HasPrefix(name, "checkpoint-"):
+			if _, err := fmt.Sscanf(name, "checkpoint-%d.dat", &seq); err == nil {
+				checkpoints = append(checkpoints, seq)
+			}
+		}
+	}
+	sort.Slice(logs, func(i, j int) bool { return logs[i] < logs[j] })
+	sort.Slice(checkpoints, func(i, j int) bool { return checkpoints[i] < checkpoints[j] })
+	return logs, checkpoints, nil
+}
+
+func openWAL(dir string, seq uint64, opts WALOptions) (*wal, error) {
+	w := &wal{dir: dir, opts: opts, seq: seq, values: opts.ValueCodec}
+	if w.values == nil {
+		w.values = TaggedCodec
+	}
+	w.cond = sync.NewCond(&w.mu)
+	w.order.init()
+	file, err := createWALFile(walPath(dir, seq), w.values)
+	if err != nil {
+		return nil, err
+	}
+	w.file = file
+	if opts.Sync == WALSyncPeriodic {
+		w.stop, w.done = make(chan struct{}), make(chan struct{})
+		go w.syncLoop()
+	}
+	return w, nil
+}
+
+// createWALFile 创建写好文件头的新文件，并 fsync 文件和目录
+func createWALFile(path string, values Codec) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Write(appendWALHeader(nil, values)); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := syncDir(filepath.Dir(path)); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func appendWALHeader(buf []byte, values Codec) []byte {
+	buf = append(buf, walMagic...)
+	buf = binary.LittleEndian.AppendUint32(buf, walFormatVersion)
+	return appendCodecNames(buf, IntCodec, values)
+}
+
+// appendCodecNames 依次追加键和值编解码器的名称，每个名称以 uvarint 长度开头
+func appendCodecNames(buf []byte, keys, values Codec) []byte {
+	for _, c := range []Codec{keys, values} {
+		buf = binary.AppendUvarint(buf, uint64(len(c.Name())))
+		buf = append(buf, c.Name()...)
+	}
+	return buf
+}
+
+// codecReader 读取文件头的 bufio.Reader 或 checksumReader
+type codecReader interface {
+	io.ByteReader
+	io.Reader
+}
+
+// readCodecNames 读取 appendCodecNames 写入的名称并查找编解码器。树的键总是 int，只接受 IntCodec
+func readCodecNames(r codecReader) (values Codec, err error) {
+	var names [2]string
+	for i := range names {
+		n, err := binary.ReadUvarint(r)
+		if err != nil || n > 256 {
+			return nil, errCorrupt
+		}
+		name := make([]byte, n)
+		if _, err := io.ReadFull(r, name); err != nil {
+			return nil, errCorrupt
+		}
+		names[i] = string(name)
+	}
+	if names[0] != IntCodec.Name() {
+		return nil, fmt.Errorf("blinkhash: unsupported key codec %q", names[0])
+	}
+	return codecByName(names[1])
+}
+
+func (w *wal) syncLoop() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.opts.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.mu.Lock()
+			w.flush(true)
+			w.mu.Unlock()
+		}
+	}
+}
+
+// append 把一条记录追加到缓冲区并返回它的序号，不等待落盘，见 wait。
+// 日志已经出错时返回该错误，记录不会被追加
+func (w *wal) append(payload []byte) (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.buf = appendWALFrame(w.buf, payload)
+	w.appended++
+	if w.opts.Sync != WALSyncAlways && len(w.buf) >= w.opts.BufferSize {
+		w.flush(false)
+	}
+	return w.appended, w.err
+}
+
+// wait 按落盘策略等待序号为 lsn 的记录落盘：只有 WALSyncAlways 需要等待，
+// 等待期间到达的记录与它合并为一次 fsync。记录没能落盘时返回日志的错误
+func (w *wal) wait(lsn uint64) error {
+	if w.opts.Sync != WALSyncAlways {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for w.synced < lsn && w.err == nil {
+		if w.flushing {
+			w.cond.Wait()
+			continue
+		}
+		if w.opts.GroupCommitDelay > 0 {
+			// 等待更多写入加入本组；等待期间其他组长可能已经刷出了这条记录
+			w.mu.Unlock()
+			time.Sleep(w.opts.GroupCommitDelay)
+			w.mu.Lock()
+			if w.synced >= lsn {
+				break
+			}
+		}
+		w.flush(true)
+	}
+	if w.synced < lsn {
+		return w.err
+	}
+	return nil
+}
+
+// flush 把缓冲区写入文件，sync 为 true 时随后 fsync。调用时持有 w.mu，IO 期间释放
+func (w *wal) flush(sync bool) {
+	for w.flushing {
+		w.cond.Wait()
+	}
+	if w.err != nil || (len(w.buf) == 0 && (!sync || w.synced == w.written)) {
+		return
+	}
+	w.flushing = true
+	buf, target, file := w.buf, w.appended, w.file
+	w.buf, w.spare = w.spare[:0], nil
+	w.mu.Unlock()
+
+	var err error
+	if len(buf) > 0 {
+		_, err = file.Write(buf)
+	}
+	if err == nil && sync {
+		err = file.Sync()
+	}
+
+	w.mu.Lock()
+	w.flushing = false
+	w.spare = buf[:0]
+	if err != nil {
+		w.err = err
+	} else {
+		w.written = target
+		if sync {
+			w.synced = target
+		}
+	}
+	w.cond.Broadcast()
+}
+
+// rotate 刷出当前日志并切换到编号为 seq 的新文件，调用方需要挡住所有写入
+func (w *wal) rotate(seq uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.flush(true)
+	if w.err != nil {
+		return w.err
+	}
+	file, err := createWALFile(walPath(w.dir, seq), w.values)
+	if err != nil {
+		w.err = err
+		return err
+	}
+	w.file.Close()
+	w.file, w.seq = file, seq
+	return nil
+}
+
+func (w *wal) sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.flush(true)
+	return w.err
+}
+
+func (w *wal) close() error {
+	if w.stop != nil {
+		close(w.stop)
+		<-w.done
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.flush(true)
+	if err := w.file.Close(); err != nil && w.err == nil {
+		w.err = err
+	}
+	err := w.err
+	if w.err == nil {
+		w.err = errWALClosed
+	}
+	return err
+}
+
+func appendWALFrame(buf, payload []byte) []byte {
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(payload)))
+	buf = binary.LittleEndian.AppendUint32(buf, crc32.Checksum(payload, walCRC))
+	return append(buf, payload...)
+}
+
+// walRecord 编码只有键的日志记录(Remove、TruncateBefore)，没有打开日志时返回 nil
+func (bt *BTree) walRecord(op byte, key interface{}) []byte {
+	if bt.wal == nil {
+		return nil
+	}
+	payload := []byte{op}
+	return binary.AppendVarint(payload, int64(key.(int)))
+}
+
+// walValueRecord 编码带值的单键写入的日志记录，没有打开日志时返回 nil。
+// 在修改树之前调用：值不能用日志的编解码器编码时返回 ErrCodecType，调用方放弃这次写入
+func (bt *BTree) walValueRecord(op byte, key, value interface{}) ([]byte, error) {
+	payload := bt.walRecord(op, key)
+	if payload == nil {
+		return nil, nil
+	}
+	if ev, ok := value.(*expiringValue); ok && op == walInsertTTL {
+		payload = binary.AppendVarint(payload, ev.deadline)
+		value = ev.value
+	}
+	return bt.wal.values.Append(payload, value)
+}
+
+// walRangeRecord 编码 DeleteRange 的日志记录，没有打开日志时返回 nil
+func (bt *BTree) walRangeRecord(lo, hi int) []byte {
+	payload := bt.walRecord(walDeleteRange, lo)
+	if payload == nil {
+		return nil
+	}
+	return binary.AppendVarint(payload, int64(hi))
+}
+
+// walBatchRecord 把一批写入编码为一条日志记录，回放时同样原子地应用。
+// 任何一个值不能编码时返回 ErrCodecType，整批都不写入
+func (bt *BTree) walBatchRecord(ops []batchOp) ([]byte, error) {
+	if bt.wal == nil || len(ops) == 0 {
+		return nil, nil
+	}
+	payload := []byte{walBatch}
+	payload = binary.AppendUvarint(payload, uint64(len(ops)))
+	for _, op := range ops {
+		if op.delete {
+			payload = append(payload, walRemove)
+		} else {
+			payload = append(payload, walInsert)
+		}
+		payload = binary.AppendVarint(payload, int64(op.key))
+		if op.delete {
+			continue
+		}
+		var err error
+		if payload, err = bt.wal.values.Append(payload, op.value); err != nil {
+			return nil, err
+		}
+	}
+	return payload, nil
+}
+
+// logLocked 追加一条日志记录并等待落盘，用于调用方已经挡住相关写入、不需要领取轮次的写入。
+// 没有记录时直接返回 true；日志出错时使树进入失败状态并返回 false，调用方不能再修改树
+func (bt *BTree) logLocked(payload []byte) bool {
+	if payload == nil {
+		return true
+	}
+	lsn, err := bt.wal.append(payload)
+	if err == nil {
+		err = bt.wal.wait(lsn)
+	}
+	if err != nil {
+		bt.fail(err)
+		return false
+	}
+	return true
+}
+
+// walOrderStripes 日志顺序按键分片的分片数，键到分片的映射与旧版本相同，见 stripeIndex
+const walOrderStripes = versionStripes
+
+// walOrder 保证同一个键上日志记录的顺序与修改树的顺序一致，回放才能得到相同的树。
+//
+// 写入持有涉及的分片锁追加日志记录并领取每个分片上的轮次(logged)，释放分片锁后等待记录落盘，
+// 再等到分片上 applied 等于自己的轮次，重新锁住分片修改树，修改完成后让出轮次并释放分片锁。
+// fsync 期间不持有分片锁；修改树期间持有，同一个分片上的下一条记录要等这次修改完成才能追加，
+// 只有落在同一个分片上的写入按日志的顺序依次修改树。多键写入在加锁的同时领取所有分片的轮次，
+// 轮次在各个分片上的先后关系一致，等待轮次时不持有任何分片锁，不会互相等待。
+// 轮次只约束写日志的写入之间的顺序，快照需要的旧版本仍由 versionStore 记录
+type walOrder struct {
+	gate    sync.RWMutex // 领取轮次和 holdWrites 的持有者持有读锁，drain 持有写锁
+	stripes [walOrderStripes]walOrderStripe
+}
+
+type walOrderStripe struct {
+	mu      sync.Mutex
+	logged  uint64     // 已领取的轮次数，即下一次写入的轮次
+	applied uint64     // 已让出的轮次数
+	turn    *sync.Cond // 基于 mu，applied 增加时广播
+}
+
+func (o *walOrder) init() {
+	for i := range o.stripes {
+		st := &o.stripes[i]
+		st.turn = sync.NewCond(&st.mu)
+	}
+}
+
+func (o *walOrder) lockStripes(stripes []int) {
+	for _, idx := range stripes {
+		o.stripes[idx].mu.Lock()
+	}
+}
+
+func (o *walOrder) unlockStripes(stripes []int) {
+	for _, idx := range stripes {
+		o.stripes[idx].mu.Unlock()
+	}
+}
+
+// awaitTurns 依次等到每个分片上轮到 tickets 中的轮次。等待时不持有其他分片的锁：
+// 排在前面的写入让出轮次时需要锁住它涉及的所有分片
+func (o *walOrder) awaitTurns(stripes []int, tickets []uint64) {
+	for i, idx := range stripes {
+		st := &o.stripes[idx]
+		st.mu.Lock()
+		for st.applied != tickets[i] {
+			st.turn.Wait()
+		}
+		st.mu.Unlock()
+	}
+}
+
+// drain 停止领取新的轮次，并等待已经领取的轮次全部让出。之后直到 undrain 没有写日志的写入在进行
+func (o *walOrder) drain() {
+	o.gate.Lock()
+	for i := range o.stripes {
+		st := &o.stripes[i]
+		st.mu.Lock()
+		for st.applied != st.logged {
+			st.turn.Wait()
+		}
+		st.mu.Unlock()
+	}
+}
+
+func (o *walOrder) undrain() {
+	o.gate.Unlock()
+}
+
+// walTurn 一次写入在 walOrder 中领取的轮次，tickets 与 stripes 一一对应。零值表示没有写日志
+type walTurn struct {
+	order   *walOrder
+	stripes []int
+	tickets []uint64
+}
+
+// logWrite 为写入 keys 的操作追加日志记录 payload，等待记录落盘并轮到这次写入，之后调用方修改树，
+// 完成后调用 walTurn.done。没有日志记录(payload 为 nil)时直接返回。
+// 返回 false 表示日志出错：树进入失败状态，写入不能生效，不需要再调用 done
+func (bt *BTree) logWrite(payload []byte, keys ...int) (walTurn, bool) {
+	if payload == nil {
+		return walTurn{}, true
+	}
+	w := bt.wal
+	o := &w.order
+	t := walTurn{order: o, stripes: batchStripes(keys)}
+	o.gate.RLock()
+	o.lockStripes(t.stripes)
+	lsn, err := w.append(payload)
+	if err == nil {
+		t.tickets = make([]uint64, len(t.stripes))
+		for i, idx := range t.stripes {
+			st := &o.stripes[idx]
+			t.tickets[i] = st.logged
+			st.logged++
+		}
+	}
+	o.unlockStripes(t.stripes)
+	o.gate.RUnlock()
+	if err != nil {
+		bt.fail(err)
+		return walTurn{}, false
+	}
+	err = w.wait(lsn)
+	// 落盘失败时同样等到自己的轮次再让出，分片上之后的写入才能继续
+	o.awaitTurns(t.stripes, t.tickets)
+	o.lockStripes(t.stripes)
+	if err != nil {
+		t.done()
+		bt.fail(err)
+		return walTurn{}, false
+	}
+	return t, true
+}
+
+// done 让出轮次并释放分片锁，各个分片上的下一次写入可以修改树
+func (t walTurn) done() {
+	if t.order == nil {
+		return
+	}
+	for _, idx := range t.stripes {
+		st := &t.order.stripes[idx]
+		st.applied++
+		st.turn.Broadcast()
+	}
+	t.order.unlockStripes(t.stripes)
+}
+
+// walHold holdWrites 锁住的分片，零值表示没有打开日志
+type walHold struct {
+	order   *walOrder
+	stripes []int
+}
+
+// holdWrites 锁住 keys 所在的分片并等到分片上已经领取的轮次全部让出，一直持有直到 release。
+// 事务只有在锁住叶子并校验读取之后才知道能否提交，不能提前领取轮次：持有期间这些分片上
+// 没有其他写入能领取轮次，事务用 logLocked 写日志，不需要轮次
+func (bt *BTree) holdWrites(keys []int) walHold {
+	if bt.wal == nil {
+		return walHold{}
+	}
+	o := &bt.wal.order
+	h := walHold{order: o, stripes: batchStripes(keys)}
+	o.gate.RLock()
+	for {
+		o.lockStripes(h.stripes)
+		var waiting *walOrderStripe
+		for _, idx := range h.stripes {
+			if st := &o.stripes[idx]; st.applied != st.logged {
+				waiting = st
+				break
+			}
+		}
+		if waiting == nil {
+			return h
+		}
+		// 让出轮次需要分片锁，等待之前全部释放
+		o.unlockStripes(h.stripes)
+		waiting.mu.Lock()
+		for waiting.applied != waiting.logged {
+			waiting.turn.Wait()
+		}
+		waiting.mu.Unlock()
+	}
+}
+
+func (h walHold) release() {
+	if h.order == nil {
+		return
+	}
+	h.order.unlockStripes(h.stripes)
+	h.order.gate.RUnlock()
+}
+
+// lockWrites 挡住所有写入，包括已经写了日志、还没修改树的写入，用于快照、截断、范围删除和检查点这类
+// 不按键领取轮次的操作：先等待日志的轮次全部让出，再通过 versionStore.lockAll 锁住旧版本的所有分片
+func (bt *BTree) lockWrites() {
+	if bt.wal != nil {
+		bt.wal.order.drain()
+	}
+	bt.versions.lockAll()
+}
+
+func (bt *BTree) unlockWrites() {
+	bt.versions.unlockAll()
+	if bt.wal != nil {
+		bt.wal.order.undrain()
+	}
+}
+
+// readWALFile 校验文件头后逐条读取记录交给 fn。返回最后一条完整记录之后的偏移；
+// 遇到不完整或校验失败的帧时停止并返回 errWALCorrupt
+func readWALFile(path string, fn func(payload []byte, values Codec) error) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	r := bufio.NewReaderSize(file, 64<<10)
+
+	header := make([]byte, len(walMagic)+4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, errWALCorrupt
+	}
+	if string(header[:len(walMagic)]) != walMagic {
+		return 0, fmt.Errorf("blinkhash: %s is not a WAL file", path)
+	}
+	offset := int64(len(header))
+	values := TaggedCodec
+	switch v := binary.LittleEndian.Uint32(header[len(walMagic):]); v {
+	case 1:
+	case walFormatVersion:
+		if values, err = readCodecNames(r); err == errCorrupt {
+			return 0, errWALCorrupt
+		} else if err != nil {
+			return 0, fmt.Errorf("blinkhash: %s: %w", path, err)
+		}
+		offset += int64(len(appendCodecNames(nil, IntCodec, values)))
+	default:
+		return 0, fmt.Errorf("blinkhash: unsupported WAL format version %d in %s", v, path)
+	}
+	var frame [walFrameHeader]byte
+	var payload []byte
+	for {
+		if _, err := io.ReadFull(r, frame[:]); err == io.EOF {
+			return offset, nil
+		} else if err != nil {
+			return offset, errWALCorrupt
+		}
+		size := binary.LittleEndian.Uint32(frame[:4])
+		if size > walMaxRecord {
+			return offset, errWALCorrupt
+		}
+		if cap(payload) < int(size) {
+			payload = make([]byte, size)
+		}
+		payload = payload[:size]
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return offset, errWALCorrupt
+		}
+		if crc32.Checksum(payload, walCRC) != binary.LittleEndian.Uint32(frame[4:]) {
+			return offset, errWALCorrupt
+		}
+		if err := fn(payload, values); err != nil {
+			return offset, err
+		}
+		offset += walFrameHeader + int64(size)
+	}
+}
+
+// replayLog 回放一个日志文件。last 为 true 时允许尾部有写到一半的记录，并把文件截断到最后一条完整记录
+func (bt *BTree) replayLog(path string, last bool, ti *ThreadInfo) error {
+	offset, err := readWALFile(path, func(payload []byte, values Codec) error {
+		return bt.applyWALRecord(payload, values, ti)
+	})
+	if err == errWALCorrupt && last {
+		return os.Truncate(path, offset)
+	}
+	if err != nil {
+		return fmt.Errorf("blinkhash: replaying %s: %w", path, err)
+	}
+	return nil
+}
+
+// applyWALRecord 回放一条日志记录，直接写入树，不再记录旧版本和日志
+func (bt *BTree) applyWALRecord(payload []byte, values Codec, ti *ThreadInfo) error {
+	d := &decoder{data: payload}
+	switch op := d.byte(); op {
+	case walInsert, walUpdate:
+		key, value := int(d.varint()), d.value(values)
+		if d.err != nil {
+			return d.err
+		}
+		switch {
+		case op == walInsert:
+			bt.insert(key, value, ti)
+		case bt.expires():
+			// 与 Update 相同，过期的键不能被更新
+			if _, live := bt.lookup(key, ti); live {
+				bt.update(key, value, ti)
+			}
+		default:
+			bt.update(key, value, ti)
+		}
+	case walInsertTTL:
+		key, deadline := int(d.varint()), d.varint()
+		value := d.value(values)
+		if d.err != nil {
+			return d.err
+		}
+		bt.markExpiring()
+		bt.insert(key, &expiringValue{value: value, deadline: deadline}, ti)
+	case walRemove:
+		key := int(d.varint())
+		if d.err != nil {
+			return d.err
+		}
+		bt.remove(key, ti)
+	case walBatch:
+		n := d.uvarint()
+		ops := make([]batchOp, 0, n)
+		for i := uint64(0); i < n && d.err == nil; i++ {
+			op := batchOp{delete: d.byte() == walRemove, key: int(d.varint())}
+			if !op.delete {
+				op.value = d.value(values)
+			}
+			ops = append(ops, op)
+		}
+		if d.err != nil {
+			return d.err
+		}
+		bt.applyBatch(ops, ti)
+	case walTruncate:
+		key := int(d.varint())
+		if d.err != nil {
+			return d.err
+		}
+		bt.truncateBefore(key, ti)
+	case walDeleteRange:
+		lo, hi := int(d.varint()), int(d.varint())
+		if d.err != nil {
+			return d.err
+		}
+		bt.deleteRange(lo, hi, ti)
+	default:
+		return errCorrupt
+	}
+	return nil
+}
+
+// Checkpoint 把树的当前状态写入检查点文件，并删除它之前的日志和检查点，限制日志的长度和恢复时间。
+//
+// 切换到新日志文件和创建快照在挡住所有写入的同一时刻完成，因此检查点恰好包含旧日志中的全部写入。
+// 写检查点期间写入照常进行，写入新的日志文件。
+func (bt *BTree) Checkpoint() error {
+	w := bt.wal
+	if w == nil {
+		return errors.New("blinkhash: Checkpoint requires a tree opened with Open")
+	}
+	bt.lockWrites()
+	seq := w.seq + 1
+	err := w.rotate(seq)
+	var snap *Snapshot
+	if err == nil {
+		snap = bt.snapshotLocked()
+	}
+	bt.unlockWrites()
+	if err != nil {
+		return err
+	}
+	defer snap.Release()
+
+	if err := bt.writeCheckpoint(checkpointPath(w.dir, seq), snap); err != nil {
+		return err
+	}
+	logs, checkpoints, err := listWALDir(w.dir)
+	if err != nil {
+		return err
+	}
+	for _, s := range logs {
+		if s < seq {
+			os.Remove(walPath(w.dir, s))
+		}
+	}
+	for _, s := range checkpoints {
+		if s < seq {
+			os.Remove(checkpointPath(w.dir, s))
+		}
+	}
+	return nil
+}
+
+// writeCheckpoint 以 SaveTo 的格式把快照写入 path。先写临时文件，fsync 后再改名，
+// 崩溃时要么保留完整的新检查点，要么仍使用旧的检查点和日志
+func (bt *BTree) writeCheckpoint(path string, snap *Snapshot) error {
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	defer file.Close()
+
+	out := bufio.NewWriterSize(file, 64<<10)
+	if err := bt.saveSnapshot(out, snap, bt.wal.values); err != nil {
+		return err
+	}
+	if err := out.Flush(); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// loadCheckpoint 经由 LoadFrom 的批量构建路径读入检查点文件
+func loadCheckpoint(path string, opts TreeOptions) (*BTree, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	bt, err := LoadFromWithOptions(bufio.NewReaderSize(file, 64<<10), opts)
+	if err != nil {
+		return nil, fmt.Errorf("blinkhash: loading %s: %w", path, err)
+	}
+	return bt, nil
+}
+
+// Sync 把已经写入的日志记录全部落盘，返回日志遇到的第一个 IO 错误。
+// 写入总是先写日志再修改树，日志出错之后的写入不再生效，树进入失败状态，见 BTree.Err
+func (bt *BTree) Sync() error {
+	if bt.wal == nil {
+		return nil
+	}
+	return bt.wal.sync()
+}
+
+// Close 停止后台清理过期条目，刷出并关闭日志，之后的写入会使树进入失败状态
+func (bt *BTree) Close() error {
+	bt.StopTTLSweeper()
+	err := bt.closeTier()
+	if bt.wal == nil {
+		return err
+	}
+	if werr := bt.wal.close(); werr != nil {
+		return werr
+	}
+	return err
+}
@@ -0,0 +1,483 @@
+package blinkhash
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWAL_Reopen(t *testing.T) {
+	dir := t.TempDir()
+	tree, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ti := NewThreadInfo(tree.GetEpoche())
+	for k := 1; k <= 1000; k++ {
+		tree.Insert(k, k, ti)
+	}
+	tree.Update(1, "one", ti)
+	tree.Update(2, []byte("two"), ti)
+	tree.Update(3, 3.5, ti)
+	tree.Update(4, true, ti)
+	tree.Update(5, nil, ti)
+	tree.Remove(6, ti)
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	ti = NewThreadInfo(tree.GetEpoche())
+	if got := tree.Lookup(1, ti); got != "one" {
+		t.Errorf("Expected \"one\", got %v", got)
+	}
+	if got, ok := tree.Lookup(2, ti).([]byte); !ok || string(got) != "two" {
+		t.Errorf("Expected []byte(\"two\"), got %v", tree.Lookup(2, ti))
+	}
+	if got := tree.Lookup(3, ti); got != 3.5 {
+		t.Errorf("Expected 3.5, got %v", got)
+	}
+	if got := tree.Lookup(4, ti); got != true {
+		t.Errorf("Expected true, got %v", got)
+	}
+	if got := tree.Lookup(6, ti); got != nil {
+		t.Errorf("Expected removed key to stay removed, got %v", got)
+	}
+	for k := 7; k <= 1000; k++ {
+		if got := tree.Lookup(k, ti); got != k {
+			t.Fatalf("key %d: expected %d, got %v", k, k, got)
+		}
+	}
+	if got := len(tree.RangeLookup(0, 2000, ti)); got != 999 {
+		t.Errorf("Expected 999 entries, got %d", got)
+	}
+}
+
+// 日志尾部写到一半的记录在恢复时被丢弃，之前的记录不受影响
+func TestWAL_TornTail(t *testing.T) {
+	dir := t.TempDir()
+	tree, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ti := NewThreadInfo(tree.GetEpoche())
+	for k := 1; k <= 100; k++ {
+		tree.Insert(k, k, ti)
+	}
+	tree.Close()
+
+	path := walPath(dir, 1)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ti = NewThreadInfo(tree.GetEpoche())
+	if got := tree.Lookup(100, ti); got != nil {
+		t.Errorf("Expected the torn record to be dropped, got %v", got)
+	}
+	if got := len(tree.RangeLookup(0, 200, ti)); got != 99 {
+		t.Errorf("Expected 99 entries, got %d", got)
+	}
+	tree.Insert(100, -100, ti)
+	tree.Close()
+
+	// 截断之后追加的新日志在下一次恢复时仍然可读
+	tree, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	ti = NewThreadInfo(tree.GetEpoche())
+	if got := tree.Lookup(100, ti); got != -100 {
+		t.Errorf("Expected -100, got %v", got)
+	}
+}
+
+// 较早的日志中出现损坏时拒绝打开，而不是静默丢弃之后的写入
+func TestWAL_CorruptMiddle(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 2; i++ {
+		tree, err := Open(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree.Insert(i, i, NewThreadInfo(tree.GetEpoche()))
+		tree.Close()
+	}
+	data, err := os.ReadFile(walPath(dir, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err := os.WriteFile(walPath(dir, 1), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(dir); err == nil {
+		t.Fatal("Expected an error for a corrupt record before the last log")
+	}
+}
+
+func TestWAL_Checkpoint(t *testing.T) {
+	dir := t.TempDir()
+	tree, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ti := NewThreadInfo(tree.GetEpoche())
+	for k := 1; k <= 5000; k++ {
+		tree.Insert(k, k, ti)
+	}
+	if err := tree.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	for k := 1; k <= 100; k++ {
+		tree.Update(k, -k, ti)
+	}
+	tree.Remove(5000, ti)
+	if err := tree.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	tree.Insert(6000, 6000, ti)
+	tree.Close()
+
+	names, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 {
+		t.Errorf("Expected one checkpoint and one log after Checkpoint, got %d files", len(names))
+	}
+
+	tree, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	ti = NewThreadInfo(tree.GetEpoche())
+	for k := 1; k <= 100; k++ {
+		if got := tree.Lookup(k, ti); got != -k {
+			t.Fatalf("key %d: expected %d, got %v", k, -k, got)
+		}
+	}
+	if got := tree.Lookup(5000, ti); got != nil {
+		t.Errorf("Expected removed key to stay removed, got %v", got)
+	}
+	if got := tree.Lookup(6000, ti); got != 6000 {
+		t.Errorf("Expected 6000, got %v", got)
+	}
+	if got := len(tree.RangeLookup(0, 10000, ti)); got != 5000 {
+		t.Errorf("Expected 5000 entries, got %d", got)
+	}
+}
+
+// 检查点与并发写入同时进行，恢复后不丢失也不重复任何写入
+func TestWAL_CheckpointConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	tree, err := OpenWithOptions(dir, WALOptions{Sync: WALSyncNone, BufferSize: 4 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	threads, inserts := 4, 5000
+	var wg sync.WaitGroup
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ti := NewThreadInfo(tree.GetEpoche())
+			for k := 0; k < inserts; k++ {
+				tree.Insert(k*threads+i, i, ti)
+			}
+		}(i)
+	}
+	for c := 0; c < 5; c++ {
+		if err := tree.Checkpoint(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+	tree.Close()
+
+	tree, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	ti := NewThreadInfo(tree.GetEpoche())
+	entries := tree.RangeLookupEntries(0, threads*inserts+1, ti)
+	if len(entries) != threads*inserts {
+		t.Fatalf("Expected %d entries, got %d", threads*inserts, len(entries))
+	}
+	for i, e := range entries {
+		if e.Key != i || e.Value != i%threads {
+			t.Fatalf("Expected %d=%d, got %v=%v", i, i%threads, e.Key, e.Value)
+		}
+	}
+}
+
+func TestWAL_BatchAndTx(t *testing.T) {
+	dir := t.TempDir()
+	tree, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ti := NewThreadInfo(tree.GetEpoche())
+	for k := 1; k <= 10; k++ {
+		tree.Insert(k, k, ti)
+	}
+	wb := tree.NewWriteBatch()
+	wb.Put(1, "a")
+	wb.Delete(2)
+	wb.Put(11, 11)
+	wb.Commit(ti)
+
+	tx := tree.Begin(ti)
+	tx.Put(3, tx.Lookup(3).(int)*100)
+	tx.Delete(4)
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	// 冲突的事务不写日志
+	tx = tree.Begin(ti)
+	tx.Lookup(5)
+	tx.Put(5, "lost")
+	tree.Update(5, 50, ti)
+	if err := tx.Commit(); err == nil {
+		t.Fatal("Expected a conflict")
+	}
+	tree.Close()
+
+	tree, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	ti = NewThreadInfo(tree.GetEpoche())
+	expected := []Entry{{1, "a"}, {3, 300}, {5, 50}, {6, 6}, {7, 7}, {8, 8}, {9, 9}, {10, 10}, {11, 11}}
+	got := tree.RangeLookupEntries(0, 100, ti)
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+// 并发写入同一批键：先写日志再修改树，恢复后的状态必须与关闭前一致
+func TestWAL_SameKeyOrder(t *testing.T) {
+	dir := t.TempDir()
+	tree, err := OpenWithOptions(dir, WALOptions{Sync: WALSyncNone, BufferSize: 1 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const threads, ops, keys = 8, 2000, 16
+	var wg sync.WaitGroup
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ti := NewThreadInfo(tree.GetEpoche())
+			for n := 0; n < ops; n++ {
+				k := (n*7 + i) % keys
+				switch n % 5 {
+				case 0, 1:
+					tree.Insert(k, i*ops+n, ti)
+				case 2:
+					tree.Update(k, -(i*ops + n), ti)
+				case 3:
+					tree.Remove(k, ti)
+				case 4:
+					wb := tree.NewWriteBatch()
+					wb.Put(k, i*ops+n)
+					wb.Delete((k + 1) % keys)
+					wb.Commit(ti)
+				}
+				if i == 0 && n%200 == 0 {
+					tree.Snapshot().Release()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	ti := NewThreadInfo(tree.GetEpoche())
+	expected := fmt.Sprint(tree.RangeLookupEntries(0, keys, ti))
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	ti = NewThreadInfo(tree.GetEpoche())
+	if got := fmt.Sprint(tree.RangeLookupEntries(0, keys, ti)); got != expected {
+		t.Errorf("Expected %v after replay, got %v", expected, got)
+	}
+}
+
+// 日志写入失败后写入不再生效，树进入失败状态
+func TestWAL_WriteFailure(t *testing.T) {
+	tree, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ti := NewThreadInfo(tree.GetEpoche())
+	for k := 1; k <= 10; k++ {
+		tree.Insert(k, k, ti)
+	}
+	// 让之后的写入和 fsync 都失败
+	tree.wal.file.Close()
+
+	tree.Insert(11, 11, ti)
+	if tree.Err() == nil {
+		t.Fatal("Expected the tree to fail after a WAL error")
+	}
+	if got := tree.Lookup(11, ti); got != nil {
+		t.Errorf("Expected the failed insert not to be applied, got %v", got)
+	}
+	if tree.Update(1, "one", ti) || tree.Lookup(1, ti) != 1 {
+		t.Error("Expected the update to fail")
+	}
+	if tree.Remove(2, ti) || tree.Lookup(2, ti) != 2 {
+		t.Error("Expected the remove to fail")
+	}
+	wb := tree.NewWriteBatch()
+	wb.Put(12, 12)
+	wb.Delete(3)
+	wb.Commit(ti)
+	if tree.Lookup(12, ti) != nil || tree.Lookup(3, ti) != 3 {
+		t.Error("Expected the batch not to be applied")
+	}
+	tx := tree.Begin(ti)
+	tx.Put(13, 13)
+	if err := tx.Commit(); err == nil {
+		t.Error("Expected the transaction to fail")
+	}
+	if got := tree.TruncateBefore(5, ti); got != 0 || tree.Lookup(4, ti) != 4 {
+		t.Errorf("Expected the truncation to fail, removed %d", got)
+	}
+	if tree.Sync() == nil {
+		t.Error("Expected Sync to report the WAL error")
+	}
+	tree.Close()
+}
+
+func TestWAL_UnencodableValue(t *testing.T) {
+	type point struct{ x, y int }
+	tree, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	ti := NewThreadInfo(tree.GetEpoche())
+	tree.Insert(1, 1, ti)
+
+	// 事务返回错误，树不受影响
+	tx := tree.Begin(ti)
+	tx.Put(2, point{1, 2})
+	if err := tx.Commit(); !errors.Is(err, ErrCodecType) {
+		t.Fatalf("Expected the transaction to fail with ErrCodecType, got %v", err)
+	}
+	if tree.Err() != nil || tree.Lookup(2, ti) != nil {
+		t.Fatalf("Expected the tree to be untouched, got %v", tree.Err())
+	}
+
+	// Insert 没有返回值，写入不生效，错误通过 Err 报告
+	tree.Insert(3, point{3, 4}, ti)
+	if !errors.Is(tree.Err(), ErrCodecType) || tree.Lookup(3, ti) != nil {
+		t.Fatalf("Expected the insert to be rejected with ErrCodecType, got %v", tree.Err())
+	}
+	if tree.Lookup(1, ti) != 1 {
+		t.Error("Expected earlier writes to stay readable")
+	}
+}
+
+func TestWAL_SyncPolicies(t *testing.T) {
+	policies := []WALOptions{
+		{Sync: WALSyncAlways},
+		{Sync: WALSyncAlways, GroupCommitDelay: 100 * time.Microsecond},
+		{Sync: WALSyncPeriodic, SyncInterval: time.Millisecond, BufferSize: 1 << 10},
+		{Sync: WALSyncNone, BufferSize: 1 << 10},
+	}
+	for _, opts := range policies {
+		t.Run(opts.Sync.String(), func(t *testing.T) {
+			dir := t.TempDir()
+			tree, err := OpenWithOptions(dir, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			threads, inserts := 4, 200
+			var wg sync.WaitGroup
+			for i := 0; i < threads; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					ti := NewThreadInfo(tree.GetEpoche())
+					for k := 0; k < inserts; k++ {
+						tree.Insert(k*threads+i, k, ti)
+					}
+				}(i)
+			}
+			wg.Wait()
+			if err := tree.Sync(); err != nil {
+				t.Fatal(err)
+			}
+			if err := tree.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			tree, err = Open(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tree.Close()
+			ti := NewThreadInfo(tree.GetEpoche())
+			if got := len(tree.RangeLookup(0, threads*inserts+1, ti)); got != threads*inserts {
+				t.Errorf("Expected %d entries, got %d", threads*inserts, got)
+			}
+		})
+	}
+}
+
+// BenchmarkWAL 比较各落盘策略下 4 个线程并发插入的开销，WALSyncAlways 的 fsync 由并发写入分摊
+func BenchmarkWAL(b *testing.B) {
+	policies := []WALOptions{
+		{Sync: WALSyncAlways},
+		{Sync: WALSyncPeriodic, SyncInterval: 10 * time.Millisecond, BufferSize: 64 << 10},
+		{Sync: WALSyncNone, BufferSize: 64 << 10},
+	}
+	for _, opts := range policies {
+		b.Run(opts.Sync.String(), func(b *testing.B) {
+			tree, err := OpenWithOptions(b.TempDir(), opts)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer tree.Close()
+			var mu sync.Mutex
+			next := 0
+			b.SetParallelism(4)
+			b.RunParallel(func(pb *testing.PB) {
+				ti := NewThreadInfo(tree.GetEpoche())
+				mu.Lock()
+				base := next << 32
+				next++
+				mu.Unlock()
+				for i := 0; pb.Next(); i++ {
+					tree.Insert(base+i, i, ti)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,287 @@
+package blinkhash
+
+import "sort"
+
+// batchOp WriteBatch 中的一次写入，delete 为 true 时删除 key
+type batchOp struct {
+	key    int
+	value  interface{}
+	delete bool
+}
+
+// batchGroup 落在同一个叶子中的一段连续写入
+type batchGroup struct {
+	leaf    *LNodeBTree
+	version uint64
+	ops     []batchOp
+}
+
+// WriteBatch 收集多个键的写入，Commit 时一次性生效：
+// 读者要么看不到批中的任何写入，要么在看到其中一个之后也能看到其余的。
+// WriteBatch 不是并发安全的，同一个批只能由一个线程构造和提交。
+type WriteBatch struct {
+	tree *BTree
+	ops  []batchOp
+}
+
+// NewWriteBatch 创建写入 bt 的空批
+func (bt *BTree) NewWriteBatch() *WriteBatch {
+	return &WriteBatch{tree: bt}
+}
+
+// Put 写入 key，key 已存在时覆盖原值(与 Insert 不同，不会产生重复键)
+func (wb *WriteBatch) Put(key, value interface{}) {
+	wb.ops = append(wb.ops, batchOp{key: key.(int), value: value})
+}
+
+// Delete 删除 key，key 不存在时什么也不做
+func (wb *WriteBatch) Delete(key interface{}) {
+	wb.ops = append(wb.ops, batchOp{key: key.(int), delete: true})
+}
+
+// Len 返回批中的写入数
+func (wb *WriteBatch) Len() int {
+	return len(wb.ops)
+}
+
+// Reset 清空批，可以重新使用
+func (wb *WriteBatch) Reset() {
+	wb.ops = wb.ops[:0]
+}
+
+// Commit 原子地应用批中的所有写入，同一个键的多次写入以最后一次为准。
+//
+// 按键的顺序定位并锁住涉及的叶子，全部锁住后才开始修改，修改完成后统一释放。
+// 锁使用节点已有的版本锁字，升级失败(读取后叶子发生了变化)时释放已持有的锁从头重试；
+// 叶子容量不够时多出的条目放入新建的右兄弟，解锁后再挂到父节点上。
+// 哈希叶子的插入只加桶锁，读者不校验节点版本，无法与其他叶子一起锁住，
+// 因此涉及的哈希叶子会先被转换为 B 树叶子。
+// 打开了日志的树先写日志再应用，日志出错或有值不能用日志的编解码器编码时整批都不生效，
+// 树进入失败状态，见 BTree.Err
+func (wb *WriteBatch) Commit(ti *ThreadInfo) {
+	ops := wb.sortedOps()
+	if len(ops) == 0 {
+		return
+	}
+	bt := wb.tree
+	keys := make([]int, len(ops))
+	for i, op := range ops {
+		keys[i] = op.key
+	}
+	rec, err := bt.walBatchRecord(ops)
+	if err != nil {
+		bt.fail(err)
+		return
+	}
+	// 先写日志并等到轮次，之后修改树，批内的前像以同一个时间戳提交
+	turn, ok := bt.logWrite(rec, keys...)
+	if !ok {
+		return
+	}
+	vb := bt.versions.beginBatch(bt, keys, ti)
+	bt.applyBatch(ops, ti)
+	vb.end()
+	turn.done()
+}
+
+// applyBatch 原子地应用有序且去重的 ops，不记录旧版本也不写日志
+func (bt *BTree) applyBatch(ops []batchOp, ti *ThreadInfo) {
+	eg := NewEpocheGuard(ti)
+	defer eg.Release()
+	for bt.Err() == nil {
+		if committed, _ := bt.tryCommitBatch(ops, nil, ti); committed {
+			return
+		}
+	}
+}
+
+// sortedOps 按键排序并去重，同一个键保留最后一次写入
+func (wb *WriteBatch) sortedOps() []batchOp {
+	ops := make([]batchOp, len(wb.ops))
+	copy(ops, wb.ops)
+	sort.SliceStable(ops, func(i, j int) bool { return ops[i].key < ops[j].key })
+	n := 0
+	for i := range ops {
+		if n > 0 && ops[n-1].key == ops[i].key {
+			ops[n-1] = ops[i]
+			continue
+		}
+		ops[n] = ops[i]
+		n++
+	}
+	return ops[:n]
+}
+
+// tryCommitBatch 尝试一次加锁并应用 ops。validate 不为 nil 时在锁住所有叶子之后、修改之前调用，
+// 返回 false 时放弃提交。retry 为 true 表示叶子在读取后发生了变化需要重试，
+// committed 和 retry 都为 false 表示校验失败。返回时不持有任何叶子锁。
+func (bt *BTree) tryCommitBatch(ops []batchOp, validate func(groups []batchGroup) bool, ti *ThreadInfo) (committed, retry bool) {
+	groups, ok := bt.groupBatch(ops, ti)
+	if !ok {
+		return false, true
+	}
+
+	for i, g := range groups {
+		if success, needRestart := g.leaf.TryUpgradeWriteLock(g.version); !success || needRestart {
+			unlockBatchGroups(groups[:i])
+			return false, true
+		}
+	}
+	// 修改任何叶子之前换入全部的叶子，换入失败时整批放弃
+	for _, g := range groups {
+		if g.leaf.loadForWrite() != nil {
+			unlockBatchGroups(groups)
+			return false, true
+		}
+	}
+	if validate != nil && !validate(groups) {
+		unlockBatchGroups(groups)
+		return false, false
+	}
+	spilled := make([][]spilledLeaf, len(groups))
+	for i, g := range groups {
+		spilled[i] = g.apply()
+	}
+	// 全部应用完之后才释放锁，整批写入同时可见。发生溢出的叶子保持写锁，
+	// 与普通分裂一样在挂到父节点之前不会被替换或再次分裂
+	for i, g := range groups {
+		if len(spilled[i]) == 0 {
+			g.leaf.WriteUnlock()
+		}
+	}
+	for i, g := range groups {
+		bt.publishSpilled(g.leaf, spilled[i])
+	}
+	return true, false
+}
+
+// spilledLeaf 写入超出叶子容量时新建的右兄弟，splitKey 为其左邻叶子的最大键
+type spilledLeaf struct {
+	leaf     *LNodeBTree
+	splitKey interface{}
+}
+
+// groupBatch 把有序的 ops 按所在叶子分组，记录每个叶子的读版本，过程中不持有锁
+func (bt *BTree) groupBatch(ops []batchOp, ti *ThreadInfo) ([]batchGroup, bool) {
+	var groups []batchGroup
+	for i := 0; i < len(ops); {
+		leaf, version := bt.findLeaf(ops[i].key)
+		lb, ok := leaf.(*LNodeBTree)
+		if !ok {
+			// 转换成功时旧叶子仍处于锁定状态，需要在这里释放；失败时 Convert 已自行解锁
+			if bt.convert(leaf, version, ti) {
+				leaf.WriteUnlock()
+			}
+			return nil, false
+		}
+		j := i + 1
+		for j < len(ops) && (lb.siblingPtr == nil || compareIntKeys(ops[j].key, lb.HighKey) <= 0) {
+			j++
+		}
+		// HighKey 和兄弟指针在节点锁内修改，版本不变说明分组是按一致的边界划分的
+		if endVersion, needRestart := lb.GetVersion(); needRestart || endVersion != version {
+			return nil, false
+		}
+		groups = append(groups, batchGroup{leaf: lb, version: version, ops: ops[i:j]})
+		i = j
+	}
+	return groups, true
+}
+
+// apply 在持有写锁并且叶子已换入时把本组的写入应用到叶子。结果放得下时原地修改；
+// 放不下时叶子只保留第一段，其余条目依次放入新建的右兄弟。新叶子创建时即加写锁，
+// 与本叶子一起保持锁定直到 publishSpilled 把它挂到父节点上。
+func (g batchGroup) apply() []spilledLeaf {
+	lb := g.leaf
+	lb.purgeLocked()
+	inserts := 0
+	for _, op := range g.ops {
+		found := lb.findPos(op.key) >= 0
+		if op.delete && found {
+			inserts--
+		} else if !op.delete && !found {
+			inserts++
+		}
+	}
+	if lb.loaded().len()+inserts <= lb.Cardinality {
+		for _, op := range g.ops {
+			if op.delete {
+				lb.removeLocked(op.key)
+			} else {
+				lb.putLocked(op.key, op.value)
+			}
+		}
+		return nil
+	}
+
+	merged := mergeBatchOps(lb.GetEntries(), g.ops)
+	pieces := (len(merged) + lb.Cardinality - 1) / lb.Cardinality
+	bound := func(i int) int { return i * len(merged) / pieces }
+	sibling, highKey := lb.siblingPtr, lb.HighKey
+
+	lb.contents.Store(newLeafContents(lb.Cardinality))
+	lb.count = 0
+	lb.appendEntries(merged[:bound(1)])
+	lb.HighKey = merged[bound(1)-1].Key
+
+	spilled := make([]spilledLeaf, 0, pieces-1)
+	prev := lb
+	for i := 1; i < pieces; i++ {
+		leaf := NewLNodeBTree(lb.level)
+		leaf.opts = lb.opts
+		leaf.appendEntries(merged[bound(i):bound(i+1)])
+		leaf.HighKey = merged[bound(i+1)-1].Key
+		leaf.written = 1
+		leaf.TryWriteLock()
+		prev.siblingPtr = leaf
+		spilled = append(spilled, spilledLeaf{leaf: leaf, splitKey: prev.HighKey})
+		prev = leaf
+	}
+	// 最后一个新叶子接管原来的兄弟和上界，最右叶子的上界取两者中较大的
+	prev.siblingPtr = sibling
+	if compareIntKeys(highKey, prev.HighKey) > 0 {
+		prev.HighKey = highKey
+	}
+	linkLeft(sibling, prev)
+	for _, s := range spilled {
+		lb.adopt(s.leaf)
+	}
+	return spilled
+}
+
+// mergeBatchOps 把有序的 ops 合并进有序的 entries，返回新的有序条目
+func mergeBatchOps(entries []Entry, ops []batchOp) []Entry {
+	merged := make([]Entry, 0, len(entries)+len(ops))
+	i := 0
+	for _, op := range ops {
+		for i < len(entries) && entries[i].Key.(int) < op.key {
+			merged = append(merged, entries[i])
+			i++
+		}
+		if i < len(entries) && entries[i].Key.(int) == op.key {
+			i++
+		}
+		if !op.delete {
+			merged = append(merged, Entry{Key: op.key, Value: op.value})
+		}
+	}
+	return append(merged, entries[i:]...)
+}
+
+// publishSpilled 把 apply 新建的叶子依次插入父节点。左邻叶子和新叶子都在 apply 中加了写锁，
+// 与插入路径的分裂相同，installSplit 锁住父节点后释放左邻叶子，最后一个新叶子在这里释放
+func (bt *BTree) publishSpilled(left *LNodeBTree, spilled []spilledLeaf) {
+	for _, s := range spilled {
+		bt.installSplit(left, s.leaf, s.splitKey, nil)
+		left = s.leaf
+	}
+	if len(spilled) > 0 {
+		left.WriteUnlock()
+	}
+}
+
+func unlockBatchGroups(groups []batchGroup) {
+	for _, g := range groups {
+		g.leaf.WriteUnlock()
+	}
+}
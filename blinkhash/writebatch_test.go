@@ -0,0 +1,270 @@
+package blinkhash
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestWriteBatch_Commit(t *testing.T) {
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	for k := 1; k <= 100; k++ {
+		tree.Insert(k*10, k, ti)
+	}
+
+	wb := tree.NewWriteBatch()
+	wb.Put(15, "a")
+	wb.Put(20, "b") // 覆盖已有的键
+	wb.Delete(30)
+	wb.Delete(35) // 不存在的键
+	wb.Put(40, "c")
+	wb.Delete(40) // 同一个键以最后一次写入为准
+	wb.Put(50, "x")
+	wb.Put(50, "d")
+	if wb.Len() != 8 {
+		t.Fatalf("Expected 8 writes in the batch, got %d", wb.Len())
+	}
+	wb.Commit(ti)
+
+	expected := map[int]interface{}{15: "a", 20: "b", 30: nil, 35: nil, 40: nil, 50: "d", 60: 6}
+	for k, v := range expected {
+		if got := tree.Lookup(k, ti); got != v {
+			t.Errorf("key %d: expected %v, got %v", k, v, got)
+		}
+	}
+	if got := len(tree.RangeLookup(0, 1000, ti)); got != 99 {
+		t.Errorf("Expected 99 entries, got %d", got)
+	}
+
+	wb.Reset()
+	wb.Commit(ti)
+	if wb.Len() != 0 {
+		t.Errorf("Expected an empty batch after Reset")
+	}
+}
+
+func TestWriteBatch_EmptyTree(t *testing.T) {
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	wb := tree.NewWriteBatch()
+	wb.Put(1, 1)
+	wb.Commit(ti)
+	if got := tree.Lookup(1, ti); got != 1 {
+		t.Fatalf("Expected 1, got %v", got)
+	}
+	// 一个批写满多个叶子，多出的条目放入新建的叶子
+	wb.Reset()
+	for k := 2; k <= 1000; k++ {
+		wb.Put(k, k)
+	}
+	wb.Commit(ti)
+	for k := 1; k <= 1000; k++ {
+		if got := tree.Lookup(k, ti); got != k {
+			t.Fatalf("key %d: expected %d, got %v", k, k, got)
+		}
+	}
+	if got := len(tree.RangeLookup(0, 2000, ti)); got != 1000 {
+		t.Errorf("Expected 1000 entries, got %d", got)
+	}
+}
+
+// 批中的键远多于一个叶子的容量，提交过程中需要多次分裂
+func TestWriteBatch_Split(t *testing.T) {
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	for k := 1; k <= 2000; k++ {
+		tree.Insert(k*100, k, ti)
+	}
+	wb := tree.NewWriteBatch()
+	for k := 1; k <= 5000; k++ {
+		wb.Put(k*37, -k)
+	}
+	wb.Commit(ti)
+	for k := 1; k <= 5000; k++ {
+		if got := tree.Lookup(k*37, ti); got != -k {
+			t.Fatalf("key %d: expected %d, got %v", k*37, -k, got)
+		}
+	}
+	for k := 1; k <= 2000; k++ {
+		if k*100%37 == 0 {
+			continue
+		}
+		if got := tree.Lookup(k*100, ti); got != k {
+			t.Fatalf("key %d: expected %d, got %v", k*100, k, got)
+		}
+	}
+	entries := tree.RangeLookupEntries(0, 10000, ti)
+	for i := 1; i < len(entries); i++ {
+		if entries[i-1].Key.(int) >= entries[i].Key.(int) {
+			t.Fatalf("Expected strictly increasing keys, got %v then %v", entries[i-1].Key, entries[i].Key)
+		}
+	}
+}
+
+// 写线程每批写入一个数据点和对应的 latest 指针，读者看到 latest 指向的点时该点必须已经存在
+func TestWriteBatch_Atomic(t *testing.T) {
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	const latest, points, rounds = 0, 1_000_000, 20000
+	for k := 1; k <= 5000; k++ {
+		tree.Insert(points+rounds+k, 0, ti)
+	}
+
+	var stop atomic.Bool
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		wti := NewThreadInfo(tree.GetEpoche())
+		wb := tree.NewWriteBatch()
+		for i := 1; i <= rounds && !stop.Load(); i++ {
+			wb.Reset()
+			wb.Put(points+i, i)
+			wb.Put(latest, i)
+			wb.Commit(wti)
+		}
+	}()
+
+	for reads := 0; reads < 100000; reads++ {
+		i, ok := tree.Lookup(latest, ti).(int)
+		if !ok {
+			continue
+		}
+		if got := tree.Lookup(points+i, ti); got != i {
+			stop.Store(true)
+			wg.Wait()
+			t.Fatalf("latest points to %d but the point is %v", i, got)
+		}
+	}
+	stop.Store(true)
+	wg.Wait()
+}
+
+func TestWriteBatch_Snapshot(t *testing.T) {
+	tree := NewBTree()
+	ti := NewThreadInfo(tree.GetEpoche())
+	for k := 1; k <= 100; k++ {
+		tree.Insert(k, k, ti)
+	}
+	snap := tree.Snapshot()
+	defer snap.Release()
+	wb := tree.NewWriteBatch()
+	wb.Put(1, -1)
+	wb.Delete(2)
+	wb.Put(101, 101)
+	wb.Commit(ti)
+
+	if got := snap.RangeLookup(0, 1000, ti); len(got) != 100 || got[0] != 1 || got[1] != 2 {
+		t.Fatalf("Expected the snapshot to miss the whole batch, got %d values starting %v", len(got), got[:2])
+	}
+	after := tree.Snapshot()
+	defer after.Release()
+	if after.Lookup(1, ti) != -1 || after.Lookup(2, ti) != nil || after.Lookup(101, ti) != 101 {
+		t.Fatalf("Expected a later snapshot to see the whole batch")
+	}
+}
+
+// 多个线程并发提交互相重叠的批，最终每个键都等于某一批写入的值，且同一批的键值一致
+func TestWriteBatch_Concurrent(t *testing.T) {
+	tree := NewBTree()
+	threads, batches, width := 4, 2000, 8
+	var wg sync.WaitGroup
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ti := NewThreadInfo(tree.GetEpoche())
+			wb := tree.NewWriteBatch()
+			for b := 0; b < batches; b++ {
+				wb.Reset()
+				base := (b * 7) % 500
+				for j := 0; j < width; j++ {
+					wb.Put(base+j*500, i*batches+b)
+				}
+				wb.Commit(ti)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	ti := NewThreadInfo(tree.GetEpoche())
+	for base := 0; base < 500; base++ {
+		first := tree.Lookup(base, ti)
+		for j := 1; j < width; j++ {
+			if got := tree.Lookup(base+j*500, ti); got != first {
+				t.Fatalf("key %d: expected %v from the same batch as key %d, got %v", base+j*500, first, base, got)
+			}
+		}
+	}
+}
+
+// 批量写入溢出的叶子在挂到父节点之前可能被并发的压缩线程替换，提交不能因此卡住或丢失写入
+func TestWriteBatch_SpillWithCompaction(t *testing.T) {
+	const n, batches = 4000, 200
+	tree, ti := newFloatTree(n)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ti := NewThreadInfo(tree.GetEpoche())
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				tree.CompactLeaves(ti)
+			}
+		}
+	}()
+	wb := tree.NewWriteBatch()
+	for b := 0; b < batches; b++ {
+		wb.Reset()
+		// 每批在一段已有键之间写入足够多的新键，使所在叶子溢出
+		base := (b * 97 % n) * 10
+		for j := 1; j <= 300; j++ {
+			wb.Put(base+j, float64(b))
+		}
+		wb.Commit(ti)
+	}
+	close(stop)
+	<-done
+
+	for b := batches - 20; b < batches; b++ {
+		base := (b * 97 % n) * 10
+		if got := tree.Lookup(base+1, ti); got == nil {
+			t.Fatalf("batch %d: key %d is missing", b, base+1)
+		}
+	}
+	entries := tree.RangeLookupEntries(math.MinInt, 10*n+10000, ti)
+	for i := 1; i < len(entries); i++ {
+		if entries[i-1].Key.(int) >= entries[i].Key.(int) {
+			t.Fatalf("Expected strictly increasing keys, got %v then %v", entries[i-1].Key, entries[i].Key)
+		}
+	}
+}
+
+// BenchmarkWriteBatch 比较逐条 Insert 与两条一批提交写入数据点和 latest 指针的开销
+func BenchmarkWriteBatch(b *testing.B) {
+	b.Run("insert", func(b *testing.B) {
+		tree := NewBTree()
+		ti := NewThreadInfo(tree.GetEpoche())
+		tree.Insert(0, 0, ti)
+		for i := 0; i < b.N; i++ {
+			tree.Insert(i+1, i, ti)
+			tree.Update(0, i, ti)
+		}
+	})
+	b.Run("batch", func(b *testing.B) {
+		tree := NewBTree()
+		ti := NewThreadInfo(tree.GetEpoche())
+		wb := tree.NewWriteBatch()
+		for i := 0; i < b.N; i++ {
+			wb.Reset()
+			wb.Put(i+1, i)
+			wb.Put(0, i)
+			wb.Commit(ti)
+		}
+	})
+}